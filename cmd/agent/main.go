@@ -6,11 +6,15 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
+	"github.com/cevrimxe/go-mini-rmm/internal/agent/credential"
 	"github.com/cevrimxe/go-mini-rmm/internal/agent/executor"
 	"github.com/cevrimxe/go-mini-rmm/internal/agent/heartbeat"
 	"github.com/cevrimxe/go-mini-rmm/internal/agent/updater"
+	"github.com/cevrimxe/go-mini-rmm/internal/tracing"
 )
 
 var Version = "dev"
@@ -19,6 +23,12 @@ func main() {
 	serverURL := flag.String("server", "http://localhost:8080", "RMM server URL")
 	agentKey := flag.String("key", "", "Agent key (ID) – sunucuda bu agent'ı tanımak için kullanılır")
 	displayName := flag.String("name", "", "Görünen isim (kurulumda girilen, dashboard'da gösterilir)")
+	enrollToken := flag.String("enroll-token", "", "Enrollment token (sadece ilk kayıtta kullanılır)")
+	secretFile := flag.String("secret-file", defaultSecretFile(), "Agent secret dosyası (enrollment sonrası yazılır)")
+	caFile := flag.String("ca-file", "", "Sunucu CA sertifikası (self-signed TLS için, PEM)")
+	mtls := flag.Bool("mtls", false, "İstemci sertifikası ile bağlan (sunucu -mtls ile çalışıyorsa)")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "Trace'lerin gönderileceği OTLP/HTTP collector URL'i, örn. http://localhost:4318 (boş = kapalı)")
+	otlpSampleRatio := flag.Float64("otlp-sample-ratio", 1, "Kaydedilecek yeni trace oranı (0-1)")
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
@@ -32,16 +42,51 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{Endpoint: *otlpEndpoint, SampleRatio: *otlpSampleRatio}, "rmm-agent", Version)
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	cred, err := credential.Load(*agentKey, *secretFile)
+	if err != nil {
+		slog.Error("failed to load agent credential", "error", err)
+		os.Exit(1)
+	}
+	if err := cred.ConfigureTLS(*caFile, *mtls); err != nil {
+		slog.Error("failed to configure TLS", "error", err)
+		os.Exit(1)
+	}
+	if !cred.Enrolled() {
+		if *enrollToken == "" {
+			slog.Error("agent is not enrolled: pass an enrollment token (-enroll-token flag)")
+			os.Exit(1)
+		}
+		if err := cred.Enroll(ctx, *serverURL, *enrollToken, *displayName); err != nil {
+			slog.Error("enrollment failed", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("agent enrolled", "secret_file", *secretFile)
+	}
+	if *mtls && !cred.HasCertificate() {
+		// Enrolled before mTLS was turned on, or the certificate files were lost
+		if err := cred.RequestCertificate(ctx, *serverURL); err != nil {
+			slog.Error("client certificate request failed", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("client certificate issued")
+	}
+
 	// Start heartbeat
-	hb := heartbeat.New(*serverURL, *agentKey, *displayName, Version)
+	hb := heartbeat.New(*serverURL, cred, *displayName, Version)
 	go hb.Run(ctx)
 
 	// Start WebSocket executor
-	exec := executor.New(*serverURL, *agentKey)
+	exec := executor.New(*serverURL, cred)
 	go exec.Run(ctx)
 
 	// Start auto-updater
-	upd := updater.New(*serverURL, Version)
+	upd := updater.New(*serverURL, Version, cred)
 	go upd.Run(ctx)
 
 	slog.Info("agent started", "server", *serverURL, "version", Version)
@@ -53,4 +98,18 @@ func main() {
 
 	slog.Info("agent shutting down...")
 	cancel()
+	flushCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("flushing traces failed", "error", err)
+	}
+}
+
+// defaultSecretFile places the secret next to the agent binary.
+func defaultSecretFile() string {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "agent.secret"
+	}
+	return filepath.Join(filepath.Dir(execPath), "agent.secret")
 }
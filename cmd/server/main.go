@@ -2,28 +2,87 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/cevrimxe/go-mini-rmm/internal/server/api"
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/alert"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/api"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/exporter"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/notify"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/oidc"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/pki"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ratelimit"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/retention"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/update"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+	"github.com/cevrimxe/go-mini-rmm/internal/tracing"
 )
 
 func main() {
 	addr := flag.String("addr", ":8080", "Server listen address")
 	dbPath := flag.String("db", "rmm.db", "SQLite database path")
+	enrollToken := flag.String("enroll-token", "", "Static multi-use agent enrollment token (optional, for automated deployments)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file (PEM); enables HTTPS")
+	tlsKey := flag.String("tls-key", "", "TLS private key file (PEM)")
+	tlsSelfSigned := flag.Bool("tls-self-signed", false, "Serve HTTPS with a certificate signed by the server's own CA (created in -pki-dir)")
+	tlsHosts := flag.String("tls-hosts", "", "Comma-separated DNS names/IPs for the self-signed certificate (default: hostname, localhost, 127.0.0.1)")
+	mtls := flag.Bool("mtls", false, "Require agents to present client certificates issued by the server CA")
+	pkiDir := flag.String("pki-dir", "pki", "Directory for the server CA and self-signed certificates")
+	queueTTL := flag.Duration("queue-ttl", ws.DefaultQueueTTL, "How long commands and file transfers wait for an offline agent before expiring")
+	recordingDir := flag.String("recordings-dir", "recordings", "Directory for shell session and command recordings")
+	recordingRetention := flag.Duration("recording-retention", retention.DefaultPolicy().Recordings, "Delete recordings older than this (0 = keep forever)")
+	commandTimeout := flag.Duration("command-timeout", 10*time.Minute, "Default timeout for commands sent without one (0 = no timeout)")
+	sessionIdle := flag.Duration("session-idle-timeout", time.Hour, "Log out dashboard sessions after this long without activity (0 = only the 7 day expiry)")
+	secureCookies := flag.Bool("secure-cookies", false, "Mark session cookies Secure even without -tls-* (use behind an HTTPS reverse proxy)")
+	lockoutThreshold := flag.Int("login-lockout-threshold", 5, "Lock an account after this many consecutive failed logins (0 = never)")
+	lockoutDuration := flag.Duration("login-lockout-duration", 15*time.Minute, "How long a locked account stays locked")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated CIDRs/IPs of reverse proxies whose X-Forwarded-For header gives the client IP")
+	agentRateLimit := flag.Float64("agent-rate-limit", 20, "Requests per second per client IP on agent and install endpoints (0 = unlimited)")
+	rawRetention := flag.Duration("metrics-raw-retention", retention.DefaultPolicy().RawMetrics, "Keep every heartbeat's metric samples this long (0 = forever)")
+	rollup5mRetention := flag.Duration("metrics-5m-retention", retention.DefaultPolicy().Metrics5m, "Keep 5-minute metric rollups this long (0 = forever)")
+	rollup1hRetention := flag.Duration("metrics-1h-retention", retention.DefaultPolicy().Metrics1h, "Keep 1-hour metric rollups this long (0 = forever)")
+	commandRetention := flag.Duration("command-retention", retention.DefaultPolicy().Commands, "Delete finished commands, their output and delivered, expired or cancelled queue messages after this long (0 = keep forever)")
+	alertRetention := flag.Duration("alert-retention", retention.DefaultPolicy().Alerts, "Delete resolved alerts and notification deliveries after this long (0 = keep forever)")
+	maintenanceRetention := flag.Duration("maintenance-retention", retention.DefaultPolicy().Maintenance, "Delete silences and one-off maintenance windows this long after they end (0 = keep forever)")
+	auditRetention := flag.Duration("audit-retention", retention.DefaultPolicy().AuditLogs, "Delete audit log entries after this long (0 = keep forever)")
+	prometheus := flag.Bool("prometheus", false, "Serve fleet and server metrics for Prometheus on /metrics")
+	prometheusToken := flag.String("prometheus-token", "", "Bearer token Prometheus must send to scrape /metrics (empty = no auth)")
+	prometheusPerAgent := flag.Bool("prometheus-per-agent", true, "Expose per-agent gauges on /metrics (false = fleet totals only)")
+	prometheusMaxAgents := flag.Int("prometheus-max-agents", 0, "Expose per-agent gauges for at most this many agents (0 = all)")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP collector URL to export traces to, e.g. http://localhost:4318 (empty = tracing off)")
+	otlpSampleRatio := flag.Float64("otlp-sample-ratio", 1, "Share of new traces to record (0-1)")
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL; enables single sign-on next to local logins")
+	oidcClientID := flag.String("oidc-client-id", "", "OIDC client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "OIDC client secret")
+	oidcRedirectURL := flag.String("oidc-redirect-url", "", "OIDC callback URL registered at the provider, e.g. https://rmm.example.com/login/oidc/callback")
+	oidcScopes := flag.String("oidc-scopes", "profile,email", "Comma-separated scopes requested besides openid")
+	oidcUsernameClaim := flag.String("oidc-username-claim", oidc.DefaultUsernameClaim, "ID token claim used as the username (falls back to email)")
+	oidcGroupsClaim := flag.String("oidc-groups-claim", oidc.DefaultGroupsClaim, "ID token claim listing the user's groups")
+	oidcAdminGroups := flag.String("oidc-admin-groups", "", "Comma-separated provider groups that map to the admin role")
+	oidcOperatorGroups := flag.String("oidc-operator-groups", "", "Comma-separated provider groups that map to the operator role")
+	oidcViewerGroups := flag.String("oidc-viewer-groups", "", "Comma-separated provider groups that map to the viewer role")
+	oidcDefaultRole := flag.String("oidc-default-role", "", "Role for SSO users in none of the groups (empty = refuse them)")
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	slog.SetDefault(logger)
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{Endpoint: *otlpEndpoint, SampleRatio: *otlpSampleRatio}, "rmm-server", "")
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
 	// Database
 	store, err := db.New(*dbPath)
 	if err != nil {
@@ -32,16 +91,121 @@ func main() {
 	}
 	defer store.Close()
 
+	if *enrollToken != "" {
+		if err := store.EnsureEnrollmentToken(*enrollToken, "static token (-enroll-token flag)"); err != nil {
+			slog.Error("failed to register enrollment token", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Shells don't survive a restart
+	if err := store.EndOpenShellSessions(); err != nil {
+		slog.Error("failed to close stale shell sessions", "error", err)
+	}
+
+	// TLS / internal CA
+	var ca *pki.CA
+	if *tlsSelfSigned || *mtls {
+		ca, err = pki.LoadOrCreateCA(*pkiDir)
+		if err != nil {
+			slog.Error("failed to load server CA", "error", err)
+			os.Exit(1)
+		}
+	}
+	certFile, keyFile := *tlsCert, *tlsKey
+	if *tlsSelfSigned && certFile == "" && keyFile == "" {
+		certFile = filepath.Join(*pkiDir, "server.crt")
+		keyFile = filepath.Join(*pkiDir, "server.key")
+		if err := ca.EnsureServerCertificate(certFile, keyFile, selfSignedHosts(*tlsHosts)); err != nil {
+			slog.Error("failed to create server certificate", "error", err)
+			os.Exit(1)
+		}
+	}
+	if (certFile == "") != (keyFile == "") {
+		slog.Error("-tls-cert and -tls-key must be given together")
+		os.Exit(1)
+	}
+	useTLS := certFile != ""
+	if *mtls && !useTLS {
+		slog.Error("-mtls requires -tls-cert/-tls-key or -tls-self-signed")
+		os.Exit(1)
+	}
+	update.ClientCertRequired = *mtls
+
+	proxies, err := ratelimit.ParseTrustedProxies(splitList(*trustedProxies))
+	if err != nil {
+		slog.Error("invalid -trusted-proxies", "error", err)
+		os.Exit(1)
+	}
+
+	// Single sign-on
+	ssoConfig := oidc.Config{
+		Issuer:         *oidcIssuer,
+		ClientID:       *oidcClientID,
+		ClientSecret:   *oidcClientSecret,
+		RedirectURL:    *oidcRedirectURL,
+		Scopes:         splitList(*oidcScopes),
+		UsernameClaim:  *oidcUsernameClaim,
+		GroupsClaim:    *oidcGroupsClaim,
+		AdminGroups:    splitList(*oidcAdminGroups),
+		OperatorGroups: splitList(*oidcOperatorGroups),
+		ViewerGroups:   splitList(*oidcViewerGroups),
+		DefaultRole:    models.Role(*oidcDefaultRole),
+	}
+	if *oidcIssuer != "" && (*oidcClientID == "" || *oidcRedirectURL == "") {
+		slog.Error("-oidc-issuer requires -oidc-client-id and -oidc-redirect-url")
+		os.Exit(1)
+	}
+	if *oidcDefaultRole != "" && !ssoConfig.DefaultRole.Valid() {
+		slog.Error("invalid -oidc-default-role", "role", *oidcDefaultRole)
+		os.Exit(1)
+	}
+
 	// WebSocket hub
 	hub := ws.NewHub(store)
+	hub.QueueTTL = *queueTTL
+	hub.RecordingDir = *recordingDir
 	go hub.Run()
 
+	// Metric rollups and data retention
+	retentionPolicy := retention.Policy{
+		RawMetrics:  *rawRetention,
+		Metrics5m:   *rollup5mRetention,
+		Metrics1h:   *rollup1hRetention,
+		Commands:    *commandRetention,
+		Alerts:      *alertRetention,
+		Maintenance: *maintenanceRetention,
+		AuditLogs:   *auditRetention,
+		Recordings:  *recordingRetention,
+		SessionIdle: *sessionIdle,
+	}
+	go retention.Run(context.Background(), store, retentionPolicy)
+
 	// Alert engine
-	alertEngine := alert.NewEngine(store)
+	notifier := notify.New(store)
+	notifier.Resume()
+	alertEngine := alert.NewEngine(store, notifier)
 	go alertEngine.Run(context.Background())
 
 	// Router
-	router := api.NewRouter(store, hub, alertEngine)
+	router := api.NewRouter(store, hub, alertEngine, notifier, api.Config{
+		CA:                    ca,
+		RequireClientCert:     *mtls,
+		DefaultCommandTimeout: *commandTimeout,
+		RecordingDir:          *recordingDir,
+		Sessions:              api.SessionPolicy{IdleTimeout: *sessionIdle, SecureCookie: *secureCookies},
+		LoginLockout:          api.LoginLockout{Threshold: *lockoutThreshold, Duration: *lockoutDuration},
+		AgentRateLimit:        *agentRateLimit,
+		TrustedProxies:        proxies,
+		OIDC:                  ssoConfig,
+		Retention:             retentionPolicy,
+		Prometheus: exporter.Config{
+			Enabled:   *prometheus,
+			Token:     *prometheusToken,
+			PerAgent:  *prometheusPerAgent,
+			MaxAgents: *prometheusMaxAgents,
+		},
+	})
 
 	srv := &http.Server{
 		Addr:         *addr,
@@ -50,11 +214,26 @@ func main() {
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	if useTLS {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if *mtls {
+			// Browsers don't have a client certificate, so it is optional at
+			// the TLS layer and enforced per agent route by api.AgentAuth
+			srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			srv.TLSConfig.ClientCAs = ca.Pool()
+		}
+	}
 
 	// Graceful shutdown
 	go func() {
-		slog.Info("server starting", "addr", *addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Info("server starting", "addr", *addr, "tls", useTLS, "mtls", *mtls, "sso", ssoConfig.Enabled())
+		var err error
+		if useTLS {
+			err = srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			slog.Error("server error", "error", err)
 			os.Exit(1)
 		}
@@ -70,4 +249,30 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		slog.Error("server shutdown error", "error", err)
 	}
+	notifier.Stop()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("flushing traces failed", "error", err)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(flagValue string) []string {
+	var out []string
+	for _, item := range strings.Split(flagValue, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func selfSignedHosts(flagValue string) []string {
+	if flagValue != "" {
+		return strings.Split(flagValue, ",")
+	}
+	hosts := []string{"localhost", "127.0.0.1"}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+	return hosts
 }
@@ -16,10 +16,11 @@ echo "Waiting for server to be ready..."
 sleep 10
 
 AGENT_NAME=$1
+ENROLL_TOKEN=$2
 echo "Installing as agent: $AGENT_NAME"
 
 # Download and run the install script
-curl -sSL http://server:8080/install.sh | tr -d '\r' | bash -s "$AGENT_NAME"
+curl -sSL http://server:8080/install.sh | tr -d '\r' | bash -s "$AGENT_NAME" "$ENROLL_TOKEN"
 
 # Extract the agent run command generated by the script
 EXEC_CMD=$(awk -F= '/ExecStart=/ {print $2}' /etc/systemd/system/rmm-agent.service)
@@ -36,7 +36,8 @@ echo "[RMM] Dashboard: http://$(curl -s ifconfig.me):9090"
 echo ""
 echo "[RMM] Watchtower her 60s yeni image kontrol edecek."
 echo "[RMM] git push main -> otomatik deploy"
-echo "[RMM] Agent kurmak icin: curl -sSL SERVER:9090/install.sh | sudo bash  veya install.ps1"
+echo "[RMM] Agent kurmak icin: Dashboard > Enrollment'dan token olusturun, sonra"
+echo "      curl -sSL SERVER:9090/install.sh | sudo bash -s -- \"AGENT_NAME\" TOKEN  veya install.ps1"
 echo ""
 echo "[RMM] Loglar: docker compose -f /opt/rmm/docker-compose.yml logs -f"
 echo "================================================"
@@ -27,6 +27,13 @@ if [ -f /tmp/agent-linux-amd64 ]; then
     echo "[RMM] Agent binary kuruldu"
 fi
 
+# Yerel agent icin enrollment token (bir kez uretilir)
+if [ ! -f "$INSTALL_DIR/enroll.token" ]; then
+    (openssl rand -hex 16 2>/dev/null || date +%s%N | sha256sum | cut -c1-32) > "$INSTALL_DIR/enroll.token"
+    chmod 600 "$INSTALL_DIR/enroll.token"
+fi
+ENROLL_TOKEN=$(cat "$INSTALL_DIR/enroll.token")
+
 # Server systemd servisi
 cat > /etc/systemd/system/rmm-server.service << EOF
 [Unit]
@@ -37,7 +44,7 @@ Wants=network-online.target
 [Service]
 Type=simple
 WorkingDirectory=${INSTALL_DIR}
-ExecStart=${INSTALL_DIR}/server -addr :${PORT} -db ${INSTALL_DIR}/rmm.db
+ExecStart=${INSTALL_DIR}/server -addr :${PORT} -db ${INSTALL_DIR}/rmm.db -enroll-token ${ENROLL_TOKEN}
 Restart=always
 RestartSec=5
 StandardOutput=journal
@@ -56,7 +63,7 @@ Wants=network-online.target
 
 [Service]
 Type=simple
-ExecStart=${INSTALL_DIR}/agent -server http://127.0.0.1:${PORT} -key sunucu-agent
+ExecStart=${INSTALL_DIR}/agent -server http://127.0.0.1:${PORT} -key sunucu-agent -enroll-token ${ENROLL_TOKEN} -secret-file ${INSTALL_DIR}/agent.secret
 Restart=always
 RestartSec=10
 StandardOutput=journal
@@ -81,5 +88,6 @@ echo "[RMM] Server log: journalctl -u rmm-server -f"
 echo "[RMM] Agent log:  journalctl -u rmm-agent -f"
 echo ""
 echo "[RMM] Baska makineden agent kurmak icin:"
-echo "  curl -sSL http://$(curl -s ifconfig.me):${PORT}/install.sh | sudo bash -s -- AGENT_KEY"
+echo "  curl -sSL http://$(curl -s ifconfig.me):${PORT}/install.sh | sudo bash -s -- \"AGENT_NAME\" ENROLL_TOKEN"
+echo "  (enrollment token: Dashboard > Enrollment)"
 echo "================================================"
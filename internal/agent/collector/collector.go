@@ -7,13 +7,14 @@ import (
 )
 
 // Collect gathers all system metrics and host info into a HeartbeatPayload.
+// A metric that fails to collect is logged and left at zero.
 func Collect(agentID, displayName, version string) models.HeartbeatPayload {
 	host, err := Host()
 	if err != nil {
 		slog.Warn("failed to collect host info", "error", err)
 	}
 
-	cpuPct, err := CPUPercent()
+	cpuPct, cores, err := CPUPercent()
 	if err != nil {
 		slog.Warn("failed to collect cpu", "error", err)
 	}
@@ -28,6 +29,24 @@ func Collect(agentID, displayName, version string) models.HeartbeatPayload {
 		slog.Warn("failed to collect disk", "error", err)
 	}
 
+	summary := models.Metric{CPUPercent: cpuPct, MemoryPercent: memPct, DiskPercent: diskPct}
+	system := &models.SystemMetrics{CPUCores: cores}
+	if avg, err := LoadAverage(); err == nil {
+		system.Load1, system.Load5, system.Load15 = avg.Load1, avg.Load5, avg.Load15
+	}
+	if system.SwapPercent, err = SwapPercent(); err != nil {
+		slog.Warn("failed to collect swap", "error", err)
+	}
+	if system.UptimeSeconds, err = UptimeSeconds(); err != nil {
+		slog.Warn("failed to collect uptime", "error", err)
+	}
+	if system.Disks, err = Disks(); err != nil {
+		slog.Warn("failed to collect filesystems", "error", err)
+	}
+	if system.Interfaces, err = Interfaces(); err != nil {
+		slog.Warn("failed to collect network interfaces", "error", err)
+	}
+
 	return models.HeartbeatPayload{
 		AgentID:       agentID,
 		DisplayName:   displayName,
@@ -38,5 +57,6 @@ func Collect(agentID, displayName, version string) models.HeartbeatPayload {
 		CPUPercent:    cpuPct,
 		MemoryPercent: memPct,
 		DiskPercent:   diskPct,
+		Samples:       append(summary.Samples(), system.Samples()...),
 	}
 }
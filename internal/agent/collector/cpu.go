@@ -4,15 +4,28 @@ import (
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/load"
 )
 
-func CPUPercent() (float64, error) {
-	percents, err := cpu.Percent(time.Second, false)
+// CPUPercent samples every core for a second and returns the overall usage
+// (the mean over cores) along with the per-core values.
+func CPUPercent() (float64, []float64, error) {
+	cores, err := cpu.Percent(time.Second, true)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
-	if len(percents) == 0 {
-		return 0, nil
+	if len(cores) == 0 {
+		return 0, nil, nil
 	}
-	return percents[0], nil
+	var sum float64
+	for _, pct := range cores {
+		sum += pct
+	}
+	return sum / float64(len(cores)), cores, nil
+}
+
+// LoadAverage returns the 1, 5 and 15 minute load averages. Windows has no
+// load average and returns an error.
+func LoadAverage() (*load.AvgStat, error) {
+	return load.Avg()
 }
@@ -4,8 +4,16 @@ import (
 	"runtime"
 
 	"github.com/shirou/gopsutil/v3/disk"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
 )
 
+// pseudoFilesystems are mounted but hold no user data worth watching.
+var pseudoFilesystems = map[string]bool{
+	"squashfs": true, "tmpfs": true, "devtmpfs": true,
+	"iso9660": true, "udf": true, "nsfs": true, "autofs": true,
+}
+
 func DiskPercent() (float64, error) {
 	path := "/"
 	if runtime.GOOS == "windows" {
@@ -17,3 +25,33 @@ func DiskPercent() (float64, error) {
 	}
 	return usage.UsedPercent, nil
 }
+
+// Disks returns usage for every mounted physical filesystem. Mounts that
+// cannot be read (e.g. an empty card reader) are skipped.
+func Disks() ([]models.DiskUsage, error) {
+	parts, err := disk.Partitions(false)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var disks []models.DiskUsage
+	for _, p := range parts {
+		if pseudoFilesystems[p.Fstype] || seen[p.Mountpoint] {
+			continue
+		}
+		usage, err := disk.Usage(p.Mountpoint)
+		if err != nil || usage.Total == 0 {
+			continue
+		}
+		seen[p.Mountpoint] = true
+		disks = append(disks, models.DiskUsage{
+			Mount:             p.Mountpoint,
+			FSType:            p.Fstype,
+			TotalBytes:        usage.Total,
+			FreeBytes:         usage.Free,
+			UsedPercent:       usage.UsedPercent,
+			InodesUsedPercent: usage.InodesUsedPercent,
+		})
+	}
+	return disks, nil
+}
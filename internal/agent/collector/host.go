@@ -5,6 +5,8 @@ import (
 	"net"
 	"os"
 	"runtime"
+
+	"github.com/shirou/gopsutil/v3/host"
 )
 
 type HostInfo struct {
@@ -37,3 +39,7 @@ func getOutboundIP() string {
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
 	return localAddr.IP.String()
 }
+
+func UptimeSeconds() (uint64, error) {
+	return host.Uptime()
+}
@@ -9,3 +9,11 @@ func MemoryPercent() (float64, error) {
 	}
 	return v.UsedPercent, nil
 }
+
+func SwapPercent() (float64, error) {
+	s, err := mem.SwapMemory()
+	if err != nil {
+		return 0, err
+	}
+	return s.UsedPercent, nil
+}
@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// Interface rates are the difference between two readings of the byte
+// counters, so the previous reading is kept between heartbeats.
+var (
+	netMu   sync.Mutex
+	netPrev map[string]net.IOCountersStat
+	netAt   time.Time
+)
+
+// Interfaces returns per-interface receive/transmit rates since the previous
+// call. The first call only records the counters and returns nothing.
+func Interfaces() ([]models.NetInterface, error) {
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	netMu.Lock()
+	defer netMu.Unlock()
+	prev, elapsed := netPrev, now.Sub(netAt).Seconds()
+	netPrev = make(map[string]net.IOCountersStat, len(counters))
+	for _, c := range counters {
+		netPrev[c.Name] = c
+	}
+	netAt = now
+	if prev == nil || elapsed <= 0 {
+		return nil, nil
+	}
+
+	var nics []models.NetInterface
+	for _, c := range counters {
+		if loopback(c.Name) {
+			continue
+		}
+		p, ok := prev[c.Name]
+		if !ok {
+			continue
+		}
+		nics = append(nics, models.NetInterface{
+			Name:          c.Name,
+			RxBytesPerSec: rate(p.BytesRecv, c.BytesRecv, elapsed),
+			TxBytesPerSec: rate(p.BytesSent, c.BytesSent, elapsed),
+		})
+	}
+	return nics, nil
+}
+
+// rate is 0 when the counter went backwards (wrapped or interface reset).
+func rate(before, after uint64, seconds float64) float64 {
+	if after < before {
+		return 0
+	}
+	return float64(after-before) / seconds
+}
+
+func loopback(name string) bool {
+	return name == "lo" || strings.HasPrefix(name, "lo0") || strings.Contains(strings.ToLower(name), "loopback")
+}
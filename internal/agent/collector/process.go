@@ -0,0 +1,48 @@
+package collector
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Processes lists running processes. CPU usage is measured over sample, so
+// the call takes at least that long. Details the agent may not read (other
+// users' processes without privileges) are left empty.
+func Processes(sample time.Duration) ([]models.Process, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	// First reading; Percent(0) compares against it below
+	for _, p := range procs {
+		p.Percent(0)
+	}
+	time.Sleep(sample)
+
+	list := make([]models.Process, 0, len(procs))
+	for _, p := range procs {
+		name, err := p.Name()
+		if err != nil {
+			continue // exited meanwhile
+		}
+		info := models.Process{PID: p.Pid, Name: name}
+		info.PPID, _ = p.Ppid()
+		info.Username, _ = p.Username()
+		info.CPUPercent, _ = p.Percent(0)
+		if mem, err := p.MemoryInfo(); err == nil && mem != nil {
+			info.RSS = mem.RSS
+		}
+		if args, err := p.CmdlineSlice(); err == nil {
+			info.Cmdline = strings.Join(args, " ")
+		}
+		if ms, err := p.CreateTime(); err == nil {
+			info.StartedAt = time.UnixMilli(ms).UTC()
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
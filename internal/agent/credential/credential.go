@@ -0,0 +1,150 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// Credential holds the agent key (ID) and the per-agent secret issued by the
+// server at enrollment. The secret is persisted to disk so it survives restarts.
+type Credential struct {
+	agentID   string
+	path      string
+	transport *http.Transport
+
+	mu        sync.RWMutex
+	secret    string
+	mtls      bool
+	tlsConfig *tls.Config
+	cert      *tls.Certificate
+}
+
+// Load reads the stored secret for agentID from path, if present.
+func Load(agentID, path string) (*Credential, error) {
+	c := &Credential{
+		agentID:   agentID,
+		path:      path,
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read secret: %w", err)
+	}
+	c.secret = strings.TrimSpace(string(data))
+	return c, nil
+}
+
+func (c *Credential) AgentID() string {
+	return c.agentID
+}
+
+func (c *Credential) Secret() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.secret
+}
+
+func (c *Credential) Enrolled() bool {
+	return c.Secret() != ""
+}
+
+// Update persists a new secret (e.g. after rotation) and starts using it.
+func (c *Credential) Update(secret string) error {
+	if err := writeFileAtomic(c.path, []byte(secret+"\n"), 0600); err != nil {
+		return fmt.Errorf("write secret: %w", err)
+	}
+
+	c.mu.Lock()
+	c.secret = secret
+	c.mu.Unlock()
+	return nil
+}
+
+// Enroll exchanges an enrollment token for a per-agent secret. In mTLS mode
+// it also sends a CSR and stores the issued client certificate.
+func (c *Credential) Enroll(ctx context.Context, serverURL, token, displayName string) error {
+	enrollReq := models.EnrollRequest{AgentID: c.agentID, Token: token, DisplayName: displayName}
+	var keyPEM []byte
+	if c.UsesClientCert() {
+		csrPEM, key, err := newCSR(c.agentID)
+		if err != nil {
+			return err
+		}
+		enrollReq.CSR = string(csrPEM)
+		keyPEM = key
+	}
+	body, _ := json.Marshal(enrollReq)
+	req, err := http.NewRequestWithContext(ctx, "POST", serverURL+"/api/v1/enroll", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient(30 * time.Second).Do(req)
+	if err != nil {
+		return fmt.Errorf("enroll request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("enroll status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var out models.EnrollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("decode enroll response: %w", err)
+	}
+	if out.Secret == "" {
+		return fmt.Errorf("enroll response missing secret")
+	}
+	if err := c.Update(out.Secret); err != nil {
+		return err
+	}
+	if keyPEM != nil && out.Certificate != "" {
+		return c.storeCertificate([]byte(out.Certificate), keyPEM)
+	}
+	return nil
+}
+
+// Header returns the authentication headers for a new request.
+func (c *Credential) Header() http.Header {
+	h := http.Header{}
+	h.Set(models.HeaderAgentKey, c.agentID)
+	h.Set(models.HeaderAgentSecret, c.Secret())
+	return h
+}
+
+// Apply sets the authentication headers on req.
+func (c *Credential) Apply(req *http.Request) {
+	for k, v := range c.Header() {
+		req.Header[k] = v
+	}
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ConfigureTLS sets up TLS for every connection to the server. caFile is
+// trusted in addition to the system roots (for the server's self-signed CA).
+// With mtls the agent presents the client certificate stored next to its
+// secret file (<secret-file>.crt / .key).
+func (c *Credential) ConfigureTLS(caFile string, mtls bool) error {
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("read ca file: %w", err)
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.mtls = mtls
+	c.tlsConfig = &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		RootCAs:              roots,
+		GetClientCertificate: c.clientCertificate,
+	}
+	c.transport.TLSClientConfig = c.tlsConfig.Clone()
+	c.mu.Unlock()
+
+	if !mtls {
+		return nil
+	}
+	return c.loadCertificate()
+}
+
+// TLSConfig returns a copy of the TLS settings for connections that do not go
+// through HTTPClient (e.g. the WebSocket dialer). The transport adds "h2" to
+// its own config, so it must not be shared.
+func (c *Credential) TLSConfig() *tls.Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.tlsConfig == nil {
+		return nil
+	}
+	cfg := c.tlsConfig.Clone()
+	cfg.NextProtos = nil
+	return cfg
+}
+
+// HTTPClient returns a client sharing the agent's TLS transport.
+func (c *Credential) HTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: c.transport}
+}
+
+// UsesClientCert reports whether the agent runs in mTLS mode.
+func (c *Credential) UsesClientCert() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.mtls
+}
+
+func (c *Credential) HasCertificate() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert != nil
+}
+
+// RequestCertificate obtains a new client certificate from the server using
+// the agent secret. The server refuses while the certificate is revoked.
+func (c *Credential) RequestCertificate(ctx context.Context, serverURL string) error {
+	csrPEM, keyPEM, err := newCSR(c.agentID)
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(models.CertificateRequest{CSR: string(csrPEM)})
+	req, err := http.NewRequestWithContext(ctx, "POST", serverURL+"/api/v1/agent/certificate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.Apply(req)
+
+	resp, err := c.HTTPClient(30 * time.Second).Do(req)
+	if err != nil {
+		return fmt.Errorf("certificate request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("certificate status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var out models.CertificateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("decode certificate response: %w", err)
+	}
+	return c.storeCertificate([]byte(out.Certificate), keyPEM)
+}
+
+func (c *Credential) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.mtls || c.cert == nil {
+		// No certificate: the server decides whether that is acceptable
+		return &tls.Certificate{}, nil
+	}
+	return c.cert, nil
+}
+
+func (c *Credential) certPaths() (certPath, keyPath string) {
+	base := strings.TrimSuffix(c.path, filepath.Ext(c.path))
+	return base + ".crt", base + ".key"
+}
+
+func (c *Credential) loadCertificate() error {
+	certPath, keyPath := c.certPaths()
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load client certificate: %w", err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+// storeCertificate persists a new key pair and starts using it. Idle
+// connections still carry the old certificate, so they are dropped.
+func (c *Credential) storeCertificate(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("invalid client certificate: %w", err)
+	}
+	certPath, keyPath := c.certPaths()
+	if err := writeFileAtomic(keyPath, keyPEM, 0600); err != nil {
+		return fmt.Errorf("write client key: %w", err)
+	}
+	if err := writeFileAtomic(certPath, certPEM, 0644); err != nil {
+		return fmt.Errorf("write client certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	c.transport.CloseIdleConnections()
+	return nil
+}
+
+func newCSR(agentID string) (csrPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: agentID},
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create csr: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
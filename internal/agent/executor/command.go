@@ -0,0 +1,288 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/tracing"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	outputFlushInterval = 250 * time.Millisecond
+	outputChunkSize     = 16 * 1024
+)
+
+var (
+	errCommandTimedOut  = errors.New("command timed out")
+	errCommandCancelled = errors.New("command cancelled")
+)
+
+type commandPayload struct {
+	CommandID      int64  `json:"command_id"`
+	Command        string `json:"command"`
+	TimeoutSeconds int    `json:"timeout_seconds"`
+}
+
+// startCommand registers the command before returning, so a command_cancel
+// read right after it always finds it, then runs it in the background. parent
+// carries the trace context the command was sent with.
+func (e *Executor) startCommand(parent context.Context, conn *websocket.Conn, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var p commandPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		slog.Warn("invalid command payload", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	if p.TimeoutSeconds > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, time.Duration(p.TimeoutSeconds)*time.Second, errCommandTimedOut)
+		inner := cancel
+		cancel = func(cause error) { inner(cause); stop() }
+	}
+
+	e.runningMu.Lock()
+	e.running[p.CommandID] = cancel
+	e.runningMu.Unlock()
+
+	go func() {
+		defer func() {
+			e.runningMu.Lock()
+			delete(e.running, p.CommandID)
+			e.runningMu.Unlock()
+			cancel(nil)
+		}()
+		e.executeCommand(ctx, conn, p)
+	}()
+}
+
+func (e *Executor) cancelCommand(payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var p struct {
+		CommandID int64 `json:"command_id"`
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		slog.Warn("invalid command_cancel payload", "error", err)
+		return
+	}
+
+	e.runningMu.Lock()
+	cancel, ok := e.running[p.CommandID]
+	e.runningMu.Unlock()
+	if !ok {
+		slog.Debug("cancel for unknown command", "command_id", p.CommandID)
+		return
+	}
+	slog.Info("cancelling command", "command_id", p.CommandID)
+	cancel(errCommandCancelled)
+}
+
+func (e *Executor) executeCommand(ctx context.Context, conn *websocket.Conn, p commandPayload) {
+	slog.Info("executing command", "command_id", p.CommandID, "command", p.Command, "timeout_seconds", p.TimeoutSeconds)
+	ctx, span := tracing.Tracer().Start(ctx, "agent.command", trace.WithAttributes(attribute.Int64("command.id", p.CommandID)))
+	defer span.End()
+
+	out := newOutputStreamer(func(stream string, offset int64, chunk string) {
+		msg := models.WSMessage{
+			Type: "command_output",
+			Payload: models.CommandOutput{
+				CommandID: p.CommandID,
+				Stream:    stream,
+				Data:      chunk,
+				Offset:    offset,
+			},
+		}
+		if err := e.send(conn, msg); err != nil {
+			slog.Warn("failed to send command output", "command_id", p.CommandID, "error", err)
+		}
+	})
+
+	cmd := shellCommand(p.Command)
+	cmd.Stdout = out.writer(models.StreamStdout)
+	cmd.Stderr = out.writer(models.StreamStderr)
+	// Children that escaped the kill may keep the pipes open; don't wait forever
+	cmd.WaitDelay = 5 * time.Second
+
+	exitCode := 0
+	var status models.CommandStatus
+	if ctx.Err() != nil {
+		// Cancelled before it started
+		exitCode, status = -1, models.CommandCancelled
+	} else if err := cmd.Start(); err != nil {
+		exitCode = -1
+		out.writer(models.StreamStderr).Write([]byte(err.Error()))
+	} else {
+		e.send(conn, models.WSMessage{
+			Type:    "command_started",
+			Payload: map[string]interface{}{"command_id": p.CommandID},
+		})
+
+		waitDone := make(chan error, 1)
+		go func() { waitDone <- cmd.Wait() }()
+
+		var err error
+		select {
+		case err = <-waitDone:
+		case <-ctx.Done():
+			if kerr := killProcessTree(cmd); kerr != nil {
+				slog.Warn("kill command failed", "command_id", p.CommandID, "error", kerr)
+			}
+			err = <-waitDone
+		}
+
+		switch cause := context.Cause(ctx); {
+		case errors.Is(cause, errCommandTimedOut):
+			exitCode, status = -1, models.CommandTimedOut
+			fmt.Fprintf(out.writer(models.StreamStderr), "\n[killed: timed out after %ds]\n", p.TimeoutSeconds)
+		case errors.Is(cause, errCommandCancelled):
+			exitCode, status = -1, models.CommandCancelled
+			fmt.Fprint(out.writer(models.StreamStderr), "\n[killed: cancelled]\n")
+		case err != nil:
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			} else {
+				exitCode = -1
+				out.writer(models.StreamStderr).Write([]byte(err.Error()))
+			}
+		}
+	}
+	out.close()
+
+	span.SetAttributes(attribute.Int("command.exit_code", exitCode))
+	if status != "" {
+		span.SetAttributes(attribute.String("command.status", string(status)))
+	}
+	if exitCode != 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("exit code %d", exitCode))
+	}
+	result := models.WSMessage{
+		Type:  "command_result",
+		Trace: tracing.Inject(ctx),
+		Payload: models.CommandResult{
+			CommandID: p.CommandID,
+			ExitCode:  exitCode,
+			Streamed:  true,
+			Status:    status,
+		},
+	}
+	if err := e.send(conn, result); err != nil {
+		slog.Error("failed to send command result", "error", err)
+	}
+}
+
+// outputStreamer batches process output and emits it in chunks at most every
+// outputFlushInterval, or sooner once outputChunkSize bytes are buffered.
+type outputStreamer struct {
+	emit func(stream string, offset int64, chunk string)
+
+	mu      sync.Mutex
+	buf     map[string][]byte
+	offsets map[string]int64
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newOutputStreamer(emit func(stream string, offset int64, chunk string)) *outputStreamer {
+	o := &outputStreamer{
+		emit:    emit,
+		buf:     make(map[string][]byte),
+		offsets: make(map[string]int64),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go o.loop()
+	return o
+}
+
+func (o *outputStreamer) writer(stream string) streamWriter {
+	return streamWriter{o: o, stream: stream}
+}
+
+type streamWriter struct {
+	o      *outputStreamer
+	stream string
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.o.mu.Lock()
+	w.o.buf[w.stream] = append(w.o.buf[w.stream], p...)
+	full := len(w.o.buf[w.stream]) >= outputChunkSize
+	w.o.mu.Unlock()
+	if full {
+		w.o.flush(false)
+	}
+	return len(p), nil
+}
+
+func (o *outputStreamer) loop() {
+	defer close(o.stopped)
+	ticker := time.NewTicker(outputFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			o.flush(false)
+		}
+	}
+}
+
+// close stops the timer and sends whatever is left, including an incomplete
+// trailing UTF-8 sequence.
+func (o *outputStreamer) close() {
+	close(o.done)
+	<-o.stopped
+	o.flush(true)
+}
+
+func (o *outputStreamer) flush(final bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, stream := range []string{models.StreamStdout, models.StreamStderr} {
+		b := o.buf[stream]
+		n := len(b)
+		if !final {
+			n = utf8Boundary(b)
+		}
+		if n == 0 {
+			continue
+		}
+		o.emit(stream, o.offsets[stream], string(b[:n]))
+		o.offsets[stream] += int64(n)
+		o.buf[stream] = append(b[:0], b[n:]...)
+	}
+}
+
+// utf8Boundary returns the length of b without a trailing incomplete rune, so
+// chunks are not mangled when JSON-encoded.
+func utf8Boundary(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
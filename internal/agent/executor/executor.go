@@ -11,25 +11,41 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
-	"strings"
+	"sync"
 	"time"
 
+	"github.com/cevrimxe/go-mini-rmm/internal/agent/credential"
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/tracing"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Executor struct {
 	serverURL string
-	agentKey  string
+	cred      *credential.Credential
+	client    *http.Client // file transfers; no timeout, files can be large
+
+	writeMu sync.Mutex // handlers run concurrently; one writer at a time
+
+	runningMu sync.Mutex
+	running   map[int64]context.CancelCauseFunc // command ID → cancel
+
+	shellsMu sync.Mutex
+	shells   map[string]*shellSession // session ID → interactive shell
 }
 
-func New(serverURL, agentKey string) *Executor {
+func New(serverURL string, cred *credential.Credential) *Executor {
 	return &Executor{
 		serverURL: serverURL,
-		agentKey:  agentKey,
+		cred:      cred,
+		client:    cred.HTTPClient(0),
+		running:   make(map[int64]context.CancelCauseFunc),
+		shells:    make(map[string]*shellSession),
 	}
 }
 
@@ -54,12 +70,23 @@ func (e *Executor) connectAndListen(ctx context.Context) {
 	wsURL := e.buildWSURL()
 	slog.Info("connecting to ws", "url", wsURL)
 
-	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, nil)
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = e.cred.TLSConfig()
+	conn, resp, err := dialer.DialContext(ctx, wsURL, e.cred.Header())
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			if resp.Header.Get(models.HeaderCertRequired) != "" {
+				slog.Error("ws rejected: client certificate not accepted")
+				return
+			}
+			slog.Error("ws rejected: agent credential is invalid or revoked")
+			return
+		}
 		slog.Warn("ws connect failed", "error", err)
 		return
 	}
 	defer conn.Close()
+	defer e.closeAllShells()
 
 	slog.Info("ws connected")
 
@@ -84,74 +111,35 @@ func (e *Executor) connectAndListen(ctx context.Context) {
 
 		switch msg.Type {
 		case "command":
-			go e.executeCommand(conn, msg.Payload)
+			e.startCommand(tracing.Extract(context.Background(), msg.Trace), conn, msg.Payload)
+		case "command_cancel":
+			e.cancelCommand(msg.Payload)
 		case "file_download":
-			go e.handleFileDownload(conn, msg.Payload)
+			go e.handleFileDownload(tracing.Extract(context.Background(), msg.Trace), conn, msg.Payload)
 		case "file_upload":
-			go e.handleFileUpload(conn, msg.Payload)
+			go e.handleFileUpload(tracing.Extract(context.Background(), msg.Trace), conn, msg.Payload)
 		case "dir_list":
 			go e.handleDirList(conn, msg.Payload)
+		case "process_list":
+			go e.handleProcessList(conn, msg.Payload)
+		case "process_kill":
+			go e.handleProcessKill(conn, msg.Payload)
+		case "shell_open":
+			e.openShell(conn, msg.Payload)
+		case "shell_input":
+			e.shellInput(msg.Payload)
+		case "shell_resize":
+			e.shellResize(msg.Payload)
+		case "shell_close":
+			e.closeShell(msg.Payload)
+		case "credential_rotate":
+			e.handleCredentialRotate(conn, msg.Payload)
 		}
 	}
 }
 
-func (e *Executor) executeCommand(conn *websocket.Conn, payload interface{}) {
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return
-	}
-
-	var cmdPayload struct {
-		CommandID int64  `json:"command_id"`
-		Command   string `json:"command"`
-	}
-	if err := json.Unmarshal(data, &cmdPayload); err != nil {
-		slog.Warn("invalid command payload", "error", err)
-		return
-	}
-
-	slog.Info("executing command", "command_id", cmdPayload.CommandID, "command", cmdPayload.Command)
-
-	// Execute based on OS
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("cmd", "/C", cmdPayload.Command)
-	} else {
-		cmd = exec.Command("sh", "-c", cmdPayload.Command)
-	}
-
-	var stdout, stderr strings.Builder
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	exitCode := 0
-	if err := cmd.Run(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		} else {
-			exitCode = -1
-			stderr.WriteString(err.Error())
-		}
-	}
-
-	result := models.WSMessage{
-		Type: "command_result",
-		Payload: models.CommandResult{
-			CommandID: cmdPayload.CommandID,
-			Stdout:    stdout.String(),
-			Stderr:    stderr.String(),
-			ExitCode:  exitCode,
-		},
-	}
-
-	resultData, _ := json.Marshal(result)
-	if err := conn.WriteMessage(websocket.TextMessage, resultData); err != nil {
-		slog.Error("failed to send command result", "error", err)
-	}
-}
-
 // handleFileDownload downloads a file from the server and writes it to the specified path
-func (e *Executor) handleFileDownload(conn *websocket.Conn, payload interface{}) {
+func (e *Executor) handleFileDownload(ctx context.Context, conn *websocket.Conn, payload interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return
@@ -169,13 +157,16 @@ func (e *Executor) handleFileDownload(conn *websocket.Conn, payload interface{})
 	}
 
 	slog.Info("downloading file from server", "transfer_id", dlPayload.TransferID, "remote_path", dlPayload.RemotePath)
+	ctx, span := tracing.Tracer().Start(ctx, "agent.file_download", trace.WithAttributes(
+		attribute.Int64("file_transfer.id", dlPayload.TransferID), attribute.String("file.path", dlPayload.RemotePath)))
+	defer span.End()
 
 	success := true
 	errMsg := ""
 
 	// Download from server
 	downloadURL := fmt.Sprintf("%s/api/v1/files/%d/serve", e.serverURL, dlPayload.TransferID)
-	resp, err := http.Get(downloadURL)
+	resp, err := e.authGet(ctx, downloadURL)
 	if err != nil {
 		success = false
 		errMsg = fmt.Sprintf("download request failed: %v", err)
@@ -211,25 +202,26 @@ func (e *Executor) handleFileDownload(conn *websocket.Conn, payload interface{})
 		slog.Info("file downloaded successfully", "path", dlPayload.RemotePath)
 	} else {
 		slog.Error("file download failed", "error", errMsg)
+		span.SetStatus(codes.Error, errMsg)
 	}
 
 	// Send result back
 	result := models.WSMessage{
-		Type: "file_download_result",
+		Type:  "file_download_result",
+		Trace: tracing.Inject(ctx),
 		Payload: map[string]interface{}{
 			"transfer_id": dlPayload.TransferID,
 			"success":     success,
 			"error":       errMsg,
 		},
 	}
-	resultData, _ := json.Marshal(result)
-	if err := conn.WriteMessage(websocket.TextMessage, resultData); err != nil {
+	if err := e.send(conn, result); err != nil {
 		slog.Error("failed to send file download result", "error", err)
 	}
 }
 
 // handleFileUpload reads a file from the agent and uploads it to the server
-func (e *Executor) handleFileUpload(conn *websocket.Conn, payload interface{}) {
+func (e *Executor) handleFileUpload(ctx context.Context, conn *websocket.Conn, payload interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return
@@ -245,6 +237,9 @@ func (e *Executor) handleFileUpload(conn *websocket.Conn, payload interface{}) {
 	}
 
 	slog.Info("uploading file to server", "transfer_id", ulPayload.TransferID, "path", ulPayload.RemotePath)
+	ctx, span := tracing.Tracer().Start(ctx, "agent.file_upload", trace.WithAttributes(
+		attribute.Int64("file_transfer.id", ulPayload.TransferID), attribute.String("file.path", ulPayload.RemotePath)))
+	defer span.End()
 
 	success := true
 	errMsg := ""
@@ -272,7 +267,7 @@ func (e *Executor) handleFileUpload(conn *websocket.Conn, payload interface{}) {
 				writer.Close()
 
 				uploadURL := fmt.Sprintf("%s/api/v1/files/%d/receive", e.serverURL, ulPayload.TransferID)
-				resp, err := http.Post(uploadURL, writer.FormDataContentType(), &buf)
+				resp, err := e.authPost(ctx, uploadURL, writer.FormDataContentType(), &buf)
 				if err != nil {
 					success = false
 					errMsg = fmt.Sprintf("upload request failed: %v", err)
@@ -291,19 +286,20 @@ func (e *Executor) handleFileUpload(conn *websocket.Conn, payload interface{}) {
 		slog.Info("file uploaded successfully", "path", ulPayload.RemotePath)
 	} else {
 		slog.Error("file upload failed", "error", errMsg)
+		span.SetStatus(codes.Error, errMsg)
 	}
 
 	// Send result back
 	result := models.WSMessage{
-		Type: "file_upload_result",
+		Type:  "file_upload_result",
+		Trace: tracing.Inject(ctx),
 		Payload: map[string]interface{}{
 			"transfer_id": ulPayload.TransferID,
 			"success":     success,
 			"error":       errMsg,
 		},
 	}
-	resultData, _ := json.Marshal(result)
-	if err := conn.WriteMessage(websocket.TextMessage, resultData); err != nil {
+	if err := e.send(conn, result); err != nil {
 		slog.Error("failed to send file upload result", "error", err)
 	}
 }
@@ -369,17 +365,79 @@ func (e *Executor) handleDirList(conn *websocket.Conn, payload interface{}) {
 			"error":      errMsg,
 		},
 	}
-	resultData, _ := json.Marshal(result)
-	if err := conn.WriteMessage(websocket.TextMessage, resultData); err != nil {
+	if err := e.send(conn, result); err != nil {
 		slog.Error("failed to send dir list result", "error", err)
 	}
 }
 
+// handleCredentialRotate stores a new secret pushed by the server and
+// acknowledges it. The server keeps accepting the old secret until the new
+// one is used, so a lost ack does not lock the agent out.
+func (e *Executor) handleCredentialRotate(conn *websocket.Conn, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var rotPayload struct {
+		RequestID string `json:"request_id"`
+		Secret    string `json:"secret"`
+	}
+	if err := json.Unmarshal(data, &rotPayload); err != nil || rotPayload.Secret == "" {
+		slog.Warn("invalid credential_rotate payload", "error", err)
+		return
+	}
+	result := models.CredentialRotated{RequestID: rotPayload.RequestID}
+	if err := e.cred.Update(rotPayload.Secret); err != nil {
+		slog.Error("failed to store rotated credential", "error", err)
+		result.Error = err.Error()
+	} else {
+		slog.Info("agent credential rotated")
+	}
+	if err := e.send(conn, models.WSMessage{Type: "credential_rotated", Payload: result}); err != nil {
+		slog.Error("failed to send credential rotation ack", "error", err)
+	}
+}
+
+// send writes a message to the server. gorilla/websocket connections support
+// only one concurrent writer.
+func (e *Executor) send(conn *websocket.Conn, msg models.WSMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// authGet and authPost carry ctx's trace context, so the server's handling
+// of the transfer joins the agent's span.
+func (e *Executor) authGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	e.cred.Apply(req)
+	tracing.InjectHeader(ctx, req.Header)
+	return e.client.Do(req)
+}
+
+func (e *Executor) authPost(ctx context.Context, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	e.cred.Apply(req)
+	tracing.InjectHeader(ctx, req.Header)
+	return e.client.Do(req)
+}
+
 func (e *Executor) buildWSURL() string {
 	u, _ := url.Parse(e.serverURL)
 	scheme := "ws"
 	if u.Scheme == "https" {
 		scheme = "wss"
 	}
-	return scheme + "://" + u.Host + "/ws/agent?agent_id=" + url.QueryEscape(e.agentKey)
+	return scheme + "://" + u.Host + "/ws/agent?agent_id=" + url.QueryEscape(e.cred.AgentID())
 }
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// shellCommand runs command through sh in its own process group so that a
+// timeout or cancel can kill everything it spawned.
+func shellCommand(command string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+func killProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	// Negative PID signals the whole process group
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+var processSignals = map[models.ProcessSignal]syscall.Signal{
+	models.SignalTerm: syscall.SIGTERM,
+	models.SignalKill: syscall.SIGKILL,
+	models.SignalInt:  syscall.SIGINT,
+	models.SignalHup:  syscall.SIGHUP,
+}
+
+func signalProcess(p *process.Process, sig models.ProcessSignal) error {
+	return p.SendSignal(processSignals[sig])
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+// killProcessTree kills the process and its children; Windows has no process
+// groups in the POSIX sense, so taskkill /T walks the tree.
+func killProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// signalProcess ends the process; Windows has no signals to ask politely, so
+// term and kill both terminate it.
+func signalProcess(p *process.Process, sig models.ProcessSignal) error {
+	switch sig {
+	case models.SignalTerm, models.SignalKill:
+		return p.Kill()
+	}
+	return fmt.Errorf("signal %q is not supported on Windows", sig)
+}
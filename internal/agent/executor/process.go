@@ -0,0 +1,83 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/agent/collector"
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/gorilla/websocket"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// processCPUSample is how long CPU usage is measured for a process list.
+const processCPUSample = 500 * time.Millisecond
+
+// handleProcessList sends the running processes back via WS
+func (e *Executor) handleProcessList(conn *websocket.Conn, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var req struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		slog.Warn("invalid process_list payload", "error", err)
+		return
+	}
+
+	result := models.ProcessList{RequestID: req.RequestID}
+	result.Processes, err = collector.Processes(processCPUSample)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if err := e.send(conn, models.WSMessage{Type: "process_list_result", Payload: result}); err != nil {
+		slog.Error("failed to send process list", "error", err)
+	}
+}
+
+// handleProcessKill signals a process and reports the outcome via WS
+func (e *Executor) handleProcessKill(conn *websocket.Conn, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var req struct {
+		RequestID string               `json:"request_id"`
+		PID       int32                `json:"pid"`
+		Signal    models.ProcessSignal `json:"signal"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		slog.Warn("invalid process_kill payload", "error", err)
+		return
+	}
+
+	result := models.ProcessKillResult{RequestID: req.RequestID, PID: req.PID}
+	if result.Name, err = killProcess(req.PID, req.Signal); err != nil {
+		result.Error = err.Error()
+	}
+	slog.Info("process signalled", "pid", req.PID, "name", result.Name, "signal", req.Signal, "error", result.Error)
+	if err := e.send(conn, models.WSMessage{Type: "process_kill_result", Payload: result}); err != nil {
+		slog.Error("failed to send process kill result", "error", err)
+	}
+}
+
+// killProcess sends sig to pid and returns the process name.
+func killProcess(pid int32, sig models.ProcessSignal) (string, error) {
+	if pid <= 0 || int(pid) == os.Getpid() {
+		return "", fmt.Errorf("refusing to signal pid %d", pid)
+	}
+	if !sig.Valid() {
+		return "", fmt.Errorf("unknown signal %q", sig)
+	}
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return "", fmt.Errorf("no process with pid %d", pid)
+	}
+	name, _ := p.Name()
+	return name, signalProcess(p, sig)
+}
@@ -0,0 +1,31 @@
+package executor
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// None of these may signal anything: killProcess must refuse before it looks
+// the process up.
+func TestKillProcessRefuses(t *testing.T) {
+	cases := []struct {
+		name string
+		pid  int32
+		sig  models.ProcessSignal
+	}{
+		{"zero pid", 0, models.SignalTerm},
+		{"negative pid", -1, models.SignalKill},
+		{"own pid", int32(os.Getpid()), models.SignalKill},
+		{"unknown signal", int32(os.Getppid()), "stop"},
+		{"empty signal", int32(os.Getppid()), ""},
+		{"no such process", math.MaxInt32, models.SignalTerm},
+	}
+	for _, c := range cases {
+		if _, err := killProcess(c.pid, c.sig); err == nil {
+			t.Errorf("%s: expected killProcess(%d, %q) to fail", c.name, c.pid, c.sig)
+		}
+	}
+}
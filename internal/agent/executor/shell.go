@@ -0,0 +1,171 @@
+package executor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os/exec"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+// shellPayload is shared by the shell_* messages. Data is base64 encoded
+// because terminal I/O is not necessarily valid UTF-8.
+type shellPayload struct {
+	SessionID string `json:"session_id"`
+	Cols      uint16 `json:"cols"`
+	Rows      uint16 `json:"rows"`
+	Data      string `json:"data"`
+}
+
+type shellSession struct {
+	tty io.ReadWriteCloser
+	cmd *exec.Cmd
+}
+
+func parseShellPayload(payload interface{}) (shellPayload, bool) {
+	var p shellPayload
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return p, false
+	}
+	if err := json.Unmarshal(data, &p); err != nil || p.SessionID == "" {
+		slog.Warn("invalid shell payload", "error", err)
+		return p, false
+	}
+	return p, true
+}
+
+func (e *Executor) openShell(conn *websocket.Conn, payload interface{}) {
+	p, ok := parseShellPayload(payload)
+	if !ok {
+		return
+	}
+
+	s, err := startShell(p.Cols, p.Rows)
+	if err != nil {
+		slog.Error("shell start failed", "session_id", p.SessionID, "error", err)
+		e.sendShellExit(conn, p.SessionID, -1, err.Error())
+		return
+	}
+	e.shellsMu.Lock()
+	e.shells[p.SessionID] = s
+	e.shellsMu.Unlock()
+	slog.Info("shell opened", "session_id", p.SessionID, "pid", s.cmd.Process.Pid)
+
+	go e.pumpShell(conn, p.SessionID, s)
+}
+
+// pumpShell forwards the terminal output until the shell exits.
+func (e *Executor) pumpShell(conn *websocket.Conn, sessionID string, s *shellSession) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.tty.Read(buf)
+		if n > 0 {
+			msg := map[string]interface{}{
+				"session_id": sessionID,
+				"data":       base64.StdEncoding.EncodeToString(buf[:n]),
+			}
+			if err := e.send(conn, models.WSMessage{Type: "shell_output", Payload: msg}); err != nil {
+				killProcessTree(s.cmd)
+				break
+			}
+		}
+		if err != nil {
+			// The pty returns EIO once the shell and its children are gone
+			break
+		}
+	}
+
+	exitCode := 0
+	errMsg := ""
+	if err := s.cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else {
+			exitCode = -1
+			errMsg = err.Error()
+		}
+	}
+	s.tty.Close()
+
+	e.shellsMu.Lock()
+	delete(e.shells, sessionID)
+	e.shellsMu.Unlock()
+
+	slog.Info("shell closed", "session_id", sessionID, "exit_code", exitCode)
+	e.sendShellExit(conn, sessionID, exitCode, errMsg)
+}
+
+func (e *Executor) sendShellExit(conn *websocket.Conn, sessionID string, exitCode int, errMsg string) {
+	msg := map[string]interface{}{
+		"session_id": sessionID,
+		"exit_code":  exitCode,
+		"error":      errMsg,
+	}
+	if err := e.send(conn, models.WSMessage{Type: "shell_exit", Payload: msg}); err != nil {
+		slog.Debug("failed to send shell exit", "session_id", sessionID, "error", err)
+	}
+}
+
+func (e *Executor) shell(sessionID string) *shellSession {
+	e.shellsMu.Lock()
+	defer e.shellsMu.Unlock()
+	return e.shells[sessionID]
+}
+
+func (e *Executor) shellInput(payload interface{}) {
+	p, ok := parseShellPayload(payload)
+	if !ok {
+		return
+	}
+	s := e.shell(p.SessionID)
+	if s == nil {
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(p.Data)
+	if err != nil {
+		slog.Warn("invalid shell input", "session_id", p.SessionID, "error", err)
+		return
+	}
+	if _, err := s.tty.Write(data); err != nil {
+		slog.Warn("shell write failed", "session_id", p.SessionID, "error", err)
+	}
+}
+
+func (e *Executor) shellResize(payload interface{}) {
+	p, ok := parseShellPayload(payload)
+	if !ok {
+		return
+	}
+	if s := e.shell(p.SessionID); s != nil {
+		if err := resizeShell(s, p.Cols, p.Rows); err != nil {
+			slog.Warn("shell resize failed", "session_id", p.SessionID, "error", err)
+		}
+	}
+}
+
+// closeShell kills the shell; pumpShell then reports shell_exit.
+func (e *Executor) closeShell(payload interface{}) {
+	p, ok := parseShellPayload(payload)
+	if !ok {
+		return
+	}
+	if s := e.shell(p.SessionID); s != nil {
+		killProcessTree(s.cmd)
+	}
+}
+
+// closeAllShells kills every shell, e.g. when the WebSocket drops; the
+// server ends its side of the sessions on disconnect.
+func (e *Executor) closeAllShells() {
+	e.shellsMu.Lock()
+	defer e.shellsMu.Unlock()
+	for _, s := range e.shells {
+		killProcessTree(s.cmd)
+	}
+}
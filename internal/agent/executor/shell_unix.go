@@ -0,0 +1,44 @@
+//go:build !windows
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// startShell starts the user's login shell on a new pseudo-terminal. The
+// shell leads its own session, so killProcessTree reaches its children.
+func startShell(cols, rows uint16) (*shellSession, error) {
+	sh := os.Getenv("SHELL")
+	if sh == "" {
+		sh = "/bin/sh"
+		if _, err := os.Stat("/bin/bash"); err == nil {
+			sh = "/bin/bash"
+		}
+	}
+	cmd := exec.Command(sh, "-l")
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	if home, err := os.UserHomeDir(); err == nil {
+		cmd.Dir = home
+	}
+
+	tty, err := pty.StartWithSize(cmd, windowSize(cols, rows))
+	if err != nil {
+		return nil, err
+	}
+	return &shellSession{tty: tty, cmd: cmd}, nil
+}
+
+func resizeShell(s *shellSession, cols, rows uint16) error {
+	return pty.Setsize(s.tty.(*os.File), windowSize(cols, rows))
+}
+
+func windowSize(cols, rows uint16) *pty.Winsize {
+	if cols == 0 || rows == 0 {
+		cols, rows = 80, 24
+	}
+	return &pty.Winsize{Cols: cols, Rows: rows}
+}
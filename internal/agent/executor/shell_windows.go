@@ -0,0 +1,15 @@
+//go:build windows
+
+package executor
+
+import "errors"
+
+var errShellUnsupported = errors.New("interactive shell is not supported on Windows yet")
+
+func startShell(cols, rows uint16) (*shellSession, error) {
+	return nil, errShellUnsupported
+}
+
+func resizeShell(s *shellSession, cols, rows uint16) error {
+	return errShellUnsupported
+}
@@ -9,25 +9,27 @@ import (
 	"time"
 
 	"github.com/cevrimxe/go-mini-rmm/internal/agent/collector"
+	"github.com/cevrimxe/go-mini-rmm/internal/agent/credential"
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
 )
 
 const interval = 30 * time.Second
 
 type Heartbeat struct {
 	serverURL   string
-	agentKey    string
+	cred        *credential.Credential
 	displayName string
 	version     string
 	client      *http.Client
 }
 
-func New(serverURL, agentKey, displayName, version string) *Heartbeat {
+func New(serverURL string, cred *credential.Credential, displayName, version string) *Heartbeat {
 	return &Heartbeat{
 		serverURL:   serverURL,
-		agentKey:    agentKey,
+		cred:        cred,
 		displayName: displayName,
 		version:     version,
-		client:      &http.Client{Timeout: 10 * time.Second},
+		client:      cred.HTTPClient(10 * time.Second),
 	}
 }
 
@@ -49,7 +51,7 @@ func (h *Heartbeat) Run(ctx context.Context) {
 }
 
 func (h *Heartbeat) send() {
-	payload := collector.Collect(h.agentKey, h.displayName, h.version)
+	payload := collector.Collect(h.cred.AgentID(), h.displayName, h.version)
 
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -63,7 +65,7 @@ func (h *Heartbeat) send() {
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Agent-Key", h.agentKey)
+	h.cred.Apply(req)
 
 	resp, err := h.client.Do(req)
 	if err != nil {
@@ -72,6 +74,14 @@ func (h *Heartbeat) send() {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		if resp.Header.Get(models.HeaderCertRequired) != "" && h.cred.UsesClientCert() {
+			h.renewCertificate()
+			return
+		}
+		slog.Error("heartbeat rejected: agent credential is invalid or revoked")
+		return
+	}
 	if resp.StatusCode != http.StatusOK {
 		slog.Warn("heartbeat unexpected status", "status", resp.StatusCode)
 		return
@@ -79,3 +89,16 @@ func (h *Heartbeat) send() {
 
 	slog.Debug("heartbeat sent successfully")
 }
+
+// renewCertificate asks for a new client certificate after the server refused
+// the current one (missing, superseded or revoked).
+func (h *Heartbeat) renewCertificate() {
+	slog.Warn("heartbeat rejected: client certificate not accepted, requesting a new one")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := h.cred.RequestCertificate(ctx, h.serverURL); err != nil {
+		slog.Error("client certificate request failed", "error", err)
+		return
+	}
+	slog.Info("client certificate renewed")
+}
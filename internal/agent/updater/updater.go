@@ -11,6 +11,8 @@ import (
 	"os/exec"
 	"runtime"
 	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/agent/credential"
 )
 
 const checkInterval = 5 * time.Minute
@@ -18,6 +20,7 @@ const checkInterval = 5 * time.Minute
 type Updater struct {
 	serverURL string
 	version   string
+	cred      *credential.Credential
 	client    *http.Client
 }
 
@@ -26,11 +29,12 @@ type UpdateCheckResponse struct {
 	LatestVersion   string `json:"latest_version"`
 }
 
-func New(serverURL, version string) *Updater {
+func New(serverURL, version string, cred *credential.Credential) *Updater {
 	return &Updater{
 		serverURL: serverURL,
 		version:   version,
-		client:    &http.Client{Timeout: 30 * time.Second},
+		cred:      cred,
+		client:    cred.HTTPClient(30 * time.Second),
 	}
 }
 
@@ -81,8 +85,14 @@ func (u *Updater) checkAndUpdate() {
 }
 
 func (u *Updater) downloadAndReplace() error {
-	resp, err := u.client.Get(fmt.Sprintf("%s/api/v1/update/download?os=%s&arch=%s",
-		u.serverURL, runtime.GOOS, runtime.GOARCH))
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/update/download?os=%s&arch=%s",
+		u.serverURL, runtime.GOOS, runtime.GOARCH), nil)
+	if err != nil {
+		return fmt.Errorf("download request: %w", err)
+	}
+	u.cred.Apply(req)
+
+	resp, err := u.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("download: %w", err)
 	}
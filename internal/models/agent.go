@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"regexp"
+	"slices"
+	"time"
+)
 
 type AgentStatus string
 
@@ -18,9 +22,29 @@ type Agent struct {
 	Version       string      `json:"version"`
 	LastHeartbeat time.Time   `json:"last_heartbeat"`
 	Status        AgentStatus `json:"status"`
+	Enrolled      bool        `json:"enrolled"`           // has an active per-agent secret
+	Revoked       bool        `json:"credential_revoked"` // secret revoked by an admin
+	CertSerial    string      `json:"cert_serial"`        // active mTLS client certificate, hex serial
+	CertRevoked   bool        `json:"cert_revoked"`       // certificate revoked; no reissue until allowed
+	Tags          []string    `json:"tags"`               // set by admins, e.g. "prod", "db"; used by silences
 	CreatedAt     time.Time   `json:"created_at"`
 }
 
+// MaxAgentTags is how many tags an agent can have.
+const MaxAgentTags = 20
+
+var tagRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+
+// ValidTag reports whether s is a usable agent tag: lower case letters,
+// digits, '_', '.' and '-', at most 32 characters.
+func ValidTag(s string) bool {
+	return tagRe.MatchString(s)
+}
+
+func (a *Agent) HasTag(tag string) bool {
+	return slices.Contains(a.Tags, tag)
+}
+
 // Name returns display name if set, else hostname, else ID (so Name column never shows key when hostname exists).
 func (a *Agent) Name() string {
 	if a.DisplayName != "" {
@@ -32,15 +56,6 @@ func (a *Agent) Name() string {
 	return a.ID
 }
 
-type Metric struct {
-	ID            int64     `json:"id"`
-	AgentID       string    `json:"agent_id"`
-	CPUPercent    float64   `json:"cpu_percent"`
-	MemoryPercent float64   `json:"memory_percent"`
-	DiskPercent   float64   `json:"disk_percent"`
-	Timestamp     time.Time `json:"timestamp"`
-}
-
 type HeartbeatPayload struct {
 	AgentID       string  `json:"agent_id"`
 	DisplayName   string  `json:"display_name"` // Kurulumda girilen isim
@@ -50,5 +65,18 @@ type HeartbeatPayload struct {
 	Version       string  `json:"version"`
 	CPUPercent    float64 `json:"cpu_percent"`
 	MemoryPercent float64 `json:"memory_percent"`
-	DiskPercent   float64 `json:"disk_percent"`
+	DiskPercent   float64 `json:"disk_percent"` // root filesystem (C:\ on Windows)
+
+	// Samples holds every metric, the three above included. Older agents
+	// send only the three fields.
+	Samples []Sample `json:"samples,omitempty"`
+}
+
+// AllSamples returns the heartbeat's samples, made up from the summary
+// fields for agents that don't send samples.
+func (p *HeartbeatPayload) AllSamples() []Sample {
+	if len(p.Samples) > 0 {
+		return p.Samples
+	}
+	return Metric{CPUPercent: p.CPUPercent, MemoryPercent: p.MemoryPercent, DiskPercent: p.DiskPercent}.Samples()
 }
@@ -1,29 +1,178 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+// RuleType is what an alert rule watches.
+type RuleType string
+
+const (
+	RuleMetric  RuleType = "metric"  // a metric crosses a threshold
+	RuleOffline RuleType = "offline" // the agent stops sending heartbeats
+)
+
+// Severity is how urgent a rule's alerts are.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+func (s Severity) Valid() bool {
+	return s.rank() > 0
+}
+
+// AtLeast reports whether s is as urgent as min or more.
+func (s Severity) AtLeast(min Severity) bool {
+	return s.rank() >= min.rank()
+}
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityInfo:
+		return 1
+	case SeverityWarning:
+		return 2
+	case SeverityCritical:
+		return 3
+	}
+	return 0
+}
+
+// DefaultOfflineGrace is how long an agent may go without a heartbeat before
+// it counts as offline: 3 missed heartbeats.
+const DefaultOfflineGrace = 90 * time.Second
 
 type AlertRule struct {
-	ID        int64     `json:"id"`
-	Metric    string    `json:"metric"`    // cpu_percent, memory_percent, disk_percent
-	Operator  string    `json:"operator"`  // >, <, >=, <=, ==
-	Threshold float64   `json:"threshold"` // e.g. 90.0
-	AgentID   string    `json:"agent_id"`  // empty = all agents
-	CreatedAt time.Time `json:"created_at"`
+	ID        int64    `json:"id"`
+	Type      RuleType `json:"type"`
+	Severity  Severity `json:"severity"`
+	Metric    string   `json:"metric"`    // e.g. cpu_percent, disk_used_percent; empty for offline rules
+	Operator  string   `json:"operator"`  // >, <, >=, <=, ==
+	Threshold float64  `json:"threshold"` // e.g. 90.0
+	AgentID   string   `json:"agent_id"`  // empty = all agents
+	Target    string   `json:"target"`    // mount point, interface or core; empty = all
+	// ForSeconds is how long the threshold must be crossed before the alert
+	// fires; 0 fires on the first sample. For offline rules it is the grace
+	// period since the last heartbeat; 0 = DefaultOfflineGrace.
+	ForSeconds int `json:"for_seconds"`
+	// ClearThreshold is where an open alert resolves; nil = Threshold.
+	ClearThreshold *float64  `json:"clear_threshold,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Grace returns how long an agent must be silent for an offline rule to fire.
+func (r AlertRule) Grace() time.Duration {
+	if r.ForSeconds == 0 {
+		return DefaultOfflineGrace
+	}
+	return time.Duration(r.ForSeconds) * time.Second
+}
+
+// Condition describes when the rule fires, e.g. "cpu_percent > 90 for 5m0s".
+func (r AlertRule) Condition() string {
+	if r.Type == RuleOffline {
+		return "offline for " + r.Grace().String()
+	}
+	c := fmt.Sprintf("%s %s %g", r.Metric, r.Operator, r.Threshold)
+	if r.Target != "" {
+		c += " on " + r.Target
+	}
+	if r.ForSeconds > 0 {
+		c += " for " + (time.Duration(r.ForSeconds) * time.Second).String()
+	}
+	return c
+}
+
+// ClearLevel returns the threshold a value must no longer cross for an open
+// alert to resolve.
+func (r AlertRule) ClearLevel() float64 {
+	if r.ClearThreshold != nil {
+		return *r.ClearThreshold
+	}
+	return r.Threshold
 }
 
 type AlertRuleRequest struct {
-	Metric    string  `json:"metric"`
-	Operator  string  `json:"operator"`
-	Threshold float64 `json:"threshold"`
-	AgentID   string  `json:"agent_id"` // optional
+	Type           RuleType `json:"type"`     // default metric
+	Severity       Severity `json:"severity"` // default warning
+	Metric         string   `json:"metric"`
+	Operator       string   `json:"operator"`
+	Threshold      float64  `json:"threshold"`
+	AgentID        string   `json:"agent_id"`        // optional
+	Target         string   `json:"target"`          // optional
+	ForSeconds     int      `json:"for_seconds"`     // optional
+	ClearThreshold *float64 `json:"clear_threshold"` // optional
 }
 
 type Alert struct {
 	ID        int64     `json:"id"`
 	RuleID    int64     `json:"rule_id"`
 	AgentID   string    `json:"agent_id"`
+	RuleType  RuleType  `json:"rule_type,omitempty"` // from the rule; empty once the rule is deleted
+	Severity  Severity  `json:"severity,omitempty"`  // from the rule; empty once the rule is deleted
+	Metric    string    `json:"metric"`              // from the rule; empty once the rule is deleted
 	Message   string    `json:"message"`
 	Resolved  bool      `json:"resolved"`
 	CreatedAt time.Time `json:"created_at"`
+	// ResolvedAt is when the condition cleared or the alert was resolved by
+	// hand; nil while open and for alerts resolved before it was recorded.
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	// ResolvedBy is the user who resolved the alert; empty if it cleared
+	// by itself.
+	ResolvedBy string `json:"resolved_by,omitempty"`
+
+	// Acknowledged alerts stay open; someone is on it.
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AckComment     string     `json:"ack_comment,omitempty"`
+
+	// Maintenance is set on alerts raised while their agent was in a
+	// maintenance window. Neither they nor their resolution are notified.
+	Maintenance bool `json:"maintenance,omitempty"`
+}
+
+type AcknowledgeRequest struct {
+	Comment string `json:"comment"`
 }
 
+// Silence suppresses the notifications of matching alerts for a while, e.g.
+// during a known incident. Alerts are still raised and listed.
+type Silence struct {
+	ID int64 `json:"id"`
+	// An alert matches when it matches every matcher that is set.
+	AgentID   string    `json:"agent_id,omitempty"`
+	RuleID    int64     `json:"rule_id,omitempty"`
+	Tag       string    `json:"tag,omitempty"` // agent tag
+	Comment   string    `json:"comment"`
+	CreatedBy string    `json:"created_by"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s Silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+// Matches reports whether alerts of the rule on the agent are silenced.
+func (s Silence) Matches(agent Agent, ruleID int64) bool {
+	return (s.AgentID == "" || s.AgentID == agent.ID) &&
+		(s.RuleID == 0 || s.RuleID == ruleID) &&
+		(s.Tag == "" || agent.HasTag(s.Tag))
+}
+
+type SilenceRequest struct {
+	AgentID  string     `json:"agent_id"`
+	RuleID   int64      `json:"rule_id"`
+	Tag      string     `json:"tag"`
+	Comment  string     `json:"comment"`
+	StartsAt *time.Time `json:"starts_at"` // default now
+	// The silence ends at EndsAt, or DurationSeconds after it starts.
+	EndsAt          *time.Time `json:"ends_at"`
+	DurationSeconds int        `json:"duration_seconds"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeverity(t *testing.T) {
+	if !SeverityCritical.AtLeast(SeverityWarning) || SeverityInfo.AtLeast(SeverityWarning) || !SeverityWarning.AtLeast(SeverityWarning) {
+		t.Error("severities out of order")
+	}
+	if Severity("").Valid() || Severity("fatal").Valid() || !SeverityInfo.Valid() {
+		t.Error("Valid")
+	}
+
+	ch := NotificationChannel{Enabled: true, MinSeverity: SeverityCritical}
+	if ch.Routes(AlertRule{ID: 1, Severity: SeverityWarning}) || !ch.Routes(AlertRule{ID: 1, Severity: SeverityCritical}) {
+		t.Error("channel ignores min_severity")
+	}
+}
+
+func TestSilenceMatches(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	web := Agent{ID: "a1", Tags: []string{"prod", "web"}}
+	db := Agent{ID: "a2", Tags: []string{"prod", "db"}}
+
+	cases := []struct {
+		name    string
+		silence Silence
+		agent   Agent
+		ruleID  int64
+		want    bool
+	}{
+		{"agent", Silence{AgentID: "a1"}, web, 3, true},
+		{"other agent", Silence{AgentID: "a1"}, db, 3, false},
+		{"rule", Silence{RuleID: 3}, db, 3, true},
+		{"other rule", Silence{RuleID: 4}, db, 3, false},
+		{"tag", Silence{Tag: "prod"}, db, 3, true},
+		{"missing tag", Silence{Tag: "web"}, db, 3, false},
+		{"tag and rule", Silence{Tag: "web", RuleID: 3}, web, 3, true},
+		{"tag but not rule", Silence{Tag: "web", RuleID: 4}, web, 3, false},
+	}
+	for _, c := range cases {
+		if got := c.silence.Matches(c.agent, c.ruleID); got != c.want {
+			t.Errorf("%s: Matches = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	s := Silence{StartsAt: now, EndsAt: now.Add(time.Hour)}
+	if !s.Active(now) || s.Active(now.Add(-time.Second)) || s.Active(now.Add(time.Hour)) {
+		t.Error("Active")
+	}
+}
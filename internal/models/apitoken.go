@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// APITokenPrefix marks personal API tokens so they are easy to spot in
+// scripts and secret scanners.
+const APITokenPrefix = "rmm_"
+
+// APIToken lets a user call the management API with
+// "Authorization: Bearer <token>". Its scope caps the user's role, so a
+// viewer-scoped token is read-only even for an admin.
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters of the token, for recognising it
+	Scope      Role       `json:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Revoked    bool       `json:"revoked"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Active reports whether the token is neither revoked nor expired.
+func (t *APIToken) Active() bool {
+	return !t.Revoked && (t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt))
+}
+
+// EffectiveRole is the lower of the user's role and the token scope.
+func (t *APIToken) EffectiveRole(userRole Role) Role {
+	if userRole.Allows(t.Scope) {
+		return t.Scope
+	}
+	return userRole
+}
+
+type APITokenRequest struct {
+	Name          string `json:"name"`
+	Scope         Role   `json:"scope"`           // defaults to the user's role
+	ExpiresInDays int    `json:"expires_in_days"` // 0 = never
+}
+
+// APITokenCreated is returned once on creation; the plain token is never stored.
+type APITokenCreated struct {
+	APIToken
+	Token string `json:"token"`
+}
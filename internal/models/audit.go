@@ -3,10 +3,12 @@ package models
 import "time"
 
 type AuditLog struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username"`
-	Action    string    `json:"action"`
-	Target    string    `json:"target"`
-	Details   string    `json:"details"`
-	CreatedAt time.Time `json:"created_at"`
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Action   string `json:"action"`
+	Target   string `json:"target"`
+	Details  string `json:"details"`
+	// RecordingID links to the transcript of the audited shell or command.
+	RecordingID *int64    `json:"recording_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
 }
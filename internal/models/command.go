@@ -5,25 +5,54 @@ import "time"
 type CommandStatus string
 
 const (
-	CommandPending  CommandStatus = "pending"
-	CommandRunning  CommandStatus = "running"
-	CommandDone     CommandStatus = "done"
-	CommandFailed   CommandStatus = "failed"
+	CommandPending   CommandStatus = "pending"
+	CommandRunning   CommandStatus = "running"
+	CommandDone      CommandStatus = "done"
+	CommandFailed    CommandStatus = "failed"
+	CommandTimedOut  CommandStatus = "timed_out"
+	CommandCancelled CommandStatus = "cancelled"
+	CommandExpired   CommandStatus = "expired" // never delivered: agent stayed offline past the queue TTL
+)
+
+// Finished reports whether the command has reached a final status.
+func (s CommandStatus) Finished() bool {
+	return s != CommandPending && s != CommandRunning
+}
+
+// Output stream names used in CommandOutput.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
 )
 
 type Command struct {
-	ID        int64         `json:"id"`
-	AgentID   string        `json:"agent_id"`
-	Command   string        `json:"command"`
-	Stdout    string        `json:"stdout"`
-	Stderr    string        `json:"stderr"`
-	ExitCode  int           `json:"exit_code"`
-	Status    CommandStatus `json:"status"`
-	CreatedAt time.Time     `json:"created_at"`
+	ID              int64         `json:"id"`
+	AgentID         string        `json:"agent_id"`
+	Command         string        `json:"command"`
+	Stdout          string        `json:"stdout"`
+	Stderr          string        `json:"stderr"`
+	OutputTruncated bool          `json:"output_truncated,omitempty"` // output hit the stored cap; the recording has all of it
+	ExitCode        int           `json:"exit_code"`
+	Status          CommandStatus `json:"status"`
+	TimeoutSeconds  int           `json:"timeout_seconds"`
+	CreatedAt       time.Time     `json:"created_at"`
 }
 
 type CommandRequest struct {
-	Command string `json:"command"`
+	Command        string `json:"command"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"` // 0 = server default
+	// RequireMaintenance rejects the command unless the agent is in a
+	// maintenance window when it is sent.
+	RequireMaintenance bool `json:"require_maintenance,omitempty"`
+}
+
+// CommandOutput is a chunk of output streamed by the agent while a command
+// runs. Offset is the byte offset of Data within its stream.
+type CommandOutput struct {
+	CommandID int64  `json:"command_id"`
+	Stream    string `json:"stream"`
+	Data      string `json:"data"`
+	Offset    int64  `json:"offset"`
 }
 
 type CommandResult struct {
@@ -31,10 +60,19 @@ type CommandResult struct {
 	Stdout    string `json:"stdout"`
 	Stderr    string `json:"stderr"`
 	ExitCode  int    `json:"exit_code"`
+	// Streamed is set when the output was already sent as command_output
+	// chunks; Stdout/Stderr are then empty.
+	Streamed bool `json:"streamed,omitempty"`
+	// Status is set by the agent when the command did not exit on its own
+	// (timed_out, cancelled); otherwise it is derived from ExitCode.
+	Status CommandStatus `json:"status,omitempty"`
 }
 
 // WSMessage is the WebSocket message envelope
 type WSMessage struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
+	// Trace is the W3C trace context (traceparent, tracestate) of the span
+	// that sent the message, so the receiver's spans join the same trace.
+	Trace map[string]string `json:"trace,omitempty"`
 }
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	// 2026-10-17 is a Saturday
+	cases := []struct {
+		expr, from, want string
+	}{
+		{"*/15 * * * *", "2026-10-17 10:07", "2026-10-17 10:15"},
+		{"0 2 * * 6", "2026-10-17 01:59", "2026-10-17 02:00"},
+		{"0 2 * * 6", "2026-10-17 02:00", "2026-10-24 02:00"},
+		{"30 22 * * 1-5", "2026-10-17 12:00", "2026-10-19 22:30"},
+		{"0 0 1 * *", "2026-12-15 00:00", "2027-01-01 00:00"},
+		{"@daily", "2026-10-17 12:00", "2026-10-18 00:00"},
+		{"0 3 * * 7", "2026-10-17 12:00", "2026-10-18 03:00"},      // 7 is Sunday
+		{"0 0 13 * 5", "2026-10-17 12:00", "2026-10-23 00:00"},     // 13th or a Friday
+		{"0 0 29 2 *", "2026-10-17 12:00", "2028-02-29 00:00"},     // leap day
+		{"5/20 8-9 * * *", "2026-10-17 08:30", "2026-10-17 08:45"}, // 5, 25, 45
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("parse %q: %v", c.expr, err)
+			continue
+		}
+		if got := cron.Next(at(c.from)); !got.Equal(at(c.want)) {
+			t.Errorf("%q after %s = %s, want %s", c.expr, c.from, got, c.want)
+		}
+	}
+
+	prev := []struct {
+		expr, from, want string
+	}{
+		{"*/15 * * * *", "2026-10-17 10:07", "2026-10-17 10:00"},
+		{"0 2 * * 6", "2026-10-17 02:00", "2026-10-17 02:00"},
+		{"0 2 * * 6", "2026-10-17 01:59", "2026-10-10 02:00"},
+		{"30 22 * * 1-5", "2026-10-19 12:00", "2026-10-16 22:30"},
+		{"0 0 1 * *", "2027-01-01 00:00", "2027-01-01 00:00"},
+		{"0 0 1 * *", "2026-12-31 23:59", "2026-12-01 00:00"},
+		{"0 0 13 * 5", "2026-10-17 12:00", "2026-10-16 00:00"},
+		{"0 0 29 2 *", "2026-10-17 12:00", "2024-02-29 00:00"},
+		{"5/20 8-9 * * *", "2026-10-17 10:30", "2026-10-17 09:45"},
+	}
+	for _, c := range prev {
+		cron, _ := ParseCron(c.expr)
+		if got := cron.Prev(at(c.from)); !got.Equal(at(c.want)) {
+			t.Errorf("%q before %s = %s, want %s", c.expr, c.from, got, c.want)
+		}
+	}
+
+	if cron, _ := ParseCron("0 0 30 2 *"); !cron.Next(at("2026-10-17 12:00")).IsZero() || !cron.Prev(at("2026-10-17 12:00")).IsZero() {
+		t.Error("Feb 30 should never fire")
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) should fail", expr)
+		}
+	}
+}
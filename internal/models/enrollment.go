@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// Headers used by agents to authenticate against the server.
+const (
+	HeaderAgentKey        = "X-Agent-Key"
+	HeaderAgentSecret     = "X-Agent-Secret"
+	HeaderEnrollmentToken = "X-Enrollment-Token"
+	// HeaderCertRequired is set on 401 responses when the server wants the
+	// agent to obtain a new client certificate.
+	HeaderCertRequired = "X-Agent-Cert-Required"
+)
+
+type EnrollmentToken struct {
+	ID          int64      `json:"id"`
+	Description string     `json:"description"`
+	MaxUses     int        `json:"max_uses"` // 0 = unlimited
+	Uses        int        `json:"uses"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Revoked     bool       `json:"revoked"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Usable reports whether the token can still be exchanged for an agent secret.
+func (t *EnrollmentToken) Usable() bool {
+	if t.Revoked {
+		return false
+	}
+	if t.MaxUses > 0 && t.Uses >= t.MaxUses {
+		return false
+	}
+	return t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt)
+}
+
+type EnrollmentTokenRequest struct {
+	Description    string `json:"description"`
+	MaxUses        int    `json:"max_uses"`         // 1 = one-time, 0 = unlimited
+	ExpiresInHours int    `json:"expires_in_hours"` // 0 = never
+}
+
+// EnrollmentTokenCreated is returned once on creation; the plain token is never stored.
+type EnrollmentTokenCreated struct {
+	EnrollmentToken
+	Token string `json:"token"`
+}
+
+type EnrollRequest struct {
+	AgentID     string `json:"agent_id"`
+	Token       string `json:"token"`
+	DisplayName string `json:"display_name"`
+	CSR         string `json:"csr,omitempty"` // PEM certificate request, used when the server runs mTLS
+}
+
+type EnrollResponse struct {
+	AgentID       string `json:"agent_id"`
+	Secret        string `json:"secret"`
+	Certificate   string `json:"certificate,omitempty"`    // PEM client certificate
+	CACertificate string `json:"ca_certificate,omitempty"` // PEM CA the server certificate chains to
+}
+
+// CertificateRequest asks the server to issue a client certificate to an
+// already enrolled agent.
+type CertificateRequest struct {
+	CSR string `json:"csr"`
+}
+
+type CertificateResponse struct {
+	Certificate   string `json:"certificate"`
+	CACertificate string `json:"ca_certificate"`
+}
+
+// CredentialRotated is the agent's answer to a credential_rotate request,
+// sent once the new secret is saved or saving it failed.
+type CredentialRotated struct {
+	RequestID string `json:"request_id"`
+	Error     string `json:"error,omitempty"`
+}
@@ -7,12 +7,14 @@ type TransferStatus string
 
 const (
 	TransferToAgent   TransferDirection = "to_agent"   // Server → Agent (upload to agent)
-	TransferFromAgent TransferDirection = "from_agent"  // Agent → Server (download from agent)
+	TransferFromAgent TransferDirection = "from_agent" // Agent → Server (download from agent)
 
-	TransferPending     TransferStatus = "pending"
+	TransferPending      TransferStatus = "pending"
 	TransferTransferring TransferStatus = "transferring"
-	TransferDone        TransferStatus = "done"
-	TransferFailed      TransferStatus = "failed"
+	TransferDone         TransferStatus = "done"
+	TransferFailed       TransferStatus = "failed"
+	TransferExpired      TransferStatus = "expired"
+	TransferCancelled    TransferStatus = "cancelled"
 )
 
 type FileTransfer struct {
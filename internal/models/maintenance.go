@@ -0,0 +1,137 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// MaintenanceWindow is planned downtime of some agents, e.g. a patching
+// night. Alerts raised during a window are recorded but not notified.
+type MaintenanceWindow struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// A recurring window starts whenever its cron Schedule fires, in
+	// Timezone; a one-off window has no schedule and starts at StartsAt.
+	Schedule        string     `json:"schedule,omitempty"`
+	Timezone        string     `json:"timezone,omitempty"`
+	StartsAt        *time.Time `json:"starts_at,omitempty"`
+	DurationSeconds int        `json:"duration_seconds"`
+	// The window covers the listed agents and the agents with any of the
+	// tags.
+	AgentIDs  []string  `json:"agent_ids"`
+	Tags      []string  `json:"tags"`
+	Comment   string    `json:"comment"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+
+	cron *Cron // Schedule, parsed on first use
+}
+
+func (w MaintenanceWindow) Duration() time.Duration {
+	return time.Duration(w.DurationSeconds) * time.Second
+}
+
+func (w MaintenanceWindow) location() *time.Location {
+	if loc, err := time.LoadLocation(w.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// schedule returns the parsed Schedule, or nil if it doesn't parse.
+func (w *MaintenanceWindow) schedule() *Cron {
+	if w.cron == nil {
+		w.cron, _ = ParseCron(w.Schedule)
+	}
+	return w.cron
+}
+
+// ActiveAt returns when the window that is under way at now ends; ok is false
+// if none is.
+func (w *MaintenanceWindow) ActiveAt(now time.Time) (end time.Time, ok bool) {
+	var start time.Time
+	if w.Schedule == "" {
+		if w.StartsAt == nil {
+			return time.Time{}, false
+		}
+		start = *w.StartsAt
+	} else {
+		cron := w.schedule()
+		if cron == nil {
+			return time.Time{}, false
+		}
+		// Occurrences overlap when the window outlasts the schedule's
+		// interval; the last one to start ends last
+		if start = cron.Prev(now.In(w.location())); start.IsZero() {
+			return time.Time{}, false
+		}
+	}
+	end = start.Add(w.Duration())
+	return end, !now.Before(start) && now.Before(end)
+}
+
+// NextStart returns when the window next starts after now; zero if it never
+// does again.
+func (w *MaintenanceWindow) NextStart(now time.Time) time.Time {
+	if w.Schedule == "" {
+		if w.StartsAt == nil || !w.StartsAt.After(now) {
+			return time.Time{}
+		}
+		return *w.StartsAt
+	}
+	cron := w.schedule()
+	if cron == nil {
+		return time.Time{}
+	}
+	return cron.Next(now.In(w.location()))
+}
+
+// Ended reports whether a one-off window is over; recurring windows never
+// end.
+func (w MaintenanceWindow) Ended(now time.Time) bool {
+	return w.Schedule == "" && w.StartsAt != nil && !now.Before(w.StartsAt.Add(w.Duration()))
+}
+
+func (w MaintenanceWindow) Covers(agent Agent) bool {
+	if slices.Contains(w.AgentIDs, agent.ID) {
+		return true
+	}
+	return slices.ContainsFunc(w.Tags, agent.HasTag)
+}
+
+// ActiveMaintenance returns the window of windows agent is in at now and
+// when it ends, or nil. Of overlapping windows it returns the one ending
+// last.
+func ActiveMaintenance(windows []MaintenanceWindow, agent Agent, now time.Time) (*MaintenanceWindow, time.Time) {
+	var active *MaintenanceWindow
+	var activeEnd time.Time
+	for i := range windows {
+		if !windows[i].Covers(agent) {
+			continue
+		}
+		if end, ok := windows[i].ActiveAt(now); ok && end.After(activeEnd) {
+			active, activeEnd = &windows[i], end
+		}
+	}
+	return active, activeEnd
+}
+
+type MaintenanceWindowRequest struct {
+	Name            string     `json:"name"`
+	Schedule        string     `json:"schedule"` // cron; empty for a one-off window
+	Timezone        string     `json:"timezone"` // default UTC
+	StartsAt        *time.Time `json:"starts_at"`
+	DurationSeconds int        `json:"duration_seconds"`
+	AgentIDs        []string   `json:"agent_ids"`
+	Tags            []string   `json:"tags"`
+	Comment         string     `json:"comment"`
+}
+
+// AgentMaintenance tells automation whether an agent is in a maintenance
+// window, so jobs that must only run inside one can check first.
+type AgentMaintenance struct {
+	AgentID       string             `json:"agent_id"`
+	InMaintenance bool               `json:"in_maintenance"`
+	Window        *MaintenanceWindow `json:"window,omitempty"`
+	EndsAt        *time.Time         `json:"ends_at,omitempty"`
+}
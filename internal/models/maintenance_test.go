@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	// Saturdays 02:00-06:00
+	weekly := MaintenanceWindow{ID: 1, Schedule: "0 2 * * 6", DurationSeconds: 4 * 3600, Tags: []string{"patch"}}
+	sat := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		at   time.Time
+		want bool
+	}{
+		{sat.Add(time.Hour + 59*time.Minute), false},
+		{sat.Add(2 * time.Hour), true},
+		{sat.Add(5*time.Hour + 59*time.Minute), true},
+		{sat.Add(6 * time.Hour), false},
+		{sat.Add(7*24*time.Hour + 3*time.Hour), true},
+	}
+	for _, c := range cases {
+		end, ok := weekly.ActiveAt(c.at)
+		if ok != c.want {
+			t.Errorf("active at %s = %v, want %v", c.at, ok, c.want)
+		}
+		if ok && end.Sub(c.at) > weekly.Duration() {
+			t.Errorf("window active at %s ends at %s", c.at, end)
+		}
+	}
+	if next := weekly.NextStart(sat.Add(3 * time.Hour)); !next.Equal(sat.Add(7*24*time.Hour + 2*time.Hour)) {
+		t.Errorf("next start = %s", next)
+	}
+
+	// Every 5 minutes for 10 minutes: always on, ending 10 minutes after
+	// the latest start
+	overlapping := MaintenanceWindow{Schedule: "*/5 * * * *", DurationSeconds: 600}
+	if end, ok := overlapping.ActiveAt(sat.Add(7 * time.Minute)); !ok || !end.Equal(sat.Add(15*time.Minute)) {
+		t.Errorf("overlapping window: end = %s, %v", end, ok)
+	}
+
+	start := sat.Add(12 * time.Hour)
+	once := MaintenanceWindow{ID: 2, StartsAt: &start, DurationSeconds: 3600, AgentIDs: []string{"a2"}}
+	if _, ok := once.ActiveAt(start.Add(30 * time.Minute)); !ok {
+		t.Error("one-off window should be active")
+	}
+	if once.Ended(start.Add(59*time.Minute)) || !once.Ended(start.Add(time.Hour)) {
+		t.Error("one-off window should end after an hour")
+	}
+	if weekly.Ended(sat.AddDate(1, 0, 0)) {
+		t.Error("recurring windows never end")
+	}
+
+	windows := []MaintenanceWindow{weekly, once}
+	tagged := Agent{ID: "a1", Tags: []string{"db", "patch"}}
+	if w, _ := ActiveMaintenance(windows, tagged, sat.Add(3*time.Hour)); w == nil || w.ID != 1 {
+		t.Errorf("tagged agent: got window %+v", w)
+	}
+	if w, _ := ActiveMaintenance(windows, tagged, start.Add(time.Minute)); w != nil {
+		t.Errorf("a1 is not in window 2, got %+v", w)
+	}
+	if w, _ := ActiveMaintenance(windows, Agent{ID: "a2"}, start.Add(time.Minute)); w == nil || w.ID != 2 {
+		t.Errorf("listed agent: got window %+v", w)
+	}
+}
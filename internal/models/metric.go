@@ -0,0 +1,255 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample is one value of a time series, identified by its name and labels,
+// e.g. disk_used_percent{mount="/var"}.
+type Sample struct {
+	Name      string    `json:"name"`
+	Labels    Labels    `json:"labels,omitempty"`
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"` // set by the server when stored
+}
+
+// Labels tell apart the series of one metric name, e.g. the mount point of a
+// disk metric. The label "agent" is reserved: queries use it for the agent ID.
+type Labels map[string]string
+
+// LabelAgent is the pseudo-label selectors use to match the agent ID.
+const LabelAgent = "agent"
+
+// String formats the labels like {fstype="ext4",mount="/"}, sorted by name;
+// empty labels give "".
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + "=" + strconv.Quote(l[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Series is the points of one metric series of one agent.
+type Series struct {
+	AgentID string  `json:"agent_id"`
+	Name    string  `json:"name"`
+	Labels  Labels  `json:"labels"`
+	Points  []Point `json:"points"`
+}
+
+// Point is one sample, or for rolled-up resolutions the average of a bucket
+// starting at Timestamp along with its minimum and maximum.
+type Point struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+	Min       *float64  `json:"min,omitempty"`
+	Max       *float64  `json:"max,omitempty"`
+}
+
+// Resolution is how finely stored points are spaced: every heartbeat, or
+// rolled up into 5-minute or 1-hour buckets.
+type Resolution string
+
+const (
+	ResolutionRaw Resolution = "raw"
+	Resolution5m  Resolution = "5m"
+	Resolution1h  Resolution = "1h"
+)
+
+// Bucket is the bucket width; 0 for raw.
+func (r Resolution) Bucket() time.Duration {
+	switch r {
+	case Resolution5m:
+		return 5 * time.Minute
+	case Resolution1h:
+		return time.Hour
+	}
+	return 0
+}
+
+func (r Resolution) Valid() bool {
+	return r == ResolutionRaw || r == Resolution5m || r == Resolution1h
+}
+
+// AlignedSeries is a series resampled onto shared, evenly spaced timestamps.
+// Each step holds the average, minimum and maximum of the points in it, or
+// null when it has none.
+type AlignedSeries struct {
+	Name   string     `json:"name"`
+	Labels Labels     `json:"labels"`
+	Values []*float64 `json:"values"`
+	Min    []*float64 `json:"min"`
+	Max    []*float64 `json:"max"`
+}
+
+// AlignSeries resamples series into steps of step, the first one holding
+// from and the last one to. Steps start at multiples of step since the Unix
+// epoch, so a chart refreshed later lines up with the previous one. It
+// returns the start of every step in Unix seconds.
+func AlignSeries(series []Series, from, to time.Time, step time.Duration) ([]int64, []AlignedSeries) {
+	width := int64(step / time.Second)
+	start := from.Unix() / width * width
+	n := max(int((to.Unix()-start)/width)+1, 0)
+
+	timestamps := make([]int64, n)
+	for i := range timestamps {
+		timestamps[i] = start + int64(i)*width
+	}
+
+	aligned := make([]AlignedSeries, len(series))
+	for si, s := range series {
+		a := AlignedSeries{
+			Name:   s.Name,
+			Labels: s.Labels,
+			Values: make([]*float64, n),
+			Min:    make([]*float64, n),
+			Max:    make([]*float64, n),
+		}
+		sums := make([]float64, n)
+		counts := make([]int, n)
+		for _, p := range s.Points {
+			i := int((p.Timestamp.Unix() - start) / width)
+			if p.Timestamp.Unix() < start || i >= n {
+				continue
+			}
+			lo, hi := p.Value, p.Value
+			if p.Min != nil {
+				lo = *p.Min
+			}
+			if p.Max != nil {
+				hi = *p.Max
+			}
+			if counts[i] == 0 {
+				a.Min[i], a.Max[i] = &lo, &hi
+			} else {
+				*a.Min[i] = min(*a.Min[i], lo)
+				*a.Max[i] = max(*a.Max[i], hi)
+			}
+			sums[i] += p.Value
+			counts[i]++
+		}
+		for i, c := range counts {
+			if c > 0 {
+				avg := sums[i] / float64(c)
+				a.Values[i] = &avg
+			}
+		}
+		aligned[si] = a
+	}
+	return timestamps, aligned
+}
+
+var metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// ValidMetricName reports whether name can be used as a metric or label
+// name (Prometheus rules: letters, digits, _ and :, not starting with a digit).
+func ValidMetricName(name string) bool {
+	return metricNameRe.MatchString(name)
+}
+
+// Metric names reported by the agent. The first three come with every
+// heartbeat, also from agents that predate samples.
+const (
+	MetricCPUPercent       = "cpu_percent"
+	MetricMemoryPercent    = "memory_percent"
+	MetricDiskPercent      = "disk_percent" // root filesystem only
+	MetricCPUCorePercent   = "cpu_core_percent"
+	MetricLoad1            = "load1"
+	MetricLoad5            = "load5"
+	MetricLoad15           = "load15"
+	MetricSwapPercent      = "swap_percent"
+	MetricUptimeSeconds    = "uptime_seconds"
+	MetricDiskUsedPercent  = "disk_used_percent"
+	MetricDiskFreeBytes    = "disk_free_bytes"
+	MetricDiskTotalBytes   = "disk_total_bytes"
+	MetricDiskInodesPct    = "disk_inodes_used_percent"
+	MetricNetRxBytesPerSec = "net_rx_bytes_per_sec"
+	MetricNetTxBytesPerSec = "net_tx_bytes_per_sec"
+)
+
+// Labels set by the agent on per-core, per-disk and per-interface metrics.
+const (
+	LabelCore      = "core"
+	LabelMount     = "mount"
+	LabelFSType    = "fstype"
+	LabelInterface = "interface"
+)
+
+// TargetLabels maps the metrics that exist once per core, mount point or
+// interface to the label naming it. Alert rules on them may name a target.
+var TargetLabels = map[string]string{
+	MetricCPUCorePercent:   LabelCore,
+	MetricDiskUsedPercent:  LabelMount,
+	MetricDiskFreeBytes:    LabelMount,
+	MetricDiskTotalBytes:   LabelMount,
+	MetricDiskInodesPct:    LabelMount,
+	MetricNetRxBytesPerSec: LabelInterface,
+	MetricNetTxBytesPerSec: LabelInterface,
+}
+
+// Metric is the CPU, memory and root disk usage of one heartbeat, the
+// summary shown on the dashboard.
+type Metric struct {
+	AgentID       string    `json:"agent_id"`
+	CPUPercent    float64   `json:"cpu_percent"`
+	MemoryPercent float64   `json:"memory_percent"`
+	DiskPercent   float64   `json:"disk_percent"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// Samples converts the summary into samples.
+func (m Metric) Samples() []Sample {
+	return []Sample{
+		{Name: MetricCPUPercent, Value: m.CPUPercent},
+		{Name: MetricMemoryPercent, Value: m.MemoryPercent},
+		{Name: MetricDiskPercent, Value: m.DiskPercent},
+	}
+}
+
+// MetricFromSamples picks the summary out of one heartbeat's samples; nil if
+// they hold none of it.
+func MetricFromSamples(agentID string, samples []Sample) *Metric {
+	m := &Metric{AgentID: agentID}
+	found := false
+	for _, s := range samples {
+		if len(s.Labels) != 0 {
+			continue
+		}
+		switch s.Name {
+		case MetricCPUPercent:
+			m.CPUPercent = s.Value
+		case MetricMemoryPercent:
+			m.MemoryPercent = s.Value
+		case MetricDiskPercent:
+			m.DiskPercent = s.Value
+		default:
+			continue
+		}
+		found = true
+		if s.Timestamp.After(m.Timestamp) {
+			m.Timestamp = s.Timestamp
+		}
+	}
+	if !found {
+		return nil
+	}
+	return m
+}
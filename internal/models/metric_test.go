@@ -0,0 +1,50 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlignSeries(t *testing.T) {
+	t0 := time.Unix(1_800_000_000, 0) // a multiple of 60
+	lo, hi := 1.0, 9.0
+	series := []Series{
+		{Name: "cpu_percent", Points: []Point{
+			{Timestamp: t0.Add(-5 * time.Second), Value: 100}, // before from's step
+			{Timestamp: t0.Add(10 * time.Second), Value: 10},
+			{Timestamp: t0.Add(40 * time.Second), Value: 20},
+			{Timestamp: t0.Add(130 * time.Second), Value: 30},
+		}},
+		{Name: "cpu_percent", Labels: Labels{"core": "0"}, Points: []Point{
+			{Timestamp: t0.Add(60 * time.Second), Value: 5, Min: &lo, Max: &hi},
+		}},
+	}
+
+	timestamps, aligned := AlignSeries(series, t0.Add(20*time.Second), t0.Add(150*time.Second), time.Minute)
+	if len(timestamps) != 3 || timestamps[0] != t0.Unix() || timestamps[2] != t0.Unix()+120 {
+		t.Fatalf("timestamps = %v", timestamps)
+	}
+	if len(aligned) != 2 || aligned[1].Labels["core"] != "0" {
+		t.Fatalf("aligned = %+v", aligned)
+	}
+
+	a := aligned[0]
+	if *a.Values[0] != 15 || *a.Min[0] != 10 || *a.Max[0] != 20 {
+		t.Errorf("step 0 = %v/%v/%v, want 15/10/20", *a.Values[0], *a.Min[0], *a.Max[0])
+	}
+	if a.Values[1] != nil || a.Min[1] != nil {
+		t.Errorf("expected an empty step to be null")
+	}
+	if *a.Values[2] != 30 {
+		t.Errorf("step 2 = %v, want 30", *a.Values[2])
+	}
+
+	// Rolled-up points keep their bucket's min and max
+	b := aligned[1]
+	if *b.Values[1] != 5 || *b.Min[1] != 1 || *b.Max[1] != 9 {
+		t.Errorf("rollup step = %v/%v/%v, want 5/1/9", *b.Values[1], *b.Min[1], *b.Max[1])
+	}
+	if lo != 1 || hi != 9 {
+		t.Errorf("AlignSeries modified the input points")
+	}
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// ChannelType is how a notification channel delivers alerts.
+type ChannelType string
+
+const (
+	ChannelWebhook ChannelType = "webhook" // JSON POST, optionally HMAC-signed
+	ChannelEmail   ChannelType = "email"   // SMTP
+	ChannelSlack   ChannelType = "slack"   // Slack incoming webhook
+	ChannelTeams   ChannelType = "teams"   // Microsoft Teams incoming webhook
+	ChannelDiscord ChannelType = "discord" // Discord webhook
+)
+
+func (t ChannelType) Valid() bool {
+	switch t {
+	case ChannelWebhook, ChannelEmail, ChannelSlack, ChannelTeams, ChannelDiscord:
+		return true
+	}
+	return false
+}
+
+// NotificationChannel is a destination alerts are sent to when they fire and
+// when they resolve.
+type NotificationChannel struct {
+	ID     int64         `json:"id"`
+	Name   string        `json:"name"`
+	Type   ChannelType   `json:"type"`
+	Config ChannelConfig `json:"config"`
+	// RuleIDs limits the channel to alerts of these rules; empty = every rule.
+	RuleIDs []int64 `json:"rule_ids"`
+	// MinSeverity limits the channel to alerts at least this severe; empty =
+	// every severity.
+	MinSeverity Severity  `json:"min_severity,omitempty"`
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Routes reports whether alerts of the rule go to this channel.
+func (c NotificationChannel) Routes(rule AlertRule) bool {
+	if !c.Enabled {
+		return false
+	}
+	if c.MinSeverity != "" && !rule.Severity.AtLeast(c.MinSeverity) {
+		return false
+	}
+	return len(c.RuleIDs) == 0 || slices.Contains(c.RuleIDs, rule.ID)
+}
+
+// ChannelConfig holds the settings of every channel type; each type uses
+// its own.
+type ChannelConfig struct {
+	// URL is where webhook, Slack, Teams and Discord channels post to.
+	URL string `json:"url,omitempty"`
+	// Secret signs generic webhook bodies with HMAC-SHA256.
+	Secret string `json:"secret,omitempty"`
+
+	SMTPHost     string `json:"smtp_host,omitempty"`
+	SMTPPort     int    `json:"smtp_port,omitempty"`
+	SMTPUsername string `json:"smtp_username,omitempty"`
+	SMTPPassword string `json:"smtp_password,omitempty"`
+	// SMTPTLS connects with TLS from the start (port 465); otherwise
+	// STARTTLS is used when the server offers it.
+	SMTPTLS bool     `json:"smtp_tls,omitempty"`
+	From    string   `json:"from,omitempty"`
+	To      []string `json:"to,omitempty"`
+}
+
+// Redacted returns the config without its secrets, for API responses.
+func (c ChannelConfig) Redacted() ChannelConfig {
+	c.Secret = ""
+	c.SMTPPassword = ""
+	return c
+}
+
+type NotificationChannelRequest struct {
+	Name        string        `json:"name"`
+	Type        ChannelType   `json:"type"`
+	Config      ChannelConfig `json:"config"` // on update, empty secrets keep the stored ones
+	RuleIDs     []int64       `json:"rule_ids"`
+	MinSeverity Severity      `json:"min_severity"`
+	Enabled     *bool         `json:"enabled"` // default true
+}
+
+// AlertEvent is what a notification tells about an alert.
+type AlertEvent string
+
+const (
+	EventFiring   AlertEvent = "firing"
+	EventResolved AlertEvent = "resolved"
+	EventTest     AlertEvent = "test" // sent from the channel's Test button
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending DeliveryStatus = "pending" // being sent or waiting to retry
+	DeliverySent    DeliveryStatus = "sent"
+	DeliveryFailed  DeliveryStatus = "failed" // gave up
+)
+
+// NotificationDelivery is the log entry of one notification to one channel.
+type NotificationDelivery struct {
+	ID          int64          `json:"id"`
+	ChannelID   int64          `json:"channel_id"`
+	ChannelName string         `json:"channel_name"` // empty once the channel is deleted
+	AlertID     int64          `json:"alert_id"`     // 0 for test notifications
+	Event       AlertEvent     `json:"event"`
+	Status      DeliveryStatus `json:"status"`
+	Attempts    int            `json:"attempts"`
+	Error       string         `json:"error,omitempty"` // of the last attempt
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Process is one entry of an agent's process list.
+type Process struct {
+	PID        int32     `json:"pid"`
+	PPID       int32     `json:"ppid"`
+	Name       string    `json:"name"`
+	Username   string    `json:"username"`
+	CPUPercent float64   `json:"cpu_percent"` // of one core, like top; can exceed 100
+	RSS        uint64    `json:"rss"`         // resident memory in bytes
+	Cmdline    string    `json:"cmdline"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// ProcessSignal is a signal the dashboard may send to a process.
+type ProcessSignal string
+
+const (
+	SignalTerm ProcessSignal = "term" // ask the process to exit
+	SignalKill ProcessSignal = "kill" // force it
+	SignalInt  ProcessSignal = "int"  // Unix only
+	SignalHup  ProcessSignal = "hup"  // Unix only
+)
+
+// Valid reports whether s is a known signal.
+func (s ProcessSignal) Valid() bool {
+	switch s {
+	case SignalTerm, SignalKill, SignalInt, SignalHup:
+		return true
+	}
+	return false
+}
+
+type ProcessKillRequest struct {
+	Signal ProcessSignal `json:"signal"` // default term
+}
+
+// ProcessList is the agent's answer to a process_list request.
+type ProcessList struct {
+	RequestID string    `json:"request_id"`
+	Processes []Process `json:"processes"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ProcessKillResult is the agent's answer to a process_kill request.
+type ProcessKillResult struct {
+	RequestID string `json:"request_id"`
+	PID       int32  `json:"pid"`
+	Name      string `json:"name"` // of the signalled process, for the audit log
+	Error     string `json:"error,omitempty"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+type QueueKind string
+type QueueStatus string
+
+const (
+	QueueCommand      QueueKind = "command"
+	QueueFileTransfer QueueKind = "file_transfer"
+
+	QueueQueued    QueueStatus = "queued"
+	QueueDelivered QueueStatus = "delivered"
+	QueueExpired   QueueStatus = "expired"
+	QueueCancelled QueueStatus = "cancelled"
+)
+
+// QueuedMessage is a WebSocket message held for an agent until it is
+// connected. RefID points at the command or file transfer it belongs to.
+type QueuedMessage struct {
+	ID          int64       `json:"id"`
+	AgentID     string      `json:"agent_id"`
+	Kind        QueueKind   `json:"kind"`
+	RefID       int64       `json:"ref_id"`
+	Summary     string      `json:"summary"` // command line or file name, for display
+	Payload     []byte      `json:"-"`       // marshalled WSMessage
+	Status      QueueStatus `json:"status"`
+	CreatedAt   time.Time   `json:"created_at"`
+	ExpiresAt   time.Time   `json:"expires_at"`
+	DeliveredAt *time.Time  `json:"delivered_at,omitempty"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+type RecordingKind string
+
+const (
+	RecordingShell   RecordingKind = "shell"   // Ref is the shell session ID
+	RecordingCommand RecordingKind = "command" // Ref is the command ID
+)
+
+// Recording is an asciicast transcript of a shell session or command.
+type Recording struct {
+	ID        int64         `json:"id"`
+	Kind      RecordingKind `json:"kind"`
+	AgentID   string        `json:"agent_id"`
+	Ref       string        `json:"ref"`
+	Username  string        `json:"username"`
+	Title     string        `json:"title"`
+	Path      string        `json:"-"`
+	Size      int64         `json:"size"`
+	StartedAt time.Time     `json:"started_at"`
+	EndedAt   *time.Time    `json:"ended_at,omitempty"`
+}
+
+// Duration is how long the recorded activity lasted, or has lasted so far.
+func (r *Recording) Duration() time.Duration {
+	end := time.Now()
+	if r.EndedAt != nil {
+		end = *r.EndedAt
+	}
+	return end.Sub(r.StartedAt).Round(time.Second)
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MatchOp compares a label value in a selector.
+type MatchOp string
+
+const (
+	MatchEqual     MatchOp = "="
+	MatchNotEqual  MatchOp = "!="
+	MatchRegexp    MatchOp = "=~"
+	MatchNotRegexp MatchOp = "!~"
+)
+
+// LabelMatcher is one condition of a selector, e.g. mount=~"/var.*".
+// Regular expressions must match the whole value. A missing label has the
+// value "".
+type LabelMatcher struct {
+	Label string
+	Op    MatchOp
+	Value string
+	re    *regexp.Regexp
+}
+
+func (m LabelMatcher) matches(value string) bool {
+	switch m.Op {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+// Selector picks series by metric name and labels, in the Prometheus syntax:
+//
+//	disk_used_percent{mount="/var",agent=~"web-.*"}
+type Selector struct {
+	Name     string
+	Matchers []LabelMatcher
+}
+
+// Matches reports whether a series of agentID with labels is selected. The
+// agent ID is matched as the label "agent".
+func (s *Selector) Matches(agentID string, labels Labels) bool {
+	for _, m := range s.Matchers {
+		value := labels[m.Label]
+		if m.Label == LabelAgent {
+			value = agentID
+		}
+		if !m.matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Selector) String() string {
+	if len(s.Matchers) == 0 {
+		return s.Name
+	}
+	parts := make([]string, len(s.Matchers))
+	for i, m := range s.Matchers {
+		parts[i] = m.Label + string(m.Op) + strconv.Quote(m.Value)
+	}
+	return s.Name + "{" + strings.Join(parts, ",") + "}"
+}
+
+// ParseSelector parses a selector. The metric name is required.
+func ParseSelector(input string) (*Selector, error) {
+	input = strings.TrimSpace(input)
+	name, rest, hasLabels := strings.Cut(input, "{")
+	name = strings.TrimSpace(name)
+	if !ValidMetricName(name) {
+		return nil, fmt.Errorf("invalid metric name %q", name)
+	}
+	sel := &Selector{Name: name}
+	if !hasLabels {
+		return sel, nil
+	}
+	rest = strings.TrimSpace(rest)
+	if !strings.HasSuffix(rest, "}") {
+		return nil, fmt.Errorf("missing } in selector")
+	}
+	rest = strings.TrimSpace(strings.TrimSuffix(rest, "}"))
+
+	for rest != "" {
+		m, remaining, err := parseMatcher(rest)
+		if err != nil {
+			return nil, err
+		}
+		sel.Matchers = append(sel.Matchers, m)
+		rest = strings.TrimSpace(remaining)
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("expected , after %s%s%q", m.Label, m.Op, m.Value)
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+	return sel, nil
+}
+
+// parseMatcher reads one label matcher off the front of s.
+func parseMatcher(s string) (LabelMatcher, string, error) {
+	var m LabelMatcher
+	i := strings.IndexAny(s, "=!")
+	if i < 0 {
+		return m, "", fmt.Errorf("expected a label matcher at %q", s)
+	}
+	m.Label = strings.TrimSpace(s[:i])
+	if !ValidMetricName(m.Label) {
+		return m, "", fmt.Errorf("invalid label name %q", m.Label)
+	}
+	s = s[i:]
+	for _, op := range []MatchOp{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+		if strings.HasPrefix(s, string(op)) {
+			m.Op = op
+			s = strings.TrimSpace(s[len(op):])
+			break
+		}
+	}
+	if m.Op == "" {
+		return m, "", fmt.Errorf("invalid operator after %s", m.Label)
+	}
+
+	quoted, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return m, "", fmt.Errorf("label %s: value must be a quoted string", m.Label)
+	}
+	m.Value, _ = strconv.Unquote(quoted)
+	if m.Op == MatchRegexp || m.Op == MatchNotRegexp {
+		if m.re, err = regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+			return m, "", fmt.Errorf("label %s: %w", m.Label, err)
+		}
+	}
+	return m, s[len(quoted):], nil
+}
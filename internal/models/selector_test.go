@@ -0,0 +1,66 @@
+package models
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	sel, err := ParseSelector(` disk_used_percent{ mount=~"/var.*", fstype!="tmpfs",agent="web-1" } `)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if sel.Name != "disk_used_percent" || len(sel.Matchers) != 3 {
+		t.Fatalf("unexpected selector %+v", sel)
+	}
+	if got := sel.String(); got != `disk_used_percent{mount=~"/var.*",fstype!="tmpfs",agent="web-1"}` {
+		t.Errorf("String() = %s", got)
+	}
+
+	cases := []struct {
+		agent  string
+		labels Labels
+		want   bool
+	}{
+		{"web-1", Labels{"mount": "/var", "fstype": "ext4"}, true},
+		{"web-1", Labels{"mount": "/var/lib", "fstype": "xfs"}, true},
+		{"web-1", Labels{"mount": "/", "fstype": "ext4"}, false},     // regex must match the whole value
+		{"web-1", Labels{"mount": "/var", "fstype": "tmpfs"}, false}, // !=
+		{"web-2", Labels{"mount": "/var", "fstype": "ext4"}, false},  // agent pseudo-label
+		{"web-1", Labels{"mount": "/var"}, true},                     // missing label is ""
+	}
+	for _, c := range cases {
+		if got := sel.Matches(c.agent, c.labels); got != c.want {
+			t.Errorf("Matches(%s, %v) = %v, want %v", c.agent, c.labels, got, c.want)
+		}
+	}
+
+	if sel, err := ParseSelector("cpu_percent"); err != nil || sel.Name != "cpu_percent" || len(sel.Matchers) != 0 {
+		t.Errorf("bare name: %+v, %v", sel, err)
+	}
+	if sel, err := ParseSelector(`net_rx_bytes_per_sec{interface="eth\"0"}`); err != nil || sel.Matchers[0].Value != `eth"0` {
+		t.Errorf("escaped quote: %+v, %v", sel, err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"{mount=\"/\"}",
+		"1cpu",
+		`cpu{mount="/"`,
+		`cpu{mount=/}`,
+		`cpu{mount~"/"}`,
+		`cpu{mount="/" fstype="x"}`,
+		`cpu{mount=~"("}`,
+		`cpu{9x="a"}`,
+	} {
+		if _, err := ParseSelector(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestLabelsString(t *testing.T) {
+	if got := (Labels{"mount": `C:\`, "fstype": "NTFS"}).String(); got != `{fstype="NTFS",mount="C:\\"}` {
+		t.Errorf("got %s", got)
+	}
+	if got := Labels(nil).String(); got != "" {
+		t.Errorf("empty labels: %q", got)
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// ShellSession records an interactive shell opened on an agent.
+type ShellSession struct {
+	ID            string     `json:"id"`
+	AgentID       string     `json:"agent_id"`
+	Username      string     `json:"username"`
+	RecordingPath string     `json:"-"`
+	RecordingID   int64      `json:"recording_id,omitempty"`
+	ExitCode      int        `json:"exit_code"`
+	StartedAt     time.Time  `json:"started_at"`
+	EndedAt       *time.Time `json:"ended_at,omitempty"`
+}
+
+// Duration is how long the session lasted, or has lasted so far.
+func (s *ShellSession) Duration() time.Duration {
+	end := time.Now()
+	if s.EndedAt != nil {
+		end = *s.EndedAt
+	}
+	return end.Sub(s.StartedAt).Round(time.Second)
+}
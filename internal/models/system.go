@@ -0,0 +1,148 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+)
+
+// SystemMetrics is what the agent collects about filesystems, network
+// interfaces and cores besides the summary. It travels as samples; this
+// struct is how the agent gathers them and the agent page shows them.
+type SystemMetrics struct {
+	CPUCores      []float64      `json:"cpu_cores"` // percent per logical core
+	Load1         float64        `json:"load1"`     // 0 on Windows
+	Load5         float64        `json:"load5"`
+	Load15        float64        `json:"load15"`
+	SwapPercent   float64        `json:"swap_percent"`
+	UptimeSeconds uint64         `json:"uptime_seconds"`
+	Disks         []DiskUsage    `json:"disks"`
+	Interfaces    []NetInterface `json:"interfaces"`
+}
+
+// DiskUsage is one mounted filesystem.
+type DiskUsage struct {
+	Mount             string  `json:"mount"`
+	FSType            string  `json:"fstype"`
+	TotalBytes        uint64  `json:"total_bytes"`
+	FreeBytes         uint64  `json:"free_bytes"`
+	UsedPercent       float64 `json:"used_percent"`
+	InodesUsedPercent float64 `json:"inodes_used_percent"` // 0 where the filesystem has no inodes
+}
+
+// NetInterface carries byte rates averaged since the previous heartbeat.
+type NetInterface struct {
+	Name          string  `json:"name"`
+	RxBytesPerSec float64 `json:"rx_bytes_per_sec"`
+	TxBytesPerSec float64 `json:"tx_bytes_per_sec"`
+}
+
+// Samples flattens the snapshot into labelled samples.
+func (s *SystemMetrics) Samples() []Sample {
+	out := []Sample{
+		{Name: MetricLoad1, Value: s.Load1},
+		{Name: MetricLoad5, Value: s.Load5},
+		{Name: MetricLoad15, Value: s.Load15},
+		{Name: MetricSwapPercent, Value: s.SwapPercent},
+		{Name: MetricUptimeSeconds, Value: float64(s.UptimeSeconds)},
+	}
+	for i, pct := range s.CPUCores {
+		out = append(out, Sample{Name: MetricCPUCorePercent, Labels: Labels{LabelCore: strconv.Itoa(i)}, Value: pct})
+	}
+	for _, d := range s.Disks {
+		labels := Labels{LabelMount: d.Mount}
+		if d.FSType != "" {
+			labels[LabelFSType] = d.FSType
+		}
+		out = append(out,
+			Sample{Name: MetricDiskUsedPercent, Labels: labels, Value: d.UsedPercent},
+			Sample{Name: MetricDiskFreeBytes, Labels: labels, Value: float64(d.FreeBytes)},
+			Sample{Name: MetricDiskTotalBytes, Labels: labels, Value: float64(d.TotalBytes)},
+			Sample{Name: MetricDiskInodesPct, Labels: labels, Value: d.InodesUsedPercent},
+		)
+	}
+	for _, n := range s.Interfaces {
+		labels := Labels{LabelInterface: n.Name}
+		out = append(out,
+			Sample{Name: MetricNetRxBytesPerSec, Labels: labels, Value: n.RxBytesPerSec},
+			Sample{Name: MetricNetTxBytesPerSec, Labels: labels, Value: n.TxBytesPerSec},
+		)
+	}
+	return out
+}
+
+// SystemFromSamples rebuilds a snapshot from one heartbeat's samples; nil if
+// they hold none of it (agents that only report the summary).
+func SystemFromSamples(samples []Sample) *SystemMetrics {
+	s := &SystemMetrics{}
+	found := false
+	cores := map[int]float64{}
+	disks := map[string]*DiskUsage{}
+	nics := map[string]*NetInterface{}
+	disk := func(labels Labels) *DiskUsage {
+		mount := labels[LabelMount]
+		if disks[mount] == nil {
+			disks[mount] = &DiskUsage{Mount: mount, FSType: labels[LabelFSType]}
+		}
+		return disks[mount]
+	}
+	nic := func(labels Labels) *NetInterface {
+		name := labels[LabelInterface]
+		if nics[name] == nil {
+			nics[name] = &NetInterface{Name: name}
+		}
+		return nics[name]
+	}
+
+	for _, v := range samples {
+		switch v.Name {
+		case MetricLoad1:
+			s.Load1 = v.Value
+		case MetricLoad5:
+			s.Load5 = v.Value
+		case MetricLoad15:
+			s.Load15 = v.Value
+		case MetricSwapPercent:
+			s.SwapPercent = v.Value
+		case MetricUptimeSeconds:
+			s.UptimeSeconds = uint64(v.Value)
+		case MetricCPUCorePercent:
+			if i, err := strconv.Atoi(v.Labels[LabelCore]); err == nil {
+				cores[i] = v.Value
+			}
+		case MetricDiskUsedPercent:
+			disk(v.Labels).UsedPercent = v.Value
+		case MetricDiskFreeBytes:
+			disk(v.Labels).FreeBytes = uint64(v.Value)
+		case MetricDiskTotalBytes:
+			disk(v.Labels).TotalBytes = uint64(v.Value)
+		case MetricDiskInodesPct:
+			disk(v.Labels).InodesUsedPercent = v.Value
+		case MetricNetRxBytesPerSec:
+			nic(v.Labels).RxBytesPerSec = v.Value
+		case MetricNetTxBytesPerSec:
+			nic(v.Labels).TxBytesPerSec = v.Value
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+
+	for i, pct := range cores {
+		for len(s.CPUCores) <= i {
+			s.CPUCores = append(s.CPUCores, 0)
+		}
+		s.CPUCores[i] = pct
+	}
+	for _, d := range disks {
+		s.Disks = append(s.Disks, *d)
+	}
+	sort.Slice(s.Disks, func(i, j int) bool { return s.Disks[i].Mount < s.Disks[j].Mount })
+	for _, n := range nics {
+		s.Interfaces = append(s.Interfaces, *n)
+	}
+	sort.Slice(s.Interfaces, func(i, j int) bool { return s.Interfaces[i].Name < s.Interfaces[j].Name })
+	return s
+}
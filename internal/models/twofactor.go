@@ -0,0 +1,26 @@
+package models
+
+// Settings are server-wide options changed by admins from the dashboard.
+type Settings struct {
+	Require2FA bool `json:"require_2fa"` // every user must enroll TOTP before using the dashboard
+}
+
+// TOTPSetup is returned when a user starts enrolling an authenticator app.
+type TOTPSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI, shown as a QR code
+}
+
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+// TOTPDisable confirms turning 2FA off with the account password.
+type TOTPDisable struct {
+	Password string `json:"password"`
+}
+
+// RecoveryCodes are shown once; each can replace a TOTP code one time.
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
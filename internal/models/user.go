@@ -2,16 +2,81 @@ package models
 
 import "time"
 
+// Role decides what a user may do. Each role includes the rights of the
+// roles below it: admin > operator > viewer.
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"    // everything, including users, enrollment and agent removal
+	RoleOperator Role = "operator" // run commands, shells, file transfers, manage alert rules
+	RoleViewer   Role = "viewer"   // read-only
+)
+
+var roleRank = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return roleRank[r] > 0
+}
+
+// Allows reports whether r includes the rights of min.
+func (r Role) Allows(min Role) bool {
+	return roleRank[r] >= roleRank[min] && r.Valid()
+}
+
 type User struct {
-	ID           int64     `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int64      `json:"id"`
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"-"`
+	Role         Role       `json:"role"`
+	Disabled     bool       `json:"disabled"`
+	TOTPEnabled  bool       `json:"totp_enabled"`
+	TOTPSecret   string     `json:"-"`
+	TOTPLastStep int64      `json:"-"` // last accepted TOTP step, so a code works only once
+	FailedLogins int        `json:"failed_logins"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+	OIDCIssuer   string     `json:"-"`
+	OIDCSubject  string     `json:"oidc_subject,omitempty"` // set for users provisioned by single sign-on
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// SSO reports whether the user signs in through the OIDC provider.
+func (u *User) SSO() bool {
+	return u.OIDCSubject != ""
 }
 
+// Locked reports whether the account is locked out after failed logins.
+func (u *User) Locked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
+}
+
+// Session is a dashboard login. Token and CSRFToken are secrets and never
+// leave the server except in the cookie and the rendered pages.
 type Session struct {
-	Token     string    `json:"token"`
-	UserID    int64     `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         int64     `json:"id"`
+	Token      string    `json:"-"`
+	CSRFToken  string    `json:"-"`
+	UserID     int64     `json:"user_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"` // the session making the request
+}
+
+type UserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     Role   `json:"role"`
+}
+
+// UserUpdate changes a user's role and/or disabled flag; nil fields are kept.
+type UserUpdate struct {
+	Role     *Role `json:"role,omitempty"`
+	Disabled *bool `json:"disabled,omitempty"`
+}
+
+type PasswordReset struct {
+	Password string `json:"password"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import "testing"
+
+func TestRoleAllows(t *testing.T) {
+	cases := []struct {
+		role, min Role
+		want      bool
+	}{
+		{RoleAdmin, RoleOperator, true},
+		{RoleOperator, RoleOperator, true},
+		{RoleViewer, RoleOperator, false},
+		{RoleOperator, RoleAdmin, false},
+		{Role("root"), RoleViewer, false},
+	}
+	for _, c := range cases {
+		if got := c.role.Allows(c.min); got != c.want {
+			t.Errorf("%s.Allows(%s) = %v, want %v", c.role, c.min, got, c.want)
+		}
+	}
+}
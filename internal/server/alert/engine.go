@@ -8,19 +8,28 @@ import (
 
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/notify"
 )
 
 const (
-	checkInterval    = 60 * time.Second
-	offlineThreshold = 90 * time.Second // 3 missed heartbeats
+	checkInterval = 60 * time.Second
+	// offlineThreshold is when an agent's status turns offline; offline
+	// rules have their own grace period.
+	offlineThreshold = models.DefaultOfflineGrace
+	// maxRuleSeries caps the series of one metric read to evaluate a rule
+	// with a duration; a metric has one per mount, interface or core.
+	maxRuleSeries = 1000
 )
 
 type Engine struct {
-	store *db.Store
+	store    *db.Store
+	notifier *notify.Notifier
 }
 
-func NewEngine(store *db.Store) *Engine {
-	return &Engine{store: store}
+// NewEngine returns an engine that sends the alerts it opens and resolves to
+// notifier; nil sends none.
+func NewEngine(store *db.Store, notifier *notify.Notifier) *Engine {
+	return &Engine{store: store, notifier: notifier}
 }
 
 func (e *Engine) Run(ctx context.Context) {
@@ -32,13 +41,15 @@ func (e *Engine) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			e.checkOfflineAgents()
-			e.checkMetricRules()
+			// The first check runs a minute after startup, so agents have
+			// reconnected before offline rules look at their heartbeats
+			e.markOfflineAgents()
+			e.checkRules()
 		}
 	}
 }
 
-func (e *Engine) checkOfflineAgents() {
+func (e *Engine) markOfflineAgents() {
 	count, err := e.store.MarkOfflineAgents(offlineThreshold)
 	if err != nil {
 		slog.Error("mark offline agents failed", "error", err)
@@ -49,7 +60,7 @@ func (e *Engine) checkOfflineAgents() {
 	}
 }
 
-func (e *Engine) checkMetricRules() {
+func (e *Engine) checkRules() {
 	rules, err := e.store.ListAlertRules()
 	if err != nil {
 		slog.Error("list alert rules failed", "error", err)
@@ -66,13 +77,78 @@ func (e *Engine) checkMetricRules() {
 		return
 	}
 
+	var metricRules []models.AlertRule
+	now := time.Now()
+	for _, rule := range rules {
+		if rule.Type == models.RuleOffline {
+			e.checkOfflineRule(rule, agents, now)
+		} else {
+			metricRules = append(metricRules, rule)
+		}
+	}
+	e.checkMetricRules(metricRules, agents, now)
+}
+
+// checkOfflineRule opens an alert for each agent of the rule that has sent
+// no heartbeat for the rule's grace period, and resolves it once the agent
+// is back.
+func (e *Engine) checkOfflineRule(rule models.AlertRule, agents []models.Agent, now time.Time) {
+	grace := rule.Grace()
+	for _, agent := range agents {
+		if rule.AgentID != "" && rule.AgentID != agent.ID {
+			continue
+		}
+		// An agent that enrolled but never connected is silent since it
+		// enrolled
+		since := agent.LastHeartbeat
+		if since.IsZero() {
+			since = agent.CreatedAt
+		}
+		if since.IsZero() {
+			continue
+		}
+		silent := now.Sub(since)
+		if silent >= grace {
+			msg := fmt.Sprintf("%s: offline, no heartbeat since %s (%s)",
+				agent.Hostname, since.UTC().Format(time.RFC3339), silent.Truncate(time.Second))
+			if agent.LastHeartbeat.IsZero() {
+				// It has no hostname yet either
+				msg = fmt.Sprintf("%s: never connected since enrolling at %s (%s)",
+					agent.ID, since.UTC().Format(time.RFC3339), silent.Truncate(time.Second))
+			}
+			opened, err := e.store.OpenAlert(rule.ID, agent.ID, msg)
+			if err != nil {
+				slog.Error("create alert failed", "error", err)
+			} else if opened != nil {
+				slog.Warn("agent offline", "agent", agent.ID, "last_heartbeat", agent.LastHeartbeat)
+				e.notify(models.EventFiring, *opened, rule, agent)
+			} else {
+				e.afterMaintenance(rule, agent)
+			}
+			continue
+		}
+		resolved, err := e.store.ResolveOpenAlert(rule.ID, agent.ID)
+		if err != nil {
+			slog.Error("resolve alert failed", "error", err)
+		} else if resolved != nil {
+			slog.Info("agent back online", "agent", agent.ID, "rule", rule.ID)
+			e.notify(models.EventResolved, *resolved, rule, agent)
+		}
+	}
+}
+
+func (e *Engine) checkMetricRules(rules []models.AlertRule, agents []models.Agent, now time.Time) {
+	if len(rules) == 0 {
+		return
+	}
 	for _, agent := range agents {
 		if agent.Status != models.AgentOnline {
 			continue
 		}
 
-		metric, err := e.store.GetLatestMetric(agent.ID)
-		if err != nil || metric == nil {
+		samples, err := e.store.GetLatestSamples(agent.ID)
+		if err != nil {
+			slog.Error("get samples failed", "agent", agent.ID, "error", err)
 			continue
 		}
 
@@ -80,31 +156,201 @@ func (e *Engine) checkMetricRules() {
 			if rule.AgentID != "" && rule.AgentID != agent.ID {
 				continue
 			}
-			value := getMetricValue(metric, rule.Metric)
-			if evaluate(value, rule.Operator, rule.Threshold) {
-				msg := fmt.Sprintf("%s: %s %s %.1f (current: %.1f)",
-					agent.Hostname, rule.Metric, rule.Operator, rule.Threshold, value)
-				if err := e.store.CreateAlert(rule.ID, agent.ID, msg); err != nil {
-					slog.Error("create alert failed", "error", err)
-				} else {
-					slog.Warn("alert triggered", "agent", agent.ID, "message", msg)
+			var matching []models.Sample
+			for _, s := range samples {
+				if ruleMatches(rule, s) {
+					matching = append(matching, s)
 				}
 			}
+			e.checkRule(agent, rule, matching, now)
 		}
 	}
 }
 
-func getMetricValue(m *models.Metric, metric string) float64 {
-	switch metric {
-	case "cpu_percent":
-		return m.CPUPercent
-	case "memory_percent":
-		return m.MemoryPercent
-	case "disk_percent":
-		return m.DiskPercent
-	default:
-		return 0
+// checkRule opens an alert when one of the samples has crossed the rule's
+// threshold for its whole duration, and resolves the open alert once none
+// of them crosses the clear threshold any more. In between, an open alert
+// stays open and none is raised.
+func (e *Engine) checkRule(agent models.Agent, rule models.AlertRule, samples []models.Sample, now time.Time) {
+	for _, s := range samples {
+		if !evaluate(s.Value, rule.Operator, rule.Threshold) {
+			continue
+		}
+		if rule.ForSeconds > 0 {
+			held, err := e.heldSince(agent.ID, rule, s, now.Add(-time.Duration(rule.ForSeconds)*time.Second))
+			if err != nil {
+				slog.Error("get rule samples failed", "agent", agent.ID, "rule", rule.ID, "error", err)
+				return
+			}
+			if !held {
+				continue
+			}
+		}
+
+		msg := fmt.Sprintf("%s: %s%s %s %.1f (current: %.1f)",
+			agent.Hostname, s.Name, s.Labels, rule.Operator, rule.Threshold, s.Value)
+		if rule.ForSeconds > 0 {
+			msg = fmt.Sprintf("%s: %s%s %s %.1f for %s (current: %.1f)",
+				agent.Hostname, s.Name, s.Labels, rule.Operator, rule.Threshold, time.Duration(rule.ForSeconds)*time.Second, s.Value)
+		}
+		opened, err := e.store.OpenAlert(rule.ID, agent.ID, msg)
+		if err != nil {
+			slog.Error("create alert failed", "error", err)
+		} else if opened != nil {
+			slog.Warn("alert triggered", "agent", agent.ID, "message", msg)
+			e.notify(models.EventFiring, *opened, rule, agent)
+		} else {
+			e.afterMaintenance(rule, agent)
+		}
+		return
+	}
+
+	for _, s := range samples {
+		if evaluate(s.Value, rule.Operator, rule.ClearLevel()) {
+			return
+		}
+	}
+	resolved, err := e.store.ResolveOpenAlert(rule.ID, agent.ID)
+	if err != nil {
+		slog.Error("resolve alert failed", "error", err)
+	} else if resolved != nil {
+		slog.Info("alert resolved", "agent", agent.ID, "rule", rule.ID)
+		e.notify(models.EventResolved, *resolved, rule, agent)
+	}
+}
+
+// Resolved sends the notifications of an alert resolved by hand.
+func (e *Engine) Resolved(id int64) error {
+	a, err := e.store.GetAlert(id)
+	if err != nil || a == nil {
+		return err
+	}
+	rule, err := e.store.GetAlertRule(a.RuleID)
+	if err != nil || rule == nil {
+		return err
+	}
+	agent, err := e.store.GetAgent(a.AgentID)
+	if err != nil || agent == nil {
+		return err
+	}
+	e.notify(models.EventResolved, *a, *rule, *agent)
+	return nil
+}
+
+// afterMaintenance is called while the open alert of rule on agent is still
+// firing. If the alert was raised during a maintenance window that is now
+// over, it is unmarked and notified, and its resolution will be too.
+func (e *Engine) afterMaintenance(rule models.AlertRule, agent models.Agent) {
+	a, err := e.store.OpenMaintenanceAlert(rule.ID, agent.ID)
+	if err != nil {
+		slog.Error("get open alert failed", "agent", agent.ID, "rule", rule.ID, "error", err)
+		return
+	}
+	if a == nil {
+		return
+	}
+	window, err := e.maintenance(agent)
+	if err != nil {
+		slog.Error("list maintenance windows failed", "error", err)
+		return
+	}
+	if window != nil {
+		return
+	}
+	if cleared, err := e.store.ClearAlertMaintenance(a.ID); err != nil || !cleared {
+		if err != nil {
+			slog.Error("clear alert maintenance failed", "alert", a.ID, "error", err)
+		}
+		return
+	}
+	a.Maintenance = false
+	slog.Warn("alert still firing after maintenance", "alert", a.ID, "agent", agent.ID)
+	if e.notifier != nil {
+		e.notifier.Notify(notify.Event{Kind: models.EventFiring, Alert: *a, Rule: rule, Agent: agent})
+	}
+}
+
+// notify sends an alert's notifications, unless the alert was raised while
+// its agent was in a maintenance window; such alerts are marked and kept
+// quiet until they resolve or outlast the window.
+func (e *Engine) notify(kind models.AlertEvent, a models.Alert, rule models.AlertRule, agent models.Agent) {
+	switch {
+	case kind == models.EventFiring:
+		window, err := e.maintenance(agent)
+		if err != nil {
+			slog.Error("list maintenance windows failed", "error", err)
+		} else if window != nil {
+			if err := e.store.MarkAlertMaintenance(a.ID); err != nil {
+				slog.Error("mark alert maintenance failed", "alert", a.ID, "error", err)
+			}
+			slog.Info("alert raised during maintenance, not notified", "alert", a.ID, "agent", agent.ID, "window", window.ID)
+			return
+		}
+	case a.Maintenance:
+		return
+	}
+	if e.notifier == nil {
+		return
+	}
+	e.notifier.Notify(notify.Event{Kind: kind, Alert: a, Rule: rule, Agent: agent})
+}
+
+// maintenance returns the maintenance window agent is in, if any.
+func (e *Engine) maintenance(agent models.Agent) (*models.MaintenanceWindow, error) {
+	windows, err := e.store.ListMaintenanceWindows()
+	if err != nil {
+		return nil, err
+	}
+	window, _ := models.ActiveMaintenance(windows, agent, time.Now())
+	return window, nil
+}
+
+// heldSince reports whether the series of sample s has crossed the rule's
+// threshold in every stored point since start.
+func (e *Engine) heldSince(agentID string, rule models.AlertRule, s models.Sample, start time.Time) (bool, error) {
+	series, _, err := e.store.QueryAgentSeries(agentID, []string{s.Name}, models.ResolutionRaw, start.Add(-offlineThreshold), s.Timestamp, maxRuleSeries)
+	if err != nil {
+		return false, err
+	}
+	for _, sr := range series {
+		if sr.Labels.String() == s.Labels.String() {
+			return held(sr.Points, rule, start), nil
+		}
+	}
+	return false, nil
+}
+
+// held reports whether points show the rule's threshold crossed throughout
+// since start: the last point at or before start and every point after it
+// cross it. Without a point at or before start, the series doesn't reach
+// back far enough to tell.
+func held(points []models.Point, rule models.AlertRule, start time.Time) bool {
+	from := -1
+	for i, p := range points {
+		if p.Timestamp.After(start) {
+			break
+		}
+		from = i
+	}
+	if from < 0 {
+		return false
+	}
+	for _, p := range points[from:] {
+		if !evaluate(p.Value, rule.Operator, rule.Threshold) {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleMatches reports whether a rule applies to a sample: same metric name
+// and, if the rule names a target, the sample's mount point, interface or
+// core is that target.
+func ruleMatches(rule models.AlertRule, s models.Sample) bool {
+	if s.Name != rule.Metric {
+		return false
 	}
+	return rule.Target == "" || s.Labels[models.TargetLabels[rule.Metric]] == rule.Target
 }
 
 func evaluate(value float64, operator string, threshold float64) bool {
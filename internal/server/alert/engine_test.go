@@ -0,0 +1,182 @@
+package alert
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+)
+
+func TestHeld(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	rule := models.AlertRule{Metric: "cpu_percent", Operator: ">", Threshold: 90, ForSeconds: 300}
+	start := now.Add(-5 * time.Minute)
+	points := func(values ...float64) []models.Point {
+		// One point every 30s, the last at now
+		var ps []models.Point
+		for i, v := range values {
+			ps = append(ps, models.Point{Timestamp: now.Add(time.Duration(i-len(values)+1) * 30 * time.Second), Value: v})
+		}
+		return ps
+	}
+
+	cases := []struct {
+		name   string
+		points []models.Point
+		want   bool
+	}{
+		{"held for the whole duration", points(95, 95, 95, 95, 95, 95, 95, 95, 95, 95, 95, 95), true},
+		{"one dip", points(95, 95, 95, 95, 95, 80, 95, 95, 95, 95, 95, 95), false},
+		{"dip before the window", points(80, 95, 95, 95, 95, 95, 95, 95, 95, 95, 95, 95), true},
+		{"crossed since the window started", points(80, 95, 95, 95, 95, 95, 95, 95, 95, 95, 95), false},
+		{"not enough history", points(95, 95, 95), false},
+		{"no points", nil, false},
+	}
+	for _, c := range cases {
+		if got := held(c.points, rule, start); got != c.want {
+			t.Errorf("%s: held = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestOfflineRule(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer store.Close()
+	e := NewEngine(store, nil)
+
+	for _, id := range []string{"a1", "a2"} {
+		store.UpsertAgent(models.HeartbeatPayload{AgentID: id, Hostname: "host-" + id})
+	}
+	fleet, _ := store.CreateAlertRule(models.AlertRuleRequest{Type: models.RuleOffline})
+	a2Only, _ := store.CreateAlertRule(models.AlertRuleRequest{Type: models.RuleOffline, AgentID: "a2", ForSeconds: 600})
+
+	now := time.Now()
+	agents := []models.Agent{
+		{ID: "a1", Hostname: "host-a1", LastHeartbeat: now.Add(-2 * time.Minute)},
+		{ID: "a2", Hostname: "host-a2", LastHeartbeat: now.Add(-5 * time.Minute)},
+	}
+	check := func(at time.Time) map[string]bool {
+		e.checkOfflineRule(*fleet, agents, at)
+		e.checkOfflineRule(*a2Only, agents, at)
+		alerts, _ := store.ListAlerts(100)
+		open := map[string]bool{}
+		for _, a := range alerts {
+			if a.RuleType != models.RuleOffline {
+				t.Errorf("alert %d has rule type %q", a.ID, a.RuleType)
+			}
+			if !a.Resolved {
+				open[fmt.Sprintf("%s/%d", a.AgentID, a.RuleID)] = true
+			}
+		}
+		return open
+	}
+
+	// Both agents are past the default 90s, a2 is within its 10 minutes
+	want := map[string]bool{fmt.Sprintf("a1/%d", fleet.ID): true, fmt.Sprintf("a2/%d", fleet.ID): true}
+	if got := check(now); !maps.Equal(got, want) {
+		t.Fatalf("open alerts = %v, want %v", got, want)
+	}
+
+	// a1 is back, a2 is still silent 10 minutes later
+	later := now.Add(10 * time.Minute)
+	agents[0].LastHeartbeat = later
+	want = map[string]bool{fmt.Sprintf("a2/%d", fleet.ID): true, fmt.Sprintf("a2/%d", a2Only.ID): true}
+	if got := check(later); !maps.Equal(got, want) {
+		t.Fatalf("open alerts = %v, want %v", got, want)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer store.Close()
+	e := NewEngine(store, nil)
+
+	now := time.Now()
+	start := now.Add(-time.Minute)
+	window, err := store.CreateMaintenanceWindow(models.MaintenanceWindow{Name: "patching", StartsAt: &start, DurationSeconds: 3600, Tags: []string{"patch"}})
+	if err != nil {
+		t.Fatalf("create window: %v", err)
+	}
+	rule, _ := store.CreateAlertRule(models.AlertRuleRequest{Type: models.RuleOffline})
+	agents := []models.Agent{
+		{ID: "a1", Hostname: "host-a1", Tags: []string{"patch"}, LastHeartbeat: now.Add(-5 * time.Minute)},
+		{ID: "a2", Hostname: "host-a2", LastHeartbeat: now.Add(-5 * time.Minute)},
+	}
+	for _, a := range agents {
+		store.UpsertAgent(models.HeartbeatPayload{AgentID: a.ID, Hostname: a.Hostname})
+	}
+	e.checkOfflineRule(*rule, agents, now)
+
+	alerts, _ := store.ListAlerts(100)
+	if len(alerts) != 2 {
+		t.Fatalf("got %d alerts, want 2", len(alerts))
+	}
+	for _, a := range alerts {
+		if want := a.AgentID == "a1"; a.Maintenance != want {
+			t.Errorf("alert of %s: maintenance = %v, want %v", a.AgentID, a.Maintenance, want)
+		}
+	}
+
+	// Still in the window: a1's alert stays quiet
+	e.checkOfflineRule(*rule, agents, now.Add(time.Minute))
+	if a, _ := store.OpenMaintenanceAlert(rule.ID, "a1"); a == nil {
+		t.Fatal("alert of a1 unmarked during the window")
+	}
+
+	// The window is over and a1 is still offline: the alert is notified
+	// like any other from now on
+	store.DeleteMaintenanceWindow(window.ID)
+	e.checkOfflineRule(*rule, agents, now.Add(2*time.Minute))
+	alerts, _ = store.ListAlerts(100)
+	if len(alerts) != 2 {
+		t.Fatalf("got %d alerts after the window, want 2", len(alerts))
+	}
+	for _, a := range alerts {
+		if a.Maintenance || a.Resolved {
+			t.Errorf("alert of %s after the window: maintenance = %v, resolved = %v", a.AgentID, a.Maintenance, a.Resolved)
+		}
+	}
+}
+
+// TestOfflineRuleNeverConnected checks that an agent that enrolled but
+// hasn't sent a heartbeat yet counts as silent since it enrolled.
+func TestOfflineRuleNeverConnected(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer store.Close()
+	e := NewEngine(store, nil)
+
+	rule, _ := store.CreateAlertRule(models.AlertRuleRequest{Type: models.RuleOffline})
+	store.CreateEnrollmentToken("tok", "", 1, nil, "admin")
+	if err := store.EnrollAgent("tok", "new", "", "secret"); err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	agents, _ := store.ListAgents()
+	if len(agents) != 1 || !agents[0].LastHeartbeat.IsZero() {
+		t.Fatalf("expected one agent without heartbeat, got %+v", agents)
+	}
+	now := agents[0].CreatedAt.Add(time.Minute)
+
+	e.checkOfflineRule(*rule, agents, now)
+	if alerts, _ := store.ListAlerts(100); len(alerts) != 0 {
+		t.Fatalf("freshly enrolled agent alerted: %+v", alerts)
+	}
+
+	e.checkOfflineRule(*rule, agents, now.Add(5*time.Minute))
+	alerts, _ := store.ListAlerts(100)
+	if len(alerts) != 1 || !strings.Contains(alerts[0].Message, "never connected") || strings.Contains(alerts[0].Message, "0001") {
+		t.Fatalf("expected a never connected alert, got %+v", alerts)
+	}
+}
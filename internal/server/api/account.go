@@ -0,0 +1,231 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer        = "Mini RMM"
+	recoveryCodeCount = 10
+)
+
+// AccountHandler lets users manage their own two-factor authentication.
+// These endpoints refuse API tokens: only someone at the dashboard may change
+// how the account logs in.
+type AccountHandler struct {
+	Store *db.Store
+}
+
+// Setup2FA creates a new TOTP secret for the user. 2FA stays off until
+// Enable2FA confirms a code from the authenticator app.
+func (h *AccountHandler) Setup2FA(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if user.SSO() {
+		http.Error(w, "single sign-on accounts use the identity provider's two-factor authentication", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		slog.Error("generate totp secret failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Store.SetTOTPSecret(user.ID, secret); err != nil {
+		slog.Error("store totp secret failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TOTPSetup{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Username, secret),
+	})
+}
+
+// Enable2FA turns 2FA on once the user proves the app is set up, and returns
+// the recovery codes.
+func (h *AccountHandler) Enable2FA(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if user.TOTPSecret == "" {
+		http.Error(w, "start the setup first", http.StatusBadRequest)
+		return
+	}
+
+	var req models.TOTPCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	step, valid := totp.Validate(user.TOTPSecret, req.Code, time.Now(), 0)
+	if !valid {
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		slog.Error("generate recovery codes failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Store.EnableTOTP(user.ID, step); err != nil {
+		slog.Error("enable totp failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Store.ReplaceRecoveryCodes(user.ID, codes); err != nil {
+		slog.Error("store recovery codes failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(user, "2fa_enable")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RecoveryCodes{Codes: codes})
+}
+
+// Disable2FA turns 2FA off after checking the account password. It is
+// refused while admins require 2FA.
+func (h *AccountHandler) Disable2FA(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.TOTPDisable
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		http.Error(w, "wrong password", http.StatusForbidden)
+		return
+	}
+	settings, err := h.Store.GetSettings()
+	if err != nil {
+		slog.Error("get settings failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if settings.Require2FA {
+		http.Error(w, "two-factor authentication is required for all users", http.StatusConflict)
+		return
+	}
+
+	if err := h.Store.DisableTOTP(user.ID); err != nil {
+		slog.Error("disable totp failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(user, "2fa_disable")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes; it takes a current
+// TOTP code.
+func (h *AccountHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, "two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	var req models.TOTPCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	step, valid := totp.Validate(user.TOTPSecret, req.Code, time.Now(), user.TOTPLastStep)
+	if !valid {
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+	if used, err := h.Store.UseTOTPStep(user.ID, step); err != nil || !used {
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		slog.Error("generate recovery codes failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Store.ReplaceRecoveryCodes(user.ID, codes); err != nil {
+		slog.Error("store recovery codes failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(user, "2fa_recovery_codes_regenerate")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RecoveryCodes{Codes: codes})
+}
+
+// sessionUser returns the current user, fresh from the database, and
+// rejects API tokens.
+func (h *AccountHandler) sessionUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	if GetAPITokenFromContext(r) != nil {
+		http.Error(w, "not available with API tokens", http.StatusForbidden)
+		return nil, false
+	}
+	current := GetUserFromContext(r)
+	user, err := h.Store.GetUser(current.ID)
+	if err != nil || user == nil {
+		slog.Error("get user failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *AccountHandler) audit(user *models.User, action string) {
+	if err := h.Store.InsertAuditLog(user.Username, action, user.Username, "{}"); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+}
+
+// generateRecoveryCodes returns single-use codes formatted as xxxxx-xxxxx.
+func generateRecoveryCodes() ([]string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(enc.EncodeToString(b))[:10]
+		codes[i] = c[:5] + "-" + c[5:]
+	}
+	return codes, nil
+}
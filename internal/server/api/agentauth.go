@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/pki"
+)
+
+const agentContextKey contextKey = "agent"
+
+// AgentAuth authenticates agents by the per-agent secret issued at enrollment
+// and, in mTLS mode, by the client certificate issued alongside it.
+type AgentAuth struct {
+	store       *db.Store
+	requireCert bool
+}
+
+func NewAgentAuth(store *db.Store, requireClientCert bool) *AgentAuth {
+	return &AgentAuth{store: store, requireCert: requireClientCert}
+}
+
+// RequireAgent rejects requests that do not carry a valid agent key and secret
+// (and, in mTLS mode, the agent's current client certificate).
+func (a *AgentAuth) RequireAgent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentID, ok := a.authenticate(r)
+		if !ok {
+			http.Error(w, "unauthorized agent", http.StatusUnauthorized)
+			return
+		}
+		if a.requireCert && !a.verifyCertificate(r, agentID) {
+			w.Header().Set(models.HeaderCertRequired, "1")
+			http.Error(w, "valid client certificate required", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), agentContextKey, agentID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireAgentSecret checks only the agent secret. It guards the endpoint
+// agents use to obtain a client certificate in the first place.
+func (a *AgentAuth) RequireAgentSecret(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentID, ok := a.authenticate(r)
+		if !ok {
+			http.Error(w, "unauthorized agent", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), agentContextKey, agentID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireAgentOrEnrollment additionally accepts a valid enrollment token, so
+// install scripts can download the agent binary before the agent is enrolled.
+func (a *AgentAuth) RequireAgentOrEnrollment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get(models.HeaderEnrollmentToken); token != "" {
+			valid, err := a.store.ValidEnrollmentToken(token)
+			if err != nil {
+				slog.Error("validate enrollment token failed", "error", err)
+			}
+			if valid {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		a.RequireAgent(next).ServeHTTP(w, r)
+	})
+}
+
+func (a *AgentAuth) authenticate(r *http.Request) (string, bool) {
+	agentID := r.Header.Get(models.HeaderAgentKey)
+	secret := r.Header.Get(models.HeaderAgentSecret)
+	if agentID == "" || secret == "" {
+		return "", false
+	}
+	// The WebSocket endpoint also names the agent in the query string
+	if q := r.URL.Query().Get("agent_id"); q != "" && q != agentID {
+		return "", false
+	}
+
+	ok, err := a.store.AuthenticateAgent(agentID, secret)
+	if err != nil {
+		slog.Error("agent authentication failed", "agent_id", agentID, "error", err)
+		return "", false
+	}
+	if !ok {
+		slog.Warn("agent credential rejected", "agent_id", agentID, "remote", r.RemoteAddr)
+		return "", false
+	}
+	return agentID, true
+}
+
+// verifyCertificate checks that the TLS client certificate (already chain-
+// verified by the TLS layer) names agentID and is the serial on record, so a
+// revoked or superseded certificate is refused on its next request.
+func (a *AgentAuth) verifyCertificate(r *http.Request, agentID string) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	if leaf.Subject.CommonName != agentID {
+		slog.Warn("client certificate does not match agent", "agent_id", agentID, "cn", leaf.Subject.CommonName)
+		return false
+	}
+	ok, err := a.store.AgentCertificateValid(agentID, pki.SerialString(leaf.SerialNumber))
+	if err != nil {
+		slog.Error("client certificate check failed", "agent_id", agentID, "error", err)
+		return false
+	}
+	if !ok {
+		slog.Warn("client certificate rejected", "agent_id", agentID, "remote", r.RemoteAddr)
+	}
+	return ok
+}
+
+// GetAgentIDFromContext returns the authenticated agent ID set by RequireAgent.
+func GetAgentIDFromContext(r *http.Request) string {
+	id, _ := r.Context().Value(agentContextKey).(string)
+	return id
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/server/pki"
+)
+
+// issueClientCert has ca sign a fresh key for agentID, as enrollment does.
+func issueClientCert(t *testing.T, ca *pki.CA, agentID string) (*x509.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: agentID}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, serial, err := ca.SignAgentCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), agentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, serial
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	store := setupTestDB(t)
+	ca, err := pki.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.CreateEnrollmentToken("tok", "", 0, nil, "admin")
+	store.EnrollAgent("tok", "agent-1", "", "secret")
+	store.EnrollAgent("tok", "agent-2", "", "secret")
+
+	cert, serial := issueClientCert(t, ca, "agent-1")
+	store.SetAgentCertificate("agent-1", serial)
+	otherCert, otherSerial := issueClientCert(t, ca, "agent-2")
+	store.SetAgentCertificate("agent-2", otherSerial)
+
+	auth := NewAgentAuth(store, true)
+	verify := func(agentID string, cert *x509.Certificate) bool {
+		r := httptest.NewRequest("GET", "/api/v1/heartbeat", nil)
+		if cert != nil {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		return auth.verifyCertificate(r, agentID)
+	}
+
+	if !verify("agent-1", cert) {
+		t.Fatal("expected the current certificate to be accepted")
+	}
+	if verify("agent-1", nil) {
+		t.Error("expected a request without a client certificate to be refused")
+	}
+	if verify("agent-1", otherCert) {
+		t.Error("expected another agent's certificate to be refused")
+	}
+
+	// A reissued certificate supersedes the old one
+	newCert, newSerial := issueClientCert(t, ca, "agent-1")
+	store.SetAgentCertificate("agent-1", newSerial)
+	if verify("agent-1", cert) {
+		t.Error("expected the superseded certificate to be refused")
+	}
+	if !verify("agent-1", newCert) {
+		t.Error("expected the reissued certificate to be accepted")
+	}
+
+	if err := store.RevokeAgentCertificate("agent-1"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if verify("agent-1", newCert) {
+		t.Error("expected the revoked certificate to be refused")
+	}
+	if !verify("agent-2", otherCert) {
+		t.Error("revoking one agent's certificate must not affect another")
+	}
+}
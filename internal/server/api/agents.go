@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
-	"strconv"
+	"slices"
+	"strings"
 
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
@@ -16,6 +17,7 @@ type AgentHandler struct {
 }
 
 func (h *AgentHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	store := h.Store.WithContext(r.Context())
 	var payload models.HeartbeatPayload
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "invalid payload", http.StatusBadRequest)
@@ -26,22 +28,19 @@ func (h *AgentHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "agent_id required", http.StatusBadRequest)
 		return
 	}
+	if payload.AgentID != GetAgentIDFromContext(r) {
+		http.Error(w, "agent_id does not match credential", http.StatusForbidden)
+		return
+	}
 
-	if err := h.Store.UpsertAgent(payload); err != nil {
+	if err := store.UpsertAgent(payload); err != nil {
 		slog.Error("upsert agent failed", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	// Store metric
-	metric := models.Metric{
-		AgentID:       payload.AgentID,
-		CPUPercent:    payload.CPUPercent,
-		MemoryPercent: payload.MemoryPercent,
-		DiskPercent:   payload.DiskPercent,
-	}
-	if err := h.Store.InsertMetric(metric); err != nil {
-		slog.Error("insert metric failed", "error", err)
+	if err := store.InsertSamples(payload.AgentID, payload.AllSamples()); err != nil {
+		slog.Error("insert samples failed", "error", err)
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -88,24 +87,67 @@ func (h *AgentHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *AgentHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+// SetTags replaces an agent's tags, which silences can match on.
+func (h *AgentHandler) SetTags(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	limitStr := r.URL.Query().Get("limit")
-	limit := 50
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	tags := []string{}
+	for _, t := range req.Tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || slices.Contains(tags, t) {
+			continue
+		}
+		if !models.ValidTag(t) {
+			http.Error(w, "invalid tag "+t+" (a-z, 0-9, _ . -, up to 32 characters)", http.StatusBadRequest)
+			return
 		}
+		tags = append(tags, t)
 	}
-	metrics, err := h.Store.GetLatestMetrics(id, limit)
+	if len(tags) > models.MaxAgentTags {
+		http.Error(w, "too many tags", http.StatusBadRequest)
+		return
+	}
+
+	agent, err := h.Store.GetAgent(id)
 	if err != nil {
-		slog.Error("get metrics failed", "error", err)
+		slog.Error("get agent failed", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
-	if metrics == nil {
-		metrics = []models.Metric{}
+	if agent == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err := h.Store.SetAgentTags(id, tags); err != nil {
+		slog.Error("set agent tags failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{"old": agent.Tags, "new": tags})
+	if err := h.Store.InsertAuditLog(auditUsername(r), "agent_tags_update", id, string(details)); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"tags": tags})
+}
+
+// System returns the per-disk, per-interface and per-core metrics of the
+// agent's latest heartbeat; null if it never sent any.
+func (h *AgentHandler) System(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	samples, err := h.Store.GetLatestSamples(id)
+	if err != nil {
+		slog.Error("get samples failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(metrics)
+	json.NewEncoder(w).Encode(models.SystemFromSamples(samples))
 }
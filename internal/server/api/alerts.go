@@ -5,6 +5,8 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/alert"
@@ -45,6 +47,14 @@ func (h *AlertHandler) ListRules(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(rules)
 }
 
+// maxRuleDuration is the longest for_seconds of a rule. Its samples must
+// still be stored at full resolution when it is evaluated.
+const maxRuleDuration = 24 * 60 * 60
+
+// minOfflineGrace is the shortest grace period of an offline rule; anything
+// shorter would fire between two heartbeats.
+const minOfflineGrace = 60
+
 var metricAliases = map[string]string{"cpu": "cpu_percent", "memory": "memory_percent", "disk": "disk_percent"}
 
 func (h *AlertHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
@@ -54,13 +64,35 @@ func (h *AlertHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Severity == "" {
+		req.Severity = models.SeverityWarning
+	} else if !req.Severity.Valid() {
+		http.Error(w, "severity must be info, warning or critical", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Type {
+	case "", models.RuleMetric:
+		req.Type = models.RuleMetric
+	case models.RuleOffline:
+		h.createOfflineRule(w, req)
+		return
+	default:
+		http.Error(w, "type must be metric or offline", http.StatusBadRequest)
+		return
+	}
+
 	// Normalize metric (form sends cpu/memory/disk)
 	if m, ok := metricAliases[req.Metric]; ok {
 		req.Metric = m
 	}
-	validMetrics := map[string]bool{"cpu_percent": true, "memory_percent": true, "disk_percent": true}
-	if !validMetrics[req.Metric] {
-		http.Error(w, "invalid metric (cpu, memory, disk)", http.StatusBadRequest)
+	if !models.ValidMetricName(req.Metric) {
+		http.Error(w, "invalid metric name", http.StatusBadRequest)
+		return
+	}
+	req.Target = strings.TrimSpace(req.Target)
+	if req.Target != "" && models.TargetLabels[req.Metric] == "" {
+		http.Error(w, "metric "+req.Metric+" has no targets", http.StatusBadRequest)
 		return
 	}
 	validOps := map[string]bool{">": true, "<": true, ">=": true, "<=": true, "==": true}
@@ -68,7 +100,42 @@ func (h *AlertHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid operator (>, <, >=, <=, ==)", http.StatusBadRequest)
 		return
 	}
+	if req.ForSeconds < 0 || req.ForSeconds > maxRuleDuration {
+		http.Error(w, "for_seconds must be between 0 and 86400", http.StatusBadRequest)
+		return
+	}
+	if req.ClearThreshold != nil {
+		// The clear threshold lies on the healthy side of the threshold, so
+		// a value between the two neither raises nor resolves an alert
+		clear := *req.ClearThreshold
+		switch {
+		case req.Operator == "==":
+			http.Error(w, "clear_threshold needs a <, <=, > or >= rule", http.StatusBadRequest)
+			return
+		case (req.Operator == ">" || req.Operator == ">=") && clear > req.Threshold:
+			http.Error(w, "clear_threshold must not be above threshold", http.StatusBadRequest)
+			return
+		case (req.Operator == "<" || req.Operator == "<=") && clear < req.Threshold:
+			http.Error(w, "clear_threshold must not be below threshold", http.StatusBadRequest)
+			return
+		}
+	}
+
+	h.createRule(w, req)
+}
 
+// createOfflineRule validates a rule that fires when agents stop sending
+// heartbeats; for_seconds is its grace period.
+func (h *AlertHandler) createOfflineRule(w http.ResponseWriter, req models.AlertRuleRequest) {
+	if req.ForSeconds != 0 && (req.ForSeconds < minOfflineGrace || req.ForSeconds > maxRuleDuration) {
+		http.Error(w, "for_seconds must be 0 (90s) or between 60 and 86400", http.StatusBadRequest)
+		return
+	}
+	// Offline rules have no metric or threshold
+	h.createRule(w, models.AlertRuleRequest{Type: models.RuleOffline, Severity: req.Severity, AgentID: req.AgentID, ForSeconds: req.ForSeconds})
+}
+
+func (h *AlertHandler) createRule(w http.ResponseWriter, req models.AlertRuleRequest) {
 	rule, err := h.Store.CreateAlertRule(req)
 	if err != nil {
 		slog.Error("create alert rule failed", "error", err)
@@ -95,3 +162,210 @@ func (h *AlertHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// maxAckComment and maxSilenceComment cap the free text stored with
+// acknowledgements and silences.
+const (
+	maxAckComment     = 1000
+	maxSilenceComment = 1000
+	// maxSilenceDuration is the longest a silence can last, so a forgotten
+	// one doesn't mute an agent for good.
+	maxSilenceDuration = 30 * 24 * time.Hour
+)
+
+// Acknowledge marks an open alert as being looked into, with an optional
+// comment.
+func (h *AlertHandler) Acknowledge(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req models.AcknowledgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if len(req.Comment) > maxAckComment {
+		http.Error(w, "comment too long", http.StatusBadRequest)
+		return
+	}
+
+	username := auditUsername(r)
+	ok, err := h.Store.AcknowledgeAlert(id, username, req.Comment)
+	if err != nil {
+		slog.Error("acknowledge alert failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "no open alert with this id", http.StatusNotFound)
+		return
+	}
+
+	details, _ := json.Marshal(map[string]string{"comment": req.Comment})
+	if err := h.Store.InsertAuditLog(username, "alert_acknowledge", strconv.FormatInt(id, 10), string(details)); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	h.writeAlert(w, id)
+}
+
+// Resolve resolves an open alert by hand and notifies its channels. If the
+// condition persists, the rule raises a new alert.
+func (h *AlertHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	username := auditUsername(r)
+	ok, err := h.Store.ResolveAlert(id, username)
+	if err != nil {
+		slog.Error("resolve alert failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "no open alert with this id", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Store.InsertAuditLog(username, "alert_resolve", strconv.FormatInt(id, 10), "{}"); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	if err := h.Engine.Resolved(id); err != nil {
+		slog.Error("notify resolved alert failed", "alert", id, "error", err)
+	}
+	h.writeAlert(w, id)
+}
+
+func (h *AlertHandler) writeAlert(w http.ResponseWriter, id int64) {
+	a, err := h.Store.GetAlert(id)
+	if err != nil || a == nil {
+		slog.Error("get alert failed", "alert", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// ListSilences returns the silences that are active or still to come.
+func (h *AlertHandler) ListSilences(w http.ResponseWriter, r *http.Request) {
+	silences, err := h.Store.ListSilences(time.Now())
+	if err != nil {
+		slog.Error("list silences failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if silences == nil {
+		silences = []models.Silence{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(silences)
+}
+
+func (h *AlertHandler) CreateSilence(w http.ResponseWriter, r *http.Request) {
+	var req models.SilenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	sl := models.Silence{
+		AgentID:   strings.TrimSpace(req.AgentID),
+		RuleID:    req.RuleID,
+		Tag:       strings.ToLower(strings.TrimSpace(req.Tag)),
+		Comment:   strings.TrimSpace(req.Comment),
+		CreatedBy: auditUsername(r),
+		StartsAt:  time.Now().UTC(),
+	}
+	if sl.AgentID == "" && sl.RuleID == 0 && sl.Tag == "" {
+		http.Error(w, "an agent, rule or tag is required", http.StatusBadRequest)
+		return
+	}
+	if sl.Tag != "" && !models.ValidTag(sl.Tag) {
+		http.Error(w, "invalid tag", http.StatusBadRequest)
+		return
+	}
+	if sl.Comment == "" {
+		http.Error(w, "comment required", http.StatusBadRequest)
+		return
+	}
+	if len(sl.Comment) > maxSilenceComment {
+		http.Error(w, "comment too long", http.StatusBadRequest)
+		return
+	}
+	if req.StartsAt != nil && req.StartsAt.After(sl.StartsAt) {
+		sl.StartsAt = req.StartsAt.UTC()
+	}
+	switch {
+	case req.EndsAt != nil:
+		sl.EndsAt = req.EndsAt.UTC()
+	case req.DurationSeconds > 0:
+		sl.EndsAt = sl.StartsAt.Add(time.Duration(req.DurationSeconds) * time.Second)
+	default:
+		http.Error(w, "ends_at or duration_seconds required", http.StatusBadRequest)
+		return
+	}
+	if !sl.EndsAt.After(sl.StartsAt) {
+		http.Error(w, "the silence must end after it starts", http.StatusBadRequest)
+		return
+	}
+	if sl.EndsAt.Sub(sl.StartsAt) > maxSilenceDuration {
+		http.Error(w, "a silence can last at most 30 days", http.StatusBadRequest)
+		return
+	}
+	if sl.RuleID != 0 {
+		rule, err := h.Store.GetAlertRule(sl.RuleID)
+		if err != nil {
+			slog.Error("get alert rule failed", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if rule == nil {
+			http.Error(w, "rule not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	created, err := h.Store.CreateSilence(sl)
+	if err != nil {
+		slog.Error("create silence failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	details, _ := json.Marshal(created)
+	if err := h.Store.InsertAuditLog(created.CreatedBy, "silence_create", strconv.FormatInt(created.ID, 10), string(details)); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// ExpireSilence ends a silence early.
+func (h *AlertHandler) ExpireSilence(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	ok, err := h.Store.ExpireSilence(id)
+	if err != nil {
+		slog.Error("expire silence failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "no active silence with this id", http.StatusNotFound)
+		return
+	}
+	if err := h.Store.InsertAuditLog(auditUsername(r), "silence_expire", idStr, "{}"); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
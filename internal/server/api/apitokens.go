@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/go-chi/chi/v5"
+)
+
+// APITokenHandler manages personal API tokens. Every user manages their own;
+// admins can also list and revoke everyone's.
+type APITokenHandler struct {
+	Store *db.Store
+}
+
+func (h *APITokenHandler) List(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromContext(r)
+	var userID int64
+	if r.URL.Query().Get("all") == "" || !user.Role.Allows(models.RoleAdmin) {
+		userID = user.ID
+	}
+
+	tokens, err := h.Store.ListAPITokens(userID)
+	if err != nil {
+		slog.Error("list api tokens failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (h *APITokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+	// A leaked token must not be able to mint new ones
+	if GetAPITokenFromContext(r) != nil {
+		http.Error(w, "API tokens can only be created from a browser session", http.StatusForbidden)
+		return
+	}
+	user := GetUserFromContext(r)
+
+	var req models.APITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = user.Role
+	}
+	if !req.Scope.Valid() || !user.Role.Allows(req.Scope) {
+		http.Error(w, "scope must be a role you have", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "expires_in_days must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		slog.Error("generate api token failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	token := models.APITokenPrefix + secret
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().UTC().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	at, err := h.Store.CreateAPIToken(user.ID, token, req.Name, req.Scope, expiresAt)
+	if err != nil {
+		slog.Error("create api token failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf(`{"name":%q,"scope":"%s"}`, at.Name, at.Scope)
+	if err := h.Store.InsertAuditLog(auditUsername(r), "api_token_create", strconv.FormatInt(at.ID, 10), details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.APITokenCreated{APIToken: *at, Token: token})
+}
+
+func (h *APITokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	at, err := h.Store.GetAPIToken(id)
+	if err != nil {
+		slog.Error("get api token failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	user := GetUserFromContext(r)
+	if at == nil || (at.UserID != user.ID && !user.Role.Allows(models.RoleAdmin)) {
+		http.Error(w, "token not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Store.RevokeAPIToken(id); err != nil {
+		slog.Error("revoke api token failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf(`{"name":%q,"owner":%q}`, at.Name, at.Username)
+	if err := h.Store.InsertAuditLog(auditUsername(r), "api_token_revoke", idStr, details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,28 +3,130 @@ package api
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/oidc"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ratelimit"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/totp"
 	"github.com/cevrimxe/go-mini-rmm/web"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// LoginLockout locks an account for Duration after Threshold consecutive
+// failed logins. A zero Threshold disables lockout.
+type LoginLockout struct {
+	Threshold int
+	Duration  time.Duration
+}
+
+// LoginThrottle slows down password guessing with progressive delays per
+// client IP and per username, on top of the account lockout.
+type LoginThrottle struct {
+	byIP   *ratelimit.Backoff
+	byUser *ratelimit.Backoff
+}
+
+func NewLoginThrottle() *LoginThrottle {
+	return &LoginThrottle{
+		byIP:   ratelimit.NewBackoff(5, time.Second, 5*time.Minute),
+		byUser: ratelimit.NewBackoff(3, time.Second, 5*time.Minute),
+	}
+}
+
+// Wait returns how long the client has to wait before trying again.
+func (t *LoginThrottle) Wait(ip, username string) time.Duration {
+	return max(t.byIP.Wait(ip), t.byUser.Wait(username))
+}
+
+func (t *LoginThrottle) Fail(ip, username string) {
+	t.byIP.Fail(ip)
+	if username != "" {
+		t.byUser.Fail(username)
+	}
+}
+
+func (t *LoginThrottle) Reset(ip, username string) {
+	t.byIP.Reset(ip)
+	t.byUser.Reset(username)
+}
+
+// ResetUser lifts the delay for a username, e.g. when an admin unlocks it.
+func (t *LoginThrottle) ResetUser(username string) {
+	t.byUser.Reset(username)
+}
+
+// SessionPolicy controls dashboard session cookies.
+type SessionPolicy struct {
+	// IdleTimeout ends sessions unused for this long; 0 keeps them until
+	// they expire.
+	IdleTimeout time.Duration
+	// SecureCookie marks the cookie Secure even when the server itself does
+	// not terminate TLS (e.g. behind an HTTPS reverse proxy).
+	SecureCookie bool
+}
+
+const (
+	sessionCookie   = "session"
+	sessionLifetime = 7 * 24 * time.Hour
+	csrfHeader      = "X-CSRF-Token"
+	csrfFormField   = "csrf_token"
+)
+
 type AuthHandler struct {
 	store     *db.Store
+	sessions  SessionPolicy
+	lockout   LoginLockout
+	throttle  *LoginThrottle
+	sso       *oidc.Provider // nil when single sign-on is off
 	loginTmpl *template.Template
+	totpTmpl  *template.Template
 	setupTmpl *template.Template
+
+	mu         sync.Mutex
+	pending    map[string]*pendingLogin // password checked, waiting for the second factor
+	ssoPending map[string]*pendingSSO   // sent to the identity provider, keyed by state
 }
 
-func NewAuthHandler(store *db.Store) *AuthHandler {
+// pendingLogin is a login that passed the password step and waits for a
+// TOTP or recovery code.
+type pendingLogin struct {
+	userID   int64
+	expires  time.Time
+	attempts int
+}
+
+const (
+	pendingLoginTTL      = 5 * time.Minute
+	maxSecondFactorTries = 5
+)
+
+func NewAuthHandler(store *db.Store, sessions SessionPolicy, lockout LoginLockout, throttle *LoginThrottle, sso *oidc.Provider) *AuthHandler {
 	loginTmpl := template.Must(template.ParseFS(web.TemplateFS, "templates/login.html"))
+	totpTmpl := template.Must(template.ParseFS(web.TemplateFS, "templates/login_2fa.html"))
 	setupTmpl := template.Must(template.ParseFS(web.TemplateFS, "templates/setup.html"))
-	return &AuthHandler{store: store, loginTmpl: loginTmpl, setupTmpl: setupTmpl}
+	return &AuthHandler{
+		store:      store,
+		sessions:   sessions,
+		lockout:    lockout,
+		throttle:   throttle,
+		sso:        sso,
+		loginTmpl:  loginTmpl,
+		totpTmpl:   totpTmpl,
+		setupTmpl:  setupTmpl,
+		pending:    make(map[string]*pendingLogin),
+		ssoPending: make(map[string]*pendingSSO),
+	}
 }
 
 func (h *AuthHandler) RequireAuth(next http.Handler) http.Handler {
@@ -35,26 +137,138 @@ func (h *AuthHandler) RequireAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		cookie, err := r.Cookie("session")
+		if token, ok := bearerToken(r); ok {
+			h.serveWithAPIToken(w, r, next, token)
+			return
+		}
+
+		cookie, err := r.Cookie(sessionCookie)
 		if err != nil || cookie.Value == "" {
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
 
-		user, err := h.store.GetUserBySession(cookie.Value)
+		sess, user, err := h.store.GetSession(cookie.Value, h.sessions.IdleTimeout)
 		if err != nil || user == nil {
+			if err != nil {
+				slog.Error("get session failed", "error", err)
+			}
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
 		}
 
+		// SSO users are left to the identity provider's MFA
+		if !user.TOTPEnabled && !user.SSO() && !allowedWithout2FA(r.URL.Path) {
+			settings, err := h.store.GetSettings()
+			if err == nil && settings.Require2FA {
+				if strings.HasPrefix(r.URL.Path, "/api/") {
+					http.Error(w, "two-factor authentication required", http.StatusForbidden)
+					return
+				}
+				http.Redirect(w, r, "/ui/account", http.StatusFound)
+				return
+			}
+		}
+
 		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, sessionContextKey, sess)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequireCSRF rejects state-changing requests from browser sessions that do
+// not carry the session's CSRF token, in the X-CSRF-Token header (fetch and
+// htmx) or the csrf_token form field. API token requests are not
+// cookie-based and need no CSRF token. It must run after RequireAuth.
+func RequireCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		sess := GetSessionFromContext(r)
+		if sess == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.Header.Get(csrfHeader)
+		if token == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			token = r.PostFormValue(csrfFormField)
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) != 1 {
+			slog.Warn("csrf token mismatch", "path", r.URL.Path, "remote", r.RemoteAddr)
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedWithout2FA lists what a user without 2FA may still reach while an
+// admin requires it: enough to enroll or log out.
+func allowedWithout2FA(path string) bool {
+	switch path {
+	case "/ui/account", "/api/v1/account/2fa/setup", "/api/v1/account/2fa/enable", "/logout":
+		return true
+	}
+	return false
+}
+
+// serveWithAPIToken authenticates a request made with a personal API token.
+// The user in the context carries the token's effective role, so RequireRole
+// applies the token scope.
+func (h *AuthHandler) serveWithAPIToken(w http.ResponseWriter, r *http.Request, next http.Handler, bearer string) {
+	token, user, err := h.store.AuthenticateAPIToken(bearer)
+	if err != nil {
+		slog.Error("authenticate api token failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="rmm"`)
+		http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	scoped := *user
+	scoped.Role = token.EffectiveRole(user.Role)
+	ctx := context.WithValue(r.Context(), userContextKey, &scoped)
+	ctx = context.WithValue(ctx, apiTokenContextKey, token)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// RequireRole rejects users whose role does not include min. It must run
+// after RequireAuth.
+func RequireRole(min models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := GetUserFromContext(r)
+			if user == nil || !user.Role.Allows(min) {
+				http.Error(w, "forbidden: requires "+string(min)+" role", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey     contextKey = "user"
+	sessionContextKey  contextKey = "session"
+	apiTokenContextKey contextKey = "api_token"
+)
 
 // GetUserFromContext retrieves the authenticated user from the request context.
 func GetUserFromContext(r *http.Request) *models.User {
@@ -65,6 +279,39 @@ func GetUserFromContext(r *http.Request) *models.User {
 	return user
 }
 
+// GetSessionFromContext returns the browser session of a request, or nil for
+// API token requests.
+func GetSessionFromContext(r *http.Request) *models.Session {
+	sess, ok := r.Context().Value(sessionContextKey).(*models.Session)
+	if !ok {
+		return nil
+	}
+	return sess
+}
+
+// GetAPITokenFromContext returns the API token a request was authenticated
+// with, or nil for browser sessions.
+func GetAPITokenFromContext(r *http.Request) *models.APIToken {
+	token, ok := r.Context().Value(apiTokenContextKey).(*models.APIToken)
+	if !ok {
+		return nil
+	}
+	return token
+}
+
+// auditUsername names who made a request in audit logs. Requests made with an
+// API token also name the token.
+func auditUsername(r *http.Request) string {
+	user := GetUserFromContext(r)
+	if user == nil {
+		return "system"
+	}
+	if token := GetAPITokenFromContext(r); token != nil {
+		return user.Username + " (token: " + token.Name + ")"
+	}
+	return user.Username
+}
+
 func (h *AuthHandler) SetupPage(w http.ResponseWriter, r *http.Request) {
 	hasUsers, _ := h.store.HasUsers()
 	if hasUsers {
@@ -106,7 +353,8 @@ func (h *AuthHandler) Setup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.store.CreateUser(username, string(hash)); err != nil {
+	// The first user administers everything else
+	if err := h.store.CreateUser(username, string(hash), models.RoleAdmin); err != nil {
 		slog.Error("create user error", "error", err)
 		h.setupTmpl.Execute(w, map[string]string{"Error": "Kullanıcı oluşturulamadı"})
 		return
@@ -128,7 +376,7 @@ func (h *AuthHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.loginTmpl.Execute(w, nil)
+	h.renderLogin(w, "")
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -136,66 +384,269 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 
+	if wait := h.throttle.Wait(ratelimit.ClientIP(r), username); wait > 0 {
+		h.renderThrottled(w, wait)
+		return
+	}
+
 	user, err := h.store.GetUserByUsername(username)
 	if err != nil || user == nil {
-		h.loginTmpl.Execute(w, map[string]string{"Error": "Geçersiz kullanıcı adı veya şifre"})
+		h.failLogin(r, nil, username, "password")
+		h.renderLogin(w, "Geçersiz kullanıcı adı veya şifre")
+		return
+	}
+	if user.Locked() {
+		h.throttle.Fail(ratelimit.ClientIP(r), "")
+		h.auditLoginFailure(r, username, "locked")
+		h.renderLogin(w, "Hesap çok fazla hatalı deneme nedeniyle geçici olarak kilitlendi")
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		h.loginTmpl.Execute(w, map[string]string{"Error": "Geçersiz kullanıcı adı veya şifre"})
+		h.failLogin(r, user, username, "password")
+		h.renderLogin(w, "Geçersiz kullanıcı adı veya şifre")
+		return
+	}
+	if user.Disabled {
+		h.auditLoginFailure(r, username, "disabled")
+		h.renderLogin(w, "Hesap devre dışı bırakılmış")
 		return
 	}
 
+	if user.TOTPEnabled {
+		challenge, err := generateToken()
+		if err != nil {
+			slog.Error("generate token error", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		h.mu.Lock()
+		h.prunePendingLocked()
+		h.pending[challenge] = &pendingLogin{userID: user.ID, expires: time.Now().Add(pendingLoginTTL)}
+		h.mu.Unlock()
+
+		h.totpTmpl.Execute(w, map[string]string{"Challenge": challenge})
+		return
+	}
+
+	h.startSession(w, r, user)
+}
+
+// LoginSecondFactor completes a login with a TOTP or recovery code.
+func (h *AuthHandler) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	challenge := r.FormValue("challenge")
+	code := strings.TrimSpace(r.FormValue("code"))
+
+	h.mu.Lock()
+	p := h.pending[challenge]
+	if p == nil || time.Now().After(p.expires) {
+		delete(h.pending, challenge)
+		h.mu.Unlock()
+		h.renderLogin(w, "Doğrulama süresi doldu, tekrar giriş yapın")
+		return
+	}
+	userID := p.userID
+	h.mu.Unlock()
+
+	user, err := h.store.GetUser(userID)
+	if err != nil || user == nil || user.Disabled || !user.TOTPEnabled {
+		h.dropPending(challenge)
+		h.renderLogin(w, "Geçersiz kullanıcı adı veya şifre")
+		return
+	}
+	if user.Locked() {
+		h.dropPending(challenge)
+		h.renderLogin(w, "Hesap çok fazla hatalı deneme nedeniyle geçici olarak kilitlendi")
+		return
+	}
+	if wait := h.throttle.Wait(ratelimit.ClientIP(r), user.Username); wait > 0 {
+		h.renderThrottled(w, wait)
+		return
+	}
+
+	ok, usedRecovery, err := h.checkSecondFactor(user, code)
+	if err != nil {
+		slog.Error("check second factor failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.failLogin(r, user, user.Username, "totp")
+
+		h.mu.Lock()
+		p.attempts++
+		exhausted := p.attempts >= maxSecondFactorTries
+		if exhausted {
+			delete(h.pending, challenge)
+		}
+		h.mu.Unlock()
+
+		if exhausted {
+			h.renderLogin(w, "Çok fazla hatalı deneme, tekrar giriş yapın")
+			return
+		}
+		h.totpTmpl.Execute(w, map[string]string{"Challenge": challenge, "Error": "Geçersiz doğrulama kodu"})
+		return
+	}
+
+	h.dropPending(challenge)
+	if usedRecovery {
+		remaining, _ := h.store.CountRecoveryCodes(user.ID)
+		details := fmt.Sprintf(`{"remaining":%d}`, remaining)
+		if err := h.store.InsertAuditLog(user.Username, "2fa_recovery_code_used", user.Username, details); err != nil {
+			slog.Error("failed to insert audit log", "error", err)
+		}
+	}
+	h.startSession(w, r, user)
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code.
+func (h *AuthHandler) checkSecondFactor(user *models.User, code string) (ok, usedRecovery bool, err error) {
+	if len(code) == totp.Digits {
+		step, valid := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+		if !valid {
+			return false, false, nil
+		}
+		ok, err := h.store.UseTOTPStep(user.ID, step)
+		return ok, false, err
+	}
+	ok, err = h.store.UseRecoveryCode(user.ID, code)
+	return ok, ok, err
+}
+
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.throttle.Reset(ratelimit.ClientIP(r), user.Username)
+	if err := h.store.ResetLoginFailures(user.ID); err != nil {
+		slog.Error("reset login failures failed", "error", err)
+	}
+
 	token, err := generateToken()
 	if err != nil {
 		slog.Error("generate token error", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	csrfToken, err := generateToken()
+	if err != nil {
+		slog.Error("generate token error", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
 
-	expires := time.Now().UTC().Add(7 * 24 * time.Hour)
-	if err := h.store.CreateSession(token, user.ID, expires); err != nil {
+	sess := &models.Session{
+		Token:     token,
+		CSRFToken: csrfToken,
+		UserID:    user.ID,
+		IP:        ratelimit.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		ExpiresAt: time.Now().UTC().Add(sessionLifetime),
+	}
+	if err := h.store.CreateSession(sess); err != nil {
 		slog.Error("create session error", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   86400 * 7,
-	})
+	h.setSessionCookie(w, r, token, int(sessionLifetime/time.Second))
 
-	slog.Info("user logged in", "username", username)
+	slog.Info("user logged in", "username", user.Username)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+// failLogin records a failed password or code for the throttle and the
+// audit log and, for existing users, counts towards the account lockout.
+func (h *AuthHandler) failLogin(r *http.Request, user *models.User, username, reason string) {
+	h.throttle.Fail(ratelimit.ClientIP(r), username)
+	h.auditLoginFailure(r, username, reason)
+	if user == nil || h.lockout.Threshold <= 0 {
+		return
+	}
+
+	until, err := h.store.RecordLoginFailure(user.ID, h.lockout.Threshold, h.lockout.Duration)
+	if err != nil {
+		slog.Error("record login failure failed", "error", err)
+		return
+	}
+	if until != nil {
+		slog.Warn("account locked", "username", username, "until", until)
+		details := fmt.Sprintf(`{"failures":%d,"locked_until":"%s","remote":%q}`, h.lockout.Threshold, until.Format(time.RFC3339), r.RemoteAddr)
+		if err := h.store.InsertAuditLog("system", "user_locked", username, details); err != nil {
+			slog.Error("failed to insert audit log", "error", err)
+		}
+	}
+}
+
+func (h *AuthHandler) renderThrottled(w http.ResponseWriter, wait time.Duration) {
+	seconds := ratelimit.RetryAfterSeconds(wait)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	h.renderLogin(w, fmt.Sprintf("Çok fazla hatalı deneme. %d saniye sonra tekrar deneyin", seconds))
+}
+
+// renderLogin shows the login form, with the SSO button when configured.
+func (h *AuthHandler) renderLogin(w http.ResponseWriter, errMsg string) {
+	h.loginTmpl.Execute(w, map[string]any{"Error": errMsg, "SSO": h.sso != nil})
+}
+
+func (h *AuthHandler) auditLoginFailure(r *http.Request, username, reason string) {
+	slog.Warn("login failed", "username", username, "reason", reason, "remote", r.RemoteAddr)
+	details := fmt.Sprintf(`{"reason":"%s","remote":%q}`, reason, r.RemoteAddr)
+	if err := h.store.InsertAuditLog(username, "login_failed", username, details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+}
+
+func (h *AuthHandler) dropPending(challenge string) {
+	h.mu.Lock()
+	delete(h.pending, challenge)
+	h.mu.Unlock()
+}
+
+func (h *AuthHandler) prunePendingLocked() {
+	now := time.Now()
+	for k, p := range h.pending {
+		if now.After(p.expires) {
+			delete(h.pending, k)
+		}
+	}
+	for k, p := range h.ssoPending {
+		if now.After(p.expires) {
+			delete(h.ssoPending, k)
+		}
+	}
+}
+
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session")
+	cookie, err := r.Cookie(sessionCookie)
 	if err == nil {
 		h.store.DeleteSession(cookie.Value)
 	}
 
+	h.setSessionCookie(w, r, "", -1)
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// setSessionCookie sets the session cookie; it is Secure whenever the
+// request came over TLS or the policy asks for it.
+func (h *AuthHandler) setSessionCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    "",
+		Name:     sessionCookie,
+		Value:    value,
 		Path:     "/",
 		HttpOnly: true,
-		MaxAge:   -1,
+		Secure:   h.sessions.SecureCookie || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
 	})
-
-	http.Redirect(w, r, "/login", http.StatusFound)
 }
 
 func (h *AuthHandler) isLoggedIn(r *http.Request) bool {
-	cookie, err := r.Cookie("session")
+	cookie, err := r.Cookie(sessionCookie)
 	if err != nil || cookie.Value == "" {
 		return false
 	}
-	user, err := h.store.GetUserBySession(cookie.Value)
+	_, user, err := h.store.GetSession(cookie.Value, h.sessions.IdleTimeout)
 	return err == nil && user != nil
 }
 
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+)
+
+// testUser creates a user with a dashboard session and returns the session.
+func testUser(t *testing.T, store *db.Store, username string, role models.Role) *models.Session {
+	t.Helper()
+	if err := store.CreateUser(username, "x", role); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	user, err := store.GetUserByUsername(username)
+	if err != nil || user == nil {
+		t.Fatalf("get user: %v", err)
+	}
+	sess := &models.Session{
+		Token:     "session-" + username,
+		CSRFToken: "csrf-" + username,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := store.CreateSession(sess); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	return sess
+}
+
+// testAPIToken issues an API token with scope to a user made by testUser.
+func testAPIToken(t *testing.T, store *db.Store, sess *models.Session, scope models.Role) string {
+	t.Helper()
+	token := "token-" + sess.Token + "-" + string(scope)
+	if _, err := store.CreateAPIToken(sess.UserID, token, "test", scope, nil); err != nil {
+		t.Fatalf("create api token: %v", err)
+	}
+	return token
+}
+
+func TestRequireCSRF(t *testing.T) {
+	store := setupTestDB(t)
+	sess := testUser(t, store, "alice", models.RoleOperator)
+	token := testAPIToken(t, store, sess, models.RoleOperator)
+
+	auth := NewAuthHandler(store, SessionPolicy{}, LoginLockout{}, NewLoginThrottle(), nil)
+	handler := auth.RequireAuth(RequireCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	form := url.Values{csrfFormField: {sess.CSRFToken}}.Encode()
+	cases := []struct {
+		name        string
+		method      string
+		body        string
+		contentType string
+		csrfHeader  string
+		bearer      string
+		want        int
+	}{
+		{name: "GET needs no token", method: "GET", want: http.StatusNoContent},
+		{name: "POST without token", method: "POST", want: http.StatusForbidden},
+		{name: "POST with wrong header", method: "POST", csrfHeader: "csrf-mallory", want: http.StatusForbidden},
+		{name: "POST with header", method: "POST", csrfHeader: sess.CSRFToken, want: http.StatusNoContent},
+		{name: "DELETE with header", method: "DELETE", csrfHeader: sess.CSRFToken, want: http.StatusNoContent},
+		{name: "POST with form field", method: "POST", body: form, contentType: "application/x-www-form-urlencoded", want: http.StatusNoContent},
+		{name: "form field in a JSON body", method: "POST", body: form, contentType: "application/json", want: http.StatusForbidden},
+		{name: "POST with API token", method: "POST", bearer: token, want: http.StatusNoContent},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, "/api/v1/test", strings.NewReader(c.body))
+		if c.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+c.bearer)
+		} else {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: sess.Token})
+		}
+		if c.contentType != "" {
+			r.Header.Set("Content-Type", c.contentType)
+		}
+		if c.csrfHeader != "" {
+			r.Header.Set(csrfHeader, c.csrfHeader)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, w.Code, c.want)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
@@ -15,9 +17,12 @@ import (
 type CommandHandler struct {
 	Store *db.Store
 	Hub   *ws.Hub
+	// DefaultTimeout applies when a request does not set timeout_seconds.
+	DefaultTimeout time.Duration
 }
 
 func (h *CommandHandler) Send(w http.ResponseWriter, r *http.Request) {
+	store := h.Store.WithContext(r.Context())
 	agentID := chi.URLParam(r, "id")
 
 	var req models.CommandRequest
@@ -30,43 +35,66 @@ func (h *CommandHandler) Send(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "command required", http.StatusBadRequest)
 		return
 	}
+	if req.TimeoutSeconds < 0 {
+		http.Error(w, "timeout_seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+	timeout := req.TimeoutSeconds
+	if timeout == 0 {
+		timeout = int(h.DefaultTimeout / time.Second)
+	}
 
 	// Check agent exists
-	agent, err := h.Store.GetAgent(agentID)
+	agent, err := store.GetAgent(agentID)
 	if err != nil || agent == nil {
 		http.Error(w, "agent not found", http.StatusNotFound)
 		return
 	}
+	if req.RequireMaintenance {
+		windows, err := store.ListMaintenanceWindows()
+		if err != nil {
+			slog.Error("list maintenance windows failed", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if window, _ := models.ActiveMaintenance(windows, *agent, time.Now()); window == nil {
+			http.Error(w, "agent is not in a maintenance window", http.StatusConflict)
+			return
+		}
+	}
 
 	// Create command record
-	cmd, err := h.Store.CreateCommand(agentID, req.Command)
+	cmd, err := store.CreateCommand(agentID, req.Command, timeout)
 	if err != nil {
 		slog.Error("create command failed", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	// Insert audit log
-	user := GetUserFromContext(r)
-	username := "system"
-	if user != nil {
-		username = user.Username
+	// Insert audit log, linked to the command's transcript
+	username := auditUsername(r)
+	recordingID, err := h.Hub.RecordCommand(cmd, username)
+	if err != nil {
+		slog.Error("create command recording failed", "error", err)
 	}
 	details := `{"command": "` + req.Command + `"}`
-	if err := h.Store.InsertAuditLog(username, "command_execution", agentID, details); err != nil {
+	if err := store.InsertAuditLogWithRecording(username, "command_execution", agentID, details, recordingID); err != nil {
 		slog.Error("failed to insert audit log", "error", err)
 	}
 
-	// Send via WebSocket
+	// Deliver via WebSocket now, or queue until the agent reconnects
 	msg := models.WSMessage{
 		Type: "command",
 		Payload: map[string]interface{}{
-			"command_id": cmd.ID,
-			"command":    req.Command,
+			"command_id":      cmd.ID,
+			"command":         req.Command,
+			"timeout_seconds": timeout,
 		},
 	}
-	if err := h.Hub.SendToAgent(agentID, msg); err != nil {
-		slog.Warn("agent not connected via ws", "agent_id", agentID, "error", err)
+	if err := h.Hub.Dispatch(r.Context(), agentID, models.QueueCommand, cmd.ID, req.Command, msg); err != nil {
+		slog.Error("queue command failed", "agent_id", agentID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -96,3 +124,147 @@ func (h *CommandHandler) List(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(cmds)
 }
+
+// Cancel asks the agent to kill a pending or running command. A command that
+// has not started yet is marked cancelled right away.
+func (h *CommandHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	store := h.Store.WithContext(r.Context())
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	cmd, err := store.GetCommand(id)
+	if err != nil {
+		slog.Error("get command failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if cmd == nil {
+		http.Error(w, "command not found", http.StatusNotFound)
+		return
+	}
+	if cmd.Status.Finished() {
+		http.Error(w, "command already finished", http.StatusConflict)
+		return
+	}
+
+	// Drop it from the queue if it was never delivered
+	if err := store.CancelQueuedRef(models.QueueCommand, cmd.ID); err != nil {
+		slog.Error("cancel queued command failed", "error", err)
+	}
+	msg := models.WSMessage{
+		Type:    "command_cancel",
+		Payload: map[string]interface{}{"command_id": cmd.ID},
+	}
+	sendErr := h.Hub.SendToAgent(r.Context(), cmd.AgentID, msg)
+	if cmd.Status == models.CommandRunning && sendErr != nil {
+		http.Error(w, "agent not connected", http.StatusConflict)
+		return
+	}
+	if cmd.Status == models.CommandPending {
+		// Not started yet: the agent drops it if it arrives; record the outcome now
+		if err := h.Hub.FinishCommand(r.Context(), cmd.ID, -1, models.CommandCancelled); err != nil {
+			slog.Error("cancel command failed", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	username := auditUsername(r)
+	details := fmt.Sprintf(`{"command_id":%d,"status":"%s"}`, cmd.ID, cmd.Status)
+	if err := store.InsertAuditLog(username, "command_cancel", cmd.AgentID, details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Stream tails a command's output as server-sent events. It first replays the
+// output stored so far, then forwards live chunks until the command finishes.
+func (h *CommandHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe before reading the snapshot so no chunk falls in between;
+	// overlap is trimmed using the chunk offsets.
+	events, cancel := h.Hub.SubscribeCommand(id)
+	defer cancel()
+
+	cmd, err := h.Store.GetCommand(id)
+	if err != nil {
+		slog.Error("get command failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if cmd == nil {
+		http.Error(w, "command not found", http.StatusNotFound)
+		return
+	}
+
+	// The server's WriteTimeout would cut long streams
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	sent := map[string]int64{
+		models.StreamStdout: int64(len(cmd.Stdout)),
+		models.StreamStderr: int64(len(cmd.Stderr)),
+	}
+	writeEvent(w, ws.CommandEvent{Type: "output", Stream: models.StreamStdout, Data: cmd.Stdout})
+	writeEvent(w, ws.CommandEvent{Type: "output", Stream: models.StreamStderr, Data: cmd.Stderr})
+	writeEvent(w, ws.CommandEvent{Type: "status", Status: cmd.Status})
+	if cmd.Status.Finished() {
+		writeEvent(w, ws.CommandEvent{Type: "done", Status: cmd.Status, ExitCode: cmd.ExitCode})
+		rc.Flush()
+		return
+	}
+	rc.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				// Dropped as a slow subscriber; EventSource reconnects and replays
+				return
+			}
+			if ev.Type == "output" {
+				end := ev.Offset + int64(len(ev.Data))
+				if end <= sent[ev.Stream] {
+					continue
+				}
+				if ev.Offset < sent[ev.Stream] {
+					ev.Data = ev.Data[sent[ev.Stream]-ev.Offset:]
+				}
+				sent[ev.Stream] = end
+			}
+			writeEvent(w, ev)
+			if ev.Type == "done" {
+				rc.Flush()
+				return
+			}
+		}
+		rc.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, ev ws.CommandEvent) {
+	if ev.Type == "output" && ev.Data == "" {
+		return
+	}
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+}
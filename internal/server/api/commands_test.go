@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestSendRequiresMaintenance(t *testing.T) {
+	store := setupTestDB(t)
+	hub := ws.NewHub(store)
+	hub.RecordingDir = t.TempDir()
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host-1"})
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-2", Hostname: "host-2"})
+	start := time.Now().Add(-time.Minute)
+	if _, err := store.CreateMaintenanceWindow(models.MaintenanceWindow{Name: "patching", StartsAt: &start, DurationSeconds: 3600, AgentIDs: []string{"agent-1"}}); err != nil {
+		t.Fatalf("create window: %v", err)
+	}
+
+	h := &CommandHandler{Store: store, Hub: hub, DefaultTimeout: time.Minute}
+	router := chi.NewRouter()
+	router.Post("/agents/{id}/command", h.Send)
+	send := func(agentID, body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/agents/"+agentID+"/command", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := send("agent-1", `{"command":"apt upgrade","require_maintenance":true}`); code != http.StatusCreated {
+		t.Errorf("agent in a window: expected 201, got %d", code)
+	}
+	if code := send("agent-2", `{"command":"apt upgrade","require_maintenance":true}`); code != http.StatusConflict {
+		t.Errorf("agent outside a window: expected 409, got %d", code)
+	}
+	if code := send("agent-2", `{"command":"uptime"}`); code != http.StatusCreated {
+		t.Errorf("without require_maintenance: expected 201, got %d", code)
+	}
+	if cmds, _ := store.GetCommandsByAgent("agent-2", 10); len(cmds) != 1 {
+		t.Errorf("agent-2 has %d commands, want only the unrestricted one", len(cmds))
+	}
+}
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/pki"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+	"github.com/go-chi/chi/v5"
+)
+
+type EnrollmentHandler struct {
+	Store *db.Store
+	Hub   *ws.Hub
+	CA    *pki.CA // nil unless the server runs its own CA (mTLS)
+}
+
+// Enroll exchanges an enrollment token for a per-agent secret (public, agent-facing).
+func (h *EnrollmentHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	var req models.EnrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if req.AgentID == "" || req.Token == "" {
+		http.Error(w, "agent_id and token required", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		slog.Error("generate agent secret failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.Store.EnrollAgent(req.Token, req.AgentID, req.DisplayName, secret)
+	switch {
+	case errors.Is(err, db.ErrInvalidEnrollmentToken):
+		slog.Warn("enrollment rejected", "agent_id", req.AgentID, "remote", r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, db.ErrAgentAlreadyEnrolled):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		slog.Error("enroll agent failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := models.EnrollResponse{AgentID: req.AgentID, Secret: secret}
+	if h.CA != nil && req.CSR != "" {
+		cert, err := h.issueCertificate(req.AgentID, req.CSR)
+		if err != nil {
+			// The secret is stored already; the agent can retry via /api/v1/agent/certificate
+			slog.Warn("issue certificate at enrollment failed", "agent_id", req.AgentID, "error", err)
+		} else {
+			resp.Certificate = cert
+			resp.CACertificate = string(h.CA.CertPEM())
+		}
+	}
+
+	details := fmt.Sprintf(`{"remote":"%s","certificate":%t}`, r.RemoteAddr, resp.Certificate != "")
+	if err := h.Store.InsertAuditLog("system", "agent_enrolled", req.AgentID, details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	slog.Info("agent enrolled", "agent_id", req.AgentID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// IssueCertificate signs a client certificate for an enrolled agent
+// (agent-facing, secret auth only). Refused while the agent's certificate is
+// revoked and no admin has allowed a reissue.
+func (h *EnrollmentHandler) IssueCertificate(w http.ResponseWriter, r *http.Request) {
+	if h.CA == nil {
+		http.Error(w, "mTLS not enabled", http.StatusNotFound)
+		return
+	}
+	agentID := GetAgentIDFromContext(r)
+	agent, err := h.Store.GetAgent(agentID)
+	if err != nil || agent == nil {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if agent.CertRevoked {
+		http.Error(w, "certificate revoked", http.StatusForbidden)
+		return
+	}
+
+	var req models.CertificateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CSR == "" {
+		http.Error(w, "csr required", http.StatusBadRequest)
+		return
+	}
+	cert, err := h.issueCertificate(agentID, req.CSR)
+	if err != nil {
+		slog.Warn("issue certificate failed", "agent_id", agentID, "error", err)
+		http.Error(w, "invalid csr", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.InsertAuditLog("system", "agent_certificate_issue", agentID, "{}"); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.CertificateResponse{
+		Certificate:   cert,
+		CACertificate: string(h.CA.CertPEM()),
+	})
+}
+
+func (h *EnrollmentHandler) issueCertificate(agentID, csr string) (string, error) {
+	certPEM, serial, err := h.CA.SignAgentCSR([]byte(csr), agentID)
+	if err != nil {
+		return "", err
+	}
+	if err := h.Store.SetAgentCertificate(agentID, serial); err != nil {
+		return "", err
+	}
+	return string(certPEM), nil
+}
+
+// CACertificate serves the CA certificate so agents and install scripts can
+// pin it (public).
+func (h *EnrollmentHandler) CACertificate(w http.ResponseWriter, r *http.Request) {
+	if h.CA == nil {
+		http.Error(w, "server CA not enabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(h.CA.CertPEM())
+}
+
+func (h *EnrollmentHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.Store.ListEnrollmentTokens()
+	if err != nil {
+		slog.Error("list enrollment tokens failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if tokens == nil {
+		tokens = []models.EnrollmentToken{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (h *EnrollmentHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	var req models.EnrollmentTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses < 0 || req.ExpiresInHours < 0 {
+		http.Error(w, "max_uses and expires_in_hours must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		slog.Error("generate enrollment token failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInHours > 0 {
+		t := time.Now().UTC().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		expiresAt = &t
+	}
+
+	username := auditUsername(r)
+
+	et, err := h.Store.CreateEnrollmentToken(token, req.Description, req.MaxUses, expiresAt, username)
+	if err != nil {
+		slog.Error("create enrollment token failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf(`{"description":"%s","max_uses":%d}`, req.Description, req.MaxUses)
+	if err := h.Store.InsertAuditLog(username, "enrollment_token_create", strconv.FormatInt(et.ID, 10), details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.EnrollmentTokenCreated{EnrollmentToken: *et, Token: token})
+}
+
+func (h *EnrollmentHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.RevokeEnrollmentToken(id); err != nil {
+		slog.Error("revoke enrollment token failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	username := auditUsername(r)
+	if err := h.Store.InsertAuditLog(username, "enrollment_token_revoke", idStr, "{}"); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeCredential invalidates the agent's secret and drops its live connection.
+func (h *EnrollmentHandler) RevokeCredential(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	agent, err := h.Store.GetAgent(agentID)
+	if err != nil || agent == nil {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Store.RevokeAgentCredential(agentID); err != nil {
+		slog.Error("revoke agent credential failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.Hub.Disconnect(agentID)
+
+	username := auditUsername(r)
+	if err := h.Store.InsertAuditLog(username, "agent_credential_revoke", agentID, "{}"); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateCredential pushes a fresh secret to a connected agent over its
+// WebSocket. The new secret is stored as pending first and replaces the old
+// one once the agent acknowledges it; without an ack both stay valid until
+// the agent authenticates with the new one, so it is never locked out.
+func (h *EnrollmentHandler) RotateCredential(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	agent, err := h.Store.GetAgent(agentID)
+	if err != nil || agent == nil {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if !h.Hub.IsConnected(agentID) {
+		http.Error(w, "agent must be connected to rotate its credential", http.StatusConflict)
+		return
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		slog.Error("generate agent secret failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Store.SetPendingAgentSecret(agentID, secret); err != nil {
+		slog.Error("store pending secret failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	username := auditUsername(r)
+	result, err := h.Hub.RotateCredential(r.Context(), agentID, secret)
+	if err != nil {
+		// The agent may still have saved the secret; it takes over when the
+		// agent next authenticates with it.
+		slog.Warn("credential rotation not acknowledged", "agent_id", agentID, "error", err)
+		details, _ := json.Marshal(map[string]string{"status": "pending", "error": err.Error()})
+		if err := h.Store.InsertAuditLog(username, "agent_credential_rotate", agentID, string(details)); err != nil {
+			slog.Error("failed to insert audit log", "error", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "pending",
+			"message": "agent did not acknowledge the new credential; the old one stays valid until the agent uses the new one",
+		})
+		return
+	}
+	if result.Error != "" {
+		slog.Warn("agent failed to store rotated credential", "agent_id", agentID, "error", result.Error)
+		if err := h.Store.ClearPendingAgentSecret(agentID, secret); err != nil {
+			slog.Error("clear pending secret failed", "error", err)
+		}
+		http.Error(w, "agent could not store the new credential: "+result.Error, http.StatusBadGateway)
+		return
+	}
+	if err := h.Store.SetAgentSecret(agentID, secret); err != nil {
+		slog.Error("store rotated secret failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Store.InsertAuditLog(username, "agent_credential_rotate", agentID, "{}"); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeCertificate invalidates the agent's client certificate and drops its
+// live connection; reconnects with that certificate are refused.
+func (h *EnrollmentHandler) RevokeCertificate(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	agent, err := h.Store.GetAgent(agentID)
+	if err != nil || agent == nil {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Store.RevokeAgentCertificate(agentID); err != nil {
+		slog.Error("revoke agent certificate failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.Hub.Disconnect(agentID)
+
+	username := auditUsername(r)
+	details := fmt.Sprintf(`{"serial":"%s"}`, agent.CertSerial)
+	if err := h.Store.InsertAuditLog(username, "agent_certificate_revoke", agentID, details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AllowCertificateReissue lets an agent with a revoked certificate request a
+// new one with its secret.
+func (h *EnrollmentHandler) AllowCertificateReissue(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	agent, err := h.Store.GetAgent(agentID)
+	if err != nil || agent == nil {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Store.AllowAgentCertificateReissue(agentID); err != nil {
+		slog.Error("allow certificate reissue failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	username := auditUsername(r)
+	if err := h.Store.InsertAuditLog(username, "agent_certificate_reissue", agentID, "{}"); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -20,9 +20,9 @@ import (
 const maxUploadSize = 50 << 20 // 50 MB
 
 type FileTransferHandler struct {
-	Store      *db.Store
-	Hub        *ws.Hub
-	UploadDir  string
+	Store     *db.Store
+	Hub       *ws.Hub
+	UploadDir string
 }
 
 func NewFileTransferHandler(store *db.Store, hub *ws.Hub, uploadDir string) *FileTransferHandler {
@@ -32,10 +32,11 @@ func NewFileTransferHandler(store *db.Store, hub *ws.Hub, uploadDir string) *Fil
 
 // Upload handles multipart file upload from dashboard → server → agent
 func (h *FileTransferHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	store := h.Store.WithContext(r.Context())
 	agentID := chi.URLParam(r, "id")
 
 	// Check agent exists
-	agent, err := h.Store.GetAgent(agentID)
+	agent, err := store.GetAgent(agentID)
 	if err != nil || agent == nil {
 		http.Error(w, "agent not found", http.StatusNotFound)
 		return
@@ -80,7 +81,7 @@ func (h *FileTransferHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create DB record
-	ft, err := h.Store.CreateFileTransfer(agentID, header.Filename, written, models.TransferToAgent, storagePath, remotePath)
+	ft, err := store.CreateFileTransfer(agentID, header.Filename, written, models.TransferToAgent, storagePath, remotePath)
 	if err != nil {
 		slog.Error("create file transfer failed", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
@@ -88,13 +89,9 @@ func (h *FileTransferHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Audit log
-	user := GetUserFromContext(r)
-	username := "system"
-	if user != nil {
-		username = user.Username
-	}
+	username := auditUsername(r)
 	details := fmt.Sprintf(`{"file":"%s","remote_path":"%s","size":%d}`, header.Filename, remotePath, written)
-	if err := h.Store.InsertAuditLog(username, "file_upload", agentID, details); err != nil {
+	if err := store.InsertAuditLog(username, "file_upload", agentID, details); err != nil {
 		slog.Error("failed to insert audit log", "error", err)
 	}
 
@@ -108,8 +105,10 @@ func (h *FileTransferHandler) Upload(w http.ResponseWriter, r *http.Request) {
 			"file_size":   written,
 		},
 	}
-	if err := h.Hub.SendToAgent(agentID, msg); err != nil {
-		slog.Warn("agent not connected via ws for file transfer", "agent_id", agentID, "error", err)
+	if err := h.Hub.Dispatch(r.Context(), agentID, models.QueueFileTransfer, ft.ID, "upload "+header.Filename+" → "+remotePath, msg); err != nil {
+		slog.Error("queue file transfer failed", "agent_id", agentID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -119,9 +118,10 @@ func (h *FileTransferHandler) Upload(w http.ResponseWriter, r *http.Request) {
 
 // RequestDownload requests a file from the agent
 func (h *FileTransferHandler) RequestDownload(w http.ResponseWriter, r *http.Request) {
+	store := h.Store.WithContext(r.Context())
 	agentID := chi.URLParam(r, "id")
 
-	agent, err := h.Store.GetAgent(agentID)
+	agent, err := store.GetAgent(agentID)
 	if err != nil || agent == nil {
 		http.Error(w, "agent not found", http.StatusNotFound)
 		return
@@ -140,7 +140,7 @@ func (h *FileTransferHandler) RequestDownload(w http.ResponseWriter, r *http.Req
 	}
 
 	fileName := filepath.Base(req.RemotePath)
-	ft, err := h.Store.CreateFileTransfer(agentID, fileName, 0, models.TransferFromAgent, "", req.RemotePath)
+	ft, err := store.CreateFileTransfer(agentID, fileName, 0, models.TransferFromAgent, "", req.RemotePath)
 	if err != nil {
 		slog.Error("create file transfer failed", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
@@ -148,13 +148,9 @@ func (h *FileTransferHandler) RequestDownload(w http.ResponseWriter, r *http.Req
 	}
 
 	// Audit log
-	user := GetUserFromContext(r)
-	username := "system"
-	if user != nil {
-		username = user.Username
-	}
+	username := auditUsername(r)
 	details := fmt.Sprintf(`{"remote_path":"%s"}`, req.RemotePath)
-	if err := h.Store.InsertAuditLog(username, "file_download_request", agentID, details); err != nil {
+	if err := store.InsertAuditLog(username, "file_download_request", agentID, details); err != nil {
 		slog.Error("failed to insert audit log", "error", err)
 	}
 
@@ -166,8 +162,10 @@ func (h *FileTransferHandler) RequestDownload(w http.ResponseWriter, r *http.Req
 			"remote_path": req.RemotePath,
 		},
 	}
-	if err := h.Hub.SendToAgent(agentID, msg); err != nil {
-		slog.Warn("agent not connected via ws for file transfer", "agent_id", agentID, "error", err)
+	if err := h.Hub.Dispatch(r.Context(), agentID, models.QueueFileTransfer, ft.ID, "download "+req.RemotePath, msg); err != nil {
+		slog.Error("queue file transfer failed", "agent_id", agentID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -208,7 +206,7 @@ func (h *FileTransferHandler) ServeFile(w http.ResponseWriter, r *http.Request)
 	}
 
 	ft, err := h.Store.GetFileTransfer(transferID)
-	if err != nil || ft == nil {
+	if err != nil || ft == nil || ft.AgentID != GetAgentIDFromContext(r) {
 		http.Error(w, "transfer not found", http.StatusNotFound)
 		return
 	}
@@ -228,6 +226,7 @@ func (h *FileTransferHandler) ServeFile(w http.ResponseWriter, r *http.Request)
 
 // ReceiveFile handles file upload from agent (agent pushes to server)
 func (h *FileTransferHandler) ReceiveFile(w http.ResponseWriter, r *http.Request) {
+	store := h.Store.WithContext(r.Context())
 	transferID, err := strconv.ParseInt(chi.URLParam(r, "transferID"), 10, 64)
 	if err != nil {
 		slog.Warn("ReceiveFile: invalid transfer id", "err", err)
@@ -235,8 +234,8 @@ func (h *FileTransferHandler) ReceiveFile(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	ft, err := h.Store.GetFileTransfer(transferID)
-	if err != nil || ft == nil {
+	ft, err := store.GetFileTransfer(transferID)
+	if err != nil || ft == nil || ft.AgentID != GetAgentIDFromContext(r) {
 		slog.Warn("ReceiveFile: transfer not found", "id", transferID)
 		http.Error(w, "transfer not found", http.StatusNotFound)
 		return
@@ -275,8 +274,8 @@ func (h *FileTransferHandler) ReceiveFile(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	h.Store.UpdateFileTransferStorage(transferID, storagePath, written)
-	h.Store.UpdateFileTransferStatus(transferID, models.TransferDone, "")
+	store.UpdateFileTransferStorage(transferID, storagePath, written)
+	store.UpdateFileTransferStatus(transferID, models.TransferDone, "")
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
@@ -311,7 +310,7 @@ func (h *FileTransferHandler) Browse(w http.ResponseWriter, r *http.Request) {
 	agentID := chi.URLParam(r, "id")
 	path := r.URL.Query().Get("path")
 
-	result, err := h.Hub.BrowseAgent(agentID, path)
+	result, err := h.Hub.BrowseAgent(r.Context(), agentID, path)
 	if err != nil {
 		slog.Warn("browse agent failed", "agent_id", agentID, "error", err)
 		http.Error(w, err.Error(), http.StatusBadGateway)
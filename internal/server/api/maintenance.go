@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxMaintenanceComment = 1000
+	// maxMaintenanceDuration caps one window; longer downtime is better
+	// handled by removing the agent.
+	maxMaintenanceDuration = 7 * 24 * time.Hour
+)
+
+// MaintenanceHandler manages maintenance windows, during which alerts are
+// recorded but not notified.
+type MaintenanceHandler struct {
+	Store *db.Store
+}
+
+func (h *MaintenanceHandler) List(w http.ResponseWriter, r *http.Request) {
+	windows, err := h.Store.ListMaintenanceWindows()
+	if err != nil {
+		slog.Error("list maintenance windows failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if windows == nil {
+		windows = []models.MaintenanceWindow{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(windows)
+}
+
+func (h *MaintenanceHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.MaintenanceWindowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	mw := models.MaintenanceWindow{
+		Name:            strings.TrimSpace(req.Name),
+		Schedule:        strings.Join(strings.Fields(req.Schedule), " "),
+		Timezone:        strings.TrimSpace(req.Timezone),
+		DurationSeconds: req.DurationSeconds,
+		Comment:         strings.TrimSpace(req.Comment),
+		CreatedBy:       auditUsername(r),
+	}
+	if mw.Name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+	if len(mw.Comment) > maxMaintenanceComment {
+		http.Error(w, "comment too long", http.StatusBadRequest)
+		return
+	}
+	if mw.Duration() < time.Minute || mw.Duration() > maxMaintenanceDuration {
+		http.Error(w, "duration_seconds must be between 60 and 604800 (7 days)", http.StatusBadRequest)
+		return
+	}
+
+	if mw.Schedule != "" {
+		if _, err := models.ParseCron(mw.Schedule); err != nil {
+			http.Error(w, "invalid schedule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if mw.Timezone == "" {
+			mw.Timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(mw.Timezone); err != nil {
+			http.Error(w, "unknown timezone", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if req.StartsAt == nil {
+			http.Error(w, "schedule or starts_at required", http.StatusBadRequest)
+			return
+		}
+		start := req.StartsAt.UTC()
+		mw.StartsAt = &start
+		mw.Timezone = ""
+		if mw.Ended(time.Now()) {
+			http.Error(w, "the window is already over", http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, id := range req.AgentIDs {
+		if id = strings.TrimSpace(id); id != "" && !slices.Contains(mw.AgentIDs, id) {
+			mw.AgentIDs = append(mw.AgentIDs, id)
+		}
+	}
+	for _, tag := range req.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || slices.Contains(mw.Tags, tag) {
+			continue
+		}
+		if !models.ValidTag(tag) {
+			http.Error(w, "invalid tag "+tag, http.StatusBadRequest)
+			return
+		}
+		mw.Tags = append(mw.Tags, tag)
+	}
+	if len(mw.AgentIDs) == 0 && len(mw.Tags) == 0 {
+		http.Error(w, "at least one agent or tag is required", http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.Store.CreateMaintenanceWindow(mw)
+	if err != nil {
+		slog.Error("create maintenance window failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	details, _ := json.Marshal(created)
+	if err := h.Store.InsertAuditLog(created.CreatedBy, "maintenance_window_create", created.Name, string(details)); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *MaintenanceHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	mw, err := h.Store.GetMaintenanceWindow(id)
+	if err != nil {
+		slog.Error("get maintenance window failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if mw == nil {
+		http.Error(w, "maintenance window not found", http.StatusNotFound)
+		return
+	}
+	if err := h.Store.DeleteMaintenanceWindow(id); err != nil {
+		slog.Error("delete maintenance window failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	details, _ := json.Marshal(mw)
+	if err := h.Store.InsertAuditLog(auditUsername(r), "maintenance_window_delete", mw.Name, string(details)); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Agent tells whether an agent is in a maintenance window now. Jobs that
+// must only run inside a window check it before they start.
+func (h *MaintenanceHandler) Agent(w http.ResponseWriter, r *http.Request) {
+	agent, err := h.Store.GetAgent(chi.URLParam(r, "id"))
+	if err != nil {
+		slog.Error("get agent failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if agent == nil {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	windows, err := h.Store.ListMaintenanceWindows()
+	if err != nil {
+		slog.Error("list maintenance windows failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	status := models.AgentMaintenance{AgentID: agent.ID}
+	if window, end := models.ActiveMaintenance(windows, *agent, time.Now()); window != nil {
+		end = end.UTC()
+		status.InMaintenance, status.Window, status.EndsAt = true, window, &end
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/retention"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultQueryRange = time.Hour
+	maxQuerySeries    = 500
+
+	// chartPoints is about how many steps a chart gets without an explicit step.
+	chartPoints = 360
+	// maxSteps bounds the steps of one aligned query.
+	maxSteps = 11000
+	// heartbeatInterval spaces raw points; finer steps would mostly be empty.
+	heartbeatInterval = 30 * time.Second
+)
+
+// MetricsHandler serves stored samples by metric name and labels.
+type MetricsHandler struct {
+	Store     *db.Store
+	Retention retention.Policy
+}
+
+// metricsQueryResponse is the result of a query. Truncated is set when the
+// selector matched more than maxQuerySeries series.
+type metricsQueryResponse struct {
+	Selector   string            `json:"selector"`
+	From       time.Time         `json:"from"`
+	To         time.Time         `json:"to"`
+	Resolution models.Resolution `json:"resolution"`
+	Series     []models.Series   `json:"series"`
+	Truncated  bool              `json:"truncated"`
+}
+
+// Query returns the points of the series a selector picks over a time range:
+//
+//	GET /api/v1/metrics/query?selector=disk_used_percent{mount="/var"}&from=...&to=...
+//
+// from and to are RFC 3339 times or Unix seconds; to defaults to now and
+// from to an hour before to. The label "agent" matches the agent ID. The
+// resolution (raw, 5m or 1h) follows from the range unless given; rolled-up
+// points carry min and max.
+func (h *MetricsHandler) Query(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sel, err := models.ParseSelector(q.Get("selector"))
+	if err != nil {
+		http.Error(w, "invalid selector: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseQueryTime(q.Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	from, err := parseQueryTime(q.Get("from"), to.Add(-defaultQueryRange))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+
+	res := models.Resolution(q.Get("resolution"))
+	if res == "" {
+		res = h.Retention.Resolution(from, to, time.Now())
+	} else if !res.Valid() {
+		http.Error(w, "resolution must be raw, 5m or 1h", http.StatusBadRequest)
+		return
+	}
+
+	series, truncated, err := h.Store.QuerySeries(sel, res, from, to, maxQuerySeries)
+	if err != nil {
+		slog.Error("query metrics failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if series == nil {
+		series = []models.Series{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metricsQueryResponse{
+		Selector:   sel.String(),
+		From:       from.UTC(),
+		To:         to.UTC(),
+		Resolution: res,
+		Series:     series,
+		Truncated:  truncated,
+	})
+}
+
+// agentMetricsResponse is an agent's series aligned on shared timestamps
+// (Unix seconds, the start of each step), with the alert rules that apply
+// to the agent so charts can draw their thresholds.
+type agentMetricsResponse struct {
+	AgentID    string                 `json:"agent_id"`
+	From       time.Time              `json:"from"`
+	To         time.Time              `json:"to"`
+	Step       int64                  `json:"step"`
+	Resolution models.Resolution      `json:"resolution"`
+	Timestamps []int64                `json:"timestamps"`
+	Series     []models.AlignedSeries `json:"series"`
+	Truncated  bool                   `json:"truncated"`
+	Thresholds []models.AlertRule     `json:"thresholds"`
+}
+
+// Agent returns an agent's metrics over a time range for charting:
+//
+//	GET /api/v1/agents/{id}/metrics?from=...&to=...&step=5m&name=cpu_percent
+//
+// Every series (or those of the given names) is resampled into steps of
+// step, a duration or seconds, with the average, minimum and maximum of each
+// step. from and to work as in Query; step defaults to a few hundred steps
+// over the range. Without from, to or step it returns the summary of the
+// last limit heartbeats instead, newest first.
+func (h *MetricsHandler) Agent(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	q := r.URL.Query()
+	if !q.Has("from") && !q.Has("to") && !q.Has("step") {
+		h.latest(w, id, q.Get("limit"))
+		return
+	}
+
+	to, err := parseQueryTime(q.Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	from, err := parseQueryTime(q.Get("from"), to.Add(-defaultQueryRange))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+	res := h.Retention.Resolution(from, to, time.Now())
+
+	var step time.Duration
+	if v := q.Get("step"); v != "" {
+		if step, err = parseStep(v); err != nil {
+			http.Error(w, "invalid step", http.StatusBadRequest)
+			return
+		}
+	} else {
+		step = chartStep(to.Sub(from), res)
+	}
+	if to.Sub(from)/step >= maxSteps {
+		http.Error(w, "step too small for the range", http.StatusBadRequest)
+		return
+	}
+
+	series, truncated, err := h.Store.QueryAgentSeries(id, q["name"], res, from, to, maxQuerySeries)
+	if err != nil {
+		slog.Error("query agent metrics failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	rules, err := h.Store.ListAlertRules()
+	if err != nil {
+		slog.Error("list alert rules failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	thresholds := []models.AlertRule{}
+	for _, rule := range rules {
+		if rule.Type != models.RuleOffline && (rule.AgentID == "" || rule.AgentID == id) {
+			thresholds = append(thresholds, rule)
+		}
+	}
+
+	timestamps, aligned := models.AlignSeries(series, from, to, step)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agentMetricsResponse{
+		AgentID:    id,
+		From:       from.UTC(),
+		To:         to.UTC(),
+		Step:       int64(step / time.Second),
+		Resolution: res,
+		Timestamps: timestamps,
+		Series:     aligned,
+		Truncated:  truncated,
+		Thresholds: thresholds,
+	})
+}
+
+func (h *MetricsHandler) latest(w http.ResponseWriter, agentID, limitStr string) {
+	limit := 50
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	metrics, err := h.Store.GetLatestMetrics(agentID, limit)
+	if err != nil {
+		slog.Error("get metrics failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if metrics == nil {
+		metrics = []models.Metric{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// Names lists every metric name with stored samples.
+func (h *MetricsHandler) Names(w http.ResponseWriter, r *http.Request) {
+	names, err := h.Store.ListMetricNames()
+	if err != nil {
+		slog.Error("list metric names failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
+
+// chartStep spaces about chartPoints steps over span, in whole multiples of
+// the resolution's point spacing.
+func chartStep(span time.Duration, res models.Resolution) time.Duration {
+	unit := res.Bucket()
+	if unit == 0 {
+		unit = heartbeatInterval
+	}
+	n := (span/chartPoints + unit - 1) / unit
+	return max(n, 1) * unit
+}
+
+// parseStep reads a step given as a duration ("5m") or in seconds, rounded
+// to whole seconds.
+func parseStep(value string) (time.Duration, error) {
+	step, err := time.ParseDuration(value)
+	if err != nil {
+		secs, serr := strconv.ParseInt(value, 10, 64)
+		if serr != nil {
+			return 0, err
+		}
+		step = time.Duration(secs) * time.Second
+	}
+	step = step.Round(time.Second)
+	if step < time.Second {
+		return 0, fmt.Errorf("step must be at least 1s")
+	}
+	return step, nil
+}
+
+func parseQueryTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/notify"
+	"github.com/go-chi/chi/v5"
+)
+
+// NotificationHandler manages the channels alerts are sent to (admin only);
+// channel configs hold credentials.
+type NotificationHandler struct {
+	Store    *db.Store
+	Notifier *notify.Notifier
+}
+
+func (h *NotificationHandler) ListChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := h.Store.ListNotificationChannels()
+	if err != nil {
+		slog.Error("list notification channels failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if channels == nil {
+		channels = []models.NotificationChannel{}
+	}
+	for i := range channels {
+		channels[i].Config = channels[i].Config.Redacted()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+func (h *NotificationHandler) CreateChannel(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeChannelRequest(w, r)
+	if !ok {
+		return
+	}
+	ch, err := h.Store.CreateNotificationChannel(req)
+	if err != nil {
+		slog.Error("create notification channel failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, "notification_channel_create", ch)
+	ch.Config = ch.Config.Redacted()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ch)
+}
+
+// UpdateChannel replaces a channel's settings; secrets sent empty are kept.
+func (h *NotificationHandler) UpdateChannel(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	req, ok := decodeChannelRequest(w, r)
+	if !ok {
+		return
+	}
+	ch, err := h.Store.UpdateNotificationChannel(id, req)
+	if err != nil {
+		slog.Error("update notification channel failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if ch == nil {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	h.audit(r, "notification_channel_update", ch)
+	ch.Config = ch.Config.Redacted()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ch)
+}
+
+func (h *NotificationHandler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	ch, err := h.Store.GetNotificationChannel(id)
+	if err != nil {
+		slog.Error("get notification channel failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if ch == nil {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if err := h.Store.DeleteNotificationChannel(id); err != nil {
+		slog.Error("delete notification channel failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, "notification_channel_delete", ch)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TestChannel sends a test notification and reports whether it arrived.
+func (h *NotificationHandler) TestChannel(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	ch, err := h.Store.GetNotificationChannel(id)
+	if err != nil {
+		slog.Error("get notification channel failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if ch == nil {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	if err := h.Notifier.Test(*ch); err != nil {
+		http.Error(w, "test notification failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries returns the delivery log, newest first.
+func (h *NotificationHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	deliveries, err := h.Store.ListDeliveries(limit)
+	if err != nil {
+		slog.Error("list notification deliveries failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.NotificationDelivery{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func (h *NotificationHandler) audit(r *http.Request, action string, ch *models.NotificationChannel) {
+	details := fmt.Sprintf(`{"id":%d,"type":"%s","enabled":%t}`, ch.ID, ch.Type, ch.Enabled)
+	if err := h.Store.InsertAuditLog(auditUsername(r), action, ch.Name, details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+}
+
+// decodeChannelRequest reads and validates a channel; on error it has
+// already answered the request.
+func decodeChannelRequest(w http.ResponseWriter, r *http.Request) (models.NotificationChannelRequest, bool) {
+	var req models.NotificationChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return req, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if msg := validateChannel(&req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func validateChannel(req *models.NotificationChannelRequest) string {
+	if req.Name == "" {
+		return "name required"
+	}
+	if !req.Type.Valid() {
+		return "type must be webhook, email, slack, teams or discord"
+	}
+	if req.MinSeverity != "" && !req.MinSeverity.Valid() {
+		return "min_severity must be info, warning or critical"
+	}
+	c := &req.Config
+	if req.Type == models.ChannelEmail {
+		c.SMTPHost = strings.TrimSpace(c.SMTPHost)
+		if c.SMTPHost == "" {
+			return "smtp_host required"
+		}
+		if c.SMTPPort < 0 || c.SMTPPort > 65535 {
+			return "invalid smtp_port"
+		}
+		if _, err := mail.ParseAddress(c.From); err != nil {
+			return "invalid from address"
+		}
+		if len(c.To) == 0 {
+			return "at least one recipient required"
+		}
+		for _, to := range c.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return "invalid recipient " + to
+			}
+		}
+		return ""
+	}
+	u, err := url.Parse(strings.TrimSpace(c.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an http or https URL"
+	}
+	c.URL = u.String()
+	return ""
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+	"github.com/go-chi/chi/v5"
+)
+
+// ProcessHandler lists and signals processes on connected agents.
+type ProcessHandler struct {
+	Store *db.Store
+	Hub   *ws.Hub
+}
+
+// List returns the agent's running processes, asked for on demand.
+func (h *ProcessHandler) List(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	result, err := h.Hub.ListProcesses(r.Context(), agentID)
+	if err != nil {
+		slog.Warn("list processes failed", "agent_id", agentID, "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+// Kill sends a signal to a process on the agent. Every attempt is audited,
+// including those the agent refuses or never answers.
+func (h *ProcessHandler) Kill(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	pid, err := strconv.ParseInt(chi.URLParam(r, "pid"), 10, 32)
+	if err != nil || pid <= 0 {
+		http.Error(w, "invalid pid", http.StatusBadRequest)
+		return
+	}
+
+	var req models.ProcessKillRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Signal == "" {
+		req.Signal = models.SignalTerm
+	}
+	if !req.Signal.Valid() {
+		http.Error(w, "signal must be term, kill, int or hup", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.Hub.KillProcess(r.Context(), agentID, int32(pid), req.Signal)
+	audit := map[string]interface{}{"pid": pid, "signal": req.Signal}
+	if err != nil {
+		// The agent may have got the signal request and acted on it
+		audit["error"] = err.Error()
+	} else {
+		audit["name"], audit["error"] = result.Name, result.Error
+	}
+	details, _ := json.Marshal(audit)
+	if err := h.Store.InsertAuditLog(auditUsername(r), "process_kill", agentID, string(details)); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	if err != nil {
+		slog.Warn("kill process failed", "agent_id", agentID, "pid", pid, "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if result.Error != "" {
+		http.Error(w, result.Error, http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
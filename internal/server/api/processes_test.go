@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+func setupTestDB(t *testing.T) *db.Store {
+	// Silence logs during tests
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	store, err := db.New(filepath.Join(t.TempDir(), "rmm.db"))
+	if err != nil {
+		t.Fatalf("failed to create test db: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// connectAgent connects a fake agent to hub that answers every process_kill
+// with reply.
+func connectAgent(t *testing.T, hub *ws.Hub, agentID string, reply func(req map[string]any) models.ProcessKillResult) {
+	srv := httptest.NewServer(http.HandlerFunc(hub.HandleAgentWS))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?agent_id="+agentID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		for {
+			var msg struct {
+				Type    string         `json:"type"`
+				Payload map[string]any `json:"payload"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == "process_kill" {
+				conn.WriteJSON(models.WSMessage{Type: "process_kill_result", Payload: reply(msg.Payload)})
+			}
+		}
+	}()
+	for deadline := time.Now().Add(5 * time.Second); !hub.IsConnected(agentID); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("agent did not connect")
+		}
+	}
+}
+
+func TestKillAuditsEveryAttempt(t *testing.T) {
+	store := setupTestDB(t)
+	hub := ws.NewHub(store)
+	go hub.Run()
+	connectAgent(t, hub, "agent-1", func(req map[string]any) models.ProcessKillResult {
+		return models.ProcessKillResult{
+			RequestID: req["request_id"].(string),
+			PID:       int32(req["pid"].(float64)),
+			Name:      "sshd",
+			Error:     "operation not permitted",
+		}
+	})
+
+	h := &ProcessHandler{Store: store, Hub: hub}
+	router := chi.NewRouter()
+	router.Post("/agents/{id}/processes/{pid}/kill", h.Kill)
+	kill := func(agentID, pid, body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/agents/"+agentID+"/processes/"+pid+"/kill", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := kill("agent-1", "0", ""); code != http.StatusBadRequest {
+		t.Errorf("pid 0: expected 400, got %d", code)
+	}
+	if code := kill("agent-1", "42", `{"signal":"stop"}`); code != http.StatusBadRequest {
+		t.Errorf("unknown signal: expected 400, got %d", code)
+	}
+	if logs, _ := store.GetAuditLogs(10); len(logs) != 0 {
+		t.Errorf("rejected requests never reach the agent and are not audited, got %d entries", len(logs))
+	}
+
+	if code := kill("agent-1", "42", `{"signal":"kill"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("refused by the agent: expected 422, got %d", code)
+	}
+	if code := kill("agent-2", "42", ""); code != http.StatusBadGateway {
+		t.Errorf("agent not connected: expected 502, got %d", code)
+	}
+
+	logs, err := store.GetAuditLogs(10)
+	if err != nil || len(logs) != 2 {
+		t.Fatalf("expected 2 audit entries, got %d (%v)", len(logs), err)
+	}
+	want := map[string]map[string]any{
+		"agent-1": {"pid": 42.0, "signal": "kill", "name": "sshd", "error": "operation not permitted"},
+		"agent-2": {"pid": 42.0, "signal": "term", "error": "agent agent-2 not connected"},
+	}
+	for _, l := range logs {
+		if l.Action != "process_kill" || l.Username != "system" {
+			t.Errorf("unexpected audit entry %+v", l)
+		}
+		var details map[string]any
+		json.Unmarshal([]byte(l.Details), &details)
+		for k, v := range want[l.Target] {
+			if details[k] != v {
+				t.Errorf("%s: audit %s = %v, want %v", l.Target, k, details[k], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+	"github.com/go-chi/chi/v5"
+)
+
+// QueueHandler exposes messages waiting for offline agents.
+type QueueHandler struct {
+	Store *db.Store
+	Hub   *ws.Hub
+}
+
+func (h *QueueHandler) List(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	msgs, err := h.Store.ListQueuedMessages(agentID)
+	if err != nil {
+		slog.Error("list queued messages failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if msgs == nil {
+		msgs = []models.QueuedMessage{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msgs)
+}
+
+// Cancel removes an undelivered message and marks its command or file
+// transfer cancelled.
+func (h *QueueHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	store := h.Store.WithContext(r.Context())
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	m, err := store.CancelQueuedMessage(id)
+	if err != nil {
+		slog.Error("cancel queued message failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if m == nil {
+		http.Error(w, "not queued (already delivered, expired or cancelled)", http.StatusConflict)
+		return
+	}
+
+	switch m.Kind {
+	case models.QueueCommand:
+		err = h.Hub.FinishCommand(r.Context(), m.RefID, -1, models.CommandCancelled)
+	case models.QueueFileTransfer:
+		err = store.UpdateFileTransferStatus(m.RefID, models.TransferCancelled, "cancelled before delivery")
+	}
+	if err != nil {
+		slog.Error("cancel queued item failed", "kind", m.Kind, "ref_id", m.RefID, "error", err)
+	}
+
+	username := auditUsername(r)
+	details := fmt.Sprintf(`{"kind":"%s","ref_id":%d}`, m.Kind, m.RefID)
+	if err := store.InsertAuditLog(username, "queue_cancel", m.AgentID, details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
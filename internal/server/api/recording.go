@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/go-chi/chi/v5"
+)
+
+// RecordingHandler serves shell and command transcripts.
+type RecordingHandler struct {
+	Store *db.Store
+}
+
+func (h *RecordingHandler) List(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	recs, err := h.Store.ListRecordings(r.URL.Query().Get("agent_id"), limit)
+	if err != nil {
+		slog.Error("list recordings failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if recs == nil {
+		recs = []models.Recording{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
+
+// Cast serves the asciicast file for the web player.
+func (h *RecordingHandler) Cast(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, false)
+}
+
+// Download serves the asciicast file as an attachment.
+func (h *RecordingHandler) Download(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, true)
+}
+
+func (h *RecordingHandler) serve(w http.ResponseWriter, r *http.Request, attachment bool) {
+	rec := h.recording(w, r)
+	if rec == nil {
+		return
+	}
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		slog.Error("open recording failed", "id", rec.ID, "error", err)
+		http.Error(w, "recording file not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	if attachment {
+		name := fmt.Sprintf("%s-%s-%d.cast", rec.Kind, rec.AgentID, rec.ID)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// recording loads the recording named in the URL, writing an error response
+// and returning nil if there is none.
+func (h *RecordingHandler) recording(w http.ResponseWriter, r *http.Request) *models.Recording {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return nil
+	}
+	rec, err := h.Store.GetRecording(id)
+	if err != nil {
+		slog.Error("get recording failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil
+	}
+	if rec == nil {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return nil
+	}
+	return rec
+}
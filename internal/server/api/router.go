@@ -2,83 +2,264 @@ package api
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/alert"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/exporter"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/notify"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/oidc"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/pki"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ratelimit"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/retention"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/update"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-func NewRouter(store *db.Store, hub *ws.Hub, alertEngine *alert.Engine) http.Handler {
+// Config carries server options that change routing or handler behaviour.
+type Config struct {
+	// CA is the server's internal CA; nil when mTLS is off.
+	CA *pki.CA
+	// RequireClientCert makes agent endpoints demand the agent's client certificate.
+	RequireClientCert bool
+	// DefaultCommandTimeout applies to commands sent without a timeout.
+	DefaultCommandTimeout time.Duration
+	// RecordingDir is where shell and command transcripts are recorded.
+	RecordingDir string
+	// Sessions sets the idle timeout and cookie flags of dashboard sessions.
+	Sessions SessionPolicy
+	// LoginLockout locks accounts after repeated failed logins.
+	LoginLockout LoginLockout
+	// OIDC enables single sign-on next to local logins when its issuer is set.
+	OIDC oidc.Config
+	// AgentRateLimit caps requests per second per client IP on the public and
+	// agent-facing endpoints; 0 disables it.
+	AgentRateLimit float64
+	// TrustedProxies are reverse proxies whose X-Forwarded-For names the
+	// client IP.
+	TrustedProxies ratelimit.TrustedProxies
+	// Retention decides which metric resolution still covers a queried range.
+	Retention retention.Policy
+	// Prometheus serves fleet and server metrics on /metrics when enabled.
+	Prometheus exporter.Config
+}
+
+func NewRouter(store *db.Store, hub *ws.Hub, alertEngine *alert.Engine, notifier *notify.Notifier, cfg Config) http.Handler {
 	r := chi.NewRouter()
 
+	var metricsExporter *exporter.Exporter
+	if cfg.Prometheus.Enabled {
+		metricsExporter = exporter.New(store, hub, cfg.Prometheus)
+		r.Use(metricsExporter.Middleware)
+	}
+	r.Use(cfg.TrustedProxies.Middleware)
+	r.Use(traceRequests)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Heartbeat("/health"))
 
 	agentHandler := &AgentHandler{Store: store}
-	cmdHandler := &CommandHandler{Store: store, Hub: hub}
+	metricsHandler := &MetricsHandler{Store: store, Retention: cfg.Retention}
+	cmdHandler := &CommandHandler{Store: store, Hub: hub, DefaultTimeout: cfg.DefaultCommandTimeout}
 	alertHandler := &AlertHandler{Store: store, Engine: alertEngine}
+	notificationHandler := &NotificationHandler{Store: store, Notifier: notifier}
+	maintenanceHandler := &MaintenanceHandler{Store: store}
 	updateHandler := &update.Handler{}
 	webHandler := NewWebHandler(store, hub)
-	authHandler := NewAuthHandler(store)
+	loginThrottle := NewLoginThrottle()
+	var sso *oidc.Provider
+	if cfg.OIDC.Enabled() {
+		sso = oidc.New(cfg.OIDC)
+	}
+	authHandler := NewAuthHandler(store, cfg.Sessions, cfg.LoginLockout, loginThrottle, sso)
 	ftHandler := NewFileTransferHandler(store, hub, "uploads")
+	queueHandler := &QueueHandler{Store: store, Hub: hub}
+	shellHandler := &ShellHandler{Store: store, Hub: hub, RecordingDir: cfg.RecordingDir}
+	recHandler := &RecordingHandler{Store: store}
+	processHandler := &ProcessHandler{Store: store, Hub: hub}
+	userHandler := &UserHandler{Store: store, Throttle: loginThrottle}
+	tokenHandler := &APITokenHandler{Store: store}
+	accountHandler := &AccountHandler{Store: store}
+	settingsHandler := &SettingsHandler{Store: store}
+	sessionHandler := &SessionHandler{Store: store}
+	enrollHandler := &EnrollmentHandler{Store: store, Hub: hub, CA: cfg.CA}
+	agentAuth := NewAgentAuth(store, cfg.RequireClientCert)
+	agentLimit := ratelimit.NewLimiter(cfg.AgentRateLimit, max(10, int(3*cfg.AgentRateLimit)))
 
 	// ── Public routes (no auth) ──
 	r.Get("/login", authHandler.LoginPage)
 	r.Post("/login", authHandler.Login)
+	r.Post("/login/2fa", authHandler.LoginSecondFactor)
+	r.Get("/login/oidc", authHandler.LoginSSO)
+	r.Get("/login/oidc/callback", authHandler.SSOCallback)
 	r.Get("/setup", authHandler.SetupPage)
 	r.Post("/setup", authHandler.Setup)
 
-	// Install scripts
-	r.Get("/install.sh", updateHandler.InstallScript)
-	r.Get("/install.ps1", updateHandler.InstallScriptPS)
+	// Prometheus scrapes (optional bearer token, checked by the exporter)
+	if metricsExporter != nil {
+		r.Get("/metrics", metricsExporter.ServeHTTP)
+	}
+
+	// Agent-facing endpoints, rate limited per client IP
+	r.Group(func(r chi.Router) {
+		r.Use(agentLimit.Middleware)
+
+		// Install scripts
+		r.Get("/install.sh", updateHandler.InstallScript)
+		r.Get("/install.ps1", updateHandler.InstallScriptPS)
+
+		// Agent enrollment (enrollment token → per-agent secret)
+		r.Post("/api/v1/enroll", enrollHandler.Enroll)
+		r.Get("/api/v1/pki/ca.pem", enrollHandler.CACertificate)
+		r.With(agentAuth.RequireAgentSecret).Post("/api/v1/agent/certificate", enrollHandler.IssueCertificate)
+		r.Get("/api/v1/update/check", updateHandler.Check)
+		r.With(agentAuth.RequireAgentOrEnrollment).Get("/api/v1/update/download", updateHandler.Download)
+	})
+
+	// Agent communication (per-agent secret, not user auth)
+	r.Group(func(r chi.Router) {
+		r.Use(agentLimit.Middleware)
+		r.Use(agentAuth.RequireAgent)
 
-	// Agent communication (API key, not user auth)
-	r.Post("/api/v1/heartbeat", agentHandler.Heartbeat)
-	r.Get("/api/v1/update/check", updateHandler.Check)
-	r.Get("/api/v1/update/download", updateHandler.Download)
+		r.Post("/api/v1/heartbeat", agentHandler.Heartbeat)
 
-	// Agent file transfer endpoints (agent pulls/pushes files)
-	r.Get("/api/v1/files/{transferID}/serve", ftHandler.ServeFile)
-	r.Post("/api/v1/files/{transferID}/receive", ftHandler.ReceiveFile)
+		// Agent file transfer endpoints (agent pulls/pushes files)
+		r.Get("/api/v1/files/{transferID}/serve", ftHandler.ServeFile)
+		r.Post("/api/v1/files/{transferID}/receive", ftHandler.ReceiveFile)
 
-	// WebSocket
-	r.Get("/ws/agent", func(w http.ResponseWriter, r *http.Request) {
-		hub.HandleAgentWS(w, r)
+		// WebSocket
+		r.Get("/ws/agent", func(w http.ResponseWriter, r *http.Request) {
+			hub.HandleAgentWS(w, r)
+		})
 	})
 
-	// ── Protected routes (user session required) ──
+	// ── Protected routes (user session or API token required) ──
 	r.Group(func(r chi.Router) {
 		r.Use(authHandler.RequireAuth)
+		r.Use(RequireCSRF)
+		r.Post("/logout", authHandler.Logout)
 
-		// Web UI
+		// Read-only: every role
 		r.Get("/", webHandler.Dashboard)
 		r.Get("/ui/agents/{id}", webHandler.AgentDetail)
 		r.Get("/ui/alerts", webHandler.Alerts)
 		r.Get("/ui/audit-logs", webHandler.AuditLogs)
-		r.Post("/logout", authHandler.Logout)
+		r.Get("/ui/tokens", webHandler.APITokens)
+		r.Get("/ui/account", webHandler.Account)
+		r.Get("/ui/sessions", webHandler.Sessions)
 
-		// Management API
 		r.Get("/api/v1/agents", agentHandler.List)
 		r.Get("/api/v1/agents/{id}", agentHandler.Get)
-		r.Delete("/api/v1/agents/{id}", agentHandler.Delete)
-		r.Get("/api/v1/agents/{id}/metrics", agentHandler.Metrics)
-		r.Post("/api/v1/agents/{id}/command", cmdHandler.Send)
-		r.Get("/api/v1/agents/{id}/commands", cmdHandler.List)
+		r.Get("/api/v1/agents/{id}/metrics", metricsHandler.Agent)
+		r.Get("/api/v1/agents/{id}/system", agentHandler.System)
+		r.Get("/api/v1/metrics/query", metricsHandler.Query)
+		r.Get("/api/v1/metrics/names", metricsHandler.Names)
+		r.Get("/api/v1/agents/{id}/queue", queueHandler.List)
+		r.Get("/api/v1/agents/{id}/shell-sessions", shellHandler.ListSessions)
+		r.Get("/api/v1/agents/{id}/files", ftHandler.List)
 		r.Get("/api/v1/alerts", alertHandler.ListAlerts)
 		r.Get("/api/v1/alerts/rules", alertHandler.ListRules)
-		r.Post("/api/v1/alerts/rules", alertHandler.CreateRule)
-		r.Delete("/api/v1/alerts/rules/{id}", alertHandler.DeleteRule)
+		r.Get("/api/v1/alerts/silences", alertHandler.ListSilences)
+		r.Get("/api/v1/maintenance-windows", maintenanceHandler.List)
+		r.Get("/api/v1/agents/{id}/maintenance", maintenanceHandler.Agent)
 
-		// File transfer (user-initiated)
-		r.Post("/api/v1/agents/{id}/files/upload", ftHandler.Upload)
-		r.Post("/api/v1/agents/{id}/files/download", ftHandler.RequestDownload)
-		r.Get("/api/v1/agents/{id}/files", ftHandler.List)
-		r.Get("/api/v1/agents/{id}/browse", ftHandler.Browse)
-		r.Get("/api/v1/files/{transferID}/download", ftHandler.DownloadFile)
+		// Personal API tokens
+		r.Get("/api/v1/tokens", tokenHandler.List)
+		r.Post("/api/v1/tokens", tokenHandler.Create)
+		r.Delete("/api/v1/tokens/{id}", tokenHandler.Revoke)
+
+		// Own sessions
+		r.Get("/api/v1/sessions", sessionHandler.List)
+		r.Delete("/api/v1/sessions/{id}", sessionHandler.Revoke)
+		r.Post("/api/v1/sessions/revoke-others", sessionHandler.RevokeOthers)
+
+		// Own account: two-factor authentication
+		r.Post("/api/v1/account/2fa/setup", accountHandler.Setup2FA)
+		r.Post("/api/v1/account/2fa/enable", accountHandler.Enable2FA)
+		r.Post("/api/v1/account/2fa/disable", accountHandler.Disable2FA)
+		r.Post("/api/v1/account/2fa/recovery-codes", accountHandler.RegenerateRecoveryCodes)
+
+		// Operators act on agents
+		r.Group(func(r chi.Router) {
+			r.Use(RequireRole(models.RoleOperator))
+
+			// Command output and session recordings (typed input included)
+			// may hold secrets, so they are not for viewers either
+			r.Get("/ui/recordings", webHandler.Recordings)
+			r.Get("/ui/recordings/{id}", webHandler.Recording)
+			r.Get("/api/v1/agents/{id}/commands", cmdHandler.List)
+			r.Get("/api/v1/commands/{id}/stream", cmdHandler.Stream)
+			r.Get("/api/v1/recordings", recHandler.List)
+			r.Get("/api/v1/recordings/{id}/cast", recHandler.Cast)
+			r.Get("/api/v1/recordings/{id}/download", recHandler.Download)
+
+			r.Post("/api/v1/agents/{id}/command", cmdHandler.Send)
+			r.Post("/api/v1/commands/{id}/cancel", cmdHandler.Cancel)
+			r.Delete("/api/v1/queue/{id}", queueHandler.Cancel)
+			r.Get("/api/v1/agents/{id}/shell", shellHandler.Open)
+			r.Post("/api/v1/alerts/rules", alertHandler.CreateRule)
+			r.Delete("/api/v1/alerts/rules/{id}", alertHandler.DeleteRule)
+			r.Post("/api/v1/alerts/{id}/acknowledge", alertHandler.Acknowledge)
+			r.Post("/api/v1/alerts/{id}/resolve", alertHandler.Resolve)
+			r.Post("/api/v1/alerts/silences", alertHandler.CreateSilence)
+			r.Delete("/api/v1/alerts/silences/{id}", alertHandler.ExpireSilence)
+			r.Post("/api/v1/maintenance-windows", maintenanceHandler.Create)
+			r.Delete("/api/v1/maintenance-windows/{id}", maintenanceHandler.Delete)
+
+			// File transfer (user-initiated)
+			r.Post("/api/v1/agents/{id}/files/upload", ftHandler.Upload)
+			r.Post("/api/v1/agents/{id}/files/download", ftHandler.RequestDownload)
+			r.Get("/api/v1/agents/{id}/browse", ftHandler.Browse)
+			r.Get("/api/v1/files/{transferID}/download", ftHandler.DownloadFile)
+
+			// Processes (command lines may hold secrets, so not for viewers)
+			r.Get("/api/v1/agents/{id}/processes", processHandler.List)
+			r.Post("/api/v1/agents/{id}/processes/{pid}/kill", processHandler.Kill)
+		})
+
+		// Admins manage users, enrollment and agent identities
+		r.Group(func(r chi.Router) {
+			r.Use(RequireRole(models.RoleAdmin))
+
+			r.Get("/ui/enrollment", webHandler.Enrollment)
+			r.Get("/ui/users", webHandler.Users)
+			r.Get("/ui/notifications", webHandler.Notifications)
+
+			r.Delete("/api/v1/agents/{id}", agentHandler.Delete)
+			r.Put("/api/v1/agents/{id}/tags", agentHandler.SetTags)
+
+			// Agent enrollment & credentials
+			r.Get("/api/v1/enrollment-tokens", enrollHandler.ListTokens)
+			r.Post("/api/v1/enrollment-tokens", enrollHandler.CreateToken)
+			r.Delete("/api/v1/enrollment-tokens/{id}", enrollHandler.RevokeToken)
+			r.Post("/api/v1/agents/{id}/credential/revoke", enrollHandler.RevokeCredential)
+			r.Post("/api/v1/agents/{id}/credential/rotate", enrollHandler.RotateCredential)
+			r.Post("/api/v1/agents/{id}/certificate/revoke", enrollHandler.RevokeCertificate)
+			r.Post("/api/v1/agents/{id}/certificate/reissue", enrollHandler.AllowCertificateReissue)
+
+			// Users
+			r.Get("/api/v1/users", userHandler.List)
+			r.Post("/api/v1/users", userHandler.Create)
+			r.Patch("/api/v1/users/{id}", userHandler.Update)
+			r.Post("/api/v1/users/{id}/password", userHandler.ResetPassword)
+			r.Post("/api/v1/users/{id}/2fa/reset", userHandler.Reset2FA)
+			r.Post("/api/v1/users/{id}/unlock", userHandler.Unlock)
+			r.Delete("/api/v1/users/{id}", userHandler.Delete)
+
+			// Alert notification channels
+			r.Get("/api/v1/notifications/channels", notificationHandler.ListChannels)
+			r.Post("/api/v1/notifications/channels", notificationHandler.CreateChannel)
+			r.Put("/api/v1/notifications/channels/{id}", notificationHandler.UpdateChannel)
+			r.Delete("/api/v1/notifications/channels/{id}", notificationHandler.DeleteChannel)
+			r.Post("/api/v1/notifications/channels/{id}/test", notificationHandler.TestChannel)
+			r.Get("/api/v1/notifications/deliveries", notificationHandler.ListDeliveries)
+
+			r.Get("/api/v1/settings", settingsHandler.Get)
+			r.Put("/api/v1/settings", settingsHandler.Update)
+		})
 	})
 
 	// Static files
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/alert"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/notify"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+)
+
+// TestRouterRoles checks that routes are grouped by role: viewers only read,
+// operators act on agents and admins manage them. API tokens are held to
+// their scope.
+func TestRouterRoles(t *testing.T) {
+	t.Chdir(t.TempDir()) // the router creates its upload directory here
+	store := setupTestDB(t)
+	hub := ws.NewHub(store)
+	notifier := notify.New(store)
+	router := NewRouter(store, hub, alert.NewEngine(store, notifier), notifier, Config{RecordingDir: t.TempDir()})
+
+	viewer := testUser(t, store, "viewer", models.RoleViewer)
+	operator := testUser(t, store, "operator", models.RoleOperator)
+	admin := testUser(t, store, "admin", models.RoleAdmin)
+	readOnly := testAPIToken(t, store, admin, models.RoleViewer)
+	operatorToken := testAPIToken(t, store, admin, models.RoleOperator)
+	// A token can't be scoped above its user's role
+	overScoped := testAPIToken(t, store, viewer, models.RoleAdmin)
+
+	const (
+		sendCommand  = "POST /api/v1/agents/agent-1/command"
+		upload       = "POST /api/v1/agents/agent-1/files/upload"
+		deleteAgent  = "DELETE /api/v1/agents/agent-1"
+		listCommands = "GET /api/v1/agents/agent-1/commands"
+		recordings   = "GET /api/v1/recordings"
+		listAgents   = "GET /api/v1/agents"
+	)
+	do := func(route string, sess *models.Session, token string) int {
+		method, path, _ := strings.Cut(route, " ")
+		r := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: sess.Token})
+			r.Header.Set(csrfHeader, sess.CSRFToken)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	cases := []struct {
+		who       string
+		sess      *models.Session
+		token     string
+		route     string
+		forbidden bool
+	}{
+		{"viewer", viewer, "", listAgents, false},
+		{"viewer", viewer, "", sendCommand, true},
+		{"viewer", viewer, "", upload, true},
+		{"viewer", viewer, "", deleteAgent, true},
+		{"viewer", viewer, "", listCommands, true},
+		{"viewer", viewer, "", recordings, true},
+		{"operator", operator, "", sendCommand, false},
+		{"operator", operator, "", upload, false},
+		{"operator", operator, "", listCommands, false},
+		{"operator", operator, "", deleteAgent, true},
+		{"admin", admin, "", deleteAgent, false},
+		{"read-only token", nil, readOnly, listAgents, false},
+		{"read-only token", nil, readOnly, sendCommand, true},
+		{"read-only token", nil, readOnly, upload, true},
+		{"read-only token", nil, readOnly, recordings, true},
+		{"operator token", nil, operatorToken, sendCommand, false},
+		{"operator token", nil, operatorToken, deleteAgent, true},
+		{"viewer's admin token", nil, overScoped, sendCommand, true},
+		{"viewer's admin token", nil, overScoped, deleteAgent, true},
+	}
+	for _, c := range cases {
+		code := do(c.route, c.sess, c.token)
+		if forbidden := code == http.StatusForbidden; forbidden != c.forbidden {
+			t.Errorf("%s: %s got %d, forbidden should be %v", c.who, c.route, code, c.forbidden)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/go-chi/chi/v5"
+)
+
+// SessionHandler lets users see and revoke their own dashboard sessions.
+type SessionHandler struct {
+	Store *db.Store
+}
+
+func (h *SessionHandler) List(w http.ResponseWriter, r *http.Request) {
+	sessions, err := listSessions(h.Store, r)
+	if err != nil {
+		slog.Error("list sessions failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (h *SessionHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	user := GetUserFromContext(r)
+	found, err := h.Store.DeleteSessionByID(user.ID, id)
+	if err != nil {
+		slog.Error("revoke session failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.Store.InsertAuditLog(auditUsername(r), "session_revoke", user.Username, fmt.Sprintf(`{"session_id":%d}`, id)); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOthers ends every session of the user except the current one. Called
+// with an API token, it ends all of them.
+func (h *SessionHandler) RevokeOthers(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromContext(r)
+	var keep int64
+	if sess := GetSessionFromContext(r); sess != nil {
+		keep = sess.ID
+	}
+	n, err := h.Store.DeleteOtherSessions(user.ID, keep)
+	if err != nil {
+		slog.Error("revoke sessions failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Store.InsertAuditLog(auditUsername(r), "session_revoke_others", user.Username, fmt.Sprintf(`{"revoked":%d}`, n)); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listSessions returns the user's sessions with the requesting one marked.
+func listSessions(store *db.Store, r *http.Request) ([]models.Session, error) {
+	sessions, err := store.ListUserSessions(GetUserFromContext(r).ID)
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []models.Session{}
+	}
+	if current := GetSessionFromContext(r); current != nil {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current.ID
+		}
+	}
+	return sessions, nil
+}
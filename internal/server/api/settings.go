@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+)
+
+// SettingsHandler reads and changes server-wide settings (admin only).
+type SettingsHandler struct {
+	Store *db.Store
+}
+
+func (h *SettingsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.Store.GetSettings()
+	if err != nil {
+		slog.Error("get settings failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *SettingsHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req models.Settings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.Store.GetSettings()
+	if err != nil {
+		slog.Error("get settings failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if req.Require2FA && !current.Require2FA {
+		// Requiring 2FA without having it would lock the admin out of this page
+		user, err := h.Store.GetUser(GetUserFromContext(r).ID)
+		if err != nil || user == nil || !user.TOTPEnabled {
+			http.Error(w, "enable two-factor authentication for your own account first", http.StatusConflict)
+			return
+		}
+	}
+
+	if err := h.Store.SetRequire2FA(req.Require2FA); err != nil {
+		slog.Error("update settings failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Require2FA != current.Require2FA {
+		details := fmt.Sprintf(`{"require_2fa":%t}`, req.Require2FA)
+		if err := h.Store.InsertAuditLog(auditUsername(r), "settings_change", "require_2fa", details); err != nil {
+			slog.Error("failed to insert audit log", "error", err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/recording"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// The browser shell is authenticated by the session cookie, so only
+// same-origin pages may open it (gorilla's default origin check).
+var shellUpgrader = websocket.Upgrader{}
+
+// shellWriteTimeout bounds a write to the browser; a stalled viewer ends
+// the session instead of holding the shell open.
+const shellWriteTimeout = 10 * time.Second
+
+// ShellHandler relays interactive shells between the browser and agents and
+// records every session.
+type ShellHandler struct {
+	Store        *db.Store
+	Hub          *ws.Hub
+	RecordingDir string
+}
+
+// shellClientMessage is sent by the browser terminal as a text frame.
+type shellClientMessage struct {
+	Type string `json:"type"` // "input" or "resize"
+	Data string `json:"data"`
+	Cols int    `json:"cols"`
+	Rows int    `json:"rows"`
+}
+
+// Open upgrades to a WebSocket and starts a shell on the agent. Output is
+// sent to the browser as binary frames; a final text frame
+// {"type":"exit",...} reports how the session ended.
+func (h *ShellHandler) Open(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	agent, err := h.Store.GetAgent(agentID)
+	if err != nil || agent == nil {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+	if !h.Hub.IsConnected(agentID) {
+		http.Error(w, "agent not connected", http.StatusConflict)
+		return
+	}
+	cols := terminalSize(r.URL.Query().Get("cols"), 80)
+	rows := terminalSize(r.URL.Query().Get("rows"), 24)
+
+	conn, err := shellUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("shell ws upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	username := auditUsername(r)
+
+	sess, err := h.Hub.OpenShell(agentID, cols, rows)
+	if err != nil {
+		slog.Error("open shell failed", "agent_id", agentID, "error", err)
+		writeShellExit(conn, -1, "agent not connected")
+		return
+	}
+
+	recPath := filepath.Join(h.RecordingDir, sess.ID+".cast")
+	title := fmt.Sprintf("%s@%s", username, agent.Name())
+	rec, err := recording.Create(recPath, cols, rows, title)
+	if err != nil {
+		// Unrecorded sessions are not allowed
+		slog.Error("create shell recording failed", "error", err)
+		h.Hub.CloseShell(sess)
+		writeShellExit(conn, -1, "recording unavailable")
+		return
+	}
+
+	ss, err := h.Store.CreateShellSession(sess.ID, agentID, username, recPath)
+	var recInfo *models.Recording
+	if err == nil {
+		recInfo, err = h.Store.CreateRecording(models.RecordingShell, agentID, sess.ID, username, title, recPath)
+	}
+	if err != nil {
+		slog.Error("create shell session failed", "error", err)
+		h.Hub.CloseShell(sess)
+		rec.Close()
+		writeShellExit(conn, -1, "internal error")
+		return
+	}
+	details := fmt.Sprintf(`{"session_id":"%s"}`, sess.ID)
+	if err := h.Store.InsertAuditLogWithRecording(username, "shell_open", agentID, details, recInfo.ID); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	slog.Info("shell session started", "session_id", sess.ID, "agent_id", agentID, "user", username)
+
+	go h.readBrowser(conn, sess, rec)
+
+	closed := false
+	for !closed {
+		select {
+		case data := <-sess.Output():
+			if !h.relayOutput(conn, rec, data) {
+				h.Hub.CloseShell(sess)
+			}
+		case <-sess.Done():
+			closed = true
+		}
+	}
+	// Output that arrived together with the exit
+	for drained := false; !drained; {
+		select {
+		case data := <-sess.Output():
+			h.relayOutput(conn, rec, data)
+		default:
+			drained = true
+		}
+	}
+
+	if err := rec.Close(); err != nil {
+		slog.Error("close shell recording failed", "error", err)
+	}
+	if err := h.Store.FinishRecording(recInfo.ID, rec.Size()); err != nil {
+		slog.Error("finish shell recording failed", "error", err)
+	}
+	if err := h.Store.EndShellSession(sess.ID, sess.ExitCode); err != nil {
+		slog.Error("end shell session failed", "error", err)
+	}
+	duration := time.Since(ss.StartedAt).Round(time.Second)
+	details = fmt.Sprintf(`{"session_id":"%s","duration_seconds":%d,"exit_code":%d}`, sess.ID, int(duration.Seconds()), sess.ExitCode)
+	if err := h.Store.InsertAuditLogWithRecording(username, "shell_close", agentID, details, recInfo.ID); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+	slog.Info("shell session ended", "session_id", sess.ID, "duration", duration, "exit_code", sess.ExitCode)
+
+	writeShellExit(conn, sess.ExitCode, sess.Err)
+}
+
+func (h *ShellHandler) relayOutput(conn *websocket.Conn, rec *recording.Writer, data []byte) bool {
+	if err := rec.Output(data); err != nil {
+		slog.Error("write shell recording failed", "error", err)
+	}
+	conn.SetWriteDeadline(time.Now().Add(shellWriteTimeout))
+	return conn.WriteMessage(websocket.BinaryMessage, data) == nil
+}
+
+// readBrowser forwards keystrokes and resizes until the browser goes away,
+// then closes the shell.
+func (h *ShellHandler) readBrowser(conn *websocket.Conn, sess *ws.ShellSession, rec *recording.Writer) {
+	defer h.Hub.CloseShell(sess)
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg shellClientMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "input":
+			rec.Input(msg.Data)
+			err = h.Hub.ShellInput(sess, []byte(msg.Data))
+		case "resize":
+			cols, rows := clampTerminal(msg.Cols), clampTerminal(msg.Rows)
+			rec.Resize(cols, rows)
+			err = h.Hub.ResizeShell(sess, cols, rows)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeShellExit(conn *websocket.Conn, exitCode int, errMsg string) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":      "exit",
+		"exit_code": exitCode,
+		"error":     errMsg,
+	})
+	conn.SetWriteDeadline(time.Now().Add(shellWriteTimeout))
+	conn.WriteMessage(websocket.TextMessage, data)
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+// ListSessions returns the latest shell sessions of an agent.
+func (h *ShellHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+	sessions, err := h.Store.ListShellSessions(agentID, 50)
+	if err != nil {
+		slog.Error("list shell sessions failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if sessions == nil {
+		sessions = []models.ShellSession{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func terminalSize(s string, def int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return clampTerminal(n)
+}
+
+func clampTerminal(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > 1000 {
+		return 1000
+	}
+	return n
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/oidc"
+)
+
+const (
+	ssoStateCookie = "oidc_state"
+	ssoLoginTTL    = 10 * time.Minute
+)
+
+// pendingSSO is a login sent to the identity provider, waiting for the
+// callback.
+type pendingSSO struct {
+	nonce    string
+	verifier string // PKCE code verifier
+	expires  time.Time
+}
+
+// LoginSSO sends the browser to the identity provider. The state is also
+// kept in a cookie, so the callback only completes in the browser that
+// started the login.
+func (h *AuthHandler) LoginSSO(w http.ResponseWriter, r *http.Request) {
+	if h.sso == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		token, err := generateToken()
+		if err != nil {
+			slog.Error("generate token error", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		secrets[i] = token
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	authURL, err := h.sso.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		slog.Error("oidc login failed", "error", err)
+		h.renderLogin(w, "Kimlik sağlayıcıya ulaşılamadı, yerel hesapla giriş yapabilirsiniz")
+		return
+	}
+
+	h.mu.Lock()
+	h.prunePendingLocked()
+	h.ssoPending[state] = &pendingSSO{nonce: nonce, verifier: verifier, expires: time.Now().Add(ssoLoginTTL)}
+	h.mu.Unlock()
+
+	h.setSSOStateCookie(w, r, state, int(ssoLoginTTL/time.Second))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// SSOCallback completes a single sign-on: it verifies the ID token,
+// provisions the user on first login and syncs the role from the groups.
+// SSO logins skip the local TOTP step; the provider enforces its own MFA.
+func (h *AuthHandler) SSOCallback(w http.ResponseWriter, r *http.Request) {
+	if h.sso == nil {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	state := q.Get("state")
+
+	h.mu.Lock()
+	p := h.ssoPending[state]
+	delete(h.ssoPending, state)
+	h.mu.Unlock()
+
+	cookie, err := r.Cookie(ssoStateCookie)
+	h.setSSOStateCookie(w, r, "", -1)
+	if err != nil || p == nil || time.Now().After(p.expires) ||
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		h.renderLogin(w, "SSO oturumu geçersiz veya süresi doldu, tekrar deneyin")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		slog.Warn("oidc provider refused login", "error", e, "description", q.Get("error_description"))
+		h.renderLogin(w, "SSO girişi iptal edildi veya reddedildi")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+	id, err := h.sso.Exchange(ctx, q.Get("code"), p.nonce, p.verifier)
+	if err != nil {
+		slog.Error("oidc login failed", "error", err)
+		h.auditLoginFailure(r, "", "oidc")
+		h.renderLogin(w, "SSO girişi doğrulanamadı")
+		return
+	}
+
+	role, ok := h.sso.Config().Role(id.Groups)
+	if !ok {
+		h.auditLoginFailure(r, id.Username, "oidc_no_role")
+		h.renderLogin(w, "Hesabınızın bu panele erişim yetkisi yok")
+		return
+	}
+	user, err := h.ssoUser(id, role)
+	if err != nil {
+		slog.Error("oidc user provisioning failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		h.auditLoginFailure(r, id.Username, "oidc_username_taken")
+		h.renderLogin(w, "Bu kullanıcı adı yerel bir hesaba ait, yöneticinize başvurun")
+		return
+	}
+	if user.Disabled {
+		h.auditLoginFailure(r, user.Username, "disabled")
+		h.renderLogin(w, "Hesap devre dışı bırakılmış")
+		return
+	}
+
+	h.startSession(w, r, user)
+}
+
+// ssoUser returns the user of a verified identity, creating it on first
+// login and keeping its role in line with the provider's groups. It returns
+// nil if the username already belongs to another account: linking them would
+// hand that account to whoever holds the name at the provider.
+func (h *AuthHandler) ssoUser(id *oidc.Identity, role models.Role) (*models.User, error) {
+	user, err := h.store.GetUserByOIDC(id.Issuer, id.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		existing, err := h.store.GetUserByUsername(id.Username)
+		if err != nil || existing != nil {
+			return nil, err
+		}
+		user, err = h.store.CreateOIDCUser(id.Username, role, id.Issuer, id.Subject)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("user provisioned by sso", "username", user.Username, "role", role)
+		if err := h.store.InsertAuditLog("system", "user_create", user.Username, fmt.Sprintf(`{"role":"%s","source":"oidc"}`, role)); err != nil {
+			slog.Error("failed to insert audit log", "error", err)
+		}
+		return user, nil
+	}
+
+	if user.Role != role {
+		if err := h.store.UpdateUserRole(user.ID, role); err != nil {
+			return nil, err
+		}
+		details := fmt.Sprintf(`{"from":"%s","to":"%s","source":"oidc"}`, user.Role, role)
+		if err := h.store.InsertAuditLog("system", "user_role_change", user.Username, details); err != nil {
+			slog.Error("failed to insert audit log", "error", err)
+		}
+		user.Role = role
+	}
+	return user, nil
+}
+
+func (h *AuthHandler) setSSOStateCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    value,
+		Path:     "/login/oidc",
+		HttpOnly: true,
+		Secure:   h.sessions.SecureCookie || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	})
+}
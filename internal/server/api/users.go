@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 6
+
+// UserHandler manages dashboard users. All routes are admin-only.
+type UserHandler struct {
+	Store    *db.Store
+	Throttle *LoginThrottle
+}
+
+func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
+	users, err := h.Store.ListUsers()
+	if err != nil {
+		slog.Error("list users failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req models.UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		http.Error(w, "username required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+	if !req.Role.Valid() {
+		http.Error(w, "role must be admin, operator or viewer", http.StatusBadRequest)
+		return
+	}
+
+	if existing, _ := h.Store.GetUserByUsername(req.Username); existing != nil {
+		http.Error(w, "username already exists", http.StatusConflict)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		slog.Error("bcrypt hash error", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Store.CreateUser(req.Username, string(hash), req.Role); err != nil {
+		slog.Error("create user failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	user, _ := h.Store.GetUserByUsername(req.Username)
+
+	h.audit(r, "user_create", req.Username, fmt.Sprintf(`{"role":"%s"}`, req.Role))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// Update changes a user's role or disables/enables the account.
+func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
+	target := h.user(w, r)
+	if target == nil {
+		return
+	}
+	var req models.UserUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if req.Role != nil && !req.Role.Valid() {
+		http.Error(w, "role must be admin, operator or viewer", http.StatusBadRequest)
+		return
+	}
+
+	losesAdmin := target.Role == models.RoleAdmin && !target.Disabled &&
+		((req.Role != nil && *req.Role != models.RoleAdmin) || (req.Disabled != nil && *req.Disabled))
+	if losesAdmin && !h.otherAdminsLeft(w) {
+		return
+	}
+	if req.Disabled != nil && *req.Disabled && h.isSelf(r, target) {
+		http.Error(w, "you cannot disable your own account", http.StatusConflict)
+		return
+	}
+
+	if req.Role != nil && *req.Role != target.Role {
+		if err := h.Store.UpdateUserRole(target.ID, *req.Role); err != nil {
+			slog.Error("update user role failed", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "user_role_change", target.Username, fmt.Sprintf(`{"from":"%s","to":"%s"}`, target.Role, *req.Role))
+	}
+	if req.Disabled != nil && *req.Disabled != target.Disabled {
+		if err := h.Store.SetUserDisabled(target.ID, *req.Disabled); err != nil {
+			slog.Error("update user disabled failed", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		action := "user_enable"
+		if *req.Disabled {
+			action = "user_disable"
+		}
+		h.audit(r, action, target.Username, "{}")
+	}
+
+	updated, _ := h.Store.GetUser(target.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// ResetPassword sets a new password and signs the user out everywhere.
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	target := h.user(w, r)
+	if target == nil {
+		return
+	}
+	var req models.PasswordReset
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		slog.Error("bcrypt hash error", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Store.UpdateUserPassword(target.ID, string(hash)); err != nil {
+		slog.Error("update user password failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !h.isSelf(r, target) {
+		if err := h.Store.DeleteUserSessions(target.ID); err != nil {
+			slog.Error("delete user sessions failed", "error", err)
+		}
+	}
+
+	h.audit(r, "user_password_reset", target.Username, "{}")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Reset2FA turns off a user's 2FA, e.g. after a lost phone. The user has to
+// enroll again if 2FA is required.
+func (h *UserHandler) Reset2FA(w http.ResponseWriter, r *http.Request) {
+	target := h.user(w, r)
+	if target == nil {
+		return
+	}
+	if err := h.Store.DisableTOTP(target.ID); err != nil {
+		slog.Error("reset user 2fa failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, "user_2fa_reset", target.Username, "{}")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Unlock lifts a lockout after failed logins.
+func (h *UserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	target := h.user(w, r)
+	if target == nil {
+		return
+	}
+	if err := h.Store.UnlockUser(target.ID); err != nil {
+		slog.Error("unlock user failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if h.Throttle != nil {
+		h.Throttle.ResetUser(target.Username)
+	}
+	h.audit(r, "user_unlock", target.Username, "{}")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	target := h.user(w, r)
+	if target == nil {
+		return
+	}
+	if h.isSelf(r, target) {
+		http.Error(w, "you cannot delete your own account", http.StatusConflict)
+		return
+	}
+	if target.Role == models.RoleAdmin && !target.Disabled && !h.otherAdminsLeft(w) {
+		return
+	}
+	if err := h.Store.DeleteUser(target.ID); err != nil {
+		slog.Error("delete user failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, "user_delete", target.Username, fmt.Sprintf(`{"role":"%s"}`, target.Role))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// user loads the user named in the URL, writing an error response and
+// returning nil if there is none.
+func (h *UserHandler) user(w http.ResponseWriter, r *http.Request) *models.User {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return nil
+	}
+	user, err := h.Store.GetUser(id)
+	if err != nil {
+		slog.Error("get user failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil
+	}
+	if user == nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return nil
+	}
+	return user
+}
+
+// otherAdminsLeft refuses changes that would leave no active admin.
+func (h *UserHandler) otherAdminsLeft(w http.ResponseWriter) bool {
+	count, err := h.Store.CountActiveAdmins()
+	if err != nil {
+		slog.Error("count admins failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return false
+	}
+	if count <= 1 {
+		http.Error(w, "at least one active admin is required", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+func (h *UserHandler) isSelf(r *http.Request, target *models.User) bool {
+	user := GetUserFromContext(r)
+	return user != nil && user.ID == target.ID
+}
+
+func (h *UserHandler) audit(r *http.Request, action, target, details string) {
+	username := auditUsername(r)
+	if err := h.Store.InsertAuditLog(username, action, target, details); err != nil {
+		slog.Error("failed to insert audit log", "error", err)
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
@@ -24,12 +27,39 @@ type WebHandler struct {
 type agentRow struct {
 	Agent  models.Agent
 	Metric *models.Metric
+	// Maintenance is the window the agent is in, ending at MaintenanceEnds
+	Maintenance     *models.MaintenanceWindow
+	MaintenanceEnds time.Time
+}
+
+type maintenanceRow struct {
+	Window models.MaintenanceWindow
+	Active bool
+	Ends   time.Time // of the window under way
+	Next   time.Time // zero if it doesn't start again
 }
 
 var funcMap = template.FuncMap{
-	"timeAgo":     timeAgo,
-	"metricColor": metricColor,
-	"formatBytes": formatBytes,
+	"timeAgo":      timeAgo,
+	"metricColor":  metricColor,
+	"formatBytes":  formatBytes,
+	"formatSize":   func(b uint64) string { return formatBytes(int64(b)) },
+	"formatRate":   func(r float64) string { return formatBytes(int64(r)) + "/s" },
+	"formatUptime": formatUptime,
+	"formatSeconds": func(s int) string {
+		if s < 60 {
+			return fmt.Sprintf("%ds", s)
+		}
+		return formatUptime(uint64(s))
+	},
+	"join": strings.Join,
+	// urlHost shows where a webhook goes without the token in its path
+	"urlHost": func(s string) string {
+		if u, err := url.Parse(s); err == nil {
+			return u.Host
+		}
+		return ""
+	},
 }
 
 func parseTemplate(name string) *template.Template {
@@ -44,16 +74,34 @@ func parseTemplate(name string) *template.Template {
 
 func NewWebHandler(store *db.Store, hub *ws.Hub) *WebHandler {
 	templates := map[string]*template.Template{
-		"dashboard":    parseTemplate("dashboard.html"),
-		"agent_detail": parseTemplate("agent_detail.html"),
-		"alerts":       parseTemplate("alerts.html"),
-		"audit_logs":   parseTemplate("audit_logs.html"),
+		"dashboard":     parseTemplate("dashboard.html"),
+		"agent_detail":  parseTemplate("agent_detail.html"),
+		"alerts":        parseTemplate("alerts.html"),
+		"audit_logs":    parseTemplate("audit_logs.html"),
+		"enrollment":    parseTemplate("enrollment.html"),
+		"recordings":    parseTemplate("recordings.html"),
+		"recording":     parseTemplate("recording.html"),
+		"users":         parseTemplate("users.html"),
+		"tokens":        parseTemplate("tokens.html"),
+		"account":       parseTemplate("account.html"),
+		"sessions":      parseTemplate("sessions.html"),
+		"notifications": parseTemplate("notifications.html"),
 	}
 
 	return &WebHandler{store: store, hub: hub, templates: templates}
 }
 
-func (h *WebHandler) render(w http.ResponseWriter, name string, data map[string]interface{}) {
+// render executes a page template. The signed-in user and what their role
+// allows are added to data for the layout and pages.
+func (h *WebHandler) render(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	if user := GetUserFromContext(r); user != nil {
+		data["CurrentUser"] = user
+		data["CanOperate"] = user.Role.Allows(models.RoleOperator)
+		data["IsAdmin"] = user.Role.Allows(models.RoleAdmin)
+	}
+	if sess := GetSessionFromContext(r); sess != nil {
+		data["CSRFToken"] = sess.CSRFToken
+	}
 	tmpl, ok := h.templates[name]
 	if !ok {
 		http.Error(w, "template not found", http.StatusInternalServerError)
@@ -74,16 +122,24 @@ func (h *WebHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		agents = []models.Agent{}
 	}
 
+	windows, _ := h.store.ListMaintenanceWindows()
+	now := time.Now()
+
 	var rows []agentRow
-	online, offline := 0, 0
+	online, offline, inMaintenance := 0, 0, 0
 	for _, a := range agents {
 		m, _ := h.store.GetLatestMetric(a.ID)
-		rows = append(rows, agentRow{Agent: a, Metric: m})
+		row := agentRow{Agent: a, Metric: m}
+		row.Maintenance, row.MaintenanceEnds = models.ActiveMaintenance(windows, a, now)
+		rows = append(rows, row)
 		if a.Status == models.AgentOnline {
 			online++
 		} else {
 			offline++
 		}
+		if row.Maintenance != nil {
+			inMaintenance++
+		}
 	}
 
 	alerts, _ := h.store.ListAlerts(100)
@@ -94,13 +150,14 @@ func (h *WebHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	h.render(w, "dashboard", map[string]interface{}{
+	h.render(w, r, "dashboard", map[string]interface{}{
 		"Title":         "Dashboard",
 		"Agents":        rows,
 		"TotalAgents":   len(agents),
 		"OnlineAgents":  online,
 		"OfflineAgents": offline,
 		"ActiveAlerts":  activeAlerts,
+		"InMaintenance": inMaintenance,
 	})
 }
 
@@ -112,10 +169,11 @@ func (h *WebHandler) AgentDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metric, _ := h.store.GetLatestMetric(id)
-	commands, _ := h.store.GetCommandsByAgent(id, 20)
-	if commands == nil {
-		commands = []models.Command{}
+	samples, _ := h.store.GetLatestSamples(id)
+	// Command output may hold secrets; viewers don't get it
+	commands := []models.Command{}
+	if user := GetUserFromContext(r); user != nil && user.Role.Allows(models.RoleOperator) {
+		commands, _ = h.store.GetCommandsByAgent(id, 20)
 	}
 
 	transfers, _ := h.store.GetFileTransfersByAgent(id, 20)
@@ -123,12 +181,19 @@ func (h *WebHandler) AgentDetail(w http.ResponseWriter, r *http.Request) {
 		transfers = []models.FileTransfer{}
 	}
 
-	h.render(w, "agent_detail", map[string]interface{}{
+	queued, _ := h.store.ListQueuedMessages(id)
+	shellSessions, _ := h.store.ListShellSessions(id, 10)
+
+	h.render(w, r, "agent_detail", map[string]interface{}{
 		"Title":         agent.Hostname,
 		"Agent":         agent,
-		"Metric":        metric,
+		"Online":        h.hub.IsConnected(id),
+		"Metric":        models.MetricFromSamples(id, samples),
+		"System":        models.SystemFromSamples(samples),
 		"Commands":      commands,
 		"FileTransfers": transfers,
+		"Queued":        queued,
+		"ShellSessions": shellSessions,
 	})
 }
 
@@ -148,11 +213,28 @@ func (h *WebHandler) Alerts(w http.ResponseWriter, r *http.Request) {
 		agents = []models.Agent{}
 	}
 
-	h.render(w, "alerts", map[string]interface{}{
-		"Title":  "Alerts",
-		"Alerts": alerts,
-		"Rules":  rules,
-		"Agents": agents,
+	now := time.Now()
+	silences, _ := h.store.ListSilences(now)
+	if silences == nil {
+		silences = []models.Silence{}
+	}
+
+	windows, _ := h.store.ListMaintenanceWindows()
+	maintenance := make([]maintenanceRow, 0, len(windows))
+	for _, mw := range windows {
+		row := maintenanceRow{Window: mw, Next: mw.NextStart(now)}
+		row.Ends, row.Active = mw.ActiveAt(now)
+		maintenance = append(maintenance, row)
+	}
+
+	h.render(w, r, "alerts", map[string]interface{}{
+		"Title":       "Alerts",
+		"Alerts":      alerts,
+		"Rules":       rules,
+		"Agents":      agents,
+		"Silences":    silences,
+		"Maintenance": maintenance,
+		"Now":         now,
 	})
 }
 
@@ -162,12 +244,152 @@ func (h *WebHandler) AuditLogs(w http.ResponseWriter, r *http.Request) {
 		logs = []models.AuditLog{}
 	}
 
-	h.render(w, "audit_logs", map[string]interface{}{
+	h.render(w, r, "audit_logs", map[string]interface{}{
 		"Title": "Audit Logs",
 		"Logs":  logs,
 	})
 }
 
+func (h *WebHandler) Recordings(w http.ResponseWriter, r *http.Request) {
+	agentID := r.URL.Query().Get("agent_id")
+	recs, _ := h.store.ListRecordings(agentID, 200)
+	if recs == nil {
+		recs = []models.Recording{}
+	}
+
+	h.render(w, r, "recordings", map[string]interface{}{
+		"Title":      "Recordings",
+		"AgentID":    agentID,
+		"Recordings": recs,
+	})
+}
+
+func (h *WebHandler) Recording(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	rec, err := h.store.GetRecording(id)
+	if err != nil || rec == nil {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return
+	}
+
+	h.render(w, r, "recording", map[string]interface{}{
+		"Title":     "Recording",
+		"Recording": rec,
+	})
+}
+
+func (h *WebHandler) Enrollment(w http.ResponseWriter, r *http.Request) {
+	tokens, _ := h.store.ListEnrollmentTokens()
+	if tokens == nil {
+		tokens = []models.EnrollmentToken{}
+	}
+
+	h.render(w, r, "enrollment", map[string]interface{}{
+		"Title":  "Enrollment",
+		"Tokens": tokens,
+	})
+}
+
+func (h *WebHandler) Users(w http.ResponseWriter, r *http.Request) {
+	users, _ := h.store.ListUsers()
+	if users == nil {
+		users = []models.User{}
+	}
+
+	settings, _ := h.store.GetSettings()
+	if settings == nil {
+		settings = &models.Settings{}
+	}
+
+	h.render(w, r, "users", map[string]interface{}{
+		"Title":    "Users",
+		"Users":    users,
+		"Settings": settings,
+	})
+}
+
+// Notifications lists the alert notification channels and their delivery log.
+func (h *WebHandler) Notifications(w http.ResponseWriter, r *http.Request) {
+	channels, _ := h.store.ListNotificationChannels()
+	if channels == nil {
+		channels = []models.NotificationChannel{}
+	}
+	for i := range channels {
+		channels[i].Config = channels[i].Config.Redacted()
+	}
+
+	deliveries, _ := h.store.ListDeliveries(100)
+	if deliveries == nil {
+		deliveries = []models.NotificationDelivery{}
+	}
+
+	rules, _ := h.store.ListAlertRules()
+	if rules == nil {
+		rules = []models.AlertRule{}
+	}
+
+	h.render(w, r, "notifications", map[string]interface{}{
+		"Title":      "Notifications",
+		"Channels":   channels,
+		"Deliveries": deliveries,
+		"Rules":      rules,
+	})
+}
+
+// APITokens lists the user's API tokens; admins see everyone's.
+func (h *WebHandler) APITokens(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromContext(r)
+	var userID int64
+	if !user.Role.Allows(models.RoleAdmin) {
+		userID = user.ID
+	}
+	tokens, _ := h.store.ListAPITokens(userID)
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+
+	h.render(w, r, "tokens", map[string]interface{}{
+		"Title":  "API Tokens",
+		"Tokens": tokens,
+		"Scopes": []models.Role{models.RoleViewer, models.RoleOperator, models.RoleAdmin},
+	})
+}
+
+// Account shows the user's two-factor authentication status.
+func (h *WebHandler) Account(w http.ResponseWriter, r *http.Request) {
+	user, _ := h.store.GetUser(GetUserFromContext(r).ID)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	recoveryCodes, _ := h.store.CountRecoveryCodes(user.ID)
+	settings, _ := h.store.GetSettings()
+	if settings == nil {
+		settings = &models.Settings{}
+	}
+
+	h.render(w, r, "account", map[string]interface{}{
+		"Title":         "Account",
+		"User":          user,
+		"RecoveryCodes": recoveryCodes,
+		"Require2FA":    settings.Require2FA,
+	})
+}
+
+// Sessions lists the user's active dashboard sessions.
+func (h *WebHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	sessions, _ := listSessions(h.store, r)
+
+	h.render(w, r, "sessions", map[string]interface{}{
+		"Title":    "Sessions",
+		"Sessions": sessions,
+	})
+}
+
 // fileServer serves static files embedded in the binary
 func fileServer(r chi.Router) {
 	staticFS, err := fs.Sub(web.StaticFS, "static")
@@ -220,3 +442,15 @@ func formatBytes(b int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
 }
+
+func formatUptime(seconds uint64) string {
+	d := time.Duration(seconds) * time.Second
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd %dh", int(d.Hours()/24), int(d.Hours())%24)
+	}
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestAlertLifecycle(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host"})
+	clear := 80.0
+	rule, err := store.CreateAlertRule(models.AlertRuleRequest{Metric: "cpu_percent", Operator: ">", Threshold: 90, ForSeconds: 300, ClearThreshold: &clear})
+	if err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+	rules, _ := store.ListAlertRules()
+	if len(rules) != 1 || rules[0].ForSeconds != 300 || rules[0].ClearLevel() != 80 {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	// One open alert per rule and agent
+	for i, want := range []bool{true, false} {
+		opened, err := store.OpenAlert(rule.ID, "agent-1", "cpu high")
+		if err != nil || (opened != nil) != want {
+			t.Fatalf("open #%d: opened=%v err=%v, want %v", i+1, opened, err, want)
+		}
+	}
+	if n, _ := store.CountActiveAlerts(); n != 1 {
+		t.Fatalf("expected 1 active alert, got %d", n)
+	}
+
+	resolved, err := store.ResolveOpenAlert(rule.ID, "agent-1")
+	if err != nil || resolved == nil || !resolved.Resolved || resolved.ResolvedAt == nil || resolved.Metric != "cpu_percent" {
+		t.Fatalf("resolve: resolved=%+v err=%v", resolved, err)
+	}
+	if resolved, _ := store.ResolveOpenAlert(rule.ID, "agent-1"); resolved != nil {
+		t.Error("nothing left to resolve")
+	}
+	alerts, _ := store.ListAlerts(10)
+	if len(alerts) != 1 || !alerts[0].Resolved || alerts[0].ResolvedAt == nil {
+		t.Fatalf("expected a resolved alert with resolved_at: %+v", alerts)
+	}
+
+	// Once resolved, the condition may fire again
+	if opened, _ := store.OpenAlert(rule.ID, "agent-1", "cpu high again"); opened == nil {
+		t.Error("expected a new alert after the last one resolved")
+	}
+	if err := store.DeleteAlertRule(rule.ID); err != nil {
+		t.Fatalf("delete rule: %v", err)
+	}
+	if n, _ := store.CountActiveAlerts(); n != 0 {
+		t.Errorf("deleting the rule should resolve its alerts, %d still open", n)
+	}
+}
+
+func TestPruneAlerts(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host"})
+	rule, _ := store.CreateAlertRule(models.AlertRuleRequest{Type: models.RuleOffline})
+	other, _ := store.CreateAlertRule(models.AlertRuleRequest{Metric: "cpu_percent", Operator: ">", Threshold: 90})
+	resolved, _ := store.OpenAlert(rule.ID, "agent-1", "offline")
+	store.ResolveOpenAlert(rule.ID, "agent-1")
+	open, _ := store.OpenAlert(other.ID, "agent-1", "cpu high")
+
+	n, err := store.PruneAlerts(time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("prune = %d, %v", n, err)
+	}
+	if a, _ := store.GetAlert(resolved.ID); a != nil {
+		t.Error("resolved alert not pruned")
+	}
+	if a, _ := store.GetAlert(open.ID); a == nil {
+		t.Error("open alert was pruned")
+	}
+}
+
+func TestDuplicateAlertsMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rmm.db")
+	store, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host"})
+	rule, _ := store.CreateAlertRule(models.AlertRuleRequest{Metric: "cpu_percent", Operator: ">", Threshold: 90})
+	// Databases from before raised an alert on every check
+	store.db.Exec(`DROP INDEX idx_alerts_open`)
+	for i := 0; i < 3; i++ {
+		store.db.Exec(`INSERT INTO alerts (rule_id, agent_id, message) VALUES (?, 'agent-1', ?)`, rule.ID, i)
+	}
+	store.Close()
+
+	store, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if n, _ := store.CountActiveAlerts(); n != 1 {
+		t.Errorf("expected duplicates resolved to 1 open alert, got %d", n)
+	}
+	if opened, _ := store.OpenAlert(rule.ID, "agent-1", "again"); opened != nil {
+		t.Error("expected the unique index to be in place")
+	}
+}
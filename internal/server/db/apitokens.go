@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ---- API Tokens ----
+
+const apiTokenColumns = `t.id, t.user_id, u.username, t.name, t.prefix, t.scope, t.expires_at, t.last_used_at, t.revoked, t.created_at`
+
+func (s *Store) CreateAPIToken(userID int64, token, name string, scope models.Role, expiresAt *time.Time) (*models.APIToken, error) {
+	now := time.Now().UTC()
+	prefix := token
+	if len(prefix) > 12 {
+		prefix = prefix[:12]
+	}
+	res, err := s.db.Exec(`INSERT INTO api_tokens (user_id, name, token_hash, prefix, scope, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, name, hashToken(token), prefix, scope, expiresAt, now)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return s.GetAPIToken(id)
+}
+
+func (s *Store) GetAPIToken(id int64) (*models.APIToken, error) {
+	return scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens t JOIN users u ON u.id = t.user_id WHERE t.id=?`, id))
+}
+
+// ListAPITokens returns a user's tokens, newest first. userID 0 lists the
+// tokens of every user.
+func (s *Store) ListAPITokens(userID int64) ([]models.APIToken, error) {
+	rows, err := s.db.Query(`SELECT `+apiTokenColumns+` FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE ?=0 OR t.user_id=? ORDER BY t.created_at DESC, t.id DESC`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// AuthenticateAPIToken resolves a bearer token to its token and user and
+// records its use. It returns nils for unknown, revoked or expired tokens
+// and for disabled users.
+func (s *Store) AuthenticateAPIToken(token string) (*models.APIToken, *models.User, error) {
+	t, err := scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens t JOIN users u ON u.id = t.user_id WHERE t.token_hash=?`, hashToken(token)))
+	if err != nil || t == nil || !t.Active() {
+		return nil, nil, err
+	}
+	user, err := s.GetUser(t.UserID)
+	if err != nil || user == nil || user.Disabled {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	if _, err := s.db.Exec(`UPDATE api_tokens SET last_used_at=? WHERE id=?`, now, t.ID); err != nil {
+		return nil, nil, err
+	}
+	t.LastUsedAt = &now
+	return t, user, nil
+}
+
+func (s *Store) RevokeAPIToken(id int64) error {
+	_, err := s.db.Exec(`UPDATE api_tokens SET revoked=1 WHERE id=?`, id)
+	return err
+}
+
+func scanAPIToken(row interface{ Scan(...any) error }) (*models.APIToken, error) {
+	var t models.APIToken
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &t.Prefix, &t.Scope, &expiresAt, &lastUsedAt, &t.Revoked, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestAPITokens(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.CreateUser("ci", "hash", models.RoleOperator)
+	user, _ := store.GetUserByUsername("ci")
+
+	tok, err := store.CreateAPIToken(user.ID, "rmm_secretvalue123", "deploy", models.RoleViewer, nil)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if tok.Prefix != "rmm_secretva" || tok.Username != "ci" || tok.Scope != models.RoleViewer {
+		t.Fatalf("unexpected token %+v", tok)
+	}
+
+	got, owner, err := store.AuthenticateAPIToken("rmm_secretvalue123")
+	if err != nil || got == nil || owner == nil || owner.ID != user.ID {
+		t.Fatalf("authenticate: %+v %+v %v", got, owner, err)
+	}
+	if got.LastUsedAt == nil {
+		t.Error("expected last use to be recorded")
+	}
+	if got.EffectiveRole(owner.Role) != models.RoleViewer {
+		t.Errorf("scope must cap the role, got %s", got.EffectiveRole(owner.Role))
+	}
+	if got, _, _ := store.AuthenticateAPIToken("rmm_wrong"); got != nil {
+		t.Error("unknown token must not authenticate")
+	}
+
+	store.SetUserDisabled(user.ID, true)
+	if got, _, _ := store.AuthenticateAPIToken("rmm_secretvalue123"); got != nil {
+		t.Error("tokens of disabled users must not authenticate")
+	}
+	store.SetUserDisabled(user.ID, false)
+
+	store.RevokeAPIToken(tok.ID)
+	if got, _, _ := store.AuthenticateAPIToken("rmm_secretvalue123"); got != nil {
+		t.Error("revoked token must not authenticate")
+	}
+
+	past := time.Now().Add(-time.Minute)
+	store.CreateAPIToken(user.ID, "rmm_expired", "old", models.RoleOperator, &past)
+	if got, _, _ := store.AuthenticateAPIToken("rmm_expired"); got != nil {
+		t.Error("expired token must not authenticate")
+	}
+
+	if tokens, _ := store.ListAPITokens(user.ID); len(tokens) != 2 {
+		t.Errorf("expected 2 tokens, got %d", len(tokens))
+	}
+	store.DeleteUser(user.ID)
+	if tokens, _ := store.ListAPITokens(0); len(tokens) != 0 {
+		t.Errorf("tokens must be deleted with their user, got %d", len(tokens))
+	}
+}
@@ -1,19 +1,27 @@
 package db
 
 import (
+	"database/sql"
 	"time"
 
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
 )
 
 func (s *Store) InsertAuditLog(username, action, target, details string) error {
-	_, err := s.db.Exec(`INSERT INTO audit_logs (username, action, target, details, created_at) VALUES (?, ?, ?, ?, ?)`,
-		username, action, target, details, time.Now().UTC())
+	return s.InsertAuditLogWithRecording(username, action, target, details, 0)
+}
+
+// InsertAuditLogWithRecording records an action whose transcript is stored
+// as recording recordingID (0 = none).
+func (s *Store) InsertAuditLogWithRecording(username, action, target, details string, recordingID int64) error {
+	recID := sql.NullInt64{Int64: recordingID, Valid: recordingID != 0}
+	_, err := s.db.Exec(`INSERT INTO audit_logs (username, action, target, details, recording_id, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		username, action, target, details, recID, time.Now().UTC())
 	return err
 }
 
 func (s *Store) GetAuditLogs(limit int) ([]models.AuditLog, error) {
-	rows, err := s.db.Query(`SELECT id, username, action, target, details, created_at FROM audit_logs ORDER BY created_at DESC, id DESC LIMIT ?`, limit)
+	rows, err := s.db.Query(`SELECT id, username, action, target, details, recording_id, created_at FROM audit_logs ORDER BY created_at DESC, id DESC LIMIT ?`, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -22,10 +30,23 @@ func (s *Store) GetAuditLogs(limit int) ([]models.AuditLog, error) {
 	var logs []models.AuditLog
 	for rows.Next() {
 		var l models.AuditLog
-		if err := rows.Scan(&l.ID, &l.Username, &l.Action, &l.Target, &l.Details, &l.CreatedAt); err != nil {
+		var recID sql.NullInt64
+		if err := rows.Scan(&l.ID, &l.Username, &l.Action, &l.Target, &l.Details, &recID, &l.CreatedAt); err != nil {
 			return nil, err
 		}
+		if recID.Valid {
+			l.RecordingID = &recID.Int64
+		}
 		logs = append(logs, l)
 	}
 	return logs, rows.Err()
 }
+
+// PruneAuditLogs deletes audit entries recorded before cutoff.
+func (s *Store) PruneAuditLogs(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM audit_logs WHERE created_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
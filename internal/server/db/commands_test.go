@@ -0,0 +1,136 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestStreamedCommandLifecycle(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host"})
+	cmd, err := store.CreateCommand("agent-1", "echo hi", 30)
+	if err != nil {
+		t.Fatalf("create command: %v", err)
+	}
+
+	if err := store.MarkCommandRunning(cmd.ID); err != nil {
+		t.Fatalf("mark running: %v", err)
+	}
+	store.AppendCommandOutput(cmd.ID, models.StreamStdout, "hello ")
+	store.AppendCommandOutput(cmd.ID, models.StreamStderr, "warn\n")
+	store.AppendCommandOutput(cmd.ID, models.StreamStdout, "world\n")
+
+	got, err := store.GetCommand(cmd.ID)
+	if err != nil {
+		t.Fatalf("get command: %v", err)
+	}
+	if got.TimeoutSeconds != 30 {
+		t.Errorf("expected timeout 30, got %d", got.TimeoutSeconds)
+	}
+	if got.Status != models.CommandRunning {
+		t.Errorf("expected running, got %s", got.Status)
+	}
+	if got.Stdout != "hello world\n" || got.Stderr != "warn\n" {
+		t.Errorf("unexpected output: stdout=%q stderr=%q", got.Stdout, got.Stderr)
+	}
+
+	if ok, err := store.FinishCommand(cmd.ID, 2, models.CommandFailed); !ok || err != nil {
+		t.Fatalf("finish: %v, %v", ok, err)
+	}
+	got, _ = store.GetCommand(cmd.ID)
+	if got.Status != models.CommandFailed || got.ExitCode != 2 || got.Stdout != "hello world\n" {
+		t.Errorf("unexpected final state: %+v", got)
+	}
+
+	// A late command_started must not move a finished command back to running
+	store.MarkCommandRunning(cmd.ID)
+	got, _ = store.GetCommand(cmd.ID)
+	if got.Status != models.CommandFailed {
+		t.Errorf("expected finished status to stick, got %s", got.Status)
+	}
+
+	// Nor a late result overwrite a cancelled command
+	cancelled, _ := store.CreateCommand("agent-1", "sleep 10", 0)
+	store.FinishCommand(cancelled.ID, -1, models.CommandCancelled)
+	if ok, err := store.FinishCommand(cancelled.ID, 0, models.CommandDone); ok || err != nil {
+		t.Errorf("finished a cancelled command again: %v, %v", ok, err)
+	}
+	store.SetCommandOutput(cancelled.ID, "late", "")
+	got, _ = store.GetCommand(cancelled.ID)
+	if got.Status != models.CommandCancelled || got.ExitCode != -1 || got.Stdout != "" {
+		t.Errorf("cancelled command changed: %+v", got)
+	}
+
+	if missing, err := store.GetCommand(999); err != nil || missing != nil {
+		t.Errorf("expected nil for unknown command, got %v, %v", missing, err)
+	}
+}
+
+func TestPruneCommands(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host"})
+	done, _ := store.CreateCommand("agent-1", "echo done", 30)
+	store.FinishCommand(done.ID, 0, models.CommandDone)
+	running, _ := store.CreateCommand("agent-1", "sleep 1000", 0)
+	store.MarkCommandRunning(running.ID)
+
+	if n, _ := store.PruneCommands(time.Now().Add(-time.Hour)); n != 0 {
+		t.Errorf("pruned %d recent commands", n)
+	}
+	n, err := store.PruneCommands(time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("prune: %d, %v", n, err)
+	}
+	if got, _ := store.GetCommand(done.ID); got != nil {
+		t.Errorf("expected the finished command to be pruned")
+	}
+	if got, _ := store.GetCommand(running.ID); got == nil {
+		t.Errorf("a running command must be kept")
+	}
+}
+
+func TestCommandOutputCap(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	cmd, _ := store.CreateCommand("agent-1", "yes", 0)
+	store.AppendCommandOutput(cmd.ID, models.StreamStdout, strings.Repeat("y", MaxCommandOutput-2))
+	store.AppendCommandOutput(cmd.ID, models.StreamStdout, "é!")
+	store.AppendCommandOutput(cmd.ID, models.StreamStdout, "more")
+	store.AppendCommandOutput(cmd.ID, models.StreamStderr, "warn")
+
+	got, _ := store.GetCommand(cmd.ID)
+	if !got.OutputTruncated {
+		t.Error("expected output to be marked truncated")
+	}
+	// "é" is two bytes and fits exactly; nothing after it is kept
+	if len(got.Stdout) != MaxCommandOutput || !strings.HasSuffix(got.Stdout, "yé") {
+		t.Errorf("unexpected stdout: %d bytes ending %q", len(got.Stdout), got.Stdout[len(got.Stdout)-4:])
+	}
+	if got.Stderr != "warn" {
+		t.Errorf("stderr has its own cap, got %q", got.Stderr)
+	}
+
+	whole, _ := store.CreateCommand("agent-1", "cat big", 0)
+	store.SetCommandOutput(whole.ID, strings.Repeat("x", MaxCommandOutput-1)+"é", "")
+	got, _ = store.GetCommand(whole.ID)
+	if !got.OutputTruncated || len(got.Stdout) != MaxCommandOutput-1 {
+		t.Errorf("expected the split character to be dropped: %d bytes, truncated %v", len(got.Stdout), got.OutputTruncated)
+	}
+
+	// Output that isn't UTF-8 is cut, not cleaned up
+	binary := "\xff\xfe" + strings.Repeat("\x80", MaxCommandOutput)
+	raw, _ := store.CreateCommand("agent-1", "cat /bin/ls", 0)
+	store.SetCommandOutput(raw.ID, binary, "")
+	got, _ = store.GetCommand(raw.ID)
+	if !got.OutputTruncated || got.Stdout != binary[:MaxCommandOutput] {
+		t.Errorf("expected the first %d bytes as they were, got %d bytes starting %q", MaxCommandOutput, len(got.Stdout), got.Stdout[:min(len(got.Stdout), 4)])
+	}
+}
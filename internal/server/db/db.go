@@ -1,17 +1,20 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"time"
+	"unicode/utf8"
 
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
 	_ "modernc.org/sqlite"
 )
 
 type Store struct {
-	db *sql.DB
+	db conn
 }
 
 func New(dbPath string) (*Store, error) {
@@ -26,8 +29,70 @@ func New(dbPath string) (*Store, error) {
 	_, _ = d.Exec("ALTER TABLE agents ADD COLUMN display_name TEXT NOT NULL DEFAULT ''")
 	// Migration: add agent_id to existing alert_rules
 	_, _ = d.Exec("ALTER TABLE alert_rules ADD COLUMN agent_id TEXT NOT NULL DEFAULT ''")
+	// Migration: per-agent secrets issued at enrollment
+	_, _ = d.Exec("ALTER TABLE agents ADD COLUMN secret_hash TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE agents ADD COLUMN secret_revoked INTEGER NOT NULL DEFAULT 0")
+	// Migration: mTLS client certificates issued to agents
+	_, _ = d.Exec("ALTER TABLE agents ADD COLUMN cert_serial TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE agents ADD COLUMN cert_revoked INTEGER NOT NULL DEFAULT 0")
+	// Migration: per-command timeout
+	_, _ = d.Exec("ALTER TABLE commands ADD COLUMN timeout_seconds INTEGER NOT NULL DEFAULT 0")
+	// Migration: audit entries link to session recordings
+	_, _ = d.Exec("ALTER TABLE audit_logs ADD COLUMN recording_id INTEGER")
+	// Migration: user roles; users from before roles existed keep full access
+	_, _ = d.Exec("ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'admin'")
+	_, _ = d.Exec("ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0")
+	// Migration: TOTP two-factor authentication
+	_, _ = d.Exec("ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0")
+	_, _ = d.Exec("ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0")
+	// Migration: account lockout after failed logins
+	_, _ = d.Exec("ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0")
+	_, _ = d.Exec("ALTER TABLE users ADD COLUMN locked_until DATETIME")
+	// Migration: CSRF tokens and activity tracking for sessions; sessions
+	// from before have no CSRF token and must log in again
+	_, _ = d.Exec("ALTER TABLE sessions ADD COLUMN csrf_token TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE sessions ADD COLUMN created_at DATETIME")
+	_, _ = d.Exec("ALTER TABLE sessions ADD COLUMN last_seen_at DATETIME")
+	// Migration: users provisioned by OIDC single sign-on
+	_, _ = d.Exec("ALTER TABLE users ADD COLUMN oidc_issuer TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE users ADD COLUMN oidc_subject TEXT NOT NULL DEFAULT ''")
+	// Migration: alert rules on a single mount point, interface or core
+	_, _ = d.Exec("ALTER TABLE alert_rules ADD COLUMN target TEXT NOT NULL DEFAULT ''")
+	// Migration: sustained alert conditions, hysteresis and auto-resolve
+	_, _ = d.Exec("ALTER TABLE alert_rules ADD COLUMN for_seconds INTEGER NOT NULL DEFAULT 0")
+	_, _ = d.Exec("ALTER TABLE alert_rules ADD COLUMN clear_threshold REAL")
+	_, _ = d.Exec("ALTER TABLE alerts ADD COLUMN resolved_at DATETIME")
+	// Migration: one open alert per rule and agent; duplicates raised on
+	// every check before are resolved, keeping the newest
+	_, _ = d.Exec(`UPDATE alerts SET resolved=1 WHERE resolved=0 AND id NOT IN
+		(SELECT MAX(id) FROM alerts WHERE resolved=0 GROUP BY rule_id, agent_id)`)
+	_, _ = d.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_open ON alerts(rule_id, agent_id) WHERE resolved=0")
+	// Migration: offline alert rules
+	_, _ = d.Exec("ALTER TABLE alert_rules ADD COLUMN type TEXT NOT NULL DEFAULT 'metric'")
+	// Migration: severities, acknowledgement and manual resolution
+	_, _ = d.Exec("ALTER TABLE alert_rules ADD COLUMN severity TEXT NOT NULL DEFAULT 'warning'")
+	_, _ = d.Exec("ALTER TABLE alerts ADD COLUMN resolved_by TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE alerts ADD COLUMN acknowledged_by TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE alerts ADD COLUMN acknowledged_at DATETIME")
+	_, _ = d.Exec("ALTER TABLE alerts ADD COLUMN ack_comment TEXT NOT NULL DEFAULT ''")
+	_, _ = d.Exec("ALTER TABLE notification_channels ADD COLUMN min_severity TEXT NOT NULL DEFAULT ''")
+	// Migration: agent tags
+	_, _ = d.Exec("ALTER TABLE agents ADD COLUMN tags TEXT NOT NULL DEFAULT '[]'")
+	// Migration: alerts raised during maintenance windows
+	_, _ = d.Exec("ALTER TABLE alerts ADD COLUMN maintenance INTEGER NOT NULL DEFAULT 0")
+	// Migration: rotated agent secrets waiting for the agent to use them
+	_, _ = d.Exec("ALTER TABLE agents ADD COLUMN pending_secret_hash TEXT NOT NULL DEFAULT ''")
+	// Migration: command output is capped; the recording keeps all of it
+	_, _ = d.Exec("ALTER TABLE commands ADD COLUMN output_truncated INTEGER NOT NULL DEFAULT 0")
+	// Migration: metrics and metric_values become labelled series
+	if err := migrateLegacyMetrics(d); err != nil {
+		return nil, fmt.Errorf("migrate metrics: %w", err)
+	}
 	slog.Info("database initialized", "path", dbPath)
-	return &Store{db: d}, nil
+	return &Store{db: conn{DB: d, ctx: context.Background()}}, nil
 }
 
 func (s *Store) Close() error {
@@ -52,8 +117,22 @@ func (s *Store) UpsertAgent(a models.HeartbeatPayload) error {
 	return err
 }
 
+const agentColumns = `id, display_name, hostname, os, ip, version, last_heartbeat, status, secret_hash != '', secret_revoked, cert_serial, cert_revoked, tags, created_at`
+
+// scanAgent scans a row selected with agentColumns. last_heartbeat is NULL
+// for agents that enrolled but never sent a heartbeat.
+func scanAgent(row interface{ Scan(...any) error }, a *models.Agent) error {
+	var lastHeartbeat sql.NullTime
+	var tags string
+	if err := row.Scan(&a.ID, &a.DisplayName, &a.Hostname, &a.OS, &a.IP, &a.Version, &lastHeartbeat, &a.Status, &a.Enrolled, &a.Revoked, &a.CertSerial, &a.CertRevoked, &tags, &a.CreatedAt); err != nil {
+		return err
+	}
+	a.LastHeartbeat = lastHeartbeat.Time
+	return json.Unmarshal([]byte(tags), &a.Tags)
+}
+
 func (s *Store) ListAgents() ([]models.Agent, error) {
-	rows, err := s.db.Query(`SELECT id, display_name, hostname, os, ip, version, last_heartbeat, status, created_at FROM agents ORDER BY display_name, id`)
+	rows, err := s.db.Query(`SELECT ` + agentColumns + ` FROM agents ORDER BY display_name, id`)
 	if err != nil {
 		return nil, err
 	}
@@ -62,7 +141,7 @@ func (s *Store) ListAgents() ([]models.Agent, error) {
 	var agents []models.Agent
 	for rows.Next() {
 		var a models.Agent
-		if err := rows.Scan(&a.ID, &a.DisplayName, &a.Hostname, &a.OS, &a.IP, &a.Version, &a.LastHeartbeat, &a.Status, &a.CreatedAt); err != nil {
+		if err := scanAgent(rows, &a); err != nil {
 			return nil, err
 		}
 		agents = append(agents, a)
@@ -72,8 +151,7 @@ func (s *Store) ListAgents() ([]models.Agent, error) {
 
 func (s *Store) GetAgent(id string) (*models.Agent, error) {
 	var a models.Agent
-	err := s.db.QueryRow(`SELECT id, display_name, hostname, os, ip, version, last_heartbeat, status, created_at FROM agents WHERE id=?`, id).
-		Scan(&a.ID, &a.DisplayName, &a.Hostname, &a.OS, &a.IP, &a.Version, &a.LastHeartbeat, &a.Status, &a.CreatedAt)
+	err := scanAgent(s.db.QueryRow(`SELECT `+agentColumns+` FROM agents WHERE id=?`, id), &a)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -83,6 +161,19 @@ func (s *Store) GetAgent(id string) (*models.Agent, error) {
 	return &a, nil
 }
 
+// SetAgentTags replaces an agent's tags.
+func (s *Store) SetAgentTags(id string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE agents SET tags=? WHERE id=?`, string(b), id)
+	return err
+}
+
 func (s *Store) MarkOfflineAgents(timeout time.Duration) (int64, error) {
 	res, err := s.db.Exec(`UPDATE agents SET status='offline' WHERE status='online' AND last_heartbeat < ?`,
 		time.Now().UTC().Add(-timeout))
@@ -97,74 +188,150 @@ func (s *Store) DeleteAgent(id string) error {
 	return err
 }
 
-// ---- Metrics ----
+// ---- Commands ----
+
+// MaxCommandOutput caps the stdout and the stderr stored with a command.
+// The command's recording keeps the full output.
+const MaxCommandOutput = 1 << 20
+
+func (s *Store) CreateCommand(agentID, command string, timeoutSeconds int) (*models.Command, error) {
+	res, err := s.db.Exec(`INSERT INTO commands (agent_id, command, status, timeout_seconds) VALUES (?, ?, 'pending', ?)`, agentID, command, timeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return &models.Command{
+		ID:             id,
+		AgentID:        agentID,
+		Command:        command,
+		Status:         models.CommandPending,
+		TimeoutSeconds: timeoutSeconds,
+		CreatedAt:      time.Now().UTC(),
+	}, nil
+}
+
+const commandColumns = `id, agent_id, command, stdout, stderr, output_truncated, exit_code, status, timeout_seconds, created_at`
+
+func scanCommand(row interface{ Scan(...any) error }, c *models.Command) error {
+	return row.Scan(&c.ID, &c.AgentID, &c.Command, &c.Stdout, &c.Stderr, &c.OutputTruncated, &c.ExitCode, &c.Status, &c.TimeoutSeconds, &c.CreatedAt)
+}
+
+// SetCommandOutput stores the whole output of a command whose agent sent it
+// at the end instead of streaming it, capped at MaxCommandOutput per stream.
+// Finished commands are left alone.
+func (s *Store) SetCommandOutput(id int64, stdout, stderr string) error {
+	stdout, cutOut := capOutput(stdout, MaxCommandOutput)
+	stderr, cutErr := capOutput(stderr, MaxCommandOutput)
+	_, err := s.db.Exec(`UPDATE commands SET stdout=?, stderr=?, output_truncated=? WHERE id=? AND status IN (?, ?)`,
+		stdout, stderr, cutOut || cutErr, id, models.CommandPending, models.CommandRunning)
+	return err
+}
 
-func (s *Store) InsertMetric(m models.Metric) error {
-	_, err := s.db.Exec(`INSERT INTO metrics (agent_id, cpu_percent, memory_percent, disk_percent, timestamp) VALUES (?, ?, ?, ?, ?)`,
-		m.AgentID, m.CPUPercent, m.MemoryPercent, m.DiskPercent, time.Now().UTC())
+// MarkCommandRunning moves a pending command to running once the agent starts it.
+func (s *Store) MarkCommandRunning(id int64) error {
+	_, err := s.db.Exec(`UPDATE commands SET status=? WHERE id=? AND status=?`, models.CommandRunning, id, models.CommandPending)
 	return err
 }
 
-func (s *Store) GetLatestMetrics(agentID string, limit int) ([]models.Metric, error) {
-	rows, err := s.db.Query(`SELECT id, agent_id, cpu_percent, memory_percent, disk_percent, timestamp FROM metrics WHERE agent_id=? ORDER BY timestamp DESC LIMIT ?`, agentID, limit)
+// RunningCommandIDs returns the commands agentID has started and not yet finished.
+func (s *Store) RunningCommandIDs(agentID string) ([]int64, error) {
+	rows, err := s.db.Query(`SELECT id FROM commands WHERE agent_id=? AND status=? ORDER BY id`, agentID, models.CommandRunning)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var metrics []models.Metric
+	var ids []int64
 	for rows.Next() {
-		var m models.Metric
-		if err := rows.Scan(&m.ID, &m.AgentID, &m.CPUPercent, &m.MemoryPercent, &m.DiskPercent, &m.Timestamp); err != nil {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		metrics = append(metrics, m)
+		ids = append(ids, id)
 	}
-	return metrics, rows.Err()
+	return ids, rows.Err()
 }
 
-func (s *Store) GetLatestMetric(agentID string) (*models.Metric, error) {
-	var m models.Metric
-	err := s.db.QueryRow(`SELECT id, agent_id, cpu_percent, memory_percent, disk_percent, timestamp FROM metrics WHERE agent_id=? ORDER BY timestamp DESC LIMIT 1`, agentID).
-		Scan(&m.ID, &m.AgentID, &m.CPUPercent, &m.MemoryPercent, &m.DiskPercent, &m.Timestamp)
+// AppendCommandOutput appends a streamed chunk to the command's stdout or
+// stderr. Once a stream holds MaxCommandOutput bytes the rest is dropped and
+// the command is marked truncated; its recording still has all of it.
+func (s *Store) AppendCommandOutput(id int64, stream, data string) error {
+	column := "stdout"
+	if stream == models.StreamStderr {
+		column = "stderr"
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stored int
+	err = tx.QueryRow(`SELECT length(CAST(`+column+` AS BLOB)) FROM commands WHERE id=?`, id).Scan(&stored)
 	if err == sql.ErrNoRows {
-		return nil, nil
+		return nil
 	}
 	if err != nil {
-		return nil, err
+		return err
+	}
+	data, cut := capOutput(data, MaxCommandOutput-stored)
+	if data == "" && !cut {
+		return nil
 	}
-	return &m, nil
+	if _, err := tx.Exec(`UPDATE commands SET `+column+`=`+column+` || ?, output_truncated=output_truncated OR ? WHERE id=?`, data, cut, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// ---- Commands ----
+// capOutput cuts s to at most n bytes without splitting a character and
+// reports whether anything was cut. Bytes that aren't UTF-8 are kept as
+// they are.
+func capOutput(s string, n int) (string, bool) {
+	n = max(n, 0)
+	if len(s) <= n {
+		return s, false
+	}
+	// Drop the start of a character the cut runs into
+	for i := n - 1; i >= 0 && i > n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if r, size := utf8.DecodeRuneInString(s[i:]); r != utf8.RuneError && i+size > n {
+				n = i
+			}
+			break
+		}
+	}
+	return s[:n], true
+}
 
-func (s *Store) CreateCommand(agentID, command string) (*models.Command, error) {
-	res, err := s.db.Exec(`INSERT INTO commands (agent_id, command, status) VALUES (?, ?, 'pending')`, agentID, command)
+// FinishCommand records the final status and exit code of a command (or
+// of one that never ran, e.g. cancelled while pending). A command finishes
+// once: it returns false if the command was already cancelled, expired or
+// done, so a late result from the agent doesn't overwrite that.
+func (s *Store) FinishCommand(id int64, exitCode int, status models.CommandStatus) (bool, error) {
+	res, err := s.db.Exec(`UPDATE commands SET exit_code=?, status=? WHERE id=? AND status IN (?, ?)`,
+		exitCode, status, id, models.CommandPending, models.CommandRunning)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	id, _ := res.LastInsertId()
-	return &models.Command{
-		ID:        id,
-		AgentID:   agentID,
-		Command:   command,
-		Status:    models.CommandPending,
-		CreatedAt: time.Now().UTC(),
-	}, nil
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
 
-func (s *Store) UpdateCommandResult(id int64, stdout, stderr string, exitCode int) error {
-	status := models.CommandDone
-	if exitCode != 0 {
-		status = models.CommandFailed
+func (s *Store) GetCommand(id int64) (*models.Command, error) {
+	var c models.Command
+	err := scanCommand(s.db.QueryRow(`SELECT `+commandColumns+` FROM commands WHERE id=?`, id), &c)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	_, err := s.db.Exec(`UPDATE commands SET stdout=?, stderr=?, exit_code=?, status=? WHERE id=?`,
-		stdout, stderr, exitCode, status, id)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
 }
 
 func (s *Store) GetCommandsByAgent(agentID string, limit int) ([]models.Command, error) {
-	rows, err := s.db.Query(`SELECT id, agent_id, command, stdout, stderr, exit_code, status, created_at FROM commands WHERE agent_id=? ORDER BY created_at DESC LIMIT ?`, agentID, limit)
+	rows, err := s.db.Query(`SELECT `+commandColumns+` FROM commands WHERE agent_id=? ORDER BY created_at DESC, id DESC LIMIT ?`, agentID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -173,7 +340,7 @@ func (s *Store) GetCommandsByAgent(agentID string, limit int) ([]models.Command,
 	var cmds []models.Command
 	for rows.Next() {
 		var c models.Command
-		if err := rows.Scan(&c.ID, &c.AgentID, &c.Command, &c.Stdout, &c.Stderr, &c.ExitCode, &c.Status, &c.CreatedAt); err != nil {
+		if err := scanCommand(rows, &c); err != nil {
 			return nil, err
 		}
 		cmds = append(cmds, c)
@@ -181,27 +348,94 @@ func (s *Store) GetCommandsByAgent(agentID string, limit int) ([]models.Command,
 	return cmds, rows.Err()
 }
 
+// CountCommandsByStatus returns how many commands are in each status.
+func (s *Store) CountCommandsByStatus() (map[models.CommandStatus]int, error) {
+	rows, err := s.db.Query(`SELECT status, COUNT(*) FROM commands GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[models.CommandStatus]int)
+	for rows.Next() {
+		var status models.CommandStatus
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// PruneCommands deletes finished commands created before cutoff. Pending and
+// running commands are kept.
+func (s *Store) PruneCommands(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM commands WHERE created_at < ? AND status NOT IN (?, ?)`,
+		before.UTC(), models.CommandPending, models.CommandRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 // ---- Alert Rules ----
 
 func (s *Store) CreateAlertRule(r models.AlertRuleRequest) (*models.AlertRule, error) {
-	res, err := s.db.Exec(`INSERT INTO alert_rules (metric, operator, threshold, agent_id) VALUES (?, ?, ?, ?)`,
-		r.Metric, r.Operator, r.Threshold, r.AgentID)
+	if r.Type == "" {
+		r.Type = models.RuleMetric
+	}
+	if r.Severity == "" {
+		r.Severity = models.SeverityWarning
+	}
+	res, err := s.db.Exec(`INSERT INTO alert_rules (type, severity, metric, operator, threshold, agent_id, target, for_seconds, clear_threshold) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Type, r.Severity, r.Metric, r.Operator, r.Threshold, r.AgentID, r.Target, r.ForSeconds, r.ClearThreshold)
 	if err != nil {
 		return nil, err
 	}
 	id, _ := res.LastInsertId()
 	return &models.AlertRule{
-		ID:        id,
-		Metric:    r.Metric,
-		Operator:  r.Operator,
-		Threshold: r.Threshold,
-		AgentID:   r.AgentID,
-		CreatedAt: time.Now().UTC(),
+		ID:             id,
+		Type:           r.Type,
+		Severity:       r.Severity,
+		Metric:         r.Metric,
+		Operator:       r.Operator,
+		Threshold:      r.Threshold,
+		AgentID:        r.AgentID,
+		Target:         r.Target,
+		ForSeconds:     r.ForSeconds,
+		ClearThreshold: r.ClearThreshold,
+		CreatedAt:      time.Now().UTC(),
 	}, nil
 }
 
+const ruleColumns = `id, type, severity, metric, operator, threshold, agent_id, target, for_seconds, clear_threshold, created_at`
+
+func scanRule(row interface{ Scan(...any) error }, r *models.AlertRule) error {
+	var clear sql.NullFloat64
+	if err := row.Scan(&r.ID, &r.Type, &r.Severity, &r.Metric, &r.Operator, &r.Threshold, &r.AgentID, &r.Target, &r.ForSeconds, &clear, &r.CreatedAt); err != nil {
+		return err
+	}
+	if clear.Valid {
+		r.ClearThreshold = &clear.Float64
+	}
+	return nil
+}
+
+func (s *Store) GetAlertRule(id int64) (*models.AlertRule, error) {
+	var r models.AlertRule
+	err := scanRule(s.db.QueryRow(`SELECT `+ruleColumns+` FROM alert_rules WHERE id=?`, id), &r)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
 func (s *Store) ListAlertRules() ([]models.AlertRule, error) {
-	rows, err := s.db.Query(`SELECT id, metric, operator, threshold, agent_id, created_at FROM alert_rules ORDER BY id`)
+	rows, err := s.db.Query(`SELECT ` + ruleColumns + ` FROM alert_rules ORDER BY id`)
 	if err != nil {
 		return nil, err
 	}
@@ -210,7 +444,7 @@ func (s *Store) ListAlertRules() ([]models.AlertRule, error) {
 	var rules []models.AlertRule
 	for rows.Next() {
 		var r models.AlertRule
-		if err := rows.Scan(&r.ID, &r.Metric, &r.Operator, &r.Threshold, &r.AgentID, &r.CreatedAt); err != nil {
+		if err := scanRule(rows, &r); err != nil {
 			return nil, err
 		}
 		rules = append(rules, r)
@@ -218,21 +452,82 @@ func (s *Store) ListAlertRules() ([]models.AlertRule, error) {
 	return rules, rows.Err()
 }
 
+// DeleteAlertRule deletes a rule and resolves its open alerts, which nothing
+// would evaluate any more.
 func (s *Store) DeleteAlertRule(id int64) error {
+	if _, err := s.db.Exec(`UPDATE alerts SET resolved=1, resolved_at=? WHERE rule_id=? AND resolved=0`, time.Now().UTC(), id); err != nil {
+		return err
+	}
 	_, err := s.db.Exec(`DELETE FROM alert_rules WHERE id=?`, id)
 	return err
 }
 
 // ---- Alerts ----
 
-func (s *Store) CreateAlert(ruleID int64, agentID, message string) error {
-	_, err := s.db.Exec(`INSERT INTO alerts (rule_id, agent_id, message) VALUES (?, ?, ?)`,
-		ruleID, agentID, message)
-	return err
+// OpenAlert raises an alert for a rule on an agent unless one is already
+// open. It returns the new alert, or nil if one was open.
+func (s *Store) OpenAlert(ruleID int64, agentID, message string) (*models.Alert, error) {
+	var id int64
+	err := s.db.QueryRow(`INSERT INTO alerts (rule_id, agent_id, message) VALUES (?, ?, ?)
+		ON CONFLICT(rule_id, agent_id) WHERE resolved=0 DO NOTHING RETURNING id`,
+		ruleID, agentID, message).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAlert(id)
+}
+
+// ResolveOpenAlert resolves the open alert of a rule on an agent. It returns
+// the resolved alert, or nil if none was open.
+func (s *Store) ResolveOpenAlert(ruleID int64, agentID string) (*models.Alert, error) {
+	var id int64
+	err := s.db.QueryRow(`UPDATE alerts SET resolved=1, resolved_at=? WHERE rule_id=? AND agent_id=? AND resolved=0 RETURNING id`,
+		time.Now().UTC(), ruleID, agentID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetAlert(id)
+}
+
+const alertColumns = `a.id, a.rule_id, a.agent_id, COALESCE(r.type, ''), COALESCE(r.severity, ''), COALESCE(r.metric, ''), a.message, a.resolved, a.created_at, a.resolved_at, a.resolved_by,
+	a.acknowledged_by, a.acknowledged_at, a.ack_comment, a.maintenance`
+
+func scanAlert(row interface{ Scan(...any) error }, a *models.Alert) error {
+	var resolvedAt, acknowledgedAt sql.NullTime
+	if err := row.Scan(&a.ID, &a.RuleID, &a.AgentID, &a.RuleType, &a.Severity, &a.Metric, &a.Message, &a.Resolved, &a.CreatedAt, &resolvedAt, &a.ResolvedBy,
+		&a.AcknowledgedBy, &acknowledgedAt, &a.AckComment, &a.Maintenance); err != nil {
+		return err
+	}
+	if resolvedAt.Valid {
+		a.ResolvedAt = &resolvedAt.Time
+	}
+	if acknowledgedAt.Valid {
+		a.AcknowledgedAt = &acknowledgedAt.Time
+	}
+	return nil
+}
+
+func (s *Store) GetAlert(id int64) (*models.Alert, error) {
+	var a models.Alert
+	err := scanAlert(s.db.QueryRow(`SELECT `+alertColumns+` FROM alerts a LEFT JOIN alert_rules r ON r.id = a.rule_id WHERE a.id=?`, id), &a)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
 }
 
 func (s *Store) ListAlerts(limit int) ([]models.Alert, error) {
-	rows, err := s.db.Query(`SELECT id, rule_id, agent_id, message, resolved, created_at FROM alerts ORDER BY created_at DESC LIMIT ?`, limit)
+	rows, err := s.db.Query(`SELECT `+alertColumns+`
+		FROM alerts a LEFT JOIN alert_rules r ON r.id = a.rule_id ORDER BY a.created_at DESC LIMIT ?`, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -241,7 +536,7 @@ func (s *Store) ListAlerts(limit int) ([]models.Alert, error) {
 	var alerts []models.Alert
 	for rows.Next() {
 		var a models.Alert
-		if err := rows.Scan(&a.ID, &a.RuleID, &a.AgentID, &a.Message, &a.Resolved, &a.CreatedAt); err != nil {
+		if err := scanAlert(rows, &a); err != nil {
 			return nil, err
 		}
 		alerts = append(alerts, a)
@@ -249,11 +544,83 @@ func (s *Store) ListAlerts(limit int) ([]models.Alert, error) {
 	return alerts, rows.Err()
 }
 
-func (s *Store) ResolveAlert(id int64) error {
-	_, err := s.db.Exec(`UPDATE alerts SET resolved=1 WHERE id=?`, id)
+// ResolveAlert resolves an open alert by hand. It returns false if the alert
+// doesn't exist or was resolved already.
+func (s *Store) ResolveAlert(id int64, username string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE alerts SET resolved=1, resolved_at=?, resolved_by=? WHERE id=? AND resolved=0`, time.Now().UTC(), username, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// MarkAlertMaintenance records that an alert was raised during a
+// maintenance window.
+func (s *Store) MarkAlertMaintenance(id int64) error {
+	_, err := s.db.Exec(`UPDATE alerts SET maintenance=1 WHERE id=?`, id)
 	return err
 }
 
+// OpenMaintenanceAlert returns the open alert of a rule on an agent if it
+// was raised during a maintenance window, or nil.
+func (s *Store) OpenMaintenanceAlert(ruleID int64, agentID string) (*models.Alert, error) {
+	var a models.Alert
+	err := scanAlert(s.db.QueryRow(`SELECT `+alertColumns+` FROM alerts a LEFT JOIN alert_rules r ON r.id = a.rule_id
+		WHERE a.rule_id=? AND a.agent_id=? AND a.resolved=0 AND a.maintenance=1`, ruleID, agentID), &a)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ClearAlertMaintenance unmarks an open alert raised during a maintenance
+// window, so it is notified like any other. It returns false if the alert
+// is resolved or was not marked.
+func (s *Store) ClearAlertMaintenance(id int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE alerts SET maintenance=0 WHERE id=? AND resolved=0 AND maintenance=1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AcknowledgeAlert records that a user is looking into an open alert. A
+// second acknowledgement replaces the first. It returns false if the alert
+// doesn't exist or is resolved.
+func (s *Store) AcknowledgeAlert(id int64, username, comment string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE alerts SET acknowledged_by=?, acknowledged_at=?, ack_comment=? WHERE id=? AND resolved=0`,
+		username, time.Now().UTC(), comment, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CountActiveAlerts returns the number of unresolved alerts.
+func (s *Store) CountActiveAlerts() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM alerts WHERE resolved=0`).Scan(&n)
+	return n, err
+}
+
+// PruneAlerts deletes alerts resolved before cutoff. Open alerts are kept
+// however old they are; deleting one would raise it again, notifications
+// included, on the next check.
+func (s *Store) PruneAlerts(before time.Time) (int64, error) {
+	// Alerts resolved before resolved_at was recorded have none
+	res, err := s.db.Exec(`DELETE FROM alerts WHERE resolved=1 AND COALESCE(resolved_at, created_at) < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 // ---- Users ----
 
 func (s *Store) HasUsers() (bool, error) {
@@ -262,45 +629,262 @@ func (s *Store) HasUsers() (bool, error) {
 	return count > 0, err
 }
 
-func (s *Store) CreateUser(username, passwordHash string) error {
-	_, err := s.db.Exec(`INSERT INTO users (username, password_hash) VALUES (?, ?)`, username, passwordHash)
+func (s *Store) CreateUser(username, passwordHash string, role models.Role) error {
+	_, err := s.db.Exec(`INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)`, username, passwordHash, role)
 	return err
 }
 
-func (s *Store) GetUserByUsername(username string) (*models.User, error) {
+const userColumns = `u.id, u.username, u.password_hash, u.role, u.disabled, u.totp_secret, u.totp_enabled, u.totp_last_step, u.failed_logins, u.locked_until, u.oidc_issuer, u.oidc_subject, u.created_at`
+
+func scanUser(row interface{ Scan(...any) error }) (*models.User, error) {
 	var u models.User
-	err := s.db.QueryRow(`SELECT id, username, password_hash, created_at FROM users WHERE username=?`, username).
-		Scan(&u.ID, &u.Username, &u.PasswordHash, &u.CreatedAt)
+	var lockedUntil sql.NullTime
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.Disabled, &u.TOTPSecret, &u.TOTPEnabled, &u.TOTPLastStep, &u.FailedLogins, &lockedUntil, &u.OIDCIssuer, &u.OIDCSubject, &u.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
+	if lockedUntil.Valid {
+		u.LockedUntil = &lockedUntil.Time
+	}
 	return &u, nil
 }
 
-// ---- Sessions ----
+func (s *Store) GetUserByUsername(username string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users u WHERE u.username=?`, username))
+}
+
+// GetUserByOIDC returns the user provisioned for an identity provider subject.
+func (s *Store) GetUserByOIDC(issuer, subject string) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users u WHERE u.oidc_issuer=? AND u.oidc_subject=?`, issuer, subject))
+}
+
+// CreateOIDCUser provisions a user on first single sign-on. The user has no
+// password, so local login stays closed until an admin sets one.
+func (s *Store) CreateOIDCUser(username string, role models.Role, issuer, subject string) (*models.User, error) {
+	res, err := s.db.Exec(`INSERT INTO users (username, password_hash, role, oidc_issuer, oidc_subject) VALUES (?, '', ?, ?, ?)`,
+		username, role, issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return s.GetUser(id)
+}
+
+func (s *Store) GetUser(id int64) (*models.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users u WHERE u.id=?`, id))
+}
+
+func (s *Store) ListUsers() ([]models.User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users u ORDER BY u.username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+func (s *Store) UpdateUserRole(id int64, role models.Role) error {
+	_, err := s.db.Exec(`UPDATE users SET role=? WHERE id=?`, role, id)
+	return err
+}
+
+// SetUserDisabled enables or disables a user; disabling also ends the
+// user's sessions.
+func (s *Store) SetUserDisabled(id int64, disabled bool) error {
+	if _, err := s.db.Exec(`UPDATE users SET disabled=? WHERE id=?`, disabled, id); err != nil {
+		return err
+	}
+	if disabled {
+		return s.DeleteUserSessions(id)
+	}
+	return nil
+}
+
+func (s *Store) UpdateUserPassword(id int64, passwordHash string) error {
+	_, err := s.db.Exec(`UPDATE users SET password_hash=? WHERE id=?`, passwordHash, id)
+	return err
+}
+
+func (s *Store) DeleteUser(id int64) error {
+	if err := s.DeleteUserSessions(id); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`DELETE FROM api_tokens WHERE user_id=?`, id); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`DELETE FROM recovery_codes WHERE user_id=?`, id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM users WHERE id=?`, id)
+	return err
+}
+
+// CountActiveAdmins counts enabled admins, so the last one cannot be removed.
+func (s *Store) CountActiveAdmins() (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM users WHERE role=? AND disabled=0`, models.RoleAdmin).Scan(&count)
+	return count, err
+}
+
+// RecordLoginFailure counts a failed login. Once threshold consecutive
+// failures are reached the account is locked for lockFor and the count starts
+// over; the lock expiry is returned in that case.
+func (s *Store) RecordLoginFailure(id int64, threshold int, lockFor time.Duration) (*time.Time, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	if err := tx.QueryRow(`UPDATE users SET failed_logins=failed_logins+1 WHERE id=? RETURNING failed_logins`, id).Scan(&failures); err != nil {
+		return nil, err
+	}
+	if threshold <= 0 || failures < threshold {
+		return nil, tx.Commit()
+	}
+
+	until := time.Now().UTC().Add(lockFor)
+	if _, err := tx.Exec(`UPDATE users SET failed_logins=0, locked_until=? WHERE id=?`, until, id); err != nil {
+		return nil, err
+	}
+	return &until, tx.Commit()
+}
+
+// ResetLoginFailures clears the failure count after a successful login.
+func (s *Store) ResetLoginFailures(id int64) error {
+	_, err := s.db.Exec(`UPDATE users SET failed_logins=0 WHERE id=? AND failed_logins != 0`, id)
+	return err
+}
 
-func (s *Store) CreateSession(token string, userID int64, expiresAt time.Time) error {
-	_, err := s.db.Exec(`INSERT INTO sessions (token, user_id, expires_at) VALUES (?, ?, ?)`, token, userID, expiresAt)
+// UnlockUser lifts a lockout before it expires.
+func (s *Store) UnlockUser(id int64) error {
+	_, err := s.db.Exec(`UPDATE users SET failed_logins=0, locked_until=NULL WHERE id=?`, id)
 	return err
 }
 
+// ---- Sessions ----
+
+func (s *Store) CreateSession(sess *models.Session) error {
+	now := time.Now().UTC()
+	res, err := s.db.Exec(`INSERT INTO sessions (token, user_id, expires_at, csrf_token, ip, user_agent, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sess.Token, sess.UserID, sess.ExpiresAt, sess.CSRFToken, sess.IP, sess.UserAgent, now, now)
+	if err != nil {
+		return err
+	}
+	sess.ID, _ = res.LastInsertId()
+	sess.CreatedAt = now
+	sess.LastSeenAt = now
+	return nil
+}
+
+// GetUserBySession returns the user of a valid session; disabled users have none.
 func (s *Store) GetUserBySession(token string) (*models.User, error) {
-	var u models.User
-	err := s.db.QueryRow(`
-		SELECT u.id, u.username, u.password_hash, u.created_at
-		FROM users u JOIN sessions s ON u.id = s.user_id
-		WHERE s.token=? AND s.expires_at > ?
-	`, token, time.Now().UTC()).Scan(&u.ID, &u.Username, &u.PasswordHash, &u.CreatedAt)
+	_, user, err := s.GetSession(token, 0)
+	return user, err
+}
+
+const sessionColumns = `rowid, token, user_id, expires_at, csrf_token, ip, user_agent, created_at, last_seen_at`
+
+func scanSession(row interface{ Scan(...any) error }) (*models.Session, error) {
+	var sess models.Session
+	var createdAt, lastSeenAt sql.NullTime
+	err := row.Scan(&sess.ID, &sess.Token, &sess.UserID, &sess.ExpiresAt, &sess.CSRFToken, &sess.IP, &sess.UserAgent, &createdAt, &lastSeenAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &u, nil
+	sess.CreatedAt = createdAt.Time
+	sess.LastSeenAt = lastSeenAt.Time
+	if !lastSeenAt.Valid {
+		sess.LastSeenAt = sess.CreatedAt
+	}
+	return &sess, nil
+}
+
+// sessionTouchInterval limits how often activity is written for a session.
+const sessionTouchInterval = time.Minute
+
+// GetSession returns a valid session and its user, and records the activity.
+// With idle > 0, a session unused for longer than idle is deleted instead.
+// Disabled users have no sessions.
+func (s *Store) GetSession(token string, idle time.Duration) (*models.Session, *models.User, error) {
+	sess, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE token=? AND expires_at > ? AND csrf_token != ''`,
+		token, time.Now().UTC()))
+	if err != nil || sess == nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	if idle > 0 && now.Sub(sess.LastSeenAt) > idle {
+		return nil, nil, s.DeleteSession(token)
+	}
+	user, err := s.GetUser(sess.UserID)
+	if err != nil || user == nil || user.Disabled {
+		return nil, nil, err
+	}
+
+	if now.Sub(sess.LastSeenAt) > sessionTouchInterval {
+		if _, err := s.db.Exec(`UPDATE sessions SET last_seen_at=? WHERE rowid=?`, now, sess.ID); err != nil {
+			return nil, nil, err
+		}
+		sess.LastSeenAt = now
+	}
+	return sess, user, nil
+}
+
+// ListUserSessions returns a user's unexpired sessions, most recently used first.
+func (s *Store) ListUserSessions(userID int64) ([]models.Session, error) {
+	rows, err := s.db.Query(`SELECT `+sessionColumns+` FROM sessions WHERE user_id=? AND expires_at > ? AND csrf_token != ''
+		ORDER BY last_seen_at DESC`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *sess)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSessionByID revokes one of the user's sessions. It reports false if
+// the user has no such session.
+func (s *Store) DeleteSessionByID(userID, id int64) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE rowid=? AND user_id=?`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// DeleteOtherSessions revokes all of the user's sessions except keepID.
+func (s *Store) DeleteOtherSessions(userID, keepID int64) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE user_id=? AND rowid != ?`, userID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
 func (s *Store) DeleteSession(token string) error {
@@ -308,11 +892,26 @@ func (s *Store) DeleteSession(token string) error {
 	return err
 }
 
-func (s *Store) CleanExpiredSessions() error {
-	_, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now().UTC())
+func (s *Store) DeleteUserSessions(userID int64) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE user_id=?`, userID)
 	return err
 }
 
+// CleanExpiredSessions deletes sessions that can no longer be used: expired,
+// unused for longer than idle (if idle > 0) or from before CSRF tokens.
+func (s *Store) CleanExpiredSessions(idle time.Duration) (int64, error) {
+	now := time.Now().UTC()
+	idleCutoff := time.Time{}
+	if idle > 0 {
+		idleCutoff = now.Add(-idle)
+	}
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at < ? OR csrf_token = '' OR last_seen_at < ?`, now, idleCutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 // ---- File Transfers ----
 
 func (s *Store) CreateFileTransfer(agentID, fileName string, fileSize int64, direction models.TransferDirection, storagePath, remotePath string) (*models.FileTransfer, error) {
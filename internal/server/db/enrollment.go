@@ -0,0 +1,246 @@
+package db
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+var (
+	ErrInvalidEnrollmentToken = errors.New("invalid or expired enrollment token")
+	ErrAgentAlreadyEnrolled   = errors.New("agent already enrolled")
+)
+
+// hashToken hashes high-entropy tokens and secrets for storage. They are
+// random 256-bit values, so a fast hash is sufficient (unlike passwords).
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ---- Enrollment Tokens ----
+
+func (s *Store) CreateEnrollmentToken(token, description string, maxUses int, expiresAt *time.Time, createdBy string) (*models.EnrollmentToken, error) {
+	res, err := s.db.Exec(`INSERT INTO enrollment_tokens (token_hash, description, max_uses, expires_at, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		hashToken(token), description, maxUses, expiresAt, createdBy, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return &models.EnrollmentToken{
+		ID:          id,
+		Description: description,
+		MaxUses:     maxUses,
+		ExpiresAt:   expiresAt,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// EnsureEnrollmentToken registers a static multi-use token (e.g. from a flag)
+// if it is not already known.
+func (s *Store) EnsureEnrollmentToken(token, description string) error {
+	_, err := s.db.Exec(`INSERT INTO enrollment_tokens (token_hash, description, max_uses, created_by, created_at) VALUES (?, ?, 0, 'system', ?)
+		ON CONFLICT(token_hash) DO NOTHING`, hashToken(token), description, time.Now().UTC())
+	return err
+}
+
+func (s *Store) ListEnrollmentTokens() ([]models.EnrollmentToken, error) {
+	rows, err := s.db.Query(`SELECT id, description, max_uses, uses, expires_at, revoked, created_by, created_at FROM enrollment_tokens ORDER BY created_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.EnrollmentToken
+	for rows.Next() {
+		var t models.EnrollmentToken
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Description, &t.MaxUses, &t.Uses, &expiresAt, &t.Revoked, &t.CreatedBy, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			t.ExpiresAt = &expiresAt.Time
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// ValidEnrollmentToken reports whether token can currently be used to enroll.
+func (s *Store) ValidEnrollmentToken(token string) (bool, error) {
+	t, err := getEnrollmentToken(s.db.QueryRow, token)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.Usable(), nil
+}
+
+func (s *Store) RevokeEnrollmentToken(id int64) error {
+	_, err := s.db.Exec(`UPDATE enrollment_tokens SET revoked=1 WHERE id=?`, id)
+	return err
+}
+
+func getEnrollmentToken(queryRow func(string, ...any) *sql.Row, token string) (*models.EnrollmentToken, error) {
+	var t models.EnrollmentToken
+	var expiresAt sql.NullTime
+	err := queryRow(`SELECT id, max_uses, uses, expires_at, revoked FROM enrollment_tokens WHERE token_hash=?`, hashToken(token)).
+		Scan(&t.ID, &t.MaxUses, &t.Uses, &expiresAt, &t.Revoked)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	return &t, nil
+}
+
+// ---- Agent Credentials ----
+
+// EnrollAgent consumes one use of an enrollment token and stores the hashed
+// secret for agentID. An agent that already holds an active secret cannot be
+// re-enrolled until its credential is revoked.
+func (s *Store) EnrollAgent(token, agentID, displayName, secret string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t, err := getEnrollmentToken(tx.QueryRow, token)
+	if err == sql.ErrNoRows {
+		return ErrInvalidEnrollmentToken
+	}
+	if err != nil {
+		return err
+	}
+	if !t.Usable() {
+		return ErrInvalidEnrollmentToken
+	}
+
+	var existing string
+	err = tx.QueryRow(`SELECT secret_hash FROM agents WHERE id=?`, agentID).Scan(&existing)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if existing != "" {
+		return ErrAgentAlreadyEnrolled
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO agents (id, display_name, hostname, secret_hash, secret_revoked)
+		VALUES (?, ?, '', ?, 0)
+		ON CONFLICT(id) DO UPDATE SET
+			secret_hash=excluded.secret_hash,
+			pending_secret_hash='',
+			secret_revoked=0,
+			cert_serial='',
+			cert_revoked=0
+	`, agentID, displayName, hashToken(secret)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE enrollment_tokens SET uses=uses+1 WHERE id=?`, t.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AuthenticateAgent reports whether secret is the active secret of agentID.
+// Unknown, never-enrolled and revoked agents are rejected. While a rotation
+// is pending both the old and the new secret are accepted; the first use of
+// the new one makes it the active secret.
+func (s *Store) AuthenticateAgent(agentID, secret string) (bool, error) {
+	var stored, pending string
+	err := s.db.QueryRow(`SELECT secret_hash, pending_secret_hash FROM agents WHERE id=?`, agentID).Scan(&stored, &pending)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if stored == "" {
+		return false, nil
+	}
+	hash := hashToken(secret)
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+		return true, nil
+	}
+	if pending == "" || subtle.ConstantTimeCompare([]byte(pending), []byte(hash)) != 1 {
+		return false, nil
+	}
+	if _, err := s.db.Exec(`UPDATE agents SET secret_hash=pending_secret_hash, pending_secret_hash='' WHERE id=? AND pending_secret_hash=?`, agentID, pending); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetAgentSecret replaces the agent's secret and drops any pending one.
+func (s *Store) SetAgentSecret(agentID, secret string) error {
+	_, err := s.db.Exec(`UPDATE agents SET secret_hash=?, pending_secret_hash='', secret_revoked=0 WHERE id=?`, hashToken(secret), agentID)
+	return err
+}
+
+// SetPendingAgentSecret starts a credential rotation: secret is accepted next
+// to the active one until the agent first authenticates with it, so an agent
+// that never got or never stored it is not locked out.
+func (s *Store) SetPendingAgentSecret(agentID, secret string) error {
+	_, err := s.db.Exec(`UPDATE agents SET pending_secret_hash=? WHERE id=? AND secret_hash != ''`, hashToken(secret), agentID)
+	return err
+}
+
+// ClearPendingAgentSecret abandons a rotation to secret, e.g. because the
+// agent could not store it. A newer pending secret is left alone.
+func (s *Store) ClearPendingAgentSecret(agentID, secret string) error {
+	_, err := s.db.Exec(`UPDATE agents SET pending_secret_hash='' WHERE id=? AND pending_secret_hash=?`, agentID, hashToken(secret))
+	return err
+}
+
+// RevokeAgentCredential revokes the agent's secret together with its client
+// certificate; the agent has to be enrolled again.
+func (s *Store) RevokeAgentCredential(agentID string) error {
+	_, err := s.db.Exec(`UPDATE agents SET secret_hash='', pending_secret_hash='', secret_revoked=1, cert_serial='' WHERE id=?`, agentID)
+	return err
+}
+
+// ---- Agent Certificates ----
+
+// SetAgentCertificate records serial as the agent's only valid client certificate.
+func (s *Store) SetAgentCertificate(agentID, serial string) error {
+	_, err := s.db.Exec(`UPDATE agents SET cert_serial=?, cert_revoked=0 WHERE id=?`, serial, agentID)
+	return err
+}
+
+// AgentCertificateValid reports whether serial is the active, unrevoked
+// client certificate of agentID.
+func (s *Store) AgentCertificateValid(agentID, serial string) (bool, error) {
+	var stored string
+	var revoked bool
+	err := s.db.QueryRow(`SELECT cert_serial, cert_revoked FROM agents WHERE id=?`, agentID).Scan(&stored, &revoked)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !revoked && stored != "" && stored == serial, nil
+}
+
+// RevokeAgentCertificate invalidates the agent's client certificate. The agent
+// cannot obtain a new one until AllowAgentCertificateReissue is called.
+func (s *Store) RevokeAgentCertificate(agentID string) error {
+	_, err := s.db.Exec(`UPDATE agents SET cert_serial='', cert_revoked=1 WHERE id=?`, agentID)
+	return err
+}
+
+func (s *Store) AllowAgentCertificateReissue(agentID string) error {
+	_, err := s.db.Exec(`UPDATE agents SET cert_revoked=0 WHERE id=?`, agentID)
+	return err
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEnrollAgentOneTimeToken(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	if _, err := store.CreateEnrollmentToken("tok-1", "one-time", 1, nil, "admin"); err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	if err := store.EnrollAgent("tok-1", "agent-1", "Agent 1", "secret-1"); err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+
+	// Token is used up
+	err := store.EnrollAgent("tok-1", "agent-2", "", "secret-2")
+	if !errors.Is(err, ErrInvalidEnrollmentToken) {
+		t.Errorf("expected ErrInvalidEnrollmentToken on reuse, got %v", err)
+	}
+
+	ok, err := store.AuthenticateAgent("agent-1", "secret-1")
+	if err != nil || !ok {
+		t.Errorf("expected agent-1 to authenticate, got ok=%v err=%v", ok, err)
+	}
+	ok, _ = store.AuthenticateAgent("agent-1", "wrong")
+	if ok {
+		t.Error("expected wrong secret to be rejected")
+	}
+	ok, _ = store.AuthenticateAgent("unknown", "secret-1")
+	if ok {
+		t.Error("expected unknown agent to be rejected")
+	}
+
+	agent, err := store.GetAgent("agent-1")
+	if err != nil || agent == nil {
+		t.Fatalf("get agent: %v", err)
+	}
+	if !agent.Enrolled || agent.Revoked {
+		t.Errorf("expected enrolled, non-revoked agent, got %+v", agent)
+	}
+	if !agent.LastHeartbeat.IsZero() {
+		t.Errorf("expected zero LastHeartbeat before first heartbeat, got %v", agent.LastHeartbeat)
+	}
+}
+
+func TestEnrollAgentMultiUseAndExpiry(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.CreateEnrollmentToken("multi", "fleet", 0, nil, "admin")
+	for _, id := range []string{"a", "b", "c"} {
+		if err := store.EnrollAgent("multi", id, "", "s-"+id); err != nil {
+			t.Fatalf("enroll %s: %v", id, err)
+		}
+	}
+
+	// An enrolled agent cannot be taken over by enrolling again
+	if err := store.EnrollAgent("multi", "a", "", "other"); !errors.Is(err, ErrAgentAlreadyEnrolled) {
+		t.Errorf("expected ErrAgentAlreadyEnrolled, got %v", err)
+	}
+
+	past := time.Now().UTC().Add(-time.Hour)
+	store.CreateEnrollmentToken("expired", "old", 0, &past, "admin")
+	if err := store.EnrollAgent("expired", "d", "", "s-d"); !errors.Is(err, ErrInvalidEnrollmentToken) {
+		t.Errorf("expected expired token to be rejected, got %v", err)
+	}
+
+	tokens, err := store.ListEnrollmentTokens()
+	if err != nil {
+		t.Fatalf("list tokens: %v", err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("expected 2 tokens, got %d", len(tokens))
+	}
+}
+
+func TestRevokeAndRotateAgentCredential(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.CreateEnrollmentToken("tok", "", 0, nil, "admin")
+	store.EnrollAgent("tok", "agent-1", "", "old")
+
+	if err := store.SetAgentSecret("agent-1", "new"); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "old"); ok {
+		t.Error("expected old secret to be rejected after rotation")
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "new"); !ok {
+		t.Error("expected new secret to authenticate")
+	}
+
+	// A rotation the agent never acknowledged: both secrets work until the
+	// new one is used
+	if err := store.SetPendingAgentSecret("agent-1", "newer"); err != nil {
+		t.Fatalf("set pending: %v", err)
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "new"); !ok {
+		t.Error("expected current secret to authenticate while rotation is pending")
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "newer"); !ok {
+		t.Error("expected pending secret to authenticate")
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "new"); ok {
+		t.Error("expected previous secret to be rejected once the pending one was used")
+	}
+
+	// A rotation the agent failed to store is abandoned
+	store.SetPendingAgentSecret("agent-1", "failed")
+	if err := store.ClearPendingAgentSecret("agent-1", "failed"); err != nil {
+		t.Fatalf("clear pending: %v", err)
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "failed"); ok {
+		t.Error("expected abandoned pending secret to be rejected")
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "newer"); !ok {
+		t.Error("expected current secret to stay valid")
+	}
+
+	store.SetPendingAgentSecret("agent-1", "unused")
+	if err := store.RevokeAgentCredential("agent-1"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "unused"); ok {
+		t.Error("expected pending secret to be revoked too")
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "newer"); ok {
+		t.Error("expected revoked agent to be rejected")
+	}
+	agent, _ := store.GetAgent("agent-1")
+	if agent.Enrolled || !agent.Revoked {
+		t.Errorf("expected revoked credential state, got %+v", agent)
+	}
+
+	// A revoked agent can be re-enrolled with a valid token
+	if err := store.EnrollAgent("tok", "agent-1", "", "fresh"); err != nil {
+		t.Fatalf("re-enroll: %v", err)
+	}
+	if ok, _ := store.AuthenticateAgent("agent-1", "fresh"); !ok {
+		t.Error("expected re-enrolled agent to authenticate")
+	}
+}
+
+func TestAgentCertificateRevocation(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.CreateEnrollmentToken("tok", "", 0, nil, "admin")
+	store.EnrollAgent("tok", "agent-1", "", "secret")
+
+	if ok, _ := store.AgentCertificateValid("agent-1", ""); ok {
+		t.Error("expected empty serial to be rejected")
+	}
+	store.SetAgentCertificate("agent-1", "abc")
+	if ok, _ := store.AgentCertificateValid("agent-1", "abc"); !ok {
+		t.Error("expected issued certificate to be valid")
+	}
+	if ok, _ := store.AgentCertificateValid("agent-2", "abc"); ok {
+		t.Error("expected certificate to be bound to its agent")
+	}
+
+	store.SetAgentCertificate("agent-1", "def")
+	if ok, _ := store.AgentCertificateValid("agent-1", "abc"); ok {
+		t.Error("expected superseded certificate to be rejected")
+	}
+
+	if err := store.RevokeAgentCertificate("agent-1"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if ok, _ := store.AgentCertificateValid("agent-1", "def"); ok {
+		t.Error("expected revoked certificate to be rejected")
+	}
+	agent, _ := store.GetAgent("agent-1")
+	if !agent.CertRevoked || agent.CertSerial != "" {
+		t.Errorf("expected revoked certificate state, got %+v", agent)
+	}
+
+	store.AllowAgentCertificateReissue("agent-1")
+	agent, _ = store.GetAgent("agent-1")
+	if agent.CertRevoked {
+		t.Error("expected reissue to be allowed")
+	}
+}
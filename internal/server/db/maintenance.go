@@ -0,0 +1,112 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ---- Maintenance windows ----
+
+const maintenanceColumns = `id, name, schedule, timezone, starts_at, duration_seconds, agent_ids, tags, comment, created_by, created_at`
+
+func scanMaintenanceWindow(row interface{ Scan(...any) error }, w *models.MaintenanceWindow) error {
+	var startsAt sql.NullTime
+	var agentIDs, tags string
+	if err := row.Scan(&w.ID, &w.Name, &w.Schedule, &w.Timezone, &startsAt, &w.DurationSeconds, &agentIDs, &tags, &w.Comment, &w.CreatedBy, &w.CreatedAt); err != nil {
+		return err
+	}
+	if startsAt.Valid {
+		w.StartsAt = &startsAt.Time
+	}
+	if err := json.Unmarshal([]byte(agentIDs), &w.AgentIDs); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(tags), &w.Tags)
+}
+
+func (s *Store) CreateMaintenanceWindow(w models.MaintenanceWindow) (*models.MaintenanceWindow, error) {
+	if w.AgentIDs == nil {
+		w.AgentIDs = []string{}
+	}
+	if w.Tags == nil {
+		w.Tags = []string{}
+	}
+	agentIDs, err := json.Marshal(w.AgentIDs)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := json.Marshal(w.Tags)
+	if err != nil {
+		return nil, err
+	}
+	var startsAt any
+	if w.StartsAt != nil {
+		startsAt = w.StartsAt.UTC()
+	}
+	res, err := s.db.Exec(`INSERT INTO maintenance_windows (name, schedule, timezone, starts_at, duration_seconds, agent_ids, tags, comment, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		w.Name, w.Schedule, w.Timezone, startsAt, w.DurationSeconds, string(agentIDs), string(tags), w.Comment, w.CreatedBy, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return s.GetMaintenanceWindow(id)
+}
+
+func (s *Store) GetMaintenanceWindow(id int64) (*models.MaintenanceWindow, error) {
+	var w models.MaintenanceWindow
+	err := scanMaintenanceWindow(s.db.QueryRow(`SELECT `+maintenanceColumns+` FROM maintenance_windows WHERE id=?`, id), &w)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *Store) ListMaintenanceWindows() ([]models.MaintenanceWindow, error) {
+	rows, err := s.db.Query(`SELECT ` + maintenanceColumns + ` FROM maintenance_windows ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var windows []models.MaintenanceWindow
+	for rows.Next() {
+		var w models.MaintenanceWindow
+		if err := scanMaintenanceWindow(rows, &w); err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+func (s *Store) DeleteMaintenanceWindow(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM maintenance_windows WHERE id=?`, id)
+	return err
+}
+
+// PruneMaintenanceWindows deletes one-off windows that ended before cutoff.
+// Recurring windows are kept.
+func (s *Store) PruneMaintenanceWindows(before time.Time) (int64, error) {
+	windows, err := s.ListMaintenanceWindows()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, w := range windows {
+		if !w.Ended(before) {
+			continue
+		}
+		if err := s.DeleteMaintenanceWindow(w.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestMaintenanceWindows(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	weekly, err := store.CreateMaintenanceWindow(models.MaintenanceWindow{
+		Name: "patch night", Schedule: "0 2 * * 6", Timezone: "UTC", DurationSeconds: 4 * 3600,
+		Tags: []string{"patch"}, CreatedBy: "alice",
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if weekly.StartsAt != nil || !slices.Equal(weekly.Tags, []string{"patch"}) || len(weekly.AgentIDs) != 0 || weekly.CreatedBy != "alice" {
+		t.Errorf("unexpected window %+v", weekly)
+	}
+
+	start := time.Now().UTC().Add(-2 * time.Hour)
+	once, _ := store.CreateMaintenanceWindow(models.MaintenanceWindow{Name: "reboot", StartsAt: &start, DurationSeconds: 3600, AgentIDs: []string{"a1"}})
+	if once.StartsAt == nil || !once.StartsAt.Equal(start) || !slices.Equal(once.AgentIDs, []string{"a1"}) {
+		t.Errorf("unexpected window %+v", once)
+	}
+
+	list, err := store.ListMaintenanceWindows()
+	if err != nil || len(list) != 2 || list[0].ID != weekly.ID {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+
+	// Only the one-off window is over
+	if n, err := store.PruneMaintenanceWindows(time.Now()); err != nil || n != 1 {
+		t.Errorf("prune = %d, %v", n, err)
+	}
+	if w, _ := store.GetMaintenanceWindow(once.ID); w != nil {
+		t.Errorf("one-off window not pruned: %+v", w)
+	}
+}
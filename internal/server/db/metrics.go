@@ -0,0 +1,432 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ---- Metrics ----
+
+// labelsKey encodes labels the way metric_series.labels stores them: a JSON
+// object with sorted keys, "{}" when there are none.
+func labelsKey(l models.Labels) string {
+	if len(l) == 0 {
+		return "{}"
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(map[string]string(l))
+	return strings.TrimSpace(buf.String())
+}
+
+func parseLabels(key string) models.Labels {
+	var l models.Labels
+	json.Unmarshal([]byte(key), &l)
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// InsertSamples stores one heartbeat's samples under a shared timestamp,
+// creating series on first sight.
+func (s *Store) InsertSamples(agentID string, samples []models.Sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert, err := tx.Prepare(`INSERT INTO metric_series (agent_id, name, labels, last_value, last_timestamp) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(agent_id, name, labels) DO UPDATE SET last_value=excluded.last_value, last_timestamp=excluded.last_timestamp
+		RETURNING id`)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	insert, err := tx.Prepare(`INSERT INTO metric_points (series_id, timestamp, value) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	now := time.Now().UTC()
+	for _, sm := range samples {
+		var seriesID int64
+		if err := upsert.QueryRow(agentID, sm.Name, labelsKey(sm.Labels), sm.Value, now).Scan(&seriesID); err != nil {
+			return err
+		}
+		if _, err := insert.Exec(seriesID, now, sm.Value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetLatestSamples returns the samples of the agent's latest heartbeat, by
+// name and labels. Series the agent stopped reporting (an unmounted disk)
+// are left out.
+func (s *Store) GetLatestSamples(agentID string) ([]models.Sample, error) {
+	rows, err := s.db.Query(`SELECT name, labels, last_value, last_timestamp FROM metric_series
+		WHERE agent_id=? AND last_timestamp=(SELECT MAX(last_timestamp) FROM metric_series WHERE agent_id=?)
+		ORDER BY name, labels`, agentID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []models.Sample
+	for rows.Next() {
+		var sm models.Sample
+		var labels string
+		if err := rows.Scan(&sm.Name, &labels, &sm.Value, &sm.Timestamp); err != nil {
+			return nil, err
+		}
+		sm.Labels = parseLabels(labels)
+		samples = append(samples, sm)
+	}
+	return samples, rows.Err()
+}
+
+// GetLatestMetric returns the CPU, memory and root disk usage of the agent's
+// latest heartbeat, or nil.
+func (s *Store) GetLatestMetric(agentID string) (*models.Metric, error) {
+	samples, err := s.GetLatestSamples(agentID)
+	if err != nil {
+		return nil, err
+	}
+	return models.MetricFromSamples(agentID, samples), nil
+}
+
+// GetLatestMetrics returns the CPU, memory and root disk usage of the
+// agent's last limit heartbeats, newest first.
+func (s *Store) GetLatestMetrics(agentID string, limit int) ([]models.Metric, error) {
+	rows, err := s.db.Query(`SELECT s.name, p.timestamp, p.value FROM metric_points p
+		JOIN metric_series s ON s.id = p.series_id
+		WHERE s.agent_id=? AND s.labels='{}' AND s.name IN (?, ?, ?)
+		ORDER BY p.timestamp DESC LIMIT ?`,
+		agentID, models.MetricCPUPercent, models.MetricMemoryPercent, models.MetricDiskPercent, 3*limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []models.Metric
+	for rows.Next() {
+		var sm models.Sample
+		if err := rows.Scan(&sm.Name, &sm.Timestamp, &sm.Value); err != nil {
+			return nil, err
+		}
+		if n := len(metrics); n == 0 || !metrics[n-1].Timestamp.Equal(sm.Timestamp) {
+			metrics = append(metrics, models.Metric{AgentID: agentID, Timestamp: sm.Timestamp})
+		}
+		m := &metrics[len(metrics)-1]
+		switch sm.Name {
+		case models.MetricCPUPercent:
+			m.CPUPercent = sm.Value
+		case models.MetricMemoryPercent:
+			m.MemoryPercent = sm.Value
+		case models.MetricDiskPercent:
+			m.DiskPercent = sm.Value
+		}
+	}
+	if len(metrics) > limit {
+		metrics = metrics[:limit]
+	}
+	return metrics, rows.Err()
+}
+
+// GetFleetMetrics returns the CPU, memory and root disk usage of every
+// agent's latest heartbeat, by agent ID.
+func (s *Store) GetFleetMetrics() (map[string]*models.Metric, error) {
+	rows, err := s.db.Query(`SELECT agent_id, name, last_value, last_timestamp FROM metric_series
+		WHERE labels='{}' AND name IN (?, ?, ?)`,
+		models.MetricCPUPercent, models.MetricMemoryPercent, models.MetricDiskPercent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := make(map[string][]models.Sample)
+	for rows.Next() {
+		var agentID string
+		var sm models.Sample
+		if err := rows.Scan(&agentID, &sm.Name, &sm.Value, &sm.Timestamp); err != nil {
+			return nil, err
+		}
+		samples[agentID] = append(samples[agentID], sm)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]*models.Metric, len(samples))
+	for agentID, sms := range samples {
+		metrics[agentID] = models.MetricFromSamples(agentID, sms)
+	}
+	return metrics, nil
+}
+
+// QuerySeries returns the points of every series the selector picks, between
+// from and to (inclusive), oldest first, at the given resolution. Rolled-up
+// points are the buckets that start in the range or contain from. At most
+// maxSeries series are read; truncated reports whether more matched.
+func (s *Store) QuerySeries(sel *models.Selector, res models.Resolution, from, to time.Time, maxSeries int) (series []models.Series, truncated bool, err error) {
+	rows, err := s.db.Query(`SELECT id, agent_id, labels FROM metric_series WHERE name=? ORDER BY agent_id, labels`, sel.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var agentID, labels string
+		if err := rows.Scan(&id, &agentID, &labels); err != nil {
+			rows.Close()
+			return nil, false, err
+		}
+		l := parseLabels(labels)
+		if !sel.Matches(agentID, l) {
+			continue
+		}
+		if len(series) == maxSeries {
+			truncated = true
+			break
+		}
+		ids = append(ids, id)
+		series = append(series, models.Series{AgentID: agentID, Name: sel.Name, Labels: l, Points: []models.Point{}})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if err := s.fillPoints(series, ids, res, from, to); err != nil {
+		return nil, false, err
+	}
+	return series, truncated, nil
+}
+
+// QueryAgentSeries is QuerySeries for every series of one agent, or only
+// those of the given metric names, ordered by name and labels.
+func (s *Store) QueryAgentSeries(agentID string, names []string, res models.Resolution, from, to time.Time, maxSeries int) (series []models.Series, truncated bool, err error) {
+	query := `SELECT id, name, labels FROM metric_series WHERE agent_id=?`
+	args := []any{agentID}
+	if len(names) > 0 {
+		query += ` AND name IN (?` + strings.Repeat(`, ?`, len(names)-1) + `)`
+		for _, name := range names {
+			args = append(args, name)
+		}
+	}
+	rows, err := s.db.Query(query+` ORDER BY name, labels LIMIT ?`, append(args, maxSeries+1)...)
+	if err != nil {
+		return nil, false, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var name, labels string
+		if err := rows.Scan(&id, &name, &labels); err != nil {
+			rows.Close()
+			return nil, false, err
+		}
+		if len(series) == maxSeries {
+			truncated = true
+			break
+		}
+		ids = append(ids, id)
+		series = append(series, models.Series{AgentID: agentID, Name: name, Labels: parseLabels(labels), Points: []models.Point{}})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if err := s.fillPoints(series, ids, res, from, to); err != nil {
+		return nil, false, err
+	}
+	return series, truncated, nil
+}
+
+// fillPoints reads the points of series[i], stored as ids[i].
+func (s *Store) fillPoints(series []models.Series, ids []int64, res models.Resolution, from, to time.Time) error {
+	for i, id := range ids {
+		var points []models.Point
+		var err error
+		if res == models.ResolutionRaw {
+			points, err = s.seriesPoints(id, from, to)
+		} else {
+			points, err = s.seriesRollups(id, res, from, to)
+		}
+		if err != nil {
+			return err
+		}
+		series[i].Points = points
+	}
+	return nil
+}
+
+func (s *Store) seriesPoints(seriesID int64, from, to time.Time) ([]models.Point, error) {
+	rows, err := s.db.Query(`SELECT timestamp, value FROM metric_points WHERE series_id=? AND timestamp>=? AND timestamp<=? ORDER BY timestamp`,
+		seriesID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []models.Point{}
+	for rows.Next() {
+		var p models.Point
+		if err := rows.Scan(&p.Timestamp, &p.Value); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (s *Store) seriesRollups(seriesID int64, res models.Resolution, from, to time.Time) ([]models.Point, error) {
+	width := int64(res.Bucket() / time.Second)
+	rows, err := s.db.Query(`SELECT bucket, min_value, max_value, sum_value, count FROM `+rollupTable(res)+`
+		WHERE series_id=? AND bucket>=? AND bucket<=? ORDER BY bucket`,
+		seriesID, from.Unix()/width*width, to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []models.Point{}
+	for rows.Next() {
+		var bucket, count int64
+		var lo, hi, sum float64
+		if err := rows.Scan(&bucket, &lo, &hi, &sum, &count); err != nil {
+			return nil, err
+		}
+		points = append(points, models.Point{
+			Timestamp: time.Unix(bucket, 0).UTC(),
+			Value:     sum / float64(count),
+			Min:       &lo,
+			Max:       &hi,
+		})
+	}
+	return points, rows.Err()
+}
+
+// ListMetricNames returns every metric name stored for any agent.
+func (s *Store) ListMetricNames() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT name FROM metric_series ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// ---- Metric rollups and retention ----
+
+func rollupTable(res models.Resolution) string {
+	if res == models.Resolution1h {
+		return "metric_rollups_1h"
+	}
+	return "metric_rollups_5m"
+}
+
+// RollupMetrics aggregates the buckets that ended by until into res: 5m
+// from raw points, 1h from the 5m rollups (so roll up 5m first). It carries
+// on after the newest bucket already rolled up and returns the number of
+// buckets written.
+func (s *Store) RollupMetrics(res models.Resolution, until time.Time) (int64, error) {
+	width := int64(res.Bucket() / time.Second)
+	if width == 0 {
+		return 0, fmt.Errorf("cannot roll up into %q", res)
+	}
+	table := rollupTable(res)
+	end := until.Unix() / width * width
+
+	var last sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(bucket) FROM ` + table).Scan(&last); err != nil {
+		return 0, err
+	}
+	var start int64
+	if last.Valid {
+		start = last.Int64 + width
+	}
+	if start >= end {
+		return 0, nil
+	}
+
+	var source string
+	var args []any
+	if res == models.Resolution5m {
+		source = `SELECT series_id, CAST(strftime('%s', substr(timestamp, 1, 19)) AS INTEGER) / 300 * 300 AS b,
+			MIN(value), MAX(value), SUM(value), COUNT(*)
+			FROM metric_points WHERE timestamp >= ? AND timestamp < ? GROUP BY series_id, b`
+		args = []any{time.Unix(start, 0).UTC(), time.Unix(end, 0).UTC()}
+	} else {
+		source = `SELECT series_id, bucket / 3600 * 3600 AS b, MIN(min_value), MAX(max_value), SUM(sum_value), SUM(count)
+			FROM metric_rollups_5m WHERE bucket >= ? AND bucket < ? GROUP BY series_id, b`
+		args = []any{start, end}
+	}
+	result, err := s.db.Exec(`INSERT INTO `+table+` (series_id, bucket, min_value, max_value, sum_value, count) `+source+`
+		ON CONFLICT(series_id, bucket) DO UPDATE SET min_value=excluded.min_value, max_value=excluded.max_value,
+			sum_value=excluded.sum_value, count=excluded.count`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PruneMetrics deletes the points of res older than before.
+func (s *Store) PruneMetrics(res models.Resolution, before time.Time) (int64, error) {
+	var result sql.Result
+	var err error
+	if res == models.ResolutionRaw {
+		result, err = s.db.Exec(`DELETE FROM metric_points WHERE timestamp < ?`, before.UTC())
+	} else {
+		result, err = s.db.Exec(`DELETE FROM `+rollupTable(res)+` WHERE bucket < ?`, before.Unix())
+	}
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PruneMetricSeries deletes series without a sample since before, e.g. of
+// removed agents or disks, along with whatever points they have left.
+func (s *Store) PruneMetricSeries(before time.Time) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stale := `(SELECT id FROM metric_series WHERE last_timestamp < ?)`
+	for _, table := range []string{"metric_points", "metric_rollups_5m", "metric_rollups_1h"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE series_id IN `+stale, before.UTC()); err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM metric_series WHERE last_timestamp < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return n, tx.Commit()
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestSamples(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	if samples, err := store.GetLatestSamples("agent-1"); err != nil || samples != nil {
+		t.Fatalf("expected no samples, got %v, %v", samples, err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	first := &models.SystemMetrics{Disks: []models.DiskUsage{{Mount: "/mnt/usb", FSType: "vfat", UsedPercent: 10}}}
+	if err := store.InsertSamples("agent-1", append(models.Metric{CPUPercent: 5}.Samples(), first.Samples()...)); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	sys := &models.SystemMetrics{
+		CPUCores:      []float64{12.5, 80},
+		Load1:         0.5,
+		UptimeSeconds: 3600,
+		Disks: []models.DiskUsage{
+			{Mount: "/var", FSType: "xfs", TotalBytes: 1000, FreeBytes: 100, UsedPercent: 90, InodesUsedPercent: 4},
+			{Mount: "/", FSType: "ext4", TotalBytes: 2000, FreeBytes: 1500, UsedPercent: 25},
+		},
+		Interfaces: []models.NetInterface{{Name: "eth0", RxBytesPerSec: 1024, TxBytesPerSec: 512}},
+	}
+	latest := append(models.Metric{CPUPercent: 50, MemoryPercent: 60, DiskPercent: 25}.Samples(), sys.Samples()...)
+	if err := store.InsertSamples("agent-1", latest); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	store.InsertSamples("agent-2", []models.Sample{{Name: models.MetricDiskUsedPercent, Labels: models.Labels{"mount": "/var"}, Value: 99}})
+
+	// The unmounted /mnt/usb is not part of the latest heartbeat
+	samples, err := store.GetLatestSamples("agent-1")
+	if err != nil {
+		t.Fatalf("latest: %v", err)
+	}
+	if len(samples) != len(latest) {
+		t.Fatalf("expected %d samples of the latest heartbeat, got %d", len(latest), len(samples))
+	}
+	got := models.SystemFromSamples(samples)
+	if len(got.CPUCores) != 2 || got.CPUCores[1] != 80 || got.Load1 != 0.5 || got.UptimeSeconds != 3600 {
+		t.Errorf("unexpected host values %+v", got)
+	}
+	if len(got.Disks) != 2 || got.Disks[0].Mount != "/" || got.Disks[0].FSType != "ext4" || got.Disks[1].FreeBytes != 100 {
+		t.Errorf("unexpected disks %+v", got.Disks)
+	}
+	if len(got.Interfaces) != 1 || got.Interfaces[0].RxBytesPerSec != 1024 {
+		t.Errorf("unexpected interfaces %+v", got.Interfaces)
+	}
+
+	m, err := store.GetLatestMetric("agent-1")
+	if err != nil || m == nil || m.CPUPercent != 50 || m.MemoryPercent != 60 {
+		t.Errorf("unexpected latest metric %+v, %v", m, err)
+	}
+	history, err := store.GetLatestMetrics("agent-1", 10)
+	if err != nil || len(history) != 2 || history[0].CPUPercent != 50 || history[1].CPUPercent != 5 {
+		t.Errorf("unexpected metric history %+v, %v", history, err)
+	}
+
+	sel, _ := models.ParseSelector(`disk_used_percent{mount=~"/var|/mnt/.*"}`)
+	series, truncated, err := store.QuerySeries(sel, models.ResolutionRaw, start, time.Now().Add(time.Second), 10)
+	if err != nil || truncated {
+		t.Fatalf("query: %v, truncated %v", err, truncated)
+	}
+	if len(series) != 3 {
+		t.Fatalf("expected /mnt/usb and /var of agent-1 and /var of agent-2, got %+v", series)
+	}
+	for _, s := range series {
+		if len(s.Points) != 1 {
+			t.Errorf("expected one point in %s%s of %s, got %d", s.Name, s.Labels, s.AgentID, len(s.Points))
+		}
+	}
+
+	sel, _ = models.ParseSelector(`disk_used_percent{agent="agent-1"}`)
+	if series, truncated, _ := store.QuerySeries(sel, models.ResolutionRaw, start, time.Now().Add(time.Second), 2); len(series) != 2 || !truncated {
+		t.Errorf("expected 2 series and truncated, got %d, %v", len(series), truncated)
+	}
+	if series, _, _ := store.QuerySeries(sel, models.ResolutionRaw, time.Now().Add(time.Hour), time.Now().Add(2*time.Hour), 10); len(series) != 3 || len(series[0].Points) != 0 {
+		t.Errorf("expected the series without points outside the range, got %+v", series)
+	}
+
+	series, truncated, err = store.QueryAgentSeries("agent-1", []string{models.MetricCPUPercent, models.MetricDiskUsedPercent}, models.ResolutionRaw, start, time.Now().Add(time.Second), 10)
+	if err != nil || truncated || len(series) != 4 {
+		t.Fatalf("expected cpu_percent and three disks of agent-1, got %+v, %v", series, err)
+	}
+	if series[0].Name != models.MetricCPUPercent || len(series[0].Points) != 2 || series[1].Labels["mount"] != "/" {
+		t.Errorf("unexpected agent series %+v", series)
+	}
+	if series, truncated, _ := store.QueryAgentSeries("agent-1", nil, models.ResolutionRaw, start, time.Now(), len(latest)); len(series) != len(latest) || !truncated {
+		t.Errorf("expected %d series and truncated, got %d, %v", len(latest), len(series), truncated)
+	}
+
+	names, _ := store.ListMetricNames()
+	if len(names) != len(models.TargetLabels)+8 {
+		t.Errorf("unexpected metric names %v", names)
+	}
+}
+
+func TestLegacyMetricsMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	d, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Now().UTC().Add(-time.Minute)
+	for _, stmt := range []string{
+		`CREATE TABLE metrics (id INTEGER PRIMARY KEY AUTOINCREMENT, agent_id TEXT NOT NULL, cpu_percent REAL NOT NULL DEFAULT 0,
+			memory_percent REAL NOT NULL DEFAULT 0, disk_percent REAL NOT NULL DEFAULT 0, timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE metric_values (id INTEGER PRIMARY KEY AUTOINCREMENT, agent_id TEXT NOT NULL, name TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '', value REAL NOT NULL, timestamp DATETIME NOT NULL)`,
+	} {
+		if _, err := d.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Exec(`INSERT INTO metrics (agent_id, cpu_percent, memory_percent, disk_percent, timestamp) VALUES (?, 10, 20, 30, ?), (?, 11, 21, 31, ?)`,
+		"agent-1", ts.Add(-time.Minute), "agent-1", ts)
+	d.Exec(`INSERT INTO metric_values (agent_id, name, target, value, timestamp) VALUES (?, 'disk_used_percent', 'C:\', 42, ?), (?, 'load1', '', 0.7, ?)`,
+		"agent-1", ts, "agent-1", ts)
+	d.Close()
+
+	store, err := New(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer store.Close()
+
+	history, err := store.GetLatestMetrics("agent-1", 10)
+	if err != nil || len(history) != 2 || history[0].CPUPercent != 11 || history[1].DiskPercent != 30 {
+		t.Errorf("unexpected migrated metrics %+v, %v", history, err)
+	}
+	samples, _ := store.GetLatestSamples("agent-1")
+	sys := models.SystemFromSamples(samples)
+	if sys == nil || sys.Load1 != 0.7 || len(sys.Disks) != 1 || sys.Disks[0].Mount != `C:\` || sys.Disks[0].UsedPercent != 42 {
+		t.Errorf("unexpected migrated samples %+v", samples)
+	}
+
+	// New samples land in the migrated series
+	store.InsertSamples("agent-1", []models.Sample{{Name: models.MetricDiskUsedPercent, Labels: models.Labels{"mount": `C:\`}, Value: 43}})
+	sel, _ := models.ParseSelector(`disk_used_percent`)
+	series, _, _ := store.QuerySeries(sel, models.ResolutionRaw, ts.Add(-time.Hour), time.Now().Add(time.Second), 10)
+	if len(series) != 1 || len(series[0].Points) != 2 {
+		t.Errorf("expected one series with two points, got %+v", series)
+	}
+}
+
+func TestMetricRollups(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.InsertSamples("agent-1", []models.Sample{{Name: "temp", Value: 0}})
+	var seriesID int64
+	store.db.QueryRow(`SELECT id FROM metric_series WHERE name='temp'`).Scan(&seriesID)
+	store.db.Exec(`DELETE FROM metric_points`)
+
+	// Two hours of heartbeats valued 0, 1, 2, ...
+	t0 := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 240; i++ {
+		ts := t0.Add(time.Duration(i)*30*time.Second + 123*time.Millisecond)
+		if _, err := store.db.Exec(`INSERT INTO metric_points (series_id, timestamp, value) VALUES (?, ?, ?)`, seriesID, ts, float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only buckets that ended are rolled up
+	if n, err := store.RollupMetrics(models.Resolution5m, t0.Add(2*time.Hour-time.Second)); err != nil || n != 23 {
+		t.Fatalf("5m rollup: %d, %v", n, err)
+	}
+	if n, _ := store.RollupMetrics(models.Resolution5m, t0.Add(2*time.Hour)); n != 1 {
+		t.Errorf("expected the last bucket on the next run, got %d", n)
+	}
+	if n, _ := store.RollupMetrics(models.Resolution5m, t0.Add(2*time.Hour)); n != 0 {
+		t.Errorf("expected nothing left to roll up, got %d", n)
+	}
+	if n, err := store.RollupMetrics(models.Resolution1h, t0.Add(2*time.Hour)); err != nil || n != 2 {
+		t.Fatalf("1h rollup: %d, %v", n, err)
+	}
+
+	sel, _ := models.ParseSelector("temp")
+	series, _, err := store.QuerySeries(sel, models.Resolution5m, t0.Add(7*time.Minute), t0.Add(20*time.Minute), 10)
+	if err != nil || len(series) != 1 {
+		t.Fatalf("query 5m: %+v, %v", series, err)
+	}
+	points := series[0].Points
+	// Buckets 10:05 (holds from), 10:10, 10:15, 10:20
+	if len(points) != 4 || !points[0].Timestamp.Equal(t0.Add(5*time.Minute)) {
+		t.Fatalf("unexpected 5m points %+v", points)
+	}
+	if points[0].Value != 14.5 || *points[0].Min != 10 || *points[0].Max != 19 {
+		t.Errorf("bucket 10:05 = avg %v min %v max %v, want 14.5/10/19", points[0].Value, *points[0].Min, *points[0].Max)
+	}
+
+	series, _, _ = store.QuerySeries(sel, models.Resolution1h, t0, t0.Add(2*time.Hour), 10)
+	if points := series[0].Points; len(points) != 2 || points[1].Value != 179.5 || *points[1].Min != 120 || *points[1].Max != 239 {
+		t.Errorf("unexpected 1h points %+v", points)
+	}
+
+	if n, err := store.PruneMetrics(models.ResolutionRaw, t0.Add(time.Hour)); err != nil || n != 120 {
+		t.Errorf("prune raw: %d, %v", n, err)
+	}
+	if n, _ := store.PruneMetrics(models.Resolution5m, t0.Add(time.Hour)); n != 12 {
+		t.Errorf("prune 5m: %d", n)
+	}
+	if n, _ := store.PruneMetrics(models.Resolution1h, t0.Add(time.Hour)); n != 1 {
+		t.Errorf("prune 1h: %d", n)
+	}
+
+	// The series had its last sample now; it goes once that is too old
+	if n, _ := store.PruneMetricSeries(time.Now().Add(-time.Hour)); n != 0 {
+		t.Errorf("pruned a live series")
+	}
+	if n, _ := store.PruneMetricSeries(time.Now().Add(time.Hour)); n != 1 {
+		t.Errorf("expected the stale series to be pruned")
+	}
+	var left int
+	store.db.QueryRow(`SELECT (SELECT COUNT(*) FROM metric_points) + (SELECT COUNT(*) FROM metric_rollups_5m) + (SELECT COUNT(*) FROM metric_rollups_1h)`).Scan(&left)
+	if left != 0 {
+		t.Errorf("expected the series' points and rollups to go with it, %d left", left)
+	}
+}
@@ -1,26 +1,77 @@
 package db
 
+import (
+	"database/sql"
+	"log/slog"
+)
+
 const schema = `
 CREATE TABLE IF NOT EXISTS users (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	username TEXT UNIQUE NOT NULL,
 	password_hash TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'admin',
+	disabled INTEGER NOT NULL DEFAULT 0,
+	totp_secret TEXT NOT NULL DEFAULT '',
+	totp_enabled INTEGER NOT NULL DEFAULT 0,
+	totp_last_step INTEGER NOT NULL DEFAULT 0,
+	failed_logins INTEGER NOT NULL DEFAULT 0,
+	locked_until DATETIME,
+	oidc_issuer TEXT NOT NULL DEFAULT '',
+	oidc_subject TEXT NOT NULL DEFAULT '',
 	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at DATETIME,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+CREATE TABLE IF NOT EXISTS settings (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
 CREATE TABLE IF NOT EXISTS sessions (
 	token TEXT PRIMARY KEY,
 	user_id INTEGER NOT NULL,
 	expires_at DATETIME NOT NULL,
+	csrf_token TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	created_at DATETIME,
+	last_seen_at DATETIME,
 	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	prefix TEXT NOT NULL,
+	scope TEXT NOT NULL,
+	expires_at DATETIME,
+	last_used_at DATETIME,
+	revoked INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+
 CREATE TABLE IF NOT EXISTS audit_logs (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	username TEXT NOT NULL,
 	action TEXT NOT NULL,
 	target TEXT NOT NULL,
 	details TEXT NOT NULL,
+	recording_id INTEGER,
 	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -33,20 +84,75 @@ CREATE TABLE IF NOT EXISTS agents (
 	version TEXT NOT NULL DEFAULT '',
 	last_heartbeat DATETIME,
 	status TEXT NOT NULL DEFAULT 'offline',
+	secret_hash TEXT NOT NULL DEFAULT '',
+	secret_revoked INTEGER NOT NULL DEFAULT 0,
+	pending_secret_hash TEXT NOT NULL DEFAULT '',
+	cert_serial TEXT NOT NULL DEFAULT '',
+	cert_revoked INTEGER NOT NULL DEFAULT 0,
+	tags TEXT NOT NULL DEFAULT '[]',
 	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE TABLE IF NOT EXISTS metrics (
+CREATE TABLE IF NOT EXISTS enrollment_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	token_hash TEXT UNIQUE NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	max_uses INTEGER NOT NULL DEFAULT 1,
+	uses INTEGER NOT NULL DEFAULT 0,
+	expires_at DATETIME,
+	revoked INTEGER NOT NULL DEFAULT 0,
+	created_by TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A series is one metric name with one label set of one agent; labels are a
+-- JSON object. last_value/last_timestamp hold the latest sample so current
+-- values don't need a scan of metric_points.
+CREATE TABLE IF NOT EXISTS metric_series (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	agent_id TEXT NOT NULL REFERENCES agents(id),
-	cpu_percent REAL NOT NULL DEFAULT 0,
-	memory_percent REAL NOT NULL DEFAULT 0,
-	disk_percent REAL NOT NULL DEFAULT 0,
-	timestamp DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	name TEXT NOT NULL,
+	labels TEXT NOT NULL DEFAULT '{}',
+	last_value REAL NOT NULL DEFAULT 0,
+	last_timestamp DATETIME NOT NULL,
+	UNIQUE(agent_id, name, labels)
+);
+
+CREATE INDEX IF NOT EXISTS idx_metric_series_name ON metric_series(name);
+
+CREATE TABLE IF NOT EXISTS metric_points (
+	series_id INTEGER NOT NULL REFERENCES metric_series(id),
+	timestamp DATETIME NOT NULL,
+	value REAL NOT NULL
 );
 
-CREATE INDEX IF NOT EXISTS idx_metrics_agent_id ON metrics(agent_id);
-CREATE INDEX IF NOT EXISTS idx_metrics_timestamp ON metrics(timestamp);
+CREATE INDEX IF NOT EXISTS idx_metric_points_series ON metric_points(series_id, timestamp);
+
+-- Rollups of metric_points into 5-minute and 1-hour buckets; bucket is the
+-- bucket start in Unix seconds
+CREATE TABLE IF NOT EXISTS metric_rollups_5m (
+	series_id INTEGER NOT NULL REFERENCES metric_series(id),
+	bucket INTEGER NOT NULL,
+	min_value REAL NOT NULL,
+	max_value REAL NOT NULL,
+	sum_value REAL NOT NULL,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (series_id, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS metric_rollups_1h (
+	series_id INTEGER NOT NULL REFERENCES metric_series(id),
+	bucket INTEGER NOT NULL,
+	min_value REAL NOT NULL,
+	max_value REAL NOT NULL,
+	sum_value REAL NOT NULL,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (series_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS idx_metric_points_timestamp ON metric_points(timestamp);
+CREATE INDEX IF NOT EXISTS idx_metric_rollups_5m_bucket ON metric_rollups_5m(bucket);
+CREATE INDEX IF NOT EXISTS idx_metric_rollups_1h_bucket ON metric_rollups_1h(bucket);
 
 CREATE TABLE IF NOT EXISTS commands (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -54,19 +160,69 @@ CREATE TABLE IF NOT EXISTS commands (
 	command TEXT NOT NULL,
 	stdout TEXT NOT NULL DEFAULT '',
 	stderr TEXT NOT NULL DEFAULT '',
+	output_truncated INTEGER NOT NULL DEFAULT 0,
 	exit_code INTEGER NOT NULL DEFAULT -1,
 	status TEXT NOT NULL DEFAULT 'pending',
+	timeout_seconds INTEGER NOT NULL DEFAULT 0,
 	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE INDEX IF NOT EXISTS idx_commands_agent_id ON commands(agent_id);
 
+CREATE TABLE IF NOT EXISTS message_queue (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	agent_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	ref_id INTEGER NOT NULL,
+	summary TEXT NOT NULL DEFAULT '',
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'queued',
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	delivered_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_queue_agent_status ON message_queue(agent_id, status);
+
+CREATE TABLE IF NOT EXISTS shell_sessions (
+	id TEXT PRIMARY KEY,
+	agent_id TEXT NOT NULL,
+	username TEXT NOT NULL,
+	recording_path TEXT NOT NULL DEFAULT '',
+	exit_code INTEGER NOT NULL DEFAULT -1,
+	started_at DATETIME NOT NULL,
+	ended_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_shell_sessions_agent_id ON shell_sessions(agent_id);
+
+CREATE TABLE IF NOT EXISTS recordings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	agent_id TEXT NOT NULL,
+	ref TEXT NOT NULL,
+	username TEXT NOT NULL,
+	title TEXT NOT NULL DEFAULT '',
+	path TEXT NOT NULL,
+	size INTEGER NOT NULL DEFAULT 0,
+	started_at DATETIME NOT NULL,
+	ended_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_recordings_agent_id ON recordings(agent_id);
+CREATE INDEX IF NOT EXISTS idx_recordings_ref ON recordings(kind, ref);
+
 CREATE TABLE IF NOT EXISTS alert_rules (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	type TEXT NOT NULL DEFAULT 'metric',
+	severity TEXT NOT NULL DEFAULT 'warning',
 	metric TEXT NOT NULL,
 	operator TEXT NOT NULL,
 	threshold REAL NOT NULL,
 	agent_id TEXT NOT NULL DEFAULT '',
+	target TEXT NOT NULL DEFAULT '',
+	for_seconds INTEGER NOT NULL DEFAULT 0,
+	clear_threshold REAL,
 	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -76,11 +232,70 @@ CREATE TABLE IF NOT EXISTS alerts (
 	agent_id TEXT NOT NULL REFERENCES agents(id),
 	message TEXT NOT NULL,
 	resolved INTEGER NOT NULL DEFAULT 0,
-	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	resolved_at DATETIME,
+	resolved_by TEXT NOT NULL DEFAULT '',
+	acknowledged_by TEXT NOT NULL DEFAULT '',
+	acknowledged_at DATETIME,
+	ack_comment TEXT NOT NULL DEFAULT '',
+	maintenance INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE INDEX IF NOT EXISTS idx_alerts_agent_id ON alerts(agent_id);
 
+CREATE TABLE IF NOT EXISTS notification_channels (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	config TEXT NOT NULL DEFAULT '{}',
+	rule_ids TEXT NOT NULL DEFAULT '[]',
+	min_severity TEXT NOT NULL DEFAULT '',
+	enabled INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	channel_id INTEGER NOT NULL,
+	alert_id INTEGER NOT NULL DEFAULT 0,
+	event TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_created_at ON notification_deliveries(created_at);
+
+CREATE TABLE IF NOT EXISTS silences (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	agent_id TEXT NOT NULL DEFAULT '',
+	rule_id INTEGER NOT NULL DEFAULT 0,
+	tag TEXT NOT NULL DEFAULT '',
+	comment TEXT NOT NULL DEFAULT '',
+	created_by TEXT NOT NULL DEFAULT '',
+	starts_at DATETIME NOT NULL,
+	ends_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_silences_ends_at ON silences(ends_at);
+
+CREATE TABLE IF NOT EXISTS maintenance_windows (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	schedule TEXT NOT NULL DEFAULT '',
+	timezone TEXT NOT NULL DEFAULT '',
+	starts_at DATETIME,
+	duration_seconds INTEGER NOT NULL,
+	agent_ids TEXT NOT NULL DEFAULT '[]',
+	tags TEXT NOT NULL DEFAULT '[]',
+	comment TEXT NOT NULL DEFAULT '',
+	created_by TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
 CREATE TABLE IF NOT EXISTS file_transfers (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	agent_id TEXT NOT NULL REFERENCES agents(id),
@@ -96,3 +311,70 @@ CREATE TABLE IF NOT EXISTS file_transfers (
 
 CREATE INDEX IF NOT EXISTS idx_file_transfers_agent_id ON file_transfers(agent_id);
 `
+
+// migrateLegacyMetrics copies the fixed-column metrics table and the
+// metric_values table into metric_series/metric_points and drops them. The
+// label JSON built here must match labelsKey.
+func migrateLegacyMetrics(d *sql.DB) error {
+	var legacy []string
+	rows, err := d.Query(`SELECT name FROM sqlite_master WHERE type='table' AND name IN ('metrics', 'metric_values')`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, name)
+	}
+	rows.Close()
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stmts []string
+	for _, table := range legacy {
+		switch table {
+		case "metrics":
+			for _, col := range []string{"cpu_percent", "memory_percent", "disk_percent"} {
+				stmts = append(stmts,
+					`INSERT OR IGNORE INTO metric_series (agent_id, name, labels, last_timestamp)
+					SELECT agent_id, '`+col+`', '{}', MAX(timestamp) FROM metrics GROUP BY agent_id`,
+					`INSERT INTO metric_points (series_id, timestamp, value)
+					SELECT s.id, m.timestamp, m.`+col+` FROM metrics m
+					JOIN metric_series s ON s.agent_id = m.agent_id AND s.name = '`+col+`' AND s.labels = '{}'`)
+			}
+		case "metric_values":
+			labels := `CASE
+				WHEN name = 'cpu_core_percent' THEN json_object('core', target)
+				WHEN name LIKE 'disk\_%' ESCAPE '\' THEN json_object('mount', target)
+				WHEN name LIKE 'net\_%' ESCAPE '\' THEN json_object('interface', target)
+				ELSE '{}' END`
+			stmts = append(stmts,
+				`INSERT OR IGNORE INTO metric_series (agent_id, name, labels, last_timestamp)
+				SELECT agent_id, name, `+labels+`, MAX(timestamp) FROM metric_values GROUP BY agent_id, name, target`,
+				`INSERT INTO metric_points (series_id, timestamp, value)
+				SELECT s.id, v.timestamp, v.value FROM (SELECT *, `+labels+` AS labels FROM metric_values) v
+				JOIN metric_series s ON s.agent_id = v.agent_id AND s.name = v.name AND s.labels = v.labels`)
+		}
+		stmts = append(stmts, `DROP TABLE `+table)
+	}
+	stmts = append(stmts, `UPDATE metric_series SET last_value = COALESCE((SELECT value FROM metric_points p
+		WHERE p.series_id = metric_series.id ORDER BY p.timestamp DESC LIMIT 1), 0)`)
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	slog.Info("migrated metrics to labelled series", "tables", legacy)
+	return tx.Commit()
+}
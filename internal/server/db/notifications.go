@@ -0,0 +1,179 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ---- Notification channels ----
+
+const channelColumns = `id, name, type, config, rule_ids, min_severity, enabled, created_at`
+
+func scanChannel(row interface{ Scan(...any) error }, c *models.NotificationChannel) error {
+	var config, ruleIDs string
+	if err := row.Scan(&c.ID, &c.Name, &c.Type, &config, &ruleIDs, &c.MinSeverity, &c.Enabled, &c.CreatedAt); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(config), &c.Config); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(ruleIDs), &c.RuleIDs)
+}
+
+// channelJSON encodes a channel's config and rule IDs for storage.
+func channelJSON(config models.ChannelConfig, ruleIDs []int64) (string, string, error) {
+	if ruleIDs == nil {
+		ruleIDs = []int64{}
+	}
+	c, err := json.Marshal(config)
+	if err != nil {
+		return "", "", err
+	}
+	r, err := json.Marshal(ruleIDs)
+	if err != nil {
+		return "", "", err
+	}
+	return string(c), string(r), nil
+}
+
+func (s *Store) CreateNotificationChannel(req models.NotificationChannelRequest) (*models.NotificationChannel, error) {
+	config, ruleIDs, err := channelJSON(req.Config, req.RuleIDs)
+	if err != nil {
+		return nil, err
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+	res, err := s.db.Exec(`INSERT INTO notification_channels (name, type, config, rule_ids, min_severity, enabled, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, req.Type, config, ruleIDs, req.MinSeverity, enabled, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return s.GetNotificationChannel(id)
+}
+
+// UpdateNotificationChannel replaces a channel's settings. Secrets left empty
+// in req keep their stored value.
+func (s *Store) UpdateNotificationChannel(id int64, req models.NotificationChannelRequest) (*models.NotificationChannel, error) {
+	current, err := s.GetNotificationChannel(id)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	if req.Config.Secret == "" {
+		req.Config.Secret = current.Config.Secret
+	}
+	if req.Config.SMTPPassword == "" {
+		req.Config.SMTPPassword = current.Config.SMTPPassword
+	}
+	enabled := current.Enabled
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	config, ruleIDs, err := channelJSON(req.Config, req.RuleIDs)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(`UPDATE notification_channels SET name=?, type=?, config=?, rule_ids=?, min_severity=?, enabled=? WHERE id=?`,
+		req.Name, req.Type, config, ruleIDs, req.MinSeverity, enabled, id); err != nil {
+		return nil, err
+	}
+	return s.GetNotificationChannel(id)
+}
+
+func (s *Store) GetNotificationChannel(id int64) (*models.NotificationChannel, error) {
+	var c models.NotificationChannel
+	err := scanChannel(s.db.QueryRow(`SELECT `+channelColumns+` FROM notification_channels WHERE id=?`, id), &c)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *Store) ListNotificationChannels() ([]models.NotificationChannel, error) {
+	rows, err := s.db.Query(`SELECT ` + channelColumns + ` FROM notification_channels ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channels []models.NotificationChannel
+	for rows.Next() {
+		var c models.NotificationChannel
+		if err := scanChannel(rows, &c); err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+func (s *Store) DeleteNotificationChannel(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM notification_channels WHERE id=?`, id)
+	return err
+}
+
+// ---- Notification deliveries ----
+
+// CreateDelivery logs a notification about to be sent to a channel.
+func (s *Store) CreateDelivery(channelID, alertID int64, event models.AlertEvent) (int64, error) {
+	now := time.Now().UTC()
+	res, err := s.db.Exec(`INSERT INTO notification_deliveries (channel_id, alert_id, event, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		channelID, alertID, event, models.DeliveryPending, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UpdateDelivery records the outcome of a delivery attempt.
+func (s *Store) UpdateDelivery(id int64, status models.DeliveryStatus, attempts int, errMsg string) error {
+	_, err := s.db.Exec(`UPDATE notification_deliveries SET status=?, attempts=?, error=?, updated_at=? WHERE id=?`,
+		status, attempts, errMsg, time.Now().UTC(), id)
+	return err
+}
+
+const deliveryColumns = `d.id, d.channel_id, COALESCE(c.name, ''), d.alert_id, d.event, d.status, d.attempts, d.error, d.created_at, d.updated_at`
+
+func (s *Store) queryDeliveries(where string, args ...any) ([]models.NotificationDelivery, error) {
+	rows, err := s.db.Query(`SELECT `+deliveryColumns+`
+		FROM notification_deliveries d LEFT JOIN notification_channels c ON c.id = d.channel_id
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.NotificationDelivery
+	for rows.Next() {
+		var d models.NotificationDelivery
+		if err := rows.Scan(&d.ID, &d.ChannelID, &d.ChannelName, &d.AlertID, &d.Event, &d.Status, &d.Attempts, &d.Error, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ListDeliveries returns the latest deliveries, newest first.
+func (s *Store) ListDeliveries(limit int) ([]models.NotificationDelivery, error) {
+	return s.queryDeliveries(`ORDER BY d.id DESC LIMIT ?`, limit)
+}
+
+// PendingDeliveries returns the deliveries still to be sent, oldest first.
+func (s *Store) PendingDeliveries() ([]models.NotificationDelivery, error) {
+	return s.queryDeliveries(`WHERE d.status=? ORDER BY d.id`, models.DeliveryPending)
+}
+
+// PruneDeliveries deletes deliveries logged before cutoff.
+func (s *Store) PruneDeliveries(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM notification_deliveries WHERE created_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
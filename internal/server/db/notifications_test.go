@@ -0,0 +1,83 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestNotificationChannelSecretsKept(t *testing.T) {
+	store := setupTestDB(t)
+
+	ch, err := store.CreateNotificationChannel(models.NotificationChannelRequest{
+		Name:    "hook",
+		Type:    models.ChannelWebhook,
+		Config:  models.ChannelConfig{URL: "https://example.com/hook", Secret: "s3cret"},
+		RuleIDs: []int64{1, 2},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if !ch.Enabled || ch.Config.Secret != "s3cret" || len(ch.RuleIDs) != 2 {
+		t.Fatalf("created %+v", ch)
+	}
+
+	// The dashboard sends the redacted config back when toggling a channel
+	off := false
+	ch, err = store.UpdateNotificationChannel(ch.ID, models.NotificationChannelRequest{
+		Name:    "hook",
+		Type:    models.ChannelWebhook,
+		Config:  ch.Config.Redacted(),
+		Enabled: &off,
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if ch.Enabled || ch.Config.Secret != "s3cret" || len(ch.RuleIDs) != 0 {
+		t.Errorf("updated %+v", ch)
+	}
+
+	missing, err := store.UpdateNotificationChannel(ch.ID+1, models.NotificationChannelRequest{Name: "x", Type: models.ChannelWebhook})
+	if err != nil || missing != nil {
+		t.Errorf("update of missing channel = %v, %v", missing, err)
+	}
+}
+
+func TestDeliveryLog(t *testing.T) {
+	store := setupTestDB(t)
+
+	ch, err := store.CreateNotificationChannel(models.NotificationChannelRequest{Name: "slack", Type: models.ChannelSlack, Config: models.ChannelConfig{URL: "https://example.com"}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	id, err := store.CreateDelivery(ch.ID, 5, models.EventFiring)
+	if err != nil {
+		t.Fatalf("create delivery: %v", err)
+	}
+	if err := store.UpdateDelivery(id, models.DeliveryFailed, 3, "webhook returned 503"); err != nil {
+		t.Fatalf("update delivery: %v", err)
+	}
+
+	ds, err := store.ListDeliveries(10)
+	if err != nil || len(ds) != 1 {
+		t.Fatalf("list = %v, %v", ds, err)
+	}
+	if d := ds[0]; d.ChannelName != "slack" || d.AlertID != 5 || d.Status != models.DeliveryFailed || d.Attempts != 3 || d.Error != "webhook returned 503" {
+		t.Errorf("delivery = %+v", d)
+	}
+
+	// Deliveries outlive their channel
+	if err := store.DeleteNotificationChannel(ch.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	ds, _ = store.ListDeliveries(10)
+	if len(ds) != 1 || ds[0].ChannelName != "" {
+		t.Errorf("after delete = %+v", ds)
+	}
+
+	n, err := store.PruneDeliveries(time.Now().Add(time.Minute))
+	if err != nil || n != 1 {
+		t.Errorf("prune = %d, %v", n, err)
+	}
+}
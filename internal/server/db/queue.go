@@ -0,0 +1,155 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ---- Message Queue ----
+
+func (s *Store) EnqueueMessage(agentID string, kind models.QueueKind, refID int64, summary string, payload []byte, ttl time.Duration) (*models.QueuedMessage, error) {
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl)
+	res, err := s.db.Exec(`INSERT INTO message_queue (agent_id, kind, ref_id, summary, payload, status, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		agentID, kind, refID, summary, string(payload), models.QueueQueued, now, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return &models.QueuedMessage{
+		ID:        id,
+		AgentID:   agentID,
+		Kind:      kind,
+		RefID:     refID,
+		Summary:   summary,
+		Payload:   payload,
+		Status:    models.QueueQueued,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ListQueuedMessages returns the undelivered messages of an agent in delivery order.
+func (s *Store) ListQueuedMessages(agentID string) ([]models.QueuedMessage, error) {
+	rows, err := s.db.Query(`SELECT id, agent_id, kind, ref_id, summary, payload, status, created_at, expires_at, delivered_at
+		FROM message_queue WHERE agent_id=? AND status=? ORDER BY id`, agentID, models.QueueQueued)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanQueuedMessages(rows)
+}
+
+// ClaimMessage marks a queued message delivered before it is sent, so a
+// message cancelled or expired in the meantime is not. It returns false if
+// the message already left the queue.
+func (s *Store) ClaimMessage(id int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE message_queue SET status=?, delivered_at=? WHERE id=? AND status=?`,
+		models.QueueDelivered, time.Now().UTC(), id, models.QueueQueued)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UnclaimMessage puts a claimed message back in the queue after sending it
+// failed.
+func (s *Store) UnclaimMessage(id int64) error {
+	_, err := s.db.Exec(`UPDATE message_queue SET status=?, delivered_at=NULL WHERE id=? AND status=?`,
+		models.QueueQueued, id, models.QueueDelivered)
+	return err
+}
+
+// CancelQueuedMessage cancels a message that has not been delivered yet. It
+// returns nil if the message does not exist or already left the queue.
+func (s *Store) CancelQueuedMessage(id int64) (*models.QueuedMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, agent_id, kind, ref_id, summary, payload, status, created_at, expires_at, delivered_at
+		FROM message_queue WHERE id=? AND status=?`, id, models.QueueQueued)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanQueuedMessages(rows)
+	rows.Close()
+	if err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE message_queue SET status=? WHERE id=?`, models.QueueCancelled, id); err != nil {
+		return nil, err
+	}
+	msgs[0].Status = models.QueueCancelled
+	return &msgs[0], tx.Commit()
+}
+
+// CancelQueuedRef cancels any undelivered message for a command or transfer.
+func (s *Store) CancelQueuedRef(kind models.QueueKind, refID int64) error {
+	_, err := s.db.Exec(`UPDATE message_queue SET status=? WHERE kind=? AND ref_id=? AND status=?`,
+		models.QueueCancelled, kind, refID, models.QueueQueued)
+	return err
+}
+
+// ExpireQueuedMessages marks undelivered messages past their TTL as expired
+// and returns them.
+func (s *Store) ExpireQueuedMessages(now time.Time) ([]models.QueuedMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, agent_id, kind, ref_id, summary, payload, status, created_at, expires_at, delivered_at
+		FROM message_queue WHERE status=? AND expires_at <= ? ORDER BY id`, models.QueueQueued, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanQueuedMessages(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range msgs {
+		if _, err := tx.Exec(`UPDATE message_queue SET status=? WHERE id=?`, models.QueueExpired, msgs[i].ID); err != nil {
+			return nil, err
+		}
+		msgs[i].Status = models.QueueExpired
+	}
+	return msgs, tx.Commit()
+}
+
+// PruneQueuedMessages deletes messages that left the queue (delivered,
+// expired or cancelled) and were queued before cutoff.
+func (s *Store) PruneQueuedMessages(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM message_queue WHERE status<>? AND created_at < ?`, models.QueueQueued, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanQueuedMessages(rows *sql.Rows) ([]models.QueuedMessage, error) {
+	var msgs []models.QueuedMessage
+	for rows.Next() {
+		var m models.QueuedMessage
+		var payload string
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.AgentID, &m.Kind, &m.RefID, &m.Summary, &payload, &m.Status, &m.CreatedAt, &m.ExpiresAt, &deliveredAt); err != nil {
+			return nil, err
+		}
+		m.Payload = []byte(payload)
+		if deliveredAt.Valid {
+			m.DeliveredAt = &deliveredAt.Time
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
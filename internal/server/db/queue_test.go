@@ -0,0 +1,92 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestMessageQueue(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	first, err := store.EnqueueMessage("agent-1", models.QueueCommand, 10, "hostname", []byte(`{"type":"command"}`), time.Hour)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	second, _ := store.EnqueueMessage("agent-1", models.QueueFileTransfer, 20, "download /etc/hosts", []byte(`{}`), time.Hour)
+	store.EnqueueMessage("agent-2", models.QueueCommand, 30, "uptime", []byte(`{}`), time.Hour)
+
+	msgs, err := store.ListQueuedMessages("agent-1")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != first.ID || msgs[1].ID != second.ID {
+		t.Fatalf("expected both messages in order, got %+v", msgs)
+	}
+	if string(msgs[0].Payload) != `{"type":"command"}` {
+		t.Errorf("unexpected payload %q", msgs[0].Payload)
+	}
+
+	if claimed, err := store.ClaimMessage(first.ID); err != nil || !claimed {
+		t.Fatalf("claim: %v %v", claimed, err)
+	}
+	cancelled, err := store.CancelQueuedMessage(second.ID)
+	if err != nil || cancelled == nil {
+		t.Fatalf("cancel: %v %v", cancelled, err)
+	}
+	if cancelled.RefID != 20 || cancelled.Status != models.QueueCancelled {
+		t.Errorf("unexpected cancelled message %+v", cancelled)
+	}
+	if again, _ := store.CancelQueuedMessage(first.ID); again != nil {
+		t.Error("delivered message must not be cancellable")
+	}
+	if claimed, _ := store.ClaimMessage(second.ID); claimed {
+		t.Error("cancelled message must not be claimed for delivery")
+	}
+	if msgs, _ := store.ListQueuedMessages("agent-1"); len(msgs) != 0 {
+		t.Errorf("expected empty queue, got %d", len(msgs))
+	}
+
+	// A failed send puts the message back
+	third, _ := store.EnqueueMessage("agent-1", models.QueueCommand, 40, "whoami", []byte(`{}`), time.Hour)
+	store.ClaimMessage(third.ID)
+	if err := store.UnclaimMessage(third.ID); err != nil {
+		t.Fatalf("unclaim: %v", err)
+	}
+	if msgs, _ := store.ListQueuedMessages("agent-1"); len(msgs) != 1 || msgs[0].ID != third.ID || msgs[0].DeliveredAt != nil {
+		t.Errorf("expected the unclaimed message back in the queue, got %+v", msgs)
+	}
+
+	// Only messages that left the queue are pruned
+	if n, err := store.PruneQueuedMessages(time.Now().Add(time.Minute)); err != nil || n != 2 {
+		t.Errorf("prune: deleted %d, %v; want the delivered and the cancelled message", n, err)
+	}
+	if msgs, _ := store.ListQueuedMessages("agent-2"); len(msgs) != 1 {
+		t.Errorf("queued message of agent-2 was pruned")
+	}
+}
+
+func TestMessageQueueExpiry(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.EnqueueMessage("agent-1", models.QueueCommand, 1, "short", []byte(`{}`), time.Minute)
+	store.EnqueueMessage("agent-1", models.QueueCommand, 2, "long", []byte(`{}`), time.Hour)
+
+	expired, err := store.ExpireQueuedMessages(time.Now().Add(10 * time.Minute))
+	if err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	if len(expired) != 1 || expired[0].RefID != 1 || expired[0].Status != models.QueueExpired {
+		t.Fatalf("expected only the short message to expire, got %+v", expired)
+	}
+
+	if err := store.CancelQueuedRef(models.QueueCommand, 2); err != nil {
+		t.Fatalf("cancel ref: %v", err)
+	}
+	if msgs, _ := store.ListQueuedMessages("agent-1"); len(msgs) != 0 {
+		t.Errorf("expected empty queue, got %d", len(msgs))
+	}
+}
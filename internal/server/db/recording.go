@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ---- Recordings ----
+
+const recordingColumns = `id, kind, agent_id, ref, username, title, path, size, started_at, ended_at`
+
+func (s *Store) CreateRecording(kind models.RecordingKind, agentID, ref, username, title, path string) (*models.Recording, error) {
+	now := time.Now().UTC()
+	res, err := s.db.Exec(`INSERT INTO recordings (kind, agent_id, ref, username, title, path, started_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		kind, agentID, ref, username, title, path, now)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return &models.Recording{
+		ID:        id,
+		Kind:      kind,
+		AgentID:   agentID,
+		Ref:       ref,
+		Username:  username,
+		Title:     title,
+		Path:      path,
+		StartedAt: now,
+	}, nil
+}
+
+func (s *Store) FinishRecording(id, size int64) error {
+	_, err := s.db.Exec(`UPDATE recordings SET ended_at=?, size=? WHERE id=? AND ended_at IS NULL`, time.Now().UTC(), size, id)
+	return err
+}
+
+func (s *Store) GetRecording(id int64) (*models.Recording, error) {
+	return s.getRecording(`SELECT `+recordingColumns+` FROM recordings WHERE id=?`, id)
+}
+
+func (s *Store) GetRecordingByRef(kind models.RecordingKind, ref string) (*models.Recording, error) {
+	return s.getRecording(`SELECT `+recordingColumns+` FROM recordings WHERE kind=? AND ref=? ORDER BY id DESC LIMIT 1`, kind, ref)
+}
+
+func (s *Store) getRecording(query string, args ...any) (*models.Recording, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	recs, err := scanRecordings(rows)
+	if err != nil || len(recs) == 0 {
+		return nil, err
+	}
+	return &recs[0], nil
+}
+
+// ListRecordings returns the newest recordings, optionally for one agent.
+func (s *Store) ListRecordings(agentID string, limit int) ([]models.Recording, error) {
+	query := `SELECT ` + recordingColumns + ` FROM recordings`
+	var args []any
+	if agentID != "" {
+		query += ` WHERE agent_id=?`
+		args = append(args, agentID)
+	}
+	query += ` ORDER BY started_at DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecordings(rows)
+}
+
+// RecordingsEndedBefore returns finished recordings older than t.
+func (s *Store) RecordingsEndedBefore(t time.Time) ([]models.Recording, error) {
+	rows, err := s.db.Query(`SELECT `+recordingColumns+` FROM recordings WHERE ended_at IS NOT NULL AND ended_at < ? ORDER BY id`, t.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRecordings(rows)
+}
+
+// DeleteRecording removes a recording and unlinks it from the audit log.
+func (s *Store) DeleteRecording(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE audit_logs SET recording_id=NULL WHERE recording_id=?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recordings WHERE id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanRecordings(rows *sql.Rows) ([]models.Recording, error) {
+	var recs []models.Recording
+	for rows.Next() {
+		var r models.Recording
+		var endedAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.Kind, &r.AgentID, &r.Ref, &r.Username, &r.Title, &r.Path, &r.Size, &r.StartedAt, &endedAt); err != nil {
+			return nil, err
+		}
+		if endedAt.Valid {
+			r.EndedAt = &endedAt.Time
+		}
+		recs = append(recs, r)
+	}
+	return recs, rows.Err()
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestRecordingLifecycle(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	rec, err := store.CreateRecording(models.RecordingCommand, "agent-1", "42", "admin", "admin@agent-1: uptime", "/tmp/command-42.cast")
+	if err != nil {
+		t.Fatalf("create recording: %v", err)
+	}
+	if err := store.InsertAuditLogWithRecording("admin", "command_execution", "agent-1", `{}`, rec.ID); err != nil {
+		t.Fatalf("insert audit log: %v", err)
+	}
+	store.InsertAuditLog("admin", "login", "admin", `{}`)
+
+	logs, _ := store.GetAuditLogs(10)
+	if len(logs) != 2 || logs[0].RecordingID != nil || logs[1].RecordingID == nil || *logs[1].RecordingID != rec.ID {
+		t.Fatalf("unexpected audit recording links: %+v", logs)
+	}
+
+	if old, _ := store.RecordingsEndedBefore(time.Now().Add(time.Hour)); len(old) != 0 {
+		t.Fatalf("running recording must not be pruned, got %d", len(old))
+	}
+	if err := store.FinishRecording(rec.ID, 1234); err != nil {
+		t.Fatalf("finish recording: %v", err)
+	}
+	got, _ := store.GetRecordingByRef(models.RecordingCommand, "42")
+	if got == nil || got.Size != 1234 || got.EndedAt == nil {
+		t.Fatalf("unexpected recording %+v", got)
+	}
+
+	old, _ := store.RecordingsEndedBefore(time.Now().Add(time.Hour))
+	if len(old) != 1 {
+		t.Fatalf("expected 1 expired recording, got %d", len(old))
+	}
+	if err := store.DeleteRecording(rec.ID); err != nil {
+		t.Fatalf("delete recording: %v", err)
+	}
+	if r, _ := store.GetRecording(rec.ID); r != nil {
+		t.Error("recording still present after delete")
+	}
+	logs, _ = store.GetAuditLogs(10)
+	if logs[1].RecordingID != nil {
+		t.Error("audit log still links to deleted recording")
+	}
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ---- Settings ----
+
+const settingRequire2FA = "require_2fa"
+
+func (s *Store) getSetting(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM settings WHERE key=?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *Store) setSetting(key, value string) error {
+	_, err := s.db.Exec(`INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value=excluded.value`, key, value)
+	return err
+}
+
+func (s *Store) GetSettings() (*models.Settings, error) {
+	require2FA, err := s.getSetting(settingRequire2FA)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Settings{Require2FA: require2FA == "1"}, nil
+}
+
+func (s *Store) SetRequire2FA(required bool) error {
+	value := "0"
+	if required {
+		value = "1"
+	}
+	return s.setSetting(settingRequire2FA, value)
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ---- Shell Sessions ----
+
+func (s *Store) CreateShellSession(id, agentID, username, recordingPath string) (*models.ShellSession, error) {
+	now := time.Now().UTC()
+	_, err := s.db.Exec(`INSERT INTO shell_sessions (id, agent_id, username, recording_path, started_at) VALUES (?, ?, ?, ?, ?)`,
+		id, agentID, username, recordingPath, now)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ShellSession{
+		ID:            id,
+		AgentID:       agentID,
+		Username:      username,
+		RecordingPath: recordingPath,
+		ExitCode:      -1,
+		StartedAt:     now,
+	}, nil
+}
+
+func (s *Store) EndShellSession(id string, exitCode int) error {
+	_, err := s.db.Exec(`UPDATE shell_sessions SET ended_at=?, exit_code=? WHERE id=? AND ended_at IS NULL`,
+		time.Now().UTC(), exitCode, id)
+	return err
+}
+
+// EndOpenShellSessions closes sessions left open by a server restart.
+func (s *Store) EndOpenShellSessions() error {
+	_, err := s.db.Exec(`UPDATE shell_sessions SET ended_at=? WHERE ended_at IS NULL`, time.Now().UTC())
+	return err
+}
+
+const shellSessionColumns = `id, agent_id, username, recording_path,
+	COALESCE((SELECT r.id FROM recordings r WHERE r.kind='shell' AND r.ref=shell_sessions.id), 0),
+	exit_code, started_at, ended_at`
+
+func (s *Store) GetShellSession(id string) (*models.ShellSession, error) {
+	rows, err := s.db.Query(`SELECT `+shellSessionColumns+` FROM shell_sessions WHERE id=?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions, err := scanShellSessions(rows)
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return &sessions[0], nil
+}
+
+func (s *Store) ListShellSessions(agentID string, limit int) ([]models.ShellSession, error) {
+	rows, err := s.db.Query(`SELECT `+shellSessionColumns+` FROM shell_sessions WHERE agent_id=? ORDER BY started_at DESC LIMIT ?`, agentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanShellSessions(rows)
+}
+
+func scanShellSessions(rows *sql.Rows) ([]models.ShellSession, error) {
+	var sessions []models.ShellSession
+	for rows.Next() {
+		var ss models.ShellSession
+		var endedAt sql.NullTime
+		if err := rows.Scan(&ss.ID, &ss.AgentID, &ss.Username, &ss.RecordingPath, &ss.RecordingID, &ss.ExitCode, &ss.StartedAt, &endedAt); err != nil {
+			return nil, err
+		}
+		if endedAt.Valid {
+			ss.EndedAt = &endedAt.Time
+		}
+		sessions = append(sessions, ss)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// ---- Silences ----
+
+const silenceColumns = `id, agent_id, rule_id, tag, comment, created_by, starts_at, ends_at, created_at`
+
+func scanSilence(row interface{ Scan(...any) error }, s *models.Silence) error {
+	return row.Scan(&s.ID, &s.AgentID, &s.RuleID, &s.Tag, &s.Comment, &s.CreatedBy, &s.StartsAt, &s.EndsAt, &s.CreatedAt)
+}
+
+func (s *Store) CreateSilence(sl models.Silence) (*models.Silence, error) {
+	res, err := s.db.Exec(`INSERT INTO silences (agent_id, rule_id, tag, comment, created_by, starts_at, ends_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sl.AgentID, sl.RuleID, sl.Tag, sl.Comment, sl.CreatedBy, sl.StartsAt.UTC(), sl.EndsAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	return s.GetSilence(id)
+}
+
+func (s *Store) GetSilence(id int64) (*models.Silence, error) {
+	var sl models.Silence
+	err := scanSilence(s.db.QueryRow(`SELECT `+silenceColumns+` FROM silences WHERE id=?`, id), &sl)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sl, nil
+}
+
+// ListSilences returns the silences that end after since, the ones still to
+// come included, ending soonest first.
+func (s *Store) ListSilences(since time.Time) ([]models.Silence, error) {
+	rows, err := s.db.Query(`SELECT `+silenceColumns+` FROM silences WHERE ends_at > ? ORDER BY ends_at, id`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var silences []models.Silence
+	for rows.Next() {
+		var sl models.Silence
+		if err := scanSilence(rows, &sl); err != nil {
+			return nil, err
+		}
+		silences = append(silences, sl)
+	}
+	return silences, rows.Err()
+}
+
+// ExpireSilence ends a silence now. It returns false if the silence doesn't
+// exist or has ended already.
+func (s *Store) ExpireSilence(id int64) (bool, error) {
+	now := time.Now().UTC()
+	// A silence that hasn't started yet ends before it starts
+	res, err := s.db.Exec(`UPDATE silences SET ends_at=?, starts_at=MIN(starts_at, ?) WHERE id=? AND ends_at > ?`, now, now, id, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// PruneSilences deletes silences that ended before cutoff.
+func (s *Store) PruneSilences(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM silences WHERE ends_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
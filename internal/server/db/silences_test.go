@@ -0,0 +1,102 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestSilences(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	now := time.Now().UTC()
+	active, err := store.CreateSilence(models.Silence{Tag: "prod", Comment: "db migration", CreatedBy: "alice", StartsAt: now, EndsAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	upcoming, _ := store.CreateSilence(models.Silence{AgentID: "a1", Comment: "reboot", StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour)})
+	store.CreateSilence(models.Silence{RuleID: 1, Comment: "over", StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour)})
+
+	list, err := store.ListSilences(now)
+	if err != nil || len(list) != 2 || list[0].ID != active.ID || list[1].ID != upcoming.ID {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+	if !list[0].Active(now) || list[1].Active(now) || list[0].Tag != "prod" || list[0].CreatedBy != "alice" {
+		t.Errorf("unexpected silences %+v", list)
+	}
+
+	for _, id := range []int64{active.ID, upcoming.ID} {
+		if ok, err := store.ExpireSilence(id); !ok || err != nil {
+			t.Fatalf("expire %d: %v, %v", id, ok, err)
+		}
+	}
+	if ok, _ := store.ExpireSilence(active.ID); ok {
+		t.Error("expired twice")
+	}
+	later := time.Now().Add(time.Second)
+	if list, _ := store.ListSilences(later); len(list) != 0 {
+		t.Errorf("still listed after expiring: %+v", list)
+	}
+	if s, _ := store.GetSilence(upcoming.ID); s == nil || s.Active(later) || s.EndsAt.Before(s.StartsAt) {
+		t.Errorf("expired upcoming silence = %+v", s)
+	}
+
+	if n, err := store.PruneSilences(later); err != nil || n != 3 {
+		t.Errorf("prune = %d, %v", n, err)
+	}
+}
+
+func TestAcknowledgeAndResolve(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host"})
+	rule, _ := store.CreateAlertRule(models.AlertRuleRequest{Metric: "cpu_percent", Operator: ">", Threshold: 90, Severity: models.SeverityCritical})
+	a, _ := store.OpenAlert(rule.ID, "agent-1", "cpu high")
+	if a.Severity != models.SeverityCritical {
+		t.Errorf("severity = %q", a.Severity)
+	}
+
+	if ok, err := store.AcknowledgeAlert(a.ID, "alice", "looking"); !ok || err != nil {
+		t.Fatalf("acknowledge: %v, %v", ok, err)
+	}
+	a, _ = store.GetAlert(a.ID)
+	if a.Resolved || a.AcknowledgedBy != "alice" || a.AckComment != "looking" || a.AcknowledgedAt == nil {
+		t.Errorf("acknowledged alert = %+v", a)
+	}
+
+	if ok, err := store.ResolveAlert(a.ID, "bob"); !ok || err != nil {
+		t.Fatalf("resolve: %v, %v", ok, err)
+	}
+	if ok, _ := store.ResolveAlert(a.ID, "bob"); ok {
+		t.Error("resolved twice")
+	}
+	if ok, _ := store.AcknowledgeAlert(a.ID, "alice", ""); ok {
+		t.Error("acknowledged a resolved alert")
+	}
+	a, _ = store.GetAlert(a.ID)
+	if !a.Resolved || a.ResolvedBy != "bob" || a.ResolvedAt == nil {
+		t.Errorf("resolved alert = %+v", a)
+	}
+}
+
+func TestAgentTags(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host"})
+	if a, _ := store.GetAgent("agent-1"); a == nil || a.Tags == nil || len(a.Tags) != 0 {
+		t.Fatalf("new agent tags = %+v", a)
+	}
+	if err := store.SetAgentTags("agent-1", []string{"prod", "db"}); err != nil {
+		t.Fatal(err)
+	}
+	// Heartbeats keep the tags
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host"})
+	a, _ := store.GetAgent("agent-1")
+	if !a.HasTag("prod") || !a.HasTag("db") || len(a.Tags) != 2 {
+		t.Errorf("tags = %v", a.Tags)
+	}
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"strings"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// conn is the database handle of a Store. For a Store from WithContext whose
+// context holds a span, every statement and transaction gets a child span
+// named after the Store method that ran it, e.g. db.CreateCommand.
+type conn struct {
+	*sql.DB
+	ctx context.Context
+}
+
+// WithContext returns a Store whose queries are traced as part of ctx's
+// trace. Cancelling ctx doesn't abort them: a command must not be left
+// half-stored because the client went away.
+func (s *Store) WithContext(ctx context.Context) *Store {
+	return &Store{db: conn{DB: s.db.DB, ctx: context.WithoutCancel(ctx)}}
+}
+
+// start begins a span for a statement, or returns a nil span when the
+// Store's context isn't part of a trace.
+func (c conn) start(query string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(c.ctx).IsValid() {
+		return c.ctx, nil
+	}
+	return tracing.Tracer().Start(c.ctx, "db."+storeMethod(), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemNameSQLite, semconv.DBQueryText(strings.Join(strings.Fields(query), " "))))
+}
+
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil && err != sql.ErrNoRows && err != sql.ErrTxDone {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (c conn) Exec(query string, args ...any) (sql.Result, error) {
+	ctx, span := c.start(query)
+	res, err := c.DB.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+func (c conn) Query(query string, args ...any) (*sql.Rows, error) {
+	ctx, span := c.start(query)
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (c conn) QueryRow(query string, args ...any) *sql.Row {
+	ctx, span := c.start(query)
+	row := c.DB.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+// Begin starts a transaction traced as one span, ended by Commit or
+// Rollback.
+func (c conn) Begin() (*txConn, error) {
+	ctx, span := c.start("BEGIN")
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &txConn{Tx: tx, span: span}, nil
+}
+
+type txConn struct {
+	*sql.Tx
+	span trace.Span
+}
+
+func (t *txConn) Commit() error {
+	err := t.Tx.Commit()
+	endSpan(t.span, err)
+	return err
+}
+
+func (t *txConn) Rollback() error {
+	err := t.Tx.Rollback()
+	endSpan(t.span, err)
+	return err
+}
+
+// storeMethod names the Store method that called into conn, e.g.
+// CreateCommand for github.com/.../db.(*Store).CreateCommand, or for a
+// closure inside it.
+func storeMethod() string {
+	pc, _, _, ok := runtime.Caller(3)
+	if !ok {
+		return "query"
+	}
+	parts := strings.Split(runtime.FuncForPC(pc).Name(), ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		if !strings.HasPrefix(parts[i], "func") {
+			return parts[i]
+		}
+	}
+	return parts[len(parts)-1]
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStoreTracing(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.Install(sdktrace.WithSyncer(exporter), "test", "dev", 1)
+	defer tp.Shutdown(context.Background())
+
+	// Outside a trace nothing is recorded
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host"})
+	if n := len(exporter.GetSpans()); n != 0 {
+		t.Fatalf("expected no spans without a trace, got %d", n)
+	}
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "request")
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	traced := store.WithContext(cancelled)
+	cmd, err := traced.CreateCommand("agent-1", "uptime", 0)
+	if err != nil {
+		t.Fatalf("a cancelled request must not abort queries: %v", err)
+	}
+	traced.GetCommand(cmd.ID)
+	traced.InsertSamples("agent-1", models.Metric{CPUPercent: 1}.Samples())
+	parent.End()
+
+	spans := exporter.GetSpans()
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+		if s.Name != "request" && s.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the request span", s.Name)
+		}
+	}
+	want := []string{"db.CreateCommand", "db.GetCommand", "db.InsertSamples", "request"}
+	if len(names) != len(want) {
+		t.Fatalf("spans = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("spans = %v, want %v", names, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// ---- Two-Factor Authentication ----
+
+// SetTOTPSecret stores a new secret for a user who is setting up 2FA. It
+// only takes effect once EnableTOTP confirms the user can produce codes.
+func (s *Store) SetTOTPSecret(userID int64, secret string) error {
+	_, err := s.db.Exec(`UPDATE users SET totp_secret=?, totp_enabled=0, totp_last_step=0 WHERE id=?`, secret, userID)
+	return err
+}
+
+func (s *Store) EnableTOTP(userID, step int64) error {
+	_, err := s.db.Exec(`UPDATE users SET totp_enabled=1, totp_last_step=? WHERE id=? AND totp_secret != ''`, step, userID)
+	return err
+}
+
+// DisableTOTP removes a user's secret and recovery codes.
+func (s *Store) DisableTOTP(userID int64) error {
+	if _, err := s.db.Exec(`UPDATE users SET totp_secret='', totp_enabled=0, totp_last_step=0 WHERE id=?`, userID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM recovery_codes WHERE user_id=?`, userID)
+	return err
+}
+
+// UseTOTPStep records step as the user's last accepted code. It reports
+// false if that step (or a later one) was used already, so concurrent logins
+// cannot replay the same code.
+func (s *Store) UseTOTPStep(userID, step int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE users SET totp_last_step=? WHERE id=? AND totp_last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// ReplaceRecoveryCodes stores a fresh set of recovery codes, invalidating
+// the previous ones.
+func (s *Store) ReplaceRecoveryCodes(userID int64, codes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id=?`, userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hashToken(normalizeRecoveryCode(code))); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode consumes an unused recovery code of the user.
+func (s *Store) UseRecoveryCode(userID int64, code string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE recovery_codes SET used_at=? WHERE user_id=? AND code_hash=? AND used_at IS NULL`,
+		time.Now().UTC(), userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *Store) CountRecoveryCodes(userID int64) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id=? AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
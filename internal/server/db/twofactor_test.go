@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestTwoFactor(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.CreateUser("alice", "hash", models.RoleAdmin)
+	user, _ := store.GetUserByUsername("alice")
+
+	store.SetTOTPSecret(user.ID, "SECRET")
+	user, _ = store.GetUser(user.ID)
+	if user.TOTPEnabled || user.TOTPSecret != "SECRET" {
+		t.Fatalf("secret must be pending until confirmed, got %+v", user)
+	}
+	store.EnableTOTP(user.ID, 100)
+	user, _ = store.GetUser(user.ID)
+	if !user.TOTPEnabled || user.TOTPLastStep != 100 {
+		t.Fatalf("expected 2FA enabled at step 100, got %+v", user)
+	}
+
+	if ok, _ := store.UseTOTPStep(user.ID, 100); ok {
+		t.Error("a used step must not be accepted again")
+	}
+	if ok, _ := store.UseTOTPStep(user.ID, 101); !ok {
+		t.Error("expected a new step to be accepted")
+	}
+
+	if err := store.ReplaceRecoveryCodes(user.ID, []string{"abcde-fghij", "klmno-pqrst"}); err != nil {
+		t.Fatalf("recovery codes: %v", err)
+	}
+	if ok, _ := store.UseRecoveryCode(user.ID, "ABCDEFGHIJ"); !ok {
+		t.Error("recovery codes should ignore case and dashes")
+	}
+	if ok, _ := store.UseRecoveryCode(user.ID, "abcde-fghij"); ok {
+		t.Error("a recovery code must work only once")
+	}
+	if n, _ := store.CountRecoveryCodes(user.ID); n != 1 {
+		t.Errorf("expected 1 unused code, got %d", n)
+	}
+
+	store.DisableTOTP(user.ID)
+	user, _ = store.GetUser(user.ID)
+	if user.TOTPEnabled || user.TOTPSecret != "" {
+		t.Errorf("expected 2FA off, got %+v", user)
+	}
+	if n, _ := store.CountRecoveryCodes(user.ID); n != 0 {
+		t.Errorf("recovery codes must be removed with 2FA, got %d", n)
+	}
+
+	if s, _ := store.GetSettings(); s.Require2FA {
+		t.Error("2FA must not be required by default")
+	}
+	store.SetRequire2FA(true)
+	if s, _ := store.GetSettings(); !s.Require2FA {
+		t.Error("expected 2FA to be required")
+	}
+}
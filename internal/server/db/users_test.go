@@ -0,0 +1,168 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+func TestUserRolesAndDisable(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	if err := store.CreateUser("root", "hash", models.RoleAdmin); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	if err := store.CreateUser("ops", "hash", models.RoleOperator); err != nil {
+		t.Fatalf("create operator: %v", err)
+	}
+	ops, _ := store.GetUserByUsername("ops")
+	if ops == nil || ops.Role != models.RoleOperator || ops.Disabled {
+		t.Fatalf("unexpected user %+v", ops)
+	}
+
+	if n, _ := store.CountActiveAdmins(); n != 1 {
+		t.Errorf("expected 1 admin, got %d", n)
+	}
+	store.UpdateUserRole(ops.ID, models.RoleAdmin)
+	if n, _ := store.CountActiveAdmins(); n != 2 {
+		t.Errorf("expected 2 admins, got %d", n)
+	}
+
+	store.CreateSession(&models.Session{Token: "tok", CSRFToken: "csrf", UserID: ops.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if u, _ := store.GetUserBySession("tok"); u == nil || u.Role != models.RoleAdmin {
+		t.Fatalf("expected session user with admin role, got %+v", u)
+	}
+	if err := store.SetUserDisabled(ops.ID, true); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	if u, _ := store.GetUserBySession("tok"); u != nil {
+		t.Error("disabled user must not keep a session")
+	}
+	if n, _ := store.CountActiveAdmins(); n != 1 {
+		t.Errorf("disabled admin must not count, got %d", n)
+	}
+
+	if err := store.DeleteUser(ops.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	users, _ := store.ListUsers()
+	if len(users) != 1 || users[0].Username != "root" {
+		t.Errorf("unexpected users %+v", users)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.CreateUser("alice", "hash", models.RoleViewer)
+	user, _ := store.GetUserByUsername("alice")
+
+	for i := 0; i < 2; i++ {
+		if until, err := store.RecordLoginFailure(user.ID, 3, time.Minute); err != nil || until != nil {
+			t.Fatalf("failure %d: unexpected lock %v %v", i+1, until, err)
+		}
+	}
+	user, _ = store.GetUser(user.ID)
+	if user.FailedLogins != 2 || user.Locked() {
+		t.Fatalf("expected 2 failures and no lock, got %+v", user)
+	}
+
+	until, err := store.RecordLoginFailure(user.ID, 3, time.Minute)
+	if err != nil || until == nil {
+		t.Fatalf("expected lockout on third failure, got %v %v", until, err)
+	}
+	user, _ = store.GetUser(user.ID)
+	if !user.Locked() || user.FailedLogins != 0 {
+		t.Fatalf("expected locked account with reset count, got %+v", user)
+	}
+
+	if err := store.UnlockUser(user.ID); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	user, _ = store.GetUser(user.ID)
+	if user.Locked() {
+		t.Error("expected account to be unlocked")
+	}
+
+	store.RecordLoginFailure(user.ID, 3, time.Minute)
+	store.ResetLoginFailures(user.ID)
+	if user, _ = store.GetUser(user.ID); user.FailedLogins != 0 {
+		t.Errorf("expected failures reset, got %d", user.FailedLogins)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	store.CreateUser("alice", "hash", models.RoleViewer)
+	user, _ := store.GetUserByUsername("alice")
+
+	expires := time.Now().Add(time.Hour)
+	first := &models.Session{Token: "one", CSRFToken: "c1", UserID: user.ID, IP: "10.0.0.1", ExpiresAt: expires}
+	second := &models.Session{Token: "two", CSRFToken: "c2", UserID: user.ID, ExpiresAt: expires}
+	store.CreateSession(first)
+	store.CreateSession(second)
+	store.CreateSession(&models.Session{Token: "legacy", UserID: user.ID, ExpiresAt: expires})
+
+	sess, u, err := store.GetSession("one", time.Hour)
+	if err != nil || sess == nil || u == nil || sess.CSRFToken != "c1" || sess.IP != "10.0.0.1" {
+		t.Fatalf("expected session one, got %+v %v", sess, err)
+	}
+	if sess, _, _ := store.GetSession("legacy", 0); sess != nil {
+		t.Error("sessions without a CSRF token must be rejected")
+	}
+	if list, _ := store.ListUserSessions(user.ID); len(list) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(list))
+	}
+
+	store.db.Exec(`UPDATE sessions SET last_seen_at=? WHERE token='two'`, time.Now().UTC().Add(-2*time.Hour))
+	if sess, _, _ := store.GetSession("two", time.Hour); sess != nil {
+		t.Error("idle session must be rejected")
+	}
+	if list, _ := store.ListUserSessions(user.ID); len(list) != 1 {
+		t.Errorf("idle session should be deleted, got %d sessions", len(list))
+	}
+
+	third := &models.Session{Token: "three", CSRFToken: "c3", UserID: user.ID, ExpiresAt: expires}
+	store.CreateSession(third)
+	if ok, _ := store.DeleteSessionByID(user.ID+1, third.ID); ok {
+		t.Error("a user must not revoke another user's session")
+	}
+	if ok, _ := store.DeleteSessionByID(user.ID, third.ID); !ok {
+		t.Error("expected session to be revoked")
+	}
+
+	store.CreateSession(&models.Session{Token: "four", CSRFToken: "c4", UserID: user.ID, ExpiresAt: expires})
+	if n, _ := store.DeleteOtherSessions(user.ID, first.ID); n != 2 {
+		t.Errorf("expected 2 other sessions revoked (including legacy), got %d", n)
+	}
+	if list, _ := store.ListUserSessions(user.ID); len(list) != 1 || list[0].ID != first.ID {
+		t.Errorf("only the kept session should remain, got %+v", list)
+	}
+}
+
+func TestOIDCUsers(t *testing.T) {
+	store := setupTestDB(t)
+	defer store.Close()
+
+	user, err := store.CreateOIDCUser("carol", models.RoleOperator, "https://idp", "sub-1")
+	if err != nil || user == nil || !user.SSO() || user.Role != models.RoleOperator {
+		t.Fatalf("expected SSO operator, got %+v %v", user, err)
+	}
+	if user.PasswordHash != "" {
+		t.Error("SSO users must not have a password")
+	}
+	if u, _ := store.GetUserByOIDC("https://idp", "sub-1"); u == nil || u.ID != user.ID {
+		t.Errorf("expected lookup by subject, got %+v", u)
+	}
+	if u, _ := store.GetUserByOIDC("https://other-idp", "sub-1"); u != nil {
+		t.Error("the same subject at another issuer is a different user")
+	}
+	if _, err := store.CreateOIDCUser("carol", models.RoleViewer, "https://idp", "sub-2"); err == nil {
+		t.Error("usernames must stay unique")
+	}
+}
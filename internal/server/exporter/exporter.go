@@ -0,0 +1,158 @@
+// Package exporter serves fleet and server metrics in the Prometheus text
+// format on /metrics.
+package exporter
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+)
+
+// Config enables the exporter and bounds how many series it exposes.
+type Config struct {
+	Enabled bool
+	// Token is the bearer token scrapers must send; empty leaves /metrics open.
+	Token string
+	// PerAgent exposes gauges for every agent. Without it only fleet totals
+	// are exposed, whatever the number of agents.
+	PerAgent bool
+	// MaxAgents caps the agents with per-agent gauges, by ID order; the rest
+	// only count towards the totals. 0 means no cap.
+	MaxAgents int
+}
+
+type Exporter struct {
+	cfg   Config
+	store *db.Store
+	hub   *ws.Hub
+	http  *httpMetrics
+}
+
+func New(store *db.Store, hub *ws.Hub, cfg Config) *Exporter {
+	return &Exporter{cfg: cfg, store: store, hub: hub, http: newHTTPMetrics()}
+}
+
+// ServeHTTP writes the current metrics for a scrape.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e.cfg.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(e.cfg.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	agents, err := e.store.ListAgents()
+	if err != nil {
+		slog.Error("list agents failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	commands, err := e.store.CountCommandsByStatus()
+	if err != nil {
+		slog.Error("count commands failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	activeAlerts, err := e.store.CountActiveAlerts()
+	if err != nil {
+		slog.Error("count alerts failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	var metrics map[string]*models.Metric
+	if e.cfg.PerAgent {
+		if metrics, err = e.store.GetFleetMetrics(); err != nil {
+			slog.Error("get fleet metrics failed", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	t := newTextWriter(w)
+
+	byStatus := map[string]int{string(models.AgentOnline): 0, string(models.AgentOffline): 0}
+	byVersion := make(map[string]int)
+	for _, a := range agents {
+		byStatus[string(a.Status)]++
+		byVersion[a.Version]++
+	}
+	t.gaugeBy("rmm_agents", "Number of agents by status.", "status", byStatus)
+	t.gaugeBy("rmm_agent_versions", "Number of agents by agent version.", "version", byVersion)
+	t.gauge("rmm_agents_connected", "Agents with an open WebSocket connection to this server.", float64(len(e.hub.ConnectedAgents())))
+
+	cmdCounts := make(map[string]int, len(commands))
+	for status, n := range commands {
+		cmdCounts[string(status)] = n
+	}
+	t.gaugeBy("rmm_commands", "Number of stored commands by status.", "status", cmdCounts)
+	t.gauge("rmm_alerts_active", "Number of unresolved alerts.", float64(activeAlerts))
+
+	if e.cfg.PerAgent {
+		// ListAgents sorts by display name; the cap keeps agents by ID so the
+		// same ones stay in when names change.
+		shown := agents
+		if e.cfg.MaxAgents > 0 && len(shown) > e.cfg.MaxAgents {
+			shown = append([]models.Agent(nil), agents...)
+			sort.Slice(shown, func(i, j int) bool { return shown[i].ID < shown[j].ID })
+			shown = shown[:e.cfg.MaxAgents]
+		}
+		t.gauge("rmm_exporter_agents_dropped", "Agents left out of the per-agent metrics by the agent cap.", float64(len(agents)-len(shown)))
+		e.writeAgents(t, shown, metrics)
+	}
+
+	e.http.write(t)
+	if err := t.flush(); err != nil {
+		slog.Debug("write metrics failed", "error", err)
+	}
+}
+
+func (e *Exporter) writeAgents(t *textWriter, agents []models.Agent, metrics map[string]*models.Metric) {
+	t.family("rmm_agent_info", "gauge", "Agent details; always 1.")
+	for _, a := range agents {
+		t.sample("rmm_agent_info", 1,
+			label{"agent", a.ID}, label{"name", a.Name()}, label{"hostname", a.Hostname}, label{"os", a.OS}, label{"version", a.Version})
+	}
+
+	t.family("rmm_agent_up", "gauge", "Whether the agent is online (1) or offline (0).")
+	for _, a := range agents {
+		up := 0.0
+		if a.Status == models.AgentOnline {
+			up = 1
+		}
+		t.sample("rmm_agent_up", up, label{"agent", a.ID})
+	}
+
+	t.family("rmm_agent_last_heartbeat_age_seconds", "gauge", "Seconds since the agent's last heartbeat.")
+	now := time.Now()
+	for _, a := range agents {
+		if !a.LastHeartbeat.IsZero() {
+			t.sample("rmm_agent_last_heartbeat_age_seconds", now.Sub(a.LastHeartbeat).Seconds(), label{"agent", a.ID})
+		}
+	}
+
+	for _, g := range []struct {
+		name, help string
+		value      func(*models.Metric) float64
+	}{
+		{"rmm_agent_cpu_percent", "CPU usage in the agent's latest heartbeat.", func(m *models.Metric) float64 { return m.CPUPercent }},
+		{"rmm_agent_memory_percent", "Memory usage in the agent's latest heartbeat.", func(m *models.Metric) float64 { return m.MemoryPercent }},
+		{"rmm_agent_disk_percent", "Root filesystem usage in the agent's latest heartbeat.", func(m *models.Metric) float64 { return m.DiskPercent }},
+	} {
+		t.family(g.name, "gauge", g.help)
+		for _, a := range agents {
+			if m := metrics[a.ID]; m != nil {
+				t.sample(g.name, g.value(m), label{"agent", a.ID})
+			}
+		}
+	}
+}
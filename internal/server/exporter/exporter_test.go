@@ -0,0 +1,89 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/ws"
+	"github.com/go-chi/chi/v5"
+)
+
+func scrape(t *testing.T, e *Exporter, token string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestExporter(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "web-2", Hostname: "web2", OS: "linux", Version: "1.2.0"})
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "web-1", Hostname: `we"b1`, OS: "linux", Version: "1.2.0"})
+	store.InsertSamples("web-1", models.Metric{CPUPercent: 12.5, MemoryPercent: 40, DiskPercent: 70}.Samples())
+	store.CreateCommand("web-1", "uptime", 0)
+
+	e := New(store, ws.NewHub(store), Config{Enabled: true, Token: "s3cret", PerAgent: true, MaxAgents: 1})
+
+	// Requests are timed by route pattern, not path
+	r := chi.NewRouter()
+	r.Use(e.Middleware)
+	r.Get("/api/v1/agents/{id}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) })
+	for _, id := range []string{"a", "b"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/agents/"+id, nil))
+	}
+
+	if code, _ := scrape(t, e, ""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", code)
+	}
+	if code, _ := scrape(t, e, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with a wrong token, got %d", code)
+	}
+	code, body := scrape(t, e, "s3cret")
+	if code != http.StatusOK {
+		t.Fatalf("scrape: %d %s", code, body)
+	}
+
+	for _, want := range []string{
+		`rmm_agents{status="online"} 2`,
+		`rmm_agents{status="offline"} 0`,
+		`rmm_agent_versions{version="1.2.0"} 2`,
+		`rmm_agents_connected 0`,
+		`rmm_commands{status="pending"} 1`,
+		`rmm_alerts_active 0`,
+		`rmm_exporter_agents_dropped 1`,
+		`rmm_agent_info{agent="web-1",name="we\"b1",hostname="we\"b1",os="linux",version="1.2.0"} 1`,
+		`rmm_agent_up{agent="web-1"} 1`,
+		`rmm_agent_cpu_percent{agent="web-1"} 12.5`,
+		`rmm_agent_disk_percent{agent="web-1"} 70`,
+		`rmm_http_request_duration_seconds_bucket{method="GET",route="/api/v1/agents/{id}",code="404",le="+Inf"} 2`,
+		`rmm_http_request_duration_seconds_count{method="GET",route="/api/v1/agents/{id}",code="404"} 2`,
+		"# TYPE rmm_http_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+	// The cap keeps agents by ID
+	if strings.Contains(body, `agent="web-2"`) {
+		t.Error("web-2 should be over the agent cap")
+	}
+
+	e.cfg.PerAgent = false
+	_, body = scrape(t, e, "s3cret")
+	if strings.Contains(body, "rmm_agent_up") || !strings.Contains(body, `rmm_agents{status="online"} 2`) {
+		t.Errorf("expected fleet totals only:\n%s", body)
+	}
+}
@@ -0,0 +1,113 @@
+package exporter
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// latencyBuckets are the upper bounds of the request duration histogram, in
+// seconds (the Prometheus client defaults).
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// requestKey identifies one histogram series. Routes are chi patterns like
+// /api/v1/agents/{id}, so agent IDs and paths don't multiply the series.
+type requestKey struct {
+	method, route, code string
+}
+
+type histogram struct {
+	buckets []uint64 // non-cumulative counts per bucket; the last is +Inf
+	sum     float64
+}
+
+// httpMetrics is the request duration histogram by method, route and status.
+type httpMetrics struct {
+	mu    sync.Mutex
+	byKey map[requestKey]*histogram
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{byKey: make(map[requestKey]*histogram)}
+}
+
+func (m *httpMetrics) observe(key requestKey, seconds float64) {
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.byKey[key]
+	if h == nil {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets)+1)}
+		m.byKey[key] = h
+	}
+	h.buckets[i]++
+	h.sum += seconds
+}
+
+// Middleware times every request. WebSocket upgrades are left out: they
+// last as long as the connection.
+func (e *Exporter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		e.http.observe(requestKey{r.Method, route, strconv.Itoa(status)}, time.Since(start).Seconds())
+	})
+}
+
+func (m *httpMetrics) write(t *textWriter) {
+	const name = "rmm_http_request_duration_seconds"
+	t.family(name, "histogram", "Duration of HTTP requests by method, route pattern and status code.")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]requestKey, 0, len(m.byKey))
+	for k := range m.byKey {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+
+	for _, k := range keys {
+		h := m.byKey[k]
+		labels := []label{{"method", k.method}, {"route", k.route}, {"code", k.code}}
+		var cumulative uint64
+		for i, n := range h.buckets {
+			cumulative += n
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = formatValue(latencyBuckets[i])
+			}
+			t.sample(name+"_bucket", float64(cumulative), append(labels, label{"le", le})...)
+		}
+		t.sample(name+"_sum", h.sum, labels...)
+		t.sample(name+"_count", float64(cumulative), labels...)
+	}
+}
@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// label is one name="value" pair of a sample.
+type label struct {
+	name, value string
+}
+
+// textWriter writes the Prometheus text exposition format (version 0.0.4).
+type textWriter struct {
+	w *bufio.Writer
+}
+
+func newTextWriter(w io.Writer) *textWriter {
+	return &textWriter{w: bufio.NewWriter(w)}
+}
+
+// family starts a metric family; its samples must follow before the next one.
+func (t *textWriter) family(name, typ, help string) {
+	t.w.WriteString("# HELP " + name + " " + helpEscaper.Replace(help) + "\n")
+	t.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func (t *textWriter) sample(name string, value float64, labels ...label) {
+	t.w.WriteString(name)
+	if len(labels) > 0 {
+		t.w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				t.w.WriteByte(',')
+			}
+			t.w.WriteString(l.name + `="` + valueEscaper.Replace(l.value) + `"`)
+		}
+		t.w.WriteByte('}')
+	}
+	t.w.WriteString(" " + formatValue(value) + "\n")
+}
+
+// gauge writes a family with a single unlabelled sample.
+func (t *textWriter) gauge(name, help string, value float64) {
+	t.family(name, "gauge", help)
+	t.sample(name, value)
+}
+
+// gaugeBy writes a family with one sample per key of counts, labelled by key
+// and sorted for stable output.
+func (t *textWriter) gaugeBy(name, help, labelName string, counts map[string]int) {
+	t.family(name, "gauge", help)
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		t.sample(name, float64(counts[k]), label{labelName, k})
+	}
+}
+
+func (t *textWriter) flush() error {
+	return t.w.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,266 @@
+// Package notify tells people about alerts: it sends firing and resolved
+// alerts to webhook, email and chat channels, retries failed deliveries with
+// backoff and logs every delivery in the store.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+)
+
+const (
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = 10 * time.Second
+	maxBackoff         = 5 * time.Minute
+	sendTimeout        = 15 * time.Second
+)
+
+// Event is an alert that fired or resolved.
+type Event struct {
+	Kind  models.AlertEvent
+	Alert models.Alert
+	Rule  models.AlertRule
+	Agent models.Agent
+}
+
+// sender delivers an event over one channel type.
+type sender func(ctx context.Context, n *Notifier, ch models.NotificationChannel, ev Event, deliveryID int64) error
+
+var senders = map[models.ChannelType]sender{
+	models.ChannelWebhook: sendWebhook,
+	models.ChannelSlack:   sendChat,
+	models.ChannelTeams:   sendChat,
+	models.ChannelDiscord: sendChat,
+	models.ChannelEmail:   sendEmail,
+}
+
+// permanentError is a failure retrying won't fix, e.g. a webhook URL that
+// returns 404.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+type Notifier struct {
+	// MaxAttempts is how often a delivery is tried before it is logged as
+	// failed. The first retry waits Backoff, each further one twice as long.
+	MaxAttempts int
+	Backoff     time.Duration
+
+	store  *db.Store
+	client *http.Client
+	wg     sync.WaitGroup
+	// stopped is cancelled by Stop; deliveries waiting for a retry then
+	// stay pending for Resume.
+	stopped context.Context
+	stop    context.CancelFunc
+}
+
+func New(store *db.Store) *Notifier {
+	stopped, stop := context.WithCancel(context.Background())
+	return &Notifier{
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+		store:       store,
+		client:      &http.Client{Timeout: sendTimeout},
+		stopped:     stopped,
+		stop:        stop,
+	}
+}
+
+// Notify sends ev to every enabled channel that routes its rule, unless a
+// silence matches it. Deliveries run in the background.
+func (n *Notifier) Notify(ev Event) {
+	if silence, err := n.silenced(ev); err != nil {
+		slog.Error("list silences failed", "error", err)
+	} else if silence != nil {
+		slog.Info("notification silenced", "alert", ev.Alert.ID, "event", ev.Kind, "silence", silence.ID)
+		return
+	}
+	channels, err := n.store.ListNotificationChannels()
+	if err != nil {
+		slog.Error("list notification channels failed", "error", err)
+		return
+	}
+	for _, ch := range channels {
+		if !ch.Routes(ev.Rule) {
+			continue
+		}
+		id, err := n.store.CreateDelivery(ch.ID, ev.Alert.ID, ev.Kind)
+		if err != nil {
+			slog.Error("log notification delivery failed", "channel", ch.ID, "error", err)
+			continue
+		}
+		n.start(ch, ev, id, 1)
+	}
+}
+
+// start delivers in the background from the given attempt on. Once the
+// notifier is stopped nothing is started; the delivery stays pending.
+func (n *Notifier) start(ch models.NotificationChannel, ev Event, id int64, attempt int) {
+	if n.stopped.Err() != nil {
+		return
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.deliver(ch, ev, id, attempt)
+	}()
+}
+
+// Resume restarts the deliveries a previous run left pending, such as those
+// waiting for a retry when the server stopped. Deliveries whose channel or
+// alert is gone are logged as failed.
+func (n *Notifier) Resume() {
+	pending, err := n.store.PendingDeliveries()
+	if err != nil {
+		slog.Error("list pending deliveries failed", "error", err)
+		return
+	}
+	for _, d := range pending {
+		ch, ev, err := n.reload(d)
+		if err != nil {
+			if err := n.store.UpdateDelivery(d.ID, models.DeliveryFailed, d.Attempts, err.Error()); err != nil {
+				slog.Error("log notification delivery failed", "delivery", d.ID, "error", err)
+			}
+			continue
+		}
+		n.start(*ch, ev, d.ID, d.Attempts+1)
+	}
+	if len(pending) > 0 {
+		slog.Info("resumed pending notifications", "count", len(pending))
+	}
+}
+
+// reload rebuilds the channel and event of a logged delivery.
+func (n *Notifier) reload(d models.NotificationDelivery) (*models.NotificationChannel, Event, error) {
+	ev := Event{Kind: d.Event}
+	if d.AlertID == 0 {
+		return nil, ev, errors.New("interrupted by a server restart")
+	}
+	ch, err := n.store.GetNotificationChannel(d.ChannelID)
+	if err != nil {
+		return nil, ev, err
+	}
+	if ch == nil || !ch.Enabled {
+		return nil, ev, errors.New("channel deleted or disabled")
+	}
+	alert, err := n.store.GetAlert(d.AlertID)
+	if err != nil {
+		return nil, ev, err
+	}
+	if alert == nil {
+		return nil, ev, errors.New("alert deleted")
+	}
+	ev.Alert = *alert
+
+	// Rules and agents may have been deleted since; the alert keeps enough
+	// of them to format the message
+	ev.Rule = models.AlertRule{ID: alert.RuleID, Type: alert.RuleType, Severity: alert.Severity, Metric: alert.Metric}
+	if rule, err := n.store.GetAlertRule(alert.RuleID); err == nil && rule != nil {
+		ev.Rule = *rule
+	}
+	ev.Agent = models.Agent{ID: alert.AgentID}
+	if agent, err := n.store.GetAgent(alert.AgentID); err == nil && agent != nil {
+		ev.Agent = *agent
+	}
+	return ch, ev, nil
+}
+
+// silenced returns the active silence matching ev, if any.
+func (n *Notifier) silenced(ev Event) (*models.Silence, error) {
+	now := time.Now()
+	silences, err := n.store.ListSilences(now)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range silences {
+		if s.Active(now) && s.Matches(ev.Agent, ev.Rule.ID) {
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
+
+// Test sends a test notification to ch once and returns the outcome.
+func (n *Notifier) Test(ch models.NotificationChannel) error {
+	ev := Event{
+		Kind:  models.EventTest,
+		Alert: models.Alert{Message: "Test notification for channel " + ch.Name, CreatedAt: time.Now().UTC()},
+	}
+	id, err := n.store.CreateDelivery(ch.ID, 0, ev.Kind)
+	if err != nil {
+		return err
+	}
+	sendErr := n.attempt(ch, ev, id)
+	status, errMsg := models.DeliverySent, ""
+	if sendErr != nil {
+		status, errMsg = models.DeliveryFailed, sendErr.Error()
+	}
+	if err := n.store.UpdateDelivery(id, status, 1, errMsg); err != nil {
+		slog.Error("log notification delivery failed", "delivery", id, "error", err)
+	}
+	return sendErr
+}
+
+// Wait blocks until the deliveries in progress are done, retries included.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// Stop cancels the retries waiting for their turn and waits for the attempts
+// in flight. Deliveries not sent yet stay pending for Resume.
+func (n *Notifier) Stop() {
+	n.stop()
+	n.wg.Wait()
+}
+
+func (n *Notifier) deliver(ch models.NotificationChannel, ev Event, id int64, first int) {
+	backoff := n.Backoff
+	for attempt := first; ; attempt++ {
+		err := n.attempt(ch, ev, id)
+		if err == nil {
+			if err := n.store.UpdateDelivery(id, models.DeliverySent, attempt, ""); err != nil {
+				slog.Error("log notification delivery failed", "delivery", id, "error", err)
+			}
+			return
+		}
+
+		var permanent *permanentError
+		status := models.DeliveryPending
+		if attempt >= n.MaxAttempts || errors.As(err, &permanent) {
+			status = models.DeliveryFailed
+		}
+		if err := n.store.UpdateDelivery(id, status, attempt, err.Error()); err != nil {
+			slog.Error("log notification delivery failed", "delivery", id, "error", err)
+		}
+		if status == models.DeliveryFailed {
+			slog.Warn("notification failed", "channel", ch.Name, "alert", ev.Alert.ID, "event", ev.Kind, "attempts", attempt, "error", err)
+			return
+		}
+		select {
+		case <-n.stopped.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (n *Notifier) attempt(ch models.NotificationChannel, ev Event, id int64) error {
+	send, ok := senders[ch.Type]
+	if !ok {
+		return &permanentError{fmt.Errorf("unknown channel type %q", ch.Type)}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	return send(ctx, n, ch, ev, id)
+}
@@ -0,0 +1,476 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+)
+
+func setup(t *testing.T) (*db.Store, *Notifier) {
+	t.Helper()
+	// Deliveries are logged from several goroutines, so use a file rather
+	// than :memory:, which is per connection
+	store, err := db.New(filepath.Join(t.TempDir(), "rmm.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	n := New(store)
+	n.Backoff = time.Millisecond
+	n.MaxAttempts = 3
+	return store, n
+}
+
+func addChannel(t *testing.T, store *db.Store, req models.NotificationChannelRequest) models.NotificationChannel {
+	t.Helper()
+	ch, err := store.CreateNotificationChannel(req)
+	if err != nil {
+		t.Fatalf("create channel: %v", err)
+	}
+	return *ch
+}
+
+func testEvent() Event {
+	return Event{
+		Kind:  models.EventFiring,
+		Alert: models.Alert{ID: 7, RuleID: 3, AgentID: "a1", Message: "cpu_percent > 90 (value: 95.00)", CreatedAt: time.Now().UTC()},
+		Rule:  models.AlertRule{ID: 3, Metric: "cpu_percent", Operator: ">", Threshold: 90},
+		Agent: models.Agent{ID: "a1", Hostname: "web-1"},
+	}
+}
+
+func deliveries(t *testing.T, store *db.Store) []models.NotificationDelivery {
+	t.Helper()
+	ds, err := store.ListDeliveries(100)
+	if err != nil {
+		t.Fatalf("list deliveries: %v", err)
+	}
+	return ds
+}
+
+func TestWebhookSignedAndRetried(t *testing.T) {
+	store, n := setup(t)
+
+	var calls atomic.Int32
+	var body []byte
+	var sig, event string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		sig = r.Header.Get(SignatureHeader)
+		event = r.Header.Get("X-RMM-Event")
+	}))
+	defer srv.Close()
+
+	addChannel(t, store, models.NotificationChannelRequest{
+		Name: "hook", Type: models.ChannelWebhook,
+		Config: models.ChannelConfig{URL: srv.URL, Secret: "s3cret"},
+	})
+	n.Notify(testEvent())
+	n.Wait()
+
+	if calls.Load() != 2 {
+		t.Fatalf("calls = %d, want 2", calls.Load())
+	}
+	if sig != Sign("s3cret", body) {
+		t.Errorf("signature %q does not match body", sig)
+	}
+	if event != "firing" {
+		t.Errorf("X-RMM-Event = %q", event)
+	}
+	var payload struct {
+		Event string       `json:"event"`
+		Alert models.Alert `json:"alert"`
+		Agent struct {
+			Hostname string `json:"hostname"`
+		} `json:"agent"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("payload: %v", err)
+	}
+	if payload.Event != "firing" || payload.Alert.ID != 7 || payload.Agent.Hostname != "web-1" {
+		t.Errorf("unexpected payload %s", body)
+	}
+
+	ds := deliveries(t, store)
+	if len(ds) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(ds))
+	}
+	if d := ds[0]; d.Status != models.DeliverySent || d.Attempts != 2 || d.AlertID != 7 || d.ChannelName != "hook" {
+		t.Errorf("delivery = %+v", d)
+	}
+}
+
+func TestPermanentFailureNotRetried(t *testing.T) {
+	store, n := setup(t)
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	addChannel(t, store, models.NotificationChannelRequest{Name: "gone", Type: models.ChannelSlack, Config: models.ChannelConfig{URL: srv.URL}})
+	n.Notify(testEvent())
+	n.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+	ds := deliveries(t, store)
+	if len(ds) != 1 || ds[0].Status != models.DeliveryFailed || ds[0].Attempts != 1 || ds[0].Error == "" {
+		t.Errorf("deliveries = %+v", ds)
+	}
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	store, n := setup(t)
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	addChannel(t, store, models.NotificationChannelRequest{Name: "down", Type: models.ChannelWebhook, Config: models.ChannelConfig{URL: srv.URL}})
+	n.Notify(testEvent())
+	n.Wait()
+
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+	ds := deliveries(t, store)
+	if len(ds) != 1 || ds[0].Status != models.DeliveryFailed || ds[0].Attempts != 3 {
+		t.Errorf("deliveries = %+v", ds)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	store, n := setup(t)
+
+	var got atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got.Add(1) }))
+	defer srv.Close()
+
+	off := false
+	cfg := models.ChannelConfig{URL: srv.URL}
+	addChannel(t, store, models.NotificationChannelRequest{Name: "all", Type: models.ChannelWebhook, Config: cfg})
+	addChannel(t, store, models.NotificationChannelRequest{Name: "rule 3", Type: models.ChannelWebhook, Config: cfg, RuleIDs: []int64{3}})
+	addChannel(t, store, models.NotificationChannelRequest{Name: "rule 4", Type: models.ChannelWebhook, Config: cfg, RuleIDs: []int64{4}})
+	addChannel(t, store, models.NotificationChannelRequest{Name: "disabled", Type: models.ChannelWebhook, Config: cfg, Enabled: &off})
+
+	n.Notify(testEvent())
+	n.Wait()
+
+	if got.Load() != 2 {
+		t.Errorf("got %d notifications, want 2", got.Load())
+	}
+	names := map[string]bool{}
+	for _, d := range deliveries(t, store) {
+		names[d.ChannelName] = true
+	}
+	if !names["all"] || !names["rule 3"] || len(names) != 2 {
+		t.Errorf("delivered to %v", names)
+	}
+}
+
+func TestChatPayloads(t *testing.T) {
+	store, n := setup(t)
+
+	bodies := make(chan map[string]string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]string
+		json.NewDecoder(r.Body).Decode(&m)
+		bodies <- m
+	}))
+	defer srv.Close()
+
+	for _, typ := range []models.ChannelType{models.ChannelSlack, models.ChannelTeams, models.ChannelDiscord} {
+		ch := addChannel(t, store, models.NotificationChannelRequest{Name: string(typ), Type: typ, Config: models.ChannelConfig{URL: srv.URL}})
+		if err := n.Test(ch); err != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		body := <-bodies
+		key := "text"
+		if typ == models.ChannelDiscord {
+			key = "content"
+		}
+		if !strings.HasPrefix(body[key], "[TEST] Test notification for channel "+string(typ)) {
+			t.Errorf("%s body = %v", typ, body)
+		}
+	}
+
+	for _, d := range deliveries(t, store) {
+		if d.Status != models.DeliverySent || d.Event != models.EventTest || d.AlertID != 0 {
+			t.Errorf("delivery = %+v", d)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"much too long", 10, "much to..."},
+		{"çğıöşüçğıöşü", 10, "çğıöşüç..."},
+	}
+	for _, c := range cases {
+		if got := truncate(c.s, c.n); got != c.want || !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q, want %q", c.s, c.n, got, c.want)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	store, n := setup(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type mail struct {
+		from string
+		to   []string
+		data string
+	}
+	received := make(chan mail, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+
+		var m mail
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); {
+			case cmd == "EHLO" || cmd == "HELO":
+				reply("250 localhost")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+				m.from = line[len("MAIL FROM:"):]
+				reply("250 OK")
+			case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				m.to = append(m.to, line[len("RCPT TO:"):])
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var b strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					b.WriteString(l)
+				}
+				m.data = b.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				received <- m
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	addChannel(t, store, models.NotificationChannelRequest{
+		Name: "ops mail", Type: models.ChannelEmail,
+		Config: models.ChannelConfig{
+			SMTPHost: "127.0.0.1", SMTPPort: port,
+			From: "Mini RMM <rmm@example.com>", To: []string{"ops@example.com", "Oncall <oncall@example.com>"},
+		},
+	})
+	ev := testEvent()
+	ev.Kind = models.EventResolved
+	resolved := time.Now().UTC()
+	ev.Alert.ResolvedAt = &resolved
+	n.Notify(ev)
+	n.Wait()
+
+	select {
+	case m := <-received:
+		if m.from != "<rmm@example.com>" {
+			t.Errorf("MAIL FROM %q", m.from)
+		}
+		if len(m.to) != 2 || m.to[0] != "<ops@example.com>" || m.to[1] != "<oncall@example.com>" {
+			t.Errorf("RCPT TO %q", m.to)
+		}
+		for _, want := range []string{"Subject: [Mini RMM] [RESOLVED] cpu_percent > 90", "Agent:    web-1 (a1)", "Resolved:"} {
+			if !strings.Contains(m.data, want) {
+				t.Errorf("message lacks %q:\n%s", want, m.data)
+			}
+		}
+	default:
+		t.Fatal("no mail received")
+	}
+
+	ds := deliveries(t, store)
+	if len(ds) != 1 || ds[0].Status != models.DeliverySent || ds[0].Event != models.EventResolved {
+		t.Errorf("deliveries = %+v", ds)
+	}
+}
+
+func TestSilenced(t *testing.T) {
+	store, n := setup(t)
+
+	var got atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got.Add(1) }))
+	defer srv.Close()
+	addChannel(t, store, models.NotificationChannelRequest{Name: "hook", Type: models.ChannelWebhook, Config: models.ChannelConfig{URL: srv.URL}})
+
+	now := time.Now()
+	store.CreateSilence(models.Silence{Tag: "prod", Comment: "maintenance", StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour)})
+	// Over, and not started yet
+	store.CreateSilence(models.Silence{RuleID: 3, Comment: "over", StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Hour)})
+	store.CreateSilence(models.Silence{AgentID: "a1", Comment: "later", StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour)})
+
+	ev := testEvent()
+	ev.Agent.Tags = []string{"prod"}
+	n.Notify(ev)
+	n.Wait()
+	if got.Load() != 0 || len(deliveries(t, store)) != 0 {
+		t.Fatalf("silenced alert was sent")
+	}
+
+	ev.Agent.Tags = []string{"staging"}
+	n.Notify(ev)
+	n.Wait()
+	if got.Load() != 1 {
+		t.Errorf("got %d notifications, want 1", got.Load())
+	}
+}
+
+func TestMinSeverity(t *testing.T) {
+	store, n := setup(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	cfg := models.ChannelConfig{URL: srv.URL}
+	addChannel(t, store, models.NotificationChannelRequest{Name: "pager", Type: models.ChannelWebhook, Config: cfg, MinSeverity: models.SeverityCritical})
+	addChannel(t, store, models.NotificationChannelRequest{Name: "all", Type: models.ChannelWebhook, Config: cfg})
+
+	ev := testEvent()
+	ev.Rule.Severity = models.SeverityWarning
+	n.Notify(ev)
+	ev.Rule.Severity = models.SeverityCritical
+	n.Notify(ev)
+	n.Wait()
+
+	count := map[string]int{}
+	for _, d := range deliveries(t, store) {
+		count[d.ChannelName]++
+	}
+	if count["pager"] != 1 || count["all"] != 2 {
+		t.Errorf("deliveries per channel = %v", count)
+	}
+}
+
+func TestStopAndResume(t *testing.T) {
+	store, n := setup(t)
+	n.Backoff = time.Hour
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	ch := addChannel(t, store, models.NotificationChannelRequest{
+		Name: "hook", Type: models.ChannelWebhook, Config: models.ChannelConfig{URL: srv.URL},
+	})
+	rule, _ := store.CreateAlertRule(models.AlertRuleRequest{Metric: "cpu_percent", Operator: ">", Threshold: 90})
+	alert, _ := store.OpenAlert(rule.ID, "a1", "cpu_percent > 90 (value: 95.00)")
+	ev := testEvent()
+	ev.Alert, ev.Rule = *alert, *rule
+	n.Notify(ev)
+
+	// Failed once and now waiting an hour for the retry; Stop must not wait
+	// that long
+	for deadline := time.Now().Add(5 * time.Second); deliveries(t, store)[0].Attempts != 1; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("first attempt not logged")
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		n.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waited for the backoff")
+	}
+	if d := deliveries(t, store)[0]; d.Status != models.DeliveryPending {
+		t.Fatalf("expected the interrupted delivery to stay pending, got %+v", d)
+	}
+	// Nothing new starts once stopped
+	n.Notify(ev)
+	if calls.Load() != 1 {
+		t.Errorf("a stopped notifier sent %d more requests", calls.Load()-1)
+	}
+
+	// The next run picks both up; one for a channel deleted meanwhile fails
+	gone := addChannel(t, store, models.NotificationChannelRequest{
+		Name: "gone", Type: models.ChannelWebhook, Config: models.ChannelConfig{URL: srv.URL},
+	})
+	orphan, _ := store.CreateDelivery(gone.ID, alert.ID, models.EventFiring)
+	store.DeleteNotificationChannel(gone.ID)
+
+	next := New(store)
+	next.Resume()
+	next.Wait()
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+	for _, d := range deliveries(t, store) {
+		switch {
+		case d.ID == orphan:
+			if d.Status != models.DeliveryFailed {
+				t.Errorf("delivery to a deleted channel: %+v", d)
+			}
+		case d.ChannelID == ch.ID && d.Status != models.DeliverySent:
+			t.Errorf("resumed delivery not sent: %+v", d)
+		}
+	}
+	if d := deliveries(t, store)[2]; d.Attempts != 2 {
+		t.Errorf("expected the retried delivery to count its earlier attempt, got %+v", d)
+	}
+}
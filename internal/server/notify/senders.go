@@ -0,0 +1,256 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of a webhook body, keyed with the
+// channel's secret, as "sha256=<hex>".
+const SignatureHeader = "X-RMM-Signature"
+
+// Sign returns the SignatureHeader value of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookPayload is the body of generic webhooks.
+type webhookPayload struct {
+	Event      models.AlertEvent `json:"event"`
+	DeliveryID int64             `json:"delivery_id"`
+	Alert      models.Alert      `json:"alert"`
+	Rule       *models.AlertRule `json:"rule,omitempty"`
+	Agent      *webhookAgent     `json:"agent,omitempty"`
+	SentAt     time.Time         `json:"sent_at"`
+}
+
+type webhookAgent struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+}
+
+func sendWebhook(ctx context.Context, n *Notifier, ch models.NotificationChannel, ev Event, deliveryID int64) error {
+	payload := webhookPayload{Event: ev.Kind, DeliveryID: deliveryID, Alert: ev.Alert, SentAt: time.Now().UTC()}
+	if ev.Kind != models.EventTest {
+		payload.Rule = &ev.Rule
+		payload.Agent = &webhookAgent{ID: ev.Agent.ID, Name: ev.Agent.Name(), Hostname: ev.Agent.Hostname}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &permanentError{err}
+	}
+	header := http.Header{}
+	header.Set("X-RMM-Event", string(ev.Kind))
+	header.Set("X-RMM-Delivery", strconv.FormatInt(deliveryID, 10))
+	if ch.Config.Secret != "" {
+		header.Set(SignatureHeader, Sign(ch.Config.Secret, body))
+	}
+	return n.post(ctx, ch.Config.URL, body, header)
+}
+
+// sendChat posts a one-line message to a Slack, Teams or Discord webhook.
+func sendChat(ctx context.Context, n *Notifier, ch models.NotificationChannel, ev Event, _ int64) error {
+	text := summary(ev)
+	if ev.Kind != models.EventTest {
+		text += "\nAgent: " + ev.Agent.Name()
+	}
+	var payload any
+	switch ch.Type {
+	case models.ChannelDiscord:
+		// Discord rejects messages over 2000 characters
+		text = truncate(text, 2000)
+		payload = map[string]string{"content": text}
+	default:
+		// Slack and Teams incoming webhooks both take a text field
+		payload = map[string]string{"text": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &permanentError{err}
+	}
+	return n.post(ctx, ch.Config.URL, body, nil)
+}
+
+// post sends a JSON body. Server errors, timeouts and rate limiting are
+// worth retrying; other client errors are not.
+func (n *Notifier) post(ctx context.Context, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mini-rmm")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	// Chat webhook URLs are credentials, so they stay out of the error
+	err = fmt.Errorf("webhook returned %s", resp.Status)
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+	return err
+}
+
+func sendEmail(ctx context.Context, _ *Notifier, ch models.NotificationChannel, ev Event, _ int64) error {
+	c := ch.Config
+	port := c.SMTPPort
+	if port == 0 {
+		port = 587
+		if c.SMTPTLS {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(c.SMTPHost, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: c.SMTPHost}
+
+	var conn net.Conn
+	var err error
+	if c.SMTPTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, c.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !c.SMTPTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if c.SMTPUsername != "" {
+		// PlainAuth refuses to send the password unencrypted, except to localhost
+		if err := client.Auth(smtp.PlainAuth("", c.SMTPUsername, c.SMTPPassword, c.SMTPHost)); err != nil {
+			return &permanentError{err}
+		}
+	}
+	// From and To may carry display names: "RMM <rmm@example.com>"
+	from, err := mail.ParseAddress(c.From)
+	if err != nil {
+		return &permanentError{err}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range c.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return &permanentError{err}
+		}
+		if err := client.Rcpt(addr.Address); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(emailMessage(c, ev)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func emailMessage(c models.ChannelConfig, ev Event) []byte {
+	var b bytes.Buffer
+	header := func(k, v string) {
+		// Values come from metric labels and hostnames; keep them on one line
+		v = strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	header("From", c.From)
+	header("To", strings.Join(c.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", "[Mini RMM] "+summary(ev)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	b.WriteString("\r\n")
+
+	line := func(k, v string) { fmt.Fprintf(&b, "%-9s %s\r\n", k+":", v) }
+	line("Alert", ev.Alert.Message)
+	line("Status", strings.ToUpper(string(ev.Kind)))
+	if ev.Kind != models.EventTest {
+		line("Severity", string(ev.Rule.Severity))
+		line("Agent", fmt.Sprintf("%s (%s)", ev.Agent.Name(), ev.Agent.ID))
+		line("Rule", ev.Rule.Condition())
+	}
+	line("Started", ev.Alert.CreatedAt.UTC().Format(time.RFC3339))
+	if ev.Alert.ResolvedAt != nil {
+		resolved := ev.Alert.ResolvedAt.UTC().Format(time.RFC3339)
+		if ev.Alert.ResolvedBy != "" {
+			resolved += " by " + ev.Alert.ResolvedBy
+		}
+		line("Resolved", resolved)
+	}
+	return b.Bytes()
+}
+
+// truncate cuts s to at most n characters, ending it with "..." if it was
+// cut.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := 0
+	for i := range s {
+		if runes == n-3 {
+			return s[:i] + "..."
+		}
+		runes++
+	}
+	return s
+}
+
+// summary is the one-line text of chat messages and email subjects.
+func summary(ev Event) string {
+	s := "[" + strings.ToUpper(string(ev.Kind)) + "] "
+	if ev.Rule.Severity != "" {
+		s += "[" + strings.ToUpper(string(ev.Rule.Severity)) + "] "
+	}
+	return s + ev.Alert.Message
+}
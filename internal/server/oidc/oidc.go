@@ -0,0 +1,201 @@
+// Package oidc signs dashboard users in through an OpenID Connect provider
+// (authorization code flow with PKCE) and maps their groups to roles.
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+const (
+	DefaultUsernameClaim = "preferred_username"
+	DefaultGroupsClaim   = "groups"
+)
+
+// Config describes the identity provider and how its users become dashboard
+// users. Group lists are matched against the groups claim; a user gets the
+// highest role one of their groups maps to.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // e.g. https://rmm.example.com/login/oidc/callback
+	Scopes       []string // requested besides "openid"
+
+	UsernameClaim string // falls back to email, then the subject
+	GroupsClaim   string
+
+	AdminGroups    []string
+	OperatorGroups []string
+	ViewerGroups   []string
+	// DefaultRole is given to users in none of the groups; empty refuses them.
+	DefaultRole models.Role
+}
+
+// Enabled reports whether SSO is configured.
+func (c Config) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+// Role returns the highest role granted by groups, or DefaultRole.
+func (c Config) Role(groups []string) (models.Role, bool) {
+	member := func(names []string) bool {
+		for _, name := range names {
+			for _, g := range groups {
+				if g == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case member(c.AdminGroups):
+		return models.RoleAdmin, true
+	case member(c.OperatorGroups):
+		return models.RoleOperator, true
+	case member(c.ViewerGroups):
+		return models.RoleViewer, true
+	}
+	return c.DefaultRole, c.DefaultRole.Valid()
+}
+
+// Identity is what the provider asserts about a signed-in user.
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Email    string
+	Groups   []string
+}
+
+// Provider talks to the identity provider. Discovery happens on first use
+// and is retried until it succeeds, so a provider that is down at startup
+// does not keep the server (and local logins) from starting.
+type Provider struct {
+	cfg Config
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+func New(cfg Config) *Provider {
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = DefaultUsernameClaim
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = DefaultGroupsClaim
+	}
+	return &Provider{cfg: cfg}
+}
+
+func (p *Provider) Config() Config {
+	return p.cfg
+}
+
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	provider, err := gooidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{gooidc.ScopeOpenID}, p.cfg.Scopes...),
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.cfg.ClientID})
+	return p.oauth, p.verifier, nil
+}
+
+// AuthCodeURL returns where to send the browser to sign in. state, nonce and
+// verifier must be kept for the callback.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange redeems the authorization code and verifies the ID token.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	oauth, idVerifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc code exchange: %w", err)
+	}
+	rawID, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc: no id_token in token response")
+	}
+	idToken, err := idVerifier.Verify(ctx, rawID)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: verify id token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("oidc: nonce mismatch")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("oidc: decode claims: %w", err)
+	}
+	id := &Identity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Email:   stringClaim(claims, "email"),
+		Groups:  listClaim(claims, p.cfg.GroupsClaim),
+	}
+	id.Username = stringClaim(claims, p.cfg.UsernameClaim)
+	if id.Username == "" {
+		id.Username = id.Email
+	}
+	if id.Username == "" {
+		id.Username = id.Subject
+	}
+	return id, nil
+}
+
+func stringClaim(claims map[string]any, name string) string {
+	s, _ := claims[name].(string)
+	return strings.TrimSpace(s)
+}
+
+// listClaim reads a claim that is a list of strings, or a single string
+// (some providers send one group that way).
+func listClaim(claims map[string]any, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
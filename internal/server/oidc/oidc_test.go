@@ -0,0 +1,208 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// mockProvider is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that issues an ID token for one authorization code.
+type mockProvider struct {
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any // extra ID token claims
+
+	code      string
+	nonce     string
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, code: "the-code"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.srv.URL,
+			"authorization_endpoint":                m.srv.URL + "/authorize",
+			"token_endpoint":                        m.srv.URL + "/token",
+			"jwks_uri":                              m.srv.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": b64(key.N.Bytes()),
+			"e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != m.code || b64(sum[:]) != m.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.idToken(t),
+		})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockProvider) idToken(t *testing.T) string {
+	claims := map[string]any{
+		"iss":   m.srv.URL,
+		"aud":   "rmm",
+		"sub":   "user-42",
+		"nonce": m.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signing := b64(header) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signing + "." + b64(sig)
+}
+
+// authorize plays the browser: it follows the login URL as if the user
+// signed in, and returns the state sent back to the callback.
+func (m *mockProvider) authorize(t *testing.T, loginURL string) string {
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected PKCE, got %q", loginURL)
+	}
+	m.nonce = q.Get("nonce")
+	m.challenge = q.Get("code_challenge")
+	return q.Get("state")
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestLoginFlow(t *testing.T) {
+	m := newMockProvider(t)
+	m.claims = map[string]any{"preferred_username": "alice", "email": "alice@example.com", "groups": []string{"staff", "rmm-ops"}}
+	p := New(Config{Issuer: m.srv.URL, ClientID: "rmm", ClientSecret: "s3cret", RedirectURL: "http://rmm/callback"})
+	ctx := context.Background()
+
+	loginURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-with-enough-entropy-0123456789")
+	if err != nil {
+		t.Fatalf("auth url: %v", err)
+	}
+	if state := m.authorize(t, loginURL); state != "state-1" {
+		t.Fatalf("state not passed through, got %q", state)
+	}
+
+	id, err := p.Exchange(ctx, m.code, "nonce-1", "verifier-with-enough-entropy-0123456789")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if id.Subject != "user-42" || id.Issuer != m.srv.URL || id.Username != "alice" || len(id.Groups) != 2 {
+		t.Errorf("unexpected identity %+v", id)
+	}
+
+	if _, err := p.Exchange(ctx, m.code, "nonce-1", "wrong-verifier"); err == nil {
+		t.Error("a wrong PKCE verifier must fail")
+	}
+	if _, err := p.Exchange(ctx, m.code, "other-nonce", "verifier-with-enough-entropy-0123456789"); err == nil {
+		t.Error("a nonce mismatch must fail")
+	}
+}
+
+func TestUsernameFallback(t *testing.T) {
+	m := newMockProvider(t)
+	m.claims = map[string]any{"email": "bob@example.com", "roles": "rmm-admins"}
+	p := New(Config{Issuer: m.srv.URL, ClientID: "rmm", GroupsClaim: "roles"})
+
+	loginURL, _ := p.AuthCodeURL(context.Background(), "s", "n", "verifier-with-enough-entropy-0123456789")
+	m.authorize(t, loginURL)
+	id, err := p.Exchange(context.Background(), m.code, "n", "verifier-with-enough-entropy-0123456789")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if id.Username != "bob@example.com" || len(id.Groups) != 1 || id.Groups[0] != "rmm-admins" {
+		t.Errorf("unexpected identity %+v", id)
+	}
+}
+
+func TestBadSignature(t *testing.T) {
+	m := newMockProvider(t)
+	other := newMockProvider(t)
+	p := New(Config{Issuer: m.srv.URL, ClientID: "rmm"})
+
+	// Tokens signed by another provider's key are rejected
+	m.key = other.key
+	loginURL, _ := p.AuthCodeURL(context.Background(), "s", "n", "verifier-with-enough-entropy-0123456789")
+	m.authorize(t, loginURL)
+	if _, err := p.Exchange(context.Background(), m.code, "n", "verifier-with-enough-entropy-0123456789"); err == nil {
+		t.Error("expected a bad signature to be rejected")
+	}
+}
+
+func TestRole(t *testing.T) {
+	cfg := Config{
+		AdminGroups:    []string{"rmm-admins"},
+		OperatorGroups: []string{"rmm-ops", "helpdesk"},
+		ViewerGroups:   []string{"staff"},
+	}
+	tests := []struct {
+		groups []string
+		want   models.Role
+		ok     bool
+	}{
+		{[]string{"staff", "rmm-ops"}, models.RoleOperator, true},
+		{[]string{"helpdesk", "rmm-admins"}, models.RoleAdmin, true},
+		{[]string{"staff"}, models.RoleViewer, true},
+		{[]string{"sales"}, "", false},
+		{nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := cfg.Role(tt.groups)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Role(%v) = %q, %v; want %q, %v", tt.groups, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	cfg.DefaultRole = models.RoleViewer
+	if got, ok := cfg.Role([]string{"sales"}); got != models.RoleViewer || !ok {
+		t.Errorf("expected the default role, got %q", got)
+	}
+}
@@ -0,0 +1,138 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testCSR(t *testing.T, commonName string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestLoadOrCreateCA(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pki")
+	ca, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if !ca.cert.IsCA {
+		t.Error("expected a CA certificate")
+	}
+	fi, err := os.Stat(filepath.Join(dir, "ca.key"))
+	if err != nil {
+		t.Fatalf("ca.key: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("ca.key mode %o, want 600", perm)
+	}
+
+	// A restart reloads the same CA rather than minting a new one
+	reloaded, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if !bytes.Equal(reloaded.CertPEM(), ca.CertPEM()) {
+		t.Error("reload created a different CA")
+	}
+	certPEM, _, err := reloaded.SignAgentCSR(testCSR(t, "agent-1"), "agent-1")
+	if err != nil {
+		t.Fatalf("sign with reloaded CA: %v", err)
+	}
+	cert, _ := parseCertPEM(certPEM)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: ca.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("certificate from the reloaded CA does not chain to the original: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "ca.key"), []byte("garbage"), 0600)
+	if _, err := LoadOrCreateCA(dir); err == nil {
+		t.Error("expected a corrupt key to be an error, not a new CA")
+	}
+}
+
+func TestSignAgentCSR(t *testing.T) {
+	ca, err := LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The agent ID is the subject whatever the CSR asks for
+	certPEM, serial, err := ca.SignAgentCSR(testCSR(t, "someone-else"), "agent-1")
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	cert, err := parseCertPEM(certPEM)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if cert.Subject.CommonName != "agent-1" {
+		t.Errorf("subject %q, want agent-1", cert.Subject.CommonName)
+	}
+	if serial != SerialString(cert.SerialNumber) {
+		t.Errorf("serial %s does not match the certificate's %s", serial, SerialString(cert.SerialNumber))
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: ca.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("client certificate does not chain to the CA: %v", err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: ca.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}); err == nil {
+		t.Error("a client certificate must not be usable as a server certificate")
+	}
+
+	other, err := LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: other.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err == nil {
+		t.Error("certificate chains to an unrelated CA")
+	}
+
+	if _, _, err := ca.SignAgentCSR([]byte("not a csr"), "agent-1"); err == nil {
+		t.Error("expected an invalid CSR to be rejected")
+	}
+}
+
+func TestEnsureServerCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if err := ca.EnsureServerCertificate(certPath, keyPath, []string{"rmm.example.com", "127.0.0.1"}); err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	chain, _ := os.ReadFile(certPath)
+	cert, err := parseCertPEM(chain)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, host := range []string{"rmm.example.com", "127.0.0.1"} {
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: ca.Pool(), DNSName: host}); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+	}
+
+	// Existing files are kept
+	if err := ca.EnsureServerCertificate(certPath, keyPath, []string{"other.example.com"}); err != nil {
+		t.Fatalf("ensure again: %v", err)
+	}
+	if again, _ := os.ReadFile(certPath); !bytes.Equal(again, chain) {
+		t.Error("server certificate was replaced")
+	}
+}
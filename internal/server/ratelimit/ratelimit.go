@@ -0,0 +1,308 @@
+// Package ratelimit throttles clients: token buckets for request floods and
+// progressive backoff for repeated failures such as wrong passwords.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idleAfter is how long an unused bucket or backoff entry is kept.
+const idleAfter = time.Hour
+
+// Limiter is a per-key token bucket: each key may make burst requests at
+// once and then perSecond requests per second. A nil Limiter allows
+// everything.
+type Limiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter, or nil (no limit) if perSecond is not positive.
+func NewLimiter(perSecond float64, burst int) *Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    perSecond,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token for key. If none is left it returns false and how long
+// until the next one.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.pruneLocked(now)
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Middleware rejects clients over the limit, keyed by client IP, with 429.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(ClientIP(r)); !ok {
+			TooManyRequests(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > idleAfter {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Backoff tracks failures per key. After free failures every further one
+// doubles the wait before the next attempt, starting at base and capped at
+// max. Keys are forgotten after an hour without failures.
+type Backoff struct {
+	mu        sync.Mutex
+	free      int
+	base, max time.Duration
+	entries   map[string]*backoffEntry
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type backoffEntry struct {
+	failures int
+	next     time.Time // no attempts before this
+	last     time.Time
+}
+
+func NewBackoff(free int, base, max time.Duration) *Backoff {
+	return &Backoff{
+		free:    free,
+		base:    base,
+		max:     max,
+		entries: make(map[string]*backoffEntry),
+		now:     time.Now,
+	}
+}
+
+// Wait returns how long key must wait before its next attempt (0 = now).
+func (b *Backoff) Wait(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.entries[key]
+	if e == nil {
+		return 0
+	}
+	if wait := e.next.Sub(b.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Fail records a failure for key and returns the delay it now has to wait.
+func (b *Backoff) Fail(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.pruneLocked(now)
+
+	e := b.entries[key]
+	if e == nil {
+		e = &backoffEntry{}
+		b.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+	if e.failures <= b.free {
+		return 0
+	}
+
+	delay := b.max
+	if shift := e.failures - b.free - 1; shift < 30 {
+		if d := b.base << shift; d > 0 && d < b.max {
+			delay = d
+		}
+	}
+	e.next = now.Add(delay)
+	return delay
+}
+
+// Failures returns the failures recorded for key.
+func (b *Backoff) Failures(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e := b.entries[key]; e != nil {
+		return e.failures
+	}
+	return 0
+}
+
+// Reset forgets key, e.g. after a successful login.
+func (b *Backoff) Reset(key string) {
+	b.mu.Lock()
+	delete(b.entries, key)
+	b.mu.Unlock()
+}
+
+func (b *Backoff) pruneLocked(now time.Time) {
+	if now.Sub(b.lastPrune) < time.Minute {
+		return
+	}
+	b.lastPrune = now
+	for k, e := range b.entries {
+		if now.Sub(e.last) > idleAfter && !now.Before(e.next) {
+			delete(b.entries, k)
+		}
+	}
+}
+
+// ClientIP returns the IP of the connecting client. Forwarding headers are
+// ignored since clients can set them freely; behind a reverse proxy,
+// TrustedProxies.Middleware puts the forwarded client IP in RemoteAddr first.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For header is
+// believed. Requests from anyone else keep their connection's address.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses CIDRs such as 10.0.0.0/8; a bare IP stands for
+// itself.
+func ParseTrustedProxies(list []string) (TrustedProxies, error) {
+	var p TrustedProxies
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			p = append(p, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		p = append(p, prefix.Masked())
+	}
+	return p, nil
+}
+
+func (p TrustedProxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client IP of a request. If the peer is a trusted
+// proxy, X-Forwarded-For is read from the right and the first address that
+// is not a trusted proxy is the client; everything left of it may be forged.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	peer := ClientIP(r)
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !p.trusts(addr) {
+		return peer
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap().String()
+		if !p.trusts(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// Middleware replaces RemoteAddr with the client IP forwarded by a trusted
+// proxy, so rate limits, login throttling and audit entries see the client
+// rather than the proxy. Without trusted proxies it does nothing.
+func (p TrustedProxies) Middleware(next http.Handler) http.Handler {
+	if len(p) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := p.ClientIP(r); ip != ClientIP(r) {
+			r.RemoteAddr = net.JoinHostPort(ip, "0")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TooManyRequests writes a 429 response with a Retry-After header.
+func TooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(RetryAfterSeconds(wait)))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
+
+// RetryAfterSeconds rounds wait up to whole seconds, at least 1.
+func RetryAfterSeconds(wait time.Duration) int {
+	s := int(math.Ceil(wait.Seconds()))
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func TestLimiter(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l := NewLimiter(2, 3)
+	l.now = clock.now
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d within burst was refused", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected refusal with 500ms wait, got %v %v", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("keys must have their own buckets")
+	}
+
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("expected a token to be refilled")
+	}
+
+	if ok, _ := (*Limiter)(nil).Allow("a"); !ok {
+		t.Error("a nil limiter must allow everything")
+	}
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	l := NewLimiter(1, 1)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/api/v1/heartbeat", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first request: got %d", rec.Code)
+	}
+
+	req.RemoteAddr = "10.0.0.1:5001" // another port, same client
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	b := NewBackoff(2, time.Second, 5*time.Second)
+	b.now = clock.now
+
+	if d := b.Fail("ip"); d != 0 {
+		t.Errorf("first failure is free, got %v", d)
+	}
+	b.Fail("ip")
+	if b.Wait("ip") != 0 {
+		t.Error("free failures must not delay")
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if d := b.Fail("ip"); d != w {
+			t.Errorf("failure %d: got delay %v, want %v", i+3, d, w)
+		}
+	}
+	if b.Wait("ip") != 5*time.Second {
+		t.Errorf("expected 5s wait, got %v", b.Wait("ip"))
+	}
+	clock.t = clock.t.Add(5 * time.Second)
+	if b.Wait("ip") != 0 {
+		t.Error("expected wait to be over")
+	}
+
+	b.Reset("ip")
+	if b.Failures("ip") != 0 {
+		t.Error("reset must forget failures")
+	}
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an invalid CIDR to be rejected")
+	}
+
+	cases := []struct {
+		remote, xff, want string
+	}{
+		{"203.0.113.5:1234", "", "203.0.113.5"},
+		// Only trusted proxies may forward
+		{"203.0.113.5:1234", "198.51.100.7", "203.0.113.5"},
+		{"10.1.2.3:443", "198.51.100.7", "198.51.100.7"},
+		{"192.0.2.1:443", "198.51.100.7", "198.51.100.7"},
+		// A client-supplied entry left of the real client is ignored
+		{"10.1.2.3:443", "1.2.3.4, 198.51.100.7", "198.51.100.7"},
+		// Chained trusted proxies are skipped
+		{"10.1.2.3:443", "198.51.100.7, 10.9.9.9", "198.51.100.7"},
+		// Garbage stops the walk at the last good hop
+		{"10.1.2.3:443", "198.51.100.7, bogus", "10.1.2.3"},
+		{"10.1.2.3:443", "", "10.1.2.3"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if got := proxies.ClientIP(r); got != c.want {
+			t.Errorf("%s with X-Forwarded-For %q: got %s, want %s", c.remote, c.xff, got, c.want)
+		}
+	}
+
+	var seen string
+	h := proxies.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = ClientIP(r) }))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.1.2.3:443"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if seen != "198.51.100.7" {
+		t.Errorf("middleware: handlers see %s", seen)
+	}
+}
@@ -0,0 +1,124 @@
+// Package recording writes terminal sessions as asciicast v2 files, the
+// format read by asciinema and its web player.
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Writer appends the events of one session to an asciicast file.
+type Writer struct {
+	mu      sync.Mutex
+	f       *os.File
+	start   time.Time
+	size    int64
+	pending []byte // incomplete UTF-8 sequence carried to the next chunk
+}
+
+type header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Create starts a recording at path, creating its directory if needed.
+func Create(path string, cols, rows int, title string) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Writer{f: f, start: time.Now()}
+	h, _ := json.Marshal(header{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: rec.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	n, err := rec.f.Write(append(h, '\n'))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	rec.size = int64(n)
+	return rec, nil
+}
+
+// Output records terminal output. Chunks may split multi-byte characters;
+// the tail is held back until the rest arrives.
+func (r *Writer) Output(data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data = append(r.pending, data...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return nil
+	}
+	return r.event("o", string(data[:cut]))
+}
+
+// Input records keystrokes sent to the terminal.
+func (r *Writer) Input(data string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.event("i", data)
+}
+
+// Resize records a terminal size change.
+func (r *Writer) Resize(cols, rows int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// event must be called with mu held.
+func (r *Writer) event(code, data string) error {
+	line, err := json.Marshal([]interface{}{time.Since(r.start).Seconds(), code, data})
+	if err != nil {
+		return err
+	}
+	n, err := r.f.Write(append(line, '\n'))
+	r.size += int64(n)
+	return err
+}
+
+// Size is the number of bytes written so far.
+func (r *Writer) Size() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.size
+}
+
+// Close writes any held-back bytes and closes the file.
+func (r *Writer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	return r.f.Close()
+}
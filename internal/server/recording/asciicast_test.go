@@ -0,0 +1,56 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriterKeepsSplitRunesTogether(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rec", "session.cast")
+	w, err := Create(path, 120, 40, "test")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	euro := []byte("€") // 3 bytes
+	w.Output(append([]byte("price "), euro[:2]...))
+	w.Output(append(euro[2:], '\n'))
+	w.Resize(100, 30)
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+
+	sc.Scan()
+	var h header
+	if err := json.Unmarshal(sc.Bytes(), &h); err != nil || h.Version != 2 || h.Width != 120 || h.Height != 40 {
+		t.Fatalf("bad header %q: %v", sc.Text(), err)
+	}
+
+	var out string
+	var events []string
+	for sc.Scan() {
+		var ev []interface{}
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("bad event %q: %v", sc.Text(), err)
+		}
+		events = append(events, ev[1].(string))
+		if ev[1] == "o" {
+			out += ev[2].(string)
+		}
+	}
+	if out != "price €\n" {
+		t.Errorf("output = %q", out)
+	}
+	if len(events) != 3 || events[2] != "r" {
+		t.Errorf("events = %v", events)
+	}
+}
@@ -0,0 +1,33 @@
+package recording
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+)
+
+// Prune deletes the files and rows of recordings that ended before cutoff
+// and returns how many it deleted. A recording whose file can't be removed
+// is kept for the next run.
+func Prune(store *db.Store, cutoff time.Time) (int64, error) {
+	recs, err := store.RecordingsEndedBefore(cutoff)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, r := range recs {
+		if err := os.Remove(r.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("delete recording file failed", "path", r.Path, "error", err)
+			continue
+		}
+		if err := store.DeleteRecording(r.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,137 @@
+// Package retention rolls metrics up into 5-minute and 1-hour buckets and
+// deletes data that has outlived its policy.
+package retention
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/recording"
+)
+
+const interval = 5 * time.Minute
+
+// rollupDelay keeps a bucket open for a while after it ends, so a heartbeat
+// being stored right at the boundary still lands in its rollup.
+const rollupDelay = time.Minute
+
+// Policy says how long each kind of data is kept; 0 keeps it forever.
+type Policy struct {
+	RawMetrics time.Duration // every heartbeat's samples
+	Metrics5m  time.Duration // 5-minute min/avg/max
+	Metrics1h  time.Duration // 1-hour min/avg/max
+	Commands   time.Duration // finished commands and their output, and messages that left the queue
+	Alerts     time.Duration // resolved alerts and notification deliveries
+	// Maintenance is how long ended silences and one-off maintenance
+	// windows are kept.
+	Maintenance time.Duration
+	AuditLogs   time.Duration
+	Recordings  time.Duration // shell and command recordings, files included
+	// SessionIdle is the dashboard idle timeout; sessions unused for longer
+	// are deleted along with expired ones.
+	SessionIdle time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		RawMetrics:  48 * time.Hour,
+		Metrics5m:   30 * 24 * time.Hour,
+		Metrics1h:   400 * 24 * time.Hour,
+		Commands:    90 * 24 * time.Hour,
+		Alerts:      90 * 24 * time.Hour,
+		Maintenance: 90 * 24 * time.Hour,
+		AuditLogs:   365 * 24 * time.Hour,
+		Recordings:  90 * 24 * time.Hour,
+	}
+}
+
+// Resolution picks the finest resolution that still holds from and keeps a
+// range to a few hundred points: raw up to 6 hours, 5-minute buckets up to a
+// week, hourly beyond.
+func (p Policy) Resolution(from, to, now time.Time) models.Resolution {
+	span := to.Sub(from)
+	switch {
+	case span <= 6*time.Hour && keeps(p.RawMetrics, from, now):
+		return models.ResolutionRaw
+	case span <= 7*24*time.Hour && keeps(p.Metrics5m, from, now):
+		return models.Resolution5m
+	}
+	return models.Resolution1h
+}
+
+func keeps(maxAge time.Duration, t, now time.Time) bool {
+	return maxAge <= 0 || !t.Before(now.Add(-maxAge))
+}
+
+// Run rolls up and prunes every five minutes until ctx is done.
+func Run(ctx context.Context, store *db.Store, p Policy) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		RunOnce(store, p, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce rolls up the buckets that ended by now, then deletes what the
+// policy no longer keeps. Raw points are only deleted once rolled up.
+func RunOnce(store *db.Store, p Policy, now time.Time) {
+	until := now.Add(-rollupDelay)
+	rolledUp := true
+	for _, res := range []models.Resolution{models.Resolution5m, models.Resolution1h} {
+		n, err := store.RollupMetrics(res, until)
+		if err != nil {
+			slog.Error("metric rollup failed", "resolution", res, "error", err)
+			rolledUp = false
+			break
+		}
+		if n > 0 {
+			slog.Debug("metrics rolled up", "resolution", res, "buckets", n)
+		}
+	}
+
+	prune := func(what string, maxAge time.Duration, fn func(time.Time) (int64, error)) {
+		if maxAge <= 0 {
+			return
+		}
+		n, err := fn(now.Add(-maxAge))
+		if err != nil {
+			slog.Error("retention prune failed", "data", what, "error", err)
+			return
+		}
+		if n > 0 {
+			slog.Info("retention pruned", "data", what, "count", n)
+		}
+	}
+	if rolledUp {
+		prune("raw metrics", p.RawMetrics, func(t time.Time) (int64, error) { return store.PruneMetrics(models.ResolutionRaw, t) })
+		prune("5m metrics", p.Metrics5m, func(t time.Time) (int64, error) { return store.PruneMetrics(models.Resolution5m, t) })
+	}
+	prune("1h metrics", p.Metrics1h, func(t time.Time) (int64, error) { return store.PruneMetrics(models.Resolution1h, t) })
+	if p.RawMetrics > 0 && p.Metrics5m > 0 && p.Metrics1h > 0 {
+		longest := max(p.RawMetrics, p.Metrics5m, p.Metrics1h)
+		prune("metric series", longest, store.PruneMetricSeries)
+	}
+	prune("commands", p.Commands, store.PruneCommands)
+	prune("queued messages", p.Commands, store.PruneQueuedMessages)
+	prune("alerts", p.Alerts, store.PruneAlerts)
+	prune("notification deliveries", p.Alerts, store.PruneDeliveries)
+	prune("silences", p.Maintenance, store.PruneSilences)
+	prune("maintenance windows", p.Maintenance, store.PruneMaintenanceWindows)
+	prune("audit logs", p.AuditLogs, store.PruneAuditLogs)
+	prune("recordings", p.Recordings, func(t time.Time) (int64, error) { return recording.Prune(store, t) })
+
+	if n, err := store.CleanExpiredSessions(p.SessionIdle); err != nil {
+		slog.Error("retention prune failed", "data", "sessions", "error", err)
+	} else if n > 0 {
+		slog.Info("retention pruned", "data", "sessions", "count", n)
+	}
+}
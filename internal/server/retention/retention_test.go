@@ -0,0 +1,87 @@
+package retention
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+)
+
+func TestResolution(t *testing.T) {
+	p := DefaultPolicy()
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	cases := []struct {
+		from, to time.Time
+		want     models.Resolution
+	}{
+		{now.Add(-time.Hour), now, models.ResolutionRaw},
+		{now.Add(-6 * time.Hour), now, models.ResolutionRaw},
+		{now.Add(-day), now, models.Resolution5m},
+		{now.Add(-7 * day), now, models.Resolution5m},
+		{now.Add(-30 * day), now, models.Resolution1h},
+		// Short, but raw points are gone by then
+		{now.Add(-3 * day), now.Add(-3*day + time.Hour), models.Resolution5m},
+		{now.Add(-60 * day), now.Add(-59 * day), models.Resolution1h},
+	}
+	for _, c := range cases {
+		if got := p.Resolution(c.from, c.to, now); got != c.want {
+			t.Errorf("Resolution(%s, %s) = %s, want %s", now.Sub(c.from), c.to.Sub(c.from), got, c.want)
+		}
+	}
+
+	// Kept forever, raw always covers short ranges
+	p.RawMetrics = 0
+	if got := p.Resolution(now.Add(-365*day), now.Add(-365*day+time.Hour), now); got != models.ResolutionRaw {
+		t.Errorf("unlimited raw retention: got %s", got)
+	}
+}
+
+// TestPrune checks which tables each setting prunes: alerts take their
+// notification deliveries along, while ended silences and maintenance
+// windows follow their own setting.
+func TestPrune(t *testing.T) {
+	store, err := db.New(filepath.Join(t.TempDir(), "rmm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	store.UpsertAgent(models.HeartbeatPayload{AgentID: "agent-1", Hostname: "host-1"})
+	rule, _ := store.CreateAlertRule(models.AlertRuleRequest{Type: models.RuleOffline})
+	alert, _ := store.OpenAlert(rule.ID, "agent-1", "offline")
+	store.ResolveOpenAlert(rule.ID, "agent-1")
+	store.CreateDelivery(1, alert.ID, models.EventFiring)
+	store.CreateSilence(models.Silence{AgentID: "agent-1", StartsAt: now, EndsAt: now.Add(time.Hour)})
+	store.CreateMaintenanceWindow(models.MaintenanceWindow{Name: "patching", StartsAt: &now, DurationSeconds: 3600, AgentIDs: []string{"agent-1"}})
+
+	type counts struct{ alerts, deliveries, silences, windows int }
+	count := func() counts {
+		alerts, _ := store.ListAlerts(100)
+		deliveries, _ := store.ListDeliveries(100)
+		silences, _ := store.ListSilences(time.Time{})
+		windows, _ := store.ListMaintenanceWindows()
+		return counts{len(alerts), len(deliveries), len(silences), len(windows)}
+	}
+
+	if got, want := count(), (counts{1, 1, 1, 1}); got != want {
+		t.Fatalf("before pruning: %+v, want %+v", got, want)
+	}
+	later := now.Add(60 * 24 * time.Hour)
+	RunOnce(store, Policy{Alerts: 30 * 24 * time.Hour}, later)
+	if got, want := count(), (counts{0, 0, 1, 1}); got != want {
+		t.Errorf("after alert retention: %+v, want %+v", got, want)
+	}
+	RunOnce(store, Policy{Maintenance: 90 * 24 * time.Hour}, later)
+	if got, want := count(), (counts{0, 0, 1, 1}); got != want {
+		t.Errorf("maintenance kept for 90 days: %+v, want %+v", got, want)
+	}
+	RunOnce(store, Policy{Maintenance: 30 * 24 * time.Hour}, later)
+	if got, want := count(), (counts{0, 0, 0, 0}); got != want {
+		t.Errorf("after maintenance retention: %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA-1 key, truncated to 6 digits.
+func TestCodeRFCVectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		got, err := Code(secret, Step(time.Unix(c.unix, 0)))
+		if err != nil {
+			t.Fatalf("code: %v", err)
+		}
+		if got != c.want {
+			t.Errorf("t=%d: got %s, want %s", c.unix, got, c.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, Step(now))
+
+	step, ok := Validate(secret, code, now, 0)
+	if !ok || step != Step(now) {
+		t.Fatalf("expected current code to validate")
+	}
+	if _, ok := Validate(secret, code, now.Add(Period), 0); !ok {
+		t.Error("expected previous step to be accepted within skew")
+	}
+	if _, ok := Validate(secret, code, now.Add(3*Period), 0); ok {
+		t.Error("expected stale code to be rejected")
+	}
+	if _, ok := Validate(secret, code, now, step); ok {
+		t.Error("expected a used code to be rejected")
+	}
+	if _, ok := Validate(secret, "12345", now, 0); ok {
+		t.Error("expected short code to be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Mini RMM", "alice", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/Mini%20RMM:alice?") || !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}
@@ -16,6 +16,10 @@ var LatestVersion = "dev"
 // BinaryDir is the directory where agent binaries are stored for download
 var BinaryDir = "./binaries"
 
+// ClientCertRequired is set when the server runs in mTLS mode, so install
+// scripts start agents with -mtls
+var ClientCertRequired = false
+
 type UpdateCheckResponse struct {
 	UpdateAvailable bool   `json:"update_available"`
 	LatestVersion   string `json:"latest_version"`
@@ -85,7 +89,11 @@ func (h *Handler) serveInstallScript(w http.ResponseWriter, r *http.Request, fil
 	}
 
 	// Placeholder replace (no Go template - script has $ and braces that confuse template engine)
-	out := strings.ReplaceAll(string(tmplBytes), "__RMM_SERVER_URL__", serverURL)
+	mtls := "0"
+	if ClientCertRequired {
+		mtls = "1"
+	}
+	out := strings.NewReplacer("__RMM_SERVER_URL__", serverURL, "__RMM_MTLS__", mtls).Replace(string(tmplBytes))
 	if out == "" {
 		http.Error(w, "install script produced empty output", http.StatusInternalServerError)
 		return
@@ -1,6 +1,9 @@
 package ws
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -10,7 +13,10 @@ import (
 
 	"github.com/cevrimxe/go-mini-rmm/internal/models"
 	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/tracing"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var upgrader = websocket.Upgrader{
@@ -20,47 +26,92 @@ var upgrader = websocket.Upgrader{
 type agentConn struct {
 	conn    *websocket.Conn
 	agentID string
+	writeMu sync.Mutex // gorilla/websocket allows only one concurrent writer
+	flushMu sync.Mutex // serializes delivery of the agent's queued messages
+}
+
+func (ac *agentConn) write(data []byte) error {
+	ac.writeMu.Lock()
+	defer ac.writeMu.Unlock()
+	return ac.conn.WriteMessage(websocket.TextMessage, data)
 }
 
 type Hub struct {
+	// QueueTTL bounds how long messages for offline agents are kept.
+	QueueTTL time.Duration
+	// RecordingDir is where command transcripts are written.
+	RecordingDir string
+
 	store      *db.Store
-	agents     map[string]*websocket.Conn
+	agents     map[string]*agentConn
 	mu         sync.RWMutex
 	register   chan *agentConn
-	unregister chan string
+	unregister chan *agentConn
+
+	// Request-response exchanges with agents (dir_list, process_list, ...)
+	requests   map[string]pendingRequest
+	requestsMu sync.Mutex
+
+	// Live command output subscribers
+	cmdSubs   map[int64]map[chan CommandEvent]struct{}
+	cmdSubsMu sync.Mutex
 
-	// Directory listing request-response
-	dirRequests   map[string]chan json.RawMessage
-	dirRequestsMu sync.Mutex
+	// Open command transcripts
+	cmdRecs   map[int64]*commandRecording
+	cmdRecsMu sync.Mutex
+
+	// Interactive shell sessions by ID
+	shells   map[string]*ShellSession
+	shellsMu sync.Mutex
 }
 
 func NewHub(store *db.Store) *Hub {
 	return &Hub{
-		store:       store,
-		agents:      make(map[string]*websocket.Conn),
-		register:    make(chan *agentConn),
-		unregister:  make(chan string),
-		dirRequests: make(map[string]chan json.RawMessage),
+		QueueTTL:     DefaultQueueTTL,
+		RecordingDir: "recordings",
+		store:        store,
+		agents:       make(map[string]*agentConn),
+		register:     make(chan *agentConn),
+		unregister:   make(chan *agentConn),
+		requests:     make(map[string]pendingRequest),
+		cmdSubs:      make(map[int64]map[chan CommandEvent]struct{}),
+		shells:       make(map[string]*ShellSession),
+		cmdRecs:      make(map[int64]*commandRecording),
 	}
 }
 
 func (h *Hub) Run() {
+	sweep := time.NewTicker(queueSweepInterval)
+	defer sweep.Stop()
+
 	for {
 		select {
 		case ac := <-h.register:
 			h.mu.Lock()
-			h.agents[ac.agentID] = ac.conn
+			h.agents[ac.agentID] = ac
 			h.mu.Unlock()
 			slog.Info("agent ws connected", "agent_id", ac.agentID)
+			go h.flushQueue(context.Background(), ac.agentID)
 
-		case agentID := <-h.unregister:
+		case ac := <-h.unregister:
+			ac.conn.Close()
 			h.mu.Lock()
-			if conn, ok := h.agents[agentID]; ok {
-				conn.Close()
-				delete(h.agents, agentID)
+			// Only drop the entry if it still belongs to this connection;
+			// the agent may already have reconnected.
+			cur, ok := h.agents[ac.agentID]
+			gone := ok && cur == ac
+			if gone {
+				delete(h.agents, ac.agentID)
 			}
 			h.mu.Unlock()
-			slog.Info("agent ws disconnected", "agent_id", agentID)
+			h.endConnShells(ac)
+			if gone {
+				go h.failRunningCommands(ac.agentID)
+			}
+			slog.Info("agent ws disconnected", "agent_id", ac.agentID)
+
+		case <-sweep.C:
+			go h.expireQueue()
 		}
 	}
 }
@@ -78,15 +129,17 @@ func (h *Hub) HandleAgentWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.register <- &agentConn{conn: conn, agentID: agentID}
+	ac := &agentConn{conn: conn, agentID: agentID}
+	h.register <- ac
 
 	// Read loop - handles command results from agent
-	go h.readPump(conn, agentID)
+	go h.readPump(ac)
 }
 
-func (h *Hub) readPump(conn *websocket.Conn, agentID string) {
+func (h *Hub) readPump(ac *agentConn) {
+	conn, agentID := ac.conn, ac.agentID
 	defer func() {
-		h.unregister <- agentID
+		h.unregister <- ac
 	}()
 
 	for {
@@ -105,21 +158,29 @@ func (h *Hub) readPump(conn *websocket.Conn, agentID string) {
 		}
 
 		switch msg.Type {
+		case "command_started":
+			h.handleCommandStarted(agentID, msg.Payload)
+		case "command_output":
+			h.handleCommandOutput(agentID, msg.Payload)
 		case "command_result":
-			h.handleCommandResult(msg.Payload)
-		case "file_download_result":
-			h.handleFileTransferResult(msg.Payload)
-		case "file_upload_result":
-			h.handleFileTransferResult(msg.Payload)
-		case "dir_list_result":
-			h.handleDirListResult(message)
+			h.handleCommandResult(tracing.Extract(context.Background(), msg.Trace), agentID, msg.Payload)
+		case "file_download_result", "file_upload_result":
+			h.handleFileTransferResult(tracing.Extract(context.Background(), msg.Trace), agentID, msg.Type, msg.Payload)
+		case "shell_output":
+			h.handleShellOutput(ac, msg.Payload)
+		case "shell_exit":
+			h.handleShellExit(ac, msg.Payload)
+		case "dir_list_result", "process_list_result", "process_kill_result", "credential_rotated":
+			h.handleRequestResult(agentID, message)
 		default:
 			slog.Debug("ws unknown message type", "type", msg.Type)
 		}
 	}
 }
 
-func (h *Hub) handleCommandResult(payload interface{}) {
+// handleCommandResult stores a command's outcome, in a span continuing the
+// trace of the agent's execution when the result carries one.
+func (h *Hub) handleCommandResult(ctx context.Context, agentID string, payload interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return
@@ -129,13 +190,37 @@ func (h *Hub) handleCommandResult(payload interface{}) {
 		slog.Warn("invalid command result", "error", err)
 		return
 	}
+	ctx, span := tracing.Tracer().Start(ctx, "ws.command_result", trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrAgentID.String(agentID), attrCommandID.Int64(result.CommandID), attrExitCode.Int(result.ExitCode)))
+	defer span.End()
+	if !h.ownsCommand(ctx, agentID, result.CommandID) {
+		return
+	}
+
+	status := result.Status
+	if status == "" {
+		status = models.CommandDone
+		if result.ExitCode != 0 {
+			status = models.CommandFailed
+		}
+	}
 
-	if err := h.store.UpdateCommandResult(result.CommandID, result.Stdout, result.Stderr, result.ExitCode); err != nil {
+	if !result.Streamed {
+		// Agents without streaming send the whole output at the end
+		if err := h.store.WithContext(ctx).SetCommandOutput(result.CommandID, result.Stdout, result.Stderr); err != nil {
+			slog.Error("update command result failed", "error", err)
+		}
+		h.recordCommandOutput(result.CommandID, models.StreamStdout, result.Stdout)
+		h.recordCommandOutput(result.CommandID, models.StreamStderr, result.Stderr)
+		h.publishCommand(result.CommandID, CommandEvent{Type: "output", Stream: models.StreamStdout, Data: result.Stdout})
+		h.publishCommand(result.CommandID, CommandEvent{Type: "output", Stream: models.StreamStderr, Data: result.Stderr})
+	}
+	if err := h.FinishCommand(ctx, result.CommandID, result.ExitCode, status); err != nil {
 		slog.Error("update command result failed", "error", err)
 	}
 }
 
-func (h *Hub) handleFileTransferResult(payload interface{}) {
+func (h *Hub) handleFileTransferResult(ctx context.Context, agentID, msgType string, payload interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return
@@ -149,99 +234,199 @@ func (h *Hub) handleFileTransferResult(payload interface{}) {
 		slog.Warn("invalid file transfer result", "error", err)
 		return
 	}
+	ctx, span := tracing.Tracer().Start(ctx, "ws."+msgType, trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrAgentID.String(agentID), attrTransferID.Int64(result.TransferID)))
+	defer span.End()
+	if !result.Success {
+		span.SetStatus(codes.Error, result.Error)
+	}
+
+	ft, err := h.store.WithContext(ctx).GetFileTransfer(result.TransferID)
+	if err != nil {
+		slog.Error("get file transfer failed", "error", err)
+		return
+	}
+	if ft == nil || ft.AgentID != agentID {
+		slog.Warn("dropping result for a file transfer of another agent", "agent_id", agentID, "transfer_id", result.TransferID)
+		return
+	}
 
 	status := models.TransferDone
 	if !result.Success {
 		status = models.TransferFailed
 	}
 
-	if err := h.store.UpdateFileTransferStatus(result.TransferID, status, result.Error); err != nil {
+	if err := h.store.WithContext(ctx).UpdateFileTransferStatus(result.TransferID, status, result.Error); err != nil {
 		slog.Error("update file transfer status failed", "error", err)
 	}
 }
 
-func (h *Hub) handleDirListResult(rawMessage []byte) {
-	// Extract request_id from the payload to route the response
+// ownsCommand reports whether a command belongs to agentID. Agents only
+// report on their own commands; messages about any other are dropped.
+func (h *Hub) ownsCommand(ctx context.Context, agentID string, commandID int64) bool {
+	cmd, err := h.store.WithContext(ctx).GetCommand(commandID)
+	if err != nil {
+		slog.Error("get command failed", "command_id", commandID, "error", err)
+		return false
+	}
+	if cmd == nil || cmd.AgentID != agentID {
+		slog.Warn("dropping message for a command of another agent", "agent_id", agentID, "command_id", commandID)
+		return false
+	}
+	return true
+}
+
+// pendingRequest is a request waiting for the answer of the agent it was
+// sent to.
+type pendingRequest struct {
+	agentID string
+	result  chan json.RawMessage
+}
+
+// handleRequestResult hands an agent's answer to the request waiting for it.
+// Answers to requests sent to another agent are dropped.
+func (h *Hub) handleRequestResult(agentID string, rawMessage []byte) {
+	var msg struct {
+		Payload json.RawMessage `json:"payload"`
+	}
 	var envelope struct {
-		Payload struct {
-			RequestID string `json:"request_id"`
-		} `json:"payload"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(rawMessage, &msg); err != nil {
+		slog.Warn("invalid request result", "error", err)
+		return
 	}
-	if err := json.Unmarshal(rawMessage, &envelope); err != nil {
-		slog.Warn("invalid dir_list_result", "error", err)
+	if err := json.Unmarshal(msg.Payload, &envelope); err != nil {
+		slog.Warn("invalid request result", "error", err)
 		return
 	}
 
-	h.dirRequestsMu.Lock()
-	ch, ok := h.dirRequests[envelope.Payload.RequestID]
-	if ok {
-		delete(h.dirRequests, envelope.Payload.RequestID)
+	h.requestsMu.Lock()
+	req, ok := h.requests[envelope.RequestID]
+	owned := ok && req.agentID == agentID
+	if owned {
+		delete(h.requests, envelope.RequestID)
 	}
-	h.dirRequestsMu.Unlock()
+	h.requestsMu.Unlock()
 
+	if ok && !owned {
+		slog.Warn("dropping result for a request to another agent", "agent_id", agentID, "request_id", envelope.RequestID)
+		return
+	}
 	if ok {
-		// Extract just the payload
-		var msg struct {
-			Payload json.RawMessage `json:"payload"`
-		}
-		json.Unmarshal(rawMessage, &msg)
-		ch <- msg.Payload
+		req.result <- msg.Payload
 	}
 }
 
-// BrowseAgent sends a dir_list command to an agent and waits for the response
-func (h *Hub) BrowseAgent(agentID, path string) (json.RawMessage, error) {
-	requestID := fmt.Sprintf("dir_%d", time.Now().UnixNano())
+// request sends msgType to an agent and waits for the result carrying the
+// same request_id. The round trip is traced as one span.
+func (h *Hub) request(ctx context.Context, agentID, msgType string, payload map[string]interface{}, timeout time.Duration) (_ json.RawMessage, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ws.request "+msgType, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrAgentID.String(agentID)))
+	defer func() { endSpan(span, err) }()
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	requestID := msgType + "_" + hex.EncodeToString(b)
+	payload["request_id"] = requestID
 
-	// Create response channel
 	ch := make(chan json.RawMessage, 1)
-	h.dirRequestsMu.Lock()
-	h.dirRequests[requestID] = ch
-	h.dirRequestsMu.Unlock()
+	h.requestsMu.Lock()
+	h.requests[requestID] = pendingRequest{agentID: agentID, result: ch}
+	h.requestsMu.Unlock()
 
-	// Cleanup on exit
 	defer func() {
-		h.dirRequestsMu.Lock()
-		delete(h.dirRequests, requestID)
-		h.dirRequestsMu.Unlock()
+		h.requestsMu.Lock()
+		delete(h.requests, requestID)
+		h.requestsMu.Unlock()
 	}()
 
-	// Send command
-	msg := models.WSMessage{
-		Type: "dir_list",
-		Payload: map[string]interface{}{
-			"request_id": requestID,
-			"path":       path,
-		},
-	}
-	if err := h.SendToAgent(agentID, msg); err != nil {
+	if err := h.SendToAgent(ctx, agentID, models.WSMessage{Type: msgType, Payload: payload}); err != nil {
 		return nil, err
 	}
 
-	// Wait for response with timeout
 	select {
 	case result := <-ch:
 		return result, nil
-	case <-time.After(10 * time.Second):
-		return nil, fmt.Errorf("timeout waiting for directory listing")
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timeout waiting for %s result", msgType)
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
-func (h *Hub) SendToAgent(agentID string, msg models.WSMessage) error {
+// BrowseAgent sends a dir_list command to an agent and waits for the response
+func (h *Hub) BrowseAgent(ctx context.Context, agentID, path string) (json.RawMessage, error) {
+	return h.request(ctx, agentID, "dir_list", map[string]interface{}{"path": path}, 10*time.Second)
+}
+
+// ListProcesses asks an agent for its running processes.
+func (h *Hub) ListProcesses(ctx context.Context, agentID string) (json.RawMessage, error) {
+	return h.request(ctx, agentID, "process_list", map[string]interface{}{}, 15*time.Second)
+}
+
+// KillProcess asks an agent to send sig to a process. An agent-side failure
+// (no such process, permission denied) is reported in the result's Error.
+func (h *Hub) KillProcess(ctx context.Context, agentID string, pid int32, sig models.ProcessSignal) (*models.ProcessKillResult, error) {
+	raw, err := h.request(ctx, agentID, "process_kill", map[string]interface{}{"pid": pid, "signal": sig}, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	var result models.ProcessKillResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RotateCredential pushes a new secret to an agent and waits until the agent
+// has saved it. An agent-side failure is reported in the result's Error.
+func (h *Hub) RotateCredential(ctx context.Context, agentID, secret string) (*models.CredentialRotated, error) {
+	raw, err := h.request(ctx, agentID, "credential_rotate", map[string]interface{}{"secret": secret}, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	var result models.CredentialRotated
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SendToAgent writes msg to the agent's WebSocket, carrying the trace
+// context of ctx so the agent's spans join the caller's trace.
+func (h *Hub) SendToAgent(ctx context.Context, agentID string, msg models.WSMessage) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ws.send "+msg.Type, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attrAgentID.String(agentID)))
+	defer func() { endSpan(span, err) }()
+
 	h.mu.RLock()
-	conn, ok := h.agents[agentID]
+	ac, ok := h.agents[agentID]
 	h.mu.RUnlock()
 
 	if !ok {
 		return fmt.Errorf("agent %s not connected", agentID)
 	}
 
+	msg.Trace = tracing.Inject(ctx)
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
-	return conn.WriteMessage(websocket.TextMessage, data)
+	return ac.write(data)
+}
+
+// Disconnect closes the agent's WebSocket, e.g. after its credential was revoked.
+func (h *Hub) Disconnect(agentID string) {
+	h.mu.RLock()
+	ac, ok := h.agents[agentID]
+	h.mu.RUnlock()
+	if ok {
+		ac.conn.Close()
+	}
 }
 
 func (h *Hub) IsConnected(agentID string) bool {
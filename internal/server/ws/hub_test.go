@@ -0,0 +1,120 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/gorilla/websocket"
+)
+
+// TestRequestCancelled checks that a request stops waiting for an agent that
+// doesn't answer once the caller gives up.
+func TestRequestCancelled(t *testing.T) {
+	store, err := db.New(filepath.Join(t.TempDir(), "rmm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	hub := NewHub(store)
+	go hub.Run()
+	srv := httptest.NewServer(http.HandlerFunc(hub.HandleAgentWS))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?agent_id=agent-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, func() bool { return hub.IsConnected("agent-1") })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := hub.ListProcesses(ctx, "agent-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("request waited %s after its context ended", waited)
+	}
+	hub.requestsMu.Lock()
+	defer hub.requestsMu.Unlock()
+	if len(hub.requests) != 0 {
+		t.Errorf("expected the pending request to be dropped, %d left", len(hub.requests))
+	}
+}
+
+// TestRequestAnsweredByOtherAgent checks that an agent can't answer a request
+// sent to another one.
+func TestRequestAnsweredByOtherAgent(t *testing.T) {
+	store, err := db.New(filepath.Join(t.TempDir(), "rmm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	hub := NewHub(store)
+	go hub.Run()
+	srv := httptest.NewServer(http.HandlerFunc(hub.HandleAgentWS))
+	defer srv.Close()
+
+	dial := func(agentID string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?agent_id="+agentID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		waitFor(t, func() bool { return hub.IsConnected(agentID) })
+		return conn
+	}
+	victim, other := dial("agent-1"), dial("agent-2")
+
+	type answer struct {
+		raw json.RawMessage
+		err error
+	}
+	done := make(chan answer, 1)
+	go func() {
+		raw, err := hub.ListProcesses(context.Background(), "agent-1")
+		done <- answer{raw, err}
+	}()
+
+	var requestID string
+	waitFor(t, func() bool {
+		hub.requestsMu.Lock()
+		defer hub.requestsMu.Unlock()
+		for id := range hub.requests {
+			requestID = id
+		}
+		return requestID != ""
+	})
+	reply := func(conn *websocket.Conn, processes string) {
+		msg := `{"type":"process_list_result","payload":{"request_id":"` + requestID + `","processes":` + processes + `}}`
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reply(other, `["forged"]`)
+	select {
+	case a := <-done:
+		t.Fatalf("request answered by another agent: %s %v", a.raw, a.err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	reply(victim, `["sshd"]`)
+	select {
+	case a := <-done:
+		if a.err != nil || !strings.Contains(string(a.raw), "sshd") {
+			t.Errorf("expected agent-1's answer, got %s %v", a.raw, a.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent-1's answer was not delivered")
+	}
+}
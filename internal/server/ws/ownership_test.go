@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/gorilla/websocket"
+)
+
+// TestForeignCommandMessages checks that an agent can't report on another
+// agent's commands or file transfers.
+func TestForeignCommandMessages(t *testing.T) {
+	store, err := db.New(filepath.Join(t.TempDir(), "rmm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	hub := NewHub(store)
+	hub.RecordingDir = t.TempDir()
+	go hub.Run()
+	srv := httptest.NewServer(http.HandlerFunc(hub.HandleAgentWS))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?agent_id=agent-2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	victim, _ := store.CreateCommand("agent-1", "whoami", 0)
+	transfer, _ := store.CreateFileTransfer("agent-1", "f.txt", 1, models.TransferFromAgent, "", "/tmp/f.txt")
+	own, _ := store.CreateCommand("agent-2", "uptime", 0)
+
+	for _, msg := range []models.WSMessage{
+		{Type: "command_started", Payload: map[string]any{"command_id": victim.ID}},
+		{Type: "command_output", Payload: models.CommandOutput{CommandID: victim.ID, Stream: models.StreamStdout, Data: "forged"}},
+		{Type: "command_result", Payload: models.CommandResult{CommandID: victim.ID, Streamed: true}},
+		{Type: "file_download_result", Payload: map[string]any{"transfer_id": transfer.ID, "success": true}},
+		// Messages are handled in order, so once this one is in the others are too
+		{Type: "command_result", Payload: models.CommandResult{CommandID: own.ID, Streamed: true}},
+	} {
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		c, _ := store.GetCommand(own.ID)
+		return c.Status == models.CommandDone
+	})
+
+	if c, _ := store.GetCommand(victim.ID); c.Status != models.CommandPending || c.Stdout != "" {
+		t.Errorf("another agent changed the command: status %s, stdout %q", c.Status, c.Stdout)
+	}
+	if ft, _ := store.GetFileTransfer(transfer.ID); ft.Status == models.TransferDone {
+		t.Error("another agent finished the file transfer")
+	}
+}
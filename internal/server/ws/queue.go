@@ -0,0 +1,107 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultQueueTTL is how long queued messages wait for an offline agent.
+const DefaultQueueTTL = 24 * time.Hour
+
+const queueSweepInterval = 30 * time.Second
+
+// Dispatch queues msg for an agent and delivers it right away if the agent is
+// connected. Queued messages are delivered in order when the agent's
+// WebSocket registers, or expire after QueueTTL. The message carries the
+// trace context of ctx, so the agent's spans join the trace even when it is
+// delivered later.
+func (h *Hub) Dispatch(ctx context.Context, agentID string, kind models.QueueKind, refID int64, summary string, msg models.WSMessage) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ws.dispatch "+msg.Type, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attrAgentID.String(agentID), attribute.Bool("agent.connected", h.IsConnected(agentID))))
+	defer func() { endSpan(span, err) }()
+
+	msg.Trace = tracing.Inject(ctx)
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := h.store.WithContext(ctx).EnqueueMessage(agentID, kind, refID, summary, data, h.QueueTTL); err != nil {
+		return err
+	}
+	h.flushQueue(ctx, agentID)
+	return nil
+}
+
+// flushQueue sends an agent's queued messages in order, stopping at the first
+// failed write. Flushes over the same connection are serialized.
+func (h *Hub) flushQueue(ctx context.Context, agentID string) {
+	h.mu.RLock()
+	ac, ok := h.agents[agentID]
+	h.mu.RUnlock()
+	if !ok {
+		return
+	}
+	ac.flushMu.Lock()
+	defer ac.flushMu.Unlock()
+
+	store := h.store.WithContext(ctx)
+	msgs, err := store.ListQueuedMessages(agentID)
+	if err != nil {
+		slog.Error("list queued messages failed", "agent_id", agentID, "error", err)
+		return
+	}
+	now := time.Now()
+	for _, m := range msgs {
+		if !m.ExpiresAt.After(now) {
+			continue // left for the sweeper, which also updates the command/transfer
+		}
+		// Claim the message first: it may have been cancelled since it
+		// was listed
+		claimed, err := store.ClaimMessage(m.ID)
+		if err != nil {
+			slog.Error("claim queued message failed", "queue_id", m.ID, "error", err)
+			return
+		}
+		if !claimed {
+			continue
+		}
+		if err := ac.write(m.Payload); err != nil {
+			slog.Warn("queued message not delivered", "agent_id", agentID, "queue_id", m.ID, "error", err)
+			if err := store.UnclaimMessage(m.ID); err != nil {
+				slog.Error("requeue message failed", "queue_id", m.ID, "error", err)
+			}
+			return
+		}
+		slog.Debug("queued message delivered", "agent_id", agentID, "kind", m.Kind, "ref_id", m.RefID)
+	}
+}
+
+// expireQueue marks messages past their TTL as expired, together with the
+// command or file transfer they carried.
+func (h *Hub) expireQueue() {
+	msgs, err := h.store.ExpireQueuedMessages(time.Now())
+	if err != nil {
+		slog.Error("expire queued messages failed", "error", err)
+		return
+	}
+	for _, m := range msgs {
+		slog.Info("queued message expired", "agent_id", m.AgentID, "kind", m.Kind, "ref_id", m.RefID)
+		switch m.Kind {
+		case models.QueueCommand:
+			if err := h.FinishCommand(context.Background(), m.RefID, -1, models.CommandExpired); err != nil {
+				slog.Error("expire command failed", "command_id", m.RefID, "error", err)
+			}
+		case models.QueueFileTransfer:
+			if err := h.store.UpdateFileTransferStatus(m.RefID, models.TransferExpired, "agent offline past queue TTL"); err != nil {
+				slog.Error("expire file transfer failed", "transfer_id", m.RefID, "error", err)
+			}
+		}
+	}
+}
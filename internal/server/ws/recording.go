@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/recording"
+)
+
+// Command transcripts are rendered as a terminal session of this size.
+const (
+	commandRecordingCols = 120
+	commandRecordingRows = 40
+)
+
+type commandRecording struct {
+	id int64
+	w  *recording.Writer
+}
+
+// RecordCommand starts the transcript of a command; its output is appended
+// as it streams in and the recording is closed when the command finishes.
+// It returns the recording ID for the audit log.
+func (h *Hub) RecordCommand(cmd *models.Command, username string) (int64, error) {
+	path := filepath.Join(h.RecordingDir, fmt.Sprintf("command-%d.cast", cmd.ID))
+	title := fmt.Sprintf("%s@%s: %s", username, cmd.AgentID, cmd.Command)
+	w, err := recording.Create(path, commandRecordingCols, commandRecordingRows, title)
+	if err != nil {
+		return 0, err
+	}
+	rec, err := h.store.CreateRecording(models.RecordingCommand, cmd.AgentID, strconv.FormatInt(cmd.ID, 10), username, title, path)
+	if err != nil {
+		w.Close()
+		return 0, err
+	}
+	w.Input(cmd.Command + "\r")
+	w.Output([]byte("$ " + cmd.Command + "\r\n"))
+
+	h.cmdRecsMu.Lock()
+	h.cmdRecs[cmd.ID] = &commandRecording{id: rec.ID, w: w}
+	h.cmdRecsMu.Unlock()
+	return rec.ID, nil
+}
+
+// recordCommandOutput appends output to a command's transcript. Command
+// output uses bare newlines, which a terminal player needs as CRLF.
+func (h *Hub) recordCommandOutput(commandID int64, stream, data string) {
+	if data == "" {
+		return
+	}
+	h.cmdRecsMu.Lock()
+	rec := h.cmdRecs[commandID]
+	h.cmdRecsMu.Unlock()
+	if rec == nil {
+		return
+	}
+
+	data = strings.ReplaceAll(strings.ReplaceAll(data, "\r\n", "\n"), "\n", "\r\n")
+	if stream == models.StreamStderr {
+		data = "\x1b[31m" + data + "\x1b[0m"
+	}
+	if err := rec.w.Output([]byte(data)); err != nil {
+		slog.Error("write command recording failed", "command_id", commandID, "error", err)
+	}
+}
+
+// finishCommandRecording closes a command's transcript with its outcome.
+func (h *Hub) finishCommandRecording(commandID int64, exitCode int, status models.CommandStatus) {
+	h.cmdRecsMu.Lock()
+	rec := h.cmdRecs[commandID]
+	delete(h.cmdRecs, commandID)
+	h.cmdRecsMu.Unlock()
+
+	if rec == nil {
+		// Started before a server restart: the transcript stops where the
+		// previous process left it
+		old, err := h.store.GetRecordingByRef(models.RecordingCommand, strconv.FormatInt(commandID, 10))
+		if err == nil && old != nil && old.EndedAt == nil {
+			h.store.FinishRecording(old.ID, fileSize(old.Path))
+		}
+		return
+	}
+
+	rec.w.Output([]byte(fmt.Sprintf("\r\n[%s, exit %d]\r\n", status, exitCode)))
+	if err := rec.w.Close(); err != nil {
+		slog.Error("close command recording failed", "command_id", commandID, "error", err)
+	}
+	if err := h.store.FinishRecording(rec.id, rec.w.Size()); err != nil {
+		slog.Error("finish command recording failed", "command_id", commandID, "error", err)
+	}
+}
+
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
@@ -0,0 +1,210 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// shellOutputBuffer bounds the output chunks waiting for the browser; a
+// session whose viewer falls further behind is closed rather than stalling
+// the agent's read loop.
+const shellOutputBuffer = 1024
+
+// ShellSession is the server side of an interactive shell on an agent.
+type ShellSession struct {
+	ID      string
+	AgentID string
+
+	conn   *agentConn // the connection the shell runs behind
+	output chan []byte
+	done   chan struct{}
+	once   sync.Once
+
+	// Set before done is closed
+	ExitCode int
+	Err      string
+}
+
+// Output delivers terminal output. Everything the agent sent before the
+// session ended is buffered here by the time Done is closed.
+func (s *ShellSession) Output() <-chan []byte { return s.output }
+
+// Done is closed when the session has ended.
+func (s *ShellSession) Done() <-chan struct{} { return s.done }
+
+// OpenShell asks the agent to start a shell on a pseudo-terminal.
+func (h *Hub) OpenShell(agentID string, cols, rows int) (*ShellSession, error) {
+	h.mu.RLock()
+	ac, ok := h.agents[agentID]
+	h.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("agent %s not connected", agentID)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	s := &ShellSession{
+		ID:      hex.EncodeToString(b),
+		AgentID: agentID,
+		conn:    ac,
+		output:  make(chan []byte, shellOutputBuffer),
+		done:    make(chan struct{}),
+	}
+
+	h.shellsMu.Lock()
+	h.shells[s.ID] = s
+	h.shellsMu.Unlock()
+
+	err := s.send(models.WSMessage{
+		Type: "shell_open",
+		Payload: map[string]interface{}{
+			"session_id": s.ID,
+			"cols":       cols,
+			"rows":       rows,
+		},
+	})
+	if err != nil {
+		h.endShell(s, -1, err.Error())
+		return nil, err
+	}
+	return s, nil
+}
+
+// ShellInput sends keystrokes to the shell.
+func (h *Hub) ShellInput(s *ShellSession, data []byte) error {
+	return s.send(models.WSMessage{
+		Type: "shell_input",
+		Payload: map[string]interface{}{
+			"session_id": s.ID,
+			"data":       base64.StdEncoding.EncodeToString(data),
+		},
+	})
+}
+
+func (h *Hub) ResizeShell(s *ShellSession, cols, rows int) error {
+	return s.send(models.WSMessage{
+		Type: "shell_resize",
+		Payload: map[string]interface{}{
+			"session_id": s.ID,
+			"cols":       cols,
+			"rows":       rows,
+		},
+	})
+}
+
+// CloseShell kills the shell on the agent and ends the session.
+func (h *Hub) CloseShell(s *ShellSession) {
+	s.send(models.WSMessage{
+		Type:    "shell_close",
+		Payload: map[string]interface{}{"session_id": s.ID},
+	})
+	h.endShell(s, -1, "closed")
+}
+
+func (h *Hub) endShell(s *ShellSession, exitCode int, errMsg string) {
+	s.once.Do(func() {
+		h.shellsMu.Lock()
+		delete(h.shells, s.ID)
+		h.shellsMu.Unlock()
+
+		s.ExitCode = exitCode
+		s.Err = errMsg
+		close(s.done)
+	})
+}
+
+func (s *ShellSession) send(msg models.WSMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.conn.write(data)
+}
+
+// endConnShells ends the sessions behind a WebSocket that went away; the
+// agent kills its shells when it loses the connection.
+func (h *Hub) endConnShells(ac *agentConn) {
+	h.shellsMu.Lock()
+	var ended []*ShellSession
+	for _, s := range h.shells {
+		if s.conn == ac {
+			ended = append(ended, s)
+		}
+	}
+	h.shellsMu.Unlock()
+	for _, s := range ended {
+		h.endShell(s, -1, "agent disconnected")
+	}
+}
+
+func (h *Hub) shellSession(ac *agentConn, id string) *ShellSession {
+	h.shellsMu.Lock()
+	defer h.shellsMu.Unlock()
+	s := h.shells[id]
+	if s == nil || s.conn != ac {
+		return nil
+	}
+	return s
+}
+
+func (h *Hub) handleShellOutput(ac *agentConn, payload interface{}) {
+	var p struct {
+		SessionID string `json:"session_id"`
+		Data      string `json:"data"`
+	}
+	if err := decodePayload(payload, &p); err != nil {
+		slog.Warn("invalid shell output", "error", err)
+		return
+	}
+	s := h.shellSession(ac, p.SessionID)
+	if s == nil {
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(p.Data)
+	if err != nil {
+		slog.Warn("invalid shell output", "error", err)
+		return
+	}
+	select {
+	case <-s.done:
+	case s.output <- data:
+	default:
+		slog.Warn("shell viewer too slow, closing session", "session_id", s.ID)
+		h.CloseShell(s)
+	}
+}
+
+func (h *Hub) handleShellExit(ac *agentConn, payload interface{}) {
+	var p struct {
+		SessionID string `json:"session_id"`
+		ExitCode  int    `json:"exit_code"`
+		Error     string `json:"error"`
+	}
+	if err := decodePayload(payload, &p); err != nil {
+		slog.Warn("invalid shell exit", "error", err)
+		return
+	}
+	if s := h.shellSession(ac, p.SessionID); s != nil {
+		h.endShell(s, p.ExitCode, p.Error)
+	}
+}
+
+func decodePayload(payload interface{}, v interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode payload: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+)
+
+// CommandEvent is delivered to live subscribers of a command.
+// Type is "output" (Stream/Data/Offset), "status" (Status) or "done" (Status/ExitCode).
+type CommandEvent struct {
+	Type     string               `json:"type"`
+	Stream   string               `json:"stream,omitempty"`
+	Data     string               `json:"data,omitempty"`
+	Offset   int64                `json:"offset"`
+	Status   models.CommandStatus `json:"status,omitempty"`
+	ExitCode int                  `json:"exit_code"`
+}
+
+// subscriberBuffer bounds how far a slow subscriber may fall behind before it
+// is dropped; it can reconnect and catch up from the stored output.
+const subscriberBuffer = 256
+
+// SubscribeCommand registers for live events of a command. The channel is
+// closed when cancel is called or the subscriber falls too far behind.
+func (h *Hub) SubscribeCommand(commandID int64) (<-chan CommandEvent, func()) {
+	ch := make(chan CommandEvent, subscriberBuffer)
+
+	h.cmdSubsMu.Lock()
+	if h.cmdSubs[commandID] == nil {
+		h.cmdSubs[commandID] = make(map[chan CommandEvent]struct{})
+	}
+	h.cmdSubs[commandID][ch] = struct{}{}
+	h.cmdSubsMu.Unlock()
+
+	cancel := func() {
+		h.cmdSubsMu.Lock()
+		defer h.cmdSubsMu.Unlock()
+		h.removeSubscriber(commandID, ch)
+	}
+	return ch, cancel
+}
+
+// removeSubscriber must be called with cmdSubsMu held.
+func (h *Hub) removeSubscriber(commandID int64, ch chan CommandEvent) {
+	subs := h.cmdSubs[commandID]
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(h.cmdSubs, commandID)
+	}
+}
+
+func (h *Hub) publishCommand(commandID int64, ev CommandEvent) {
+	if ev.Type == "output" && ev.Data == "" {
+		return
+	}
+	h.cmdSubsMu.Lock()
+	defer h.cmdSubsMu.Unlock()
+	for ch := range h.cmdSubs[commandID] {
+		select {
+		case ch <- ev:
+		default:
+			slog.Warn("dropping slow command output subscriber", "command_id", commandID)
+			h.removeSubscriber(commandID, ch)
+		}
+	}
+}
+
+// FinishCommand stores a command's final status, closes its transcript and
+// notifies live subscribers. A command that has already finished keeps its
+// status and nothing is published.
+func (h *Hub) FinishCommand(ctx context.Context, commandID int64, exitCode int, status models.CommandStatus) error {
+	finished, err := h.store.WithContext(ctx).FinishCommand(commandID, exitCode, status)
+	if err != nil || !finished {
+		return err
+	}
+	h.finishCommandRecording(commandID, exitCode, status)
+	h.publishCommand(commandID, CommandEvent{Type: "done", Status: status, ExitCode: exitCode})
+	return nil
+}
+
+// failRunningCommands fails the commands an agent was running when its
+// connection dropped and closes their transcripts. A result the agent sends
+// after reconnecting is dropped like for any finished command.
+func (h *Hub) failRunningCommands(agentID string) {
+	ids, err := h.store.RunningCommandIDs(agentID)
+	if err != nil {
+		slog.Error("list running commands failed", "agent_id", agentID, "error", err)
+		return
+	}
+	for _, id := range ids {
+		if err := h.FinishCommand(context.Background(), id, -1, models.CommandFailed); err != nil {
+			slog.Error("fail running command failed", "command_id", id, "error", err)
+		}
+	}
+	if len(ids) > 0 {
+		slog.Warn("agent disconnected with commands running", "agent_id", agentID, "failed", len(ids))
+	}
+}
+
+func (h *Hub) handleCommandStarted(agentID string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var started struct {
+		CommandID int64 `json:"command_id"`
+	}
+	if err := json.Unmarshal(data, &started); err != nil {
+		slog.Warn("invalid command_started", "error", err)
+		return
+	}
+	if !h.ownsCommand(context.Background(), agentID, started.CommandID) {
+		return
+	}
+
+	if err := h.store.MarkCommandRunning(started.CommandID); err != nil {
+		slog.Error("mark command running failed", "error", err)
+	}
+	h.publishCommand(started.CommandID, CommandEvent{Type: "status", Status: models.CommandRunning})
+}
+
+func (h *Hub) handleCommandOutput(agentID string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	var out models.CommandOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		slog.Warn("invalid command_output", "error", err)
+		return
+	}
+	if !h.ownsCommand(context.Background(), agentID, out.CommandID) {
+		return
+	}
+
+	if err := h.store.AppendCommandOutput(out.CommandID, out.Stream, out.Data); err != nil {
+		slog.Error("append command output failed", "error", err)
+	}
+	h.recordCommandOutput(out.CommandID, out.Stream, out.Data)
+	h.publishCommand(out.CommandID, CommandEvent{
+		Type:   "output",
+		Stream: out.Stream,
+		Data:   out.Data,
+		Offset: out.Offset,
+	})
+}
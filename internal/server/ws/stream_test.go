@@ -0,0 +1,61 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/gorilla/websocket"
+)
+
+// TestDisconnectFailsRunningCommands checks that commands still running when
+// their agent goes away are failed and their transcripts closed.
+func TestDisconnectFailsRunningCommands(t *testing.T) {
+	store, err := db.New(filepath.Join(t.TempDir(), "rmm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	hub := NewHub(store)
+	hub.RecordingDir = t.TempDir()
+	go hub.Run()
+	srv := httptest.NewServer(http.HandlerFunc(hub.HandleAgentWS))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?agent_id=agent-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	running, _ := store.CreateCommand("agent-1", "sleep 60", 0)
+	if _, err := hub.RecordCommand(running, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := store.CreateCommand("agent-1", "uptime", 0)
+	if err := conn.WriteJSON(models.WSMessage{Type: "command_started", Payload: map[string]any{"command_id": running.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		c, _ := store.GetCommand(running.ID)
+		return c.Status == models.CommandRunning
+	})
+
+	conn.Close()
+	ref := strconv.FormatInt(running.ID, 10)
+	waitFor(t, func() bool {
+		rec, _ := store.GetRecordingByRef(models.RecordingCommand, ref)
+		return rec != nil && rec.EndedAt != nil
+	})
+	if c, _ := store.GetCommand(running.ID); c.Status != models.CommandFailed {
+		t.Errorf("expected the running command to fail, got %s", c.Status)
+	}
+	// Queued commands are still delivered when the agent comes back
+	if c, _ := store.GetCommand(pending.ID); c.Status != models.CommandPending {
+		t.Errorf("expected the pending command to stay pending, got %s", c.Status)
+	}
+}
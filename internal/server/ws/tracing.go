@@ -0,0 +1,23 @@
+package ws
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attributes of agent round trips.
+const (
+	attrAgentID    = attribute.Key("agent.id")
+	attrCommandID  = attribute.Key("command.id")
+	attrExitCode   = attribute.Key("command.exit_code")
+	attrTransferID = attribute.Key("file_transfer.id")
+)
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,118 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cevrimxe/go-mini-rmm/internal/models"
+	"github.com/cevrimxe/go-mini-rmm/internal/server/db"
+	"github.com/cevrimxe/go-mini-rmm/internal/tracing"
+	"github.com/gorilla/websocket"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestCommandTrace follows a command from the API through the hub to a fake
+// agent and back, and expects one trace covering all of it.
+func TestCommandTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.Install(sdktrace.WithSyncer(exporter), "test", "dev", 1)
+	defer tp.Shutdown(context.Background())
+
+	store, err := db.New(filepath.Join(t.TempDir(), "rmm.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	hub := NewHub(store)
+	go hub.Run()
+	srv := httptest.NewServer(http.HandlerFunc(hub.HandleAgentWS))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?agent_id=agent-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, func() bool { return hub.IsConnected("agent-1") })
+
+	cmd, err := store.CreateCommand("agent-1", "uptime", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, api := tracing.Tracer().Start(context.Background(), "api")
+	msg := models.WSMessage{Type: "command", Payload: map[string]interface{}{"command_id": cmd.ID, "command": "uptime"}}
+	if err := hub.Dispatch(ctx, "agent-1", models.QueueCommand, cmd.ID, "uptime", msg); err != nil {
+		t.Fatal(err)
+	}
+	api.End()
+
+	// The agent continues the trace it was sent and reports back in it
+	var got models.WSMessage
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatal(err)
+	}
+	agentCtx, agentSpan := tracing.Tracer().Start(tracing.Extract(context.Background(), got.Trace), "agent.command")
+	if agentSpan.SpanContext().TraceID() != api.SpanContext().TraceID() {
+		t.Fatalf("agent span is not part of the API trace: %v", got.Trace)
+	}
+	agentSpan.End()
+	err = conn.WriteJSON(models.WSMessage{
+		Type:    "command_result",
+		Trace:   tracing.Inject(agentCtx),
+		Payload: models.CommandResult{CommandID: cmd.ID, Streamed: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return spanNamed(exporter.GetSpans(), "ws.command_result") != nil })
+
+	spans := exporter.GetSpans()
+	for _, s := range spans {
+		if s.SpanContext.TraceID() != api.SpanContext().TraceID() {
+			t.Errorf("%s is not part of the API trace", s.Name)
+		}
+	}
+	for child, parent := range map[string]trace.SpanContext{
+		"ws.dispatch command": api.SpanContext(),
+		"agent.command":       spanNamed(spans, "ws.dispatch command").SpanContext,
+		"ws.command_result":   agentSpan.SpanContext(),
+		"db.FinishCommand":    spanNamed(spans, "ws.command_result").SpanContext,
+	} {
+		s := spanNamed(spans, child)
+		if s == nil {
+			t.Errorf("no %s span", child)
+		} else if s.Parent.SpanID() != parent.SpanID() {
+			t.Errorf("%s has the wrong parent", child)
+		}
+	}
+	if s := spanNamed(spans, "db.EnqueueMessage"); s == nil || s.Parent.SpanID() != spanNamed(spans, "ws.dispatch command").SpanContext.SpanID() {
+		t.Error("expected the queue insert inside the dispatch span")
+	}
+}
+
+func spanNamed(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
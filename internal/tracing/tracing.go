@@ -0,0 +1,117 @@
+// Package tracing sets up OpenTelemetry tracing for the server and the agent
+// and carries trace context across HTTP requests and WebSocket messages, so
+// one trace follows a command from the API through the hub to the agent and
+// back.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/cevrimxe/go-mini-rmm"
+
+// propagator reads and writes W3C traceparent/tracestate. It is used
+// directly rather than through the global one, so trace context is passed
+// on even by a side that doesn't export spans itself.
+var propagator = propagation.TraceContext{}
+
+// Config says where spans go. Tracing is off when Endpoint is empty.
+type Config struct {
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://localhost:4318;
+	// spans are posted to /v1/traces unless it has a path of its own.
+	Endpoint string
+	// SampleRatio is the share of new traces recorded; traces continued from
+	// a caller follow the caller's decision.
+	SampleRatio float64
+}
+
+func (c Config) Enabled() bool {
+	return c.Endpoint != ""
+}
+
+// Tracer returns the tracer of this module. Until Setup or Install runs it
+// is a no-op.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Setup exports spans of service via OTLP/HTTP; version may be empty. It returns a function that
+// flushes and stops the exporter; without an endpoint it does nothing.
+func Setup(ctx context.Context, cfg Config, service, version string) (shutdown func(context.Context) error, err error) {
+	if !cfg.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("otlp endpoint: %w", err)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		// A collector's base URL, as with OTEL_EXPORTER_OTLP_ENDPOINT
+		endpoint.Path = "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint.String()))
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+	tp := Install(sdktrace.WithBatcher(exporter), service, version, cfg.SampleRatio)
+	return tp.Shutdown, nil
+}
+
+// Install makes a tracer provider with the given span processor the global
+// one. Tests pass sdktrace.WithSyncer with an in-memory exporter.
+func Install(processor sdktrace.TracerProviderOption, service, version string, sampleRatio float64) *sdktrace.TracerProvider {
+	attrs := []attribute.KeyValue{semconv.ServiceName(service)}
+	if version != "" {
+		attrs = append(attrs, semconv.ServiceVersion(version))
+	}
+	res, _ := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+	tp := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return tp
+}
+
+// Inject returns the trace context of ctx for a WebSocket message; nil if
+// ctx has no span.
+func Inject(ctx context.Context) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// Extract returns ctx continuing the trace a WebSocket message carries.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// InjectHeader adds the trace context of ctx to an outgoing request.
+func InjectHeader(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// ExtractHeader returns ctx continuing the trace of an incoming request.
+func ExtractHeader(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := Install(sdktrace.WithSyncer(exporter), "test", "dev", 1)
+	defer tp.Shutdown(context.Background())
+
+	if carrier := Inject(context.Background()); carrier != nil {
+		t.Errorf("expected no trace context without a span, got %v", carrier)
+	}
+
+	ctx, parent := Tracer().Start(context.Background(), "api")
+	carrier := Inject(ctx)
+	if carrier["traceparent"] == "" {
+		t.Fatalf("expected a traceparent, got %v", carrier)
+	}
+
+	// Across a WebSocket message
+	_, child := Tracer().Start(Extract(context.Background(), carrier), "agent")
+	child.End()
+	// Across an HTTP request
+	h := http.Header{}
+	InjectHeader(ctx, h)
+	_, req := Tracer().Start(ExtractHeader(context.Background(), h), "request")
+	req.End()
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	root := spans[2].SpanContext
+	for _, s := range spans[:2] {
+		if s.SpanContext.TraceID() != root.TraceID() || s.Parent.SpanID() != root.SpanID() {
+			t.Errorf("%s is not a child of the api span", s.Name)
+		}
+	}
+	if got := spans[2].Resource.Attributes(); len(got) == 0 {
+		t.Error("expected resource attributes")
+	}
+
+	if ctx := Extract(context.Background(), nil); ctx != context.Background() {
+		t.Error("an empty carrier must leave the context alone")
+	}
+}
@@ -1,5 +1,5 @@
 # Go Mini RMM - Windows Agent Installer
-# Usage: irm http://SERVER:PORT/install.ps1 | iex
+# Usage: $env:RMM_ENROLL_TOKEN="TOKEN"; irm http://SERVER:PORT/install.ps1 | iex
 
 $ServerURL = "__RMM_SERVER_URL__"
 
@@ -52,6 +52,12 @@ $DefaultName = $env:COMPUTERNAME
 $AgentName = Read-Host "  Agent ismi [$DefaultName]"
 if ([string]::IsNullOrWhiteSpace($AgentName)) { $AgentName = $DefaultName }
 
+$EnrollToken = $env:RMM_ENROLL_TOKEN
+if ([string]::IsNullOrWhiteSpace($EnrollToken)) {
+    $EnrollToken = Read-Host "  Enrollment token (dashboard > Enrollment)"
+}
+if ([string]::IsNullOrWhiteSpace($EnrollToken)) { Err "Enrollment token gerekli" }
+
 $Slug = ($AgentName.ToLower() -replace '[^a-z0-9]', '-').Trim('-')
 if ([string]::IsNullOrWhiteSpace($Slug)) { $Slug = "agent" }
 $Rand = [System.Guid]::NewGuid().ToString("n").Substring(0, 6)
@@ -77,11 +83,16 @@ Log "Agent indiriliyor..."
 New-Item -ItemType Directory -Path $InstallDir -Force | Out-Null
 
 try {
-    Invoke-WebRequest -Uri "$ServerURL/api/v1/update/download?os=windows&arch=amd64" -OutFile "$InstallDir\agent.exe" -UseBasicParsing
+    Invoke-WebRequest -Uri "$ServerURL/api/v1/update/download?os=windows&arch=amd64" -Headers @{ "X-Enrollment-Token" = $EnrollToken } -OutFile "$InstallDir\agent.exe" -UseBasicParsing
 } catch {
     Err "Agent indirilemedi. Server calistigina emin olun: $ServerURL"
 }
 Log "Agent indirildi: $InstallDir\agent.exe"
+Remove-Item "$InstallDir\agent.secret", "$InstallDir\agent.crt", "$InstallDir\agent.key" -Force -ErrorAction SilentlyContinue
+
+# mTLS: agent enrollment also requests a client certificate
+$AgentTLSArgs = ""
+if ("__RMM_MTLS__" -eq "1") { $AgentTLSArgs = " -mtls" }
 
 # Save config
 @{ server = $ServerURL; key = $AgentKey; name = $AgentName } | ConvertTo-Json | Out-File -FilePath "$InstallDir\config.json" -Encoding UTF8
@@ -89,7 +100,7 @@ Log "Config kaydedildi: $InstallDir\config.json"
 
 # Create scheduled task (runs at startup, as SYSTEM)
 Log "Gorev zamanlayici olusturuluyor..."
-$action = New-ScheduledTaskAction -Execute "$InstallDir\agent.exe" -Argument "-server $ServerURL -key $AgentKey -name `"$AgentName`"" -WorkingDirectory $InstallDir
+$action = New-ScheduledTaskAction -Execute "$InstallDir\agent.exe" -Argument "-server $ServerURL -key $AgentKey -name `"$AgentName`" -enroll-token $EnrollToken -secret-file `"$InstallDir\agent.secret`"$AgentTLSArgs" -WorkingDirectory $InstallDir
 $trigger = New-ScheduledTaskTrigger -AtStartup
 $settings = New-ScheduledTaskSettingsSet -AllowStartIfOnBatteries -DontStopIfGoingOnBatteries -RestartCount 3 -RestartInterval (New-TimeSpan -Minutes 1) -ExecutionTimeLimit (New-TimeSpan -Days 365)
 $principal = New-ScheduledTaskPrincipal -UserId "SYSTEM" -LogonType ServiceAccount -RunLevel Highest
@@ -2,7 +2,7 @@
 set -e
 
 # Go Mini RMM - Interactive Agent Installer
-# Usage: curl -sSL http://SERVER:PORT/install.sh | bash
+# Usage: curl -sSL http://SERVER:PORT/install.sh | sudo bash -s -- "Agent Name" ENROLL_TOKEN
 
 SERVER_URL="__RMM_SERVER_URL__"
 INSTALL_DIR="/opt/rmm"
@@ -32,21 +32,50 @@ if [ "$(id -u)" -ne 0 ]; then
     err "Root olarak calistirin:\n    curl -sSL ${SERVER_URL}/install.sh | sudo bash"
 fi
 
-# If args passed, use non-interactive mode: $1 = agent name (key auto-generated)
+# HTTPS with the server's own CA (self-signed bootstrap): fetch the CA once
+# and use it for curl and the agent
+CURL_TLS=""
+AGENT_TLS_ARGS=""
+CA_TMP=""
+case "$SERVER_URL" in
+    https://*)
+        CA_TMP=$(mktemp)
+        if curl -sSfk "${SERVER_URL}/api/v1/pki/ca.pem" -o "$CA_TMP" 2>/dev/null; then
+            if ! curl -sSf -o /dev/null "${SERVER_URL}/health" 2>/dev/null; then
+                CURL_TLS="--cacert $CA_TMP"
+            fi
+        else
+            rm -f "$CA_TMP"
+            CA_TMP=""
+        fi
+        ;;
+esac
+if [ "__RMM_MTLS__" = "1" ]; then
+    AGENT_TLS_ARGS="-mtls"
+fi
+
+# If args passed, use non-interactive mode: $1 = agent name (key auto-generated), $2 = enrollment token
+ENROLL_TOKEN="${2:-${RMM_ENROLL_TOKEN:-}}"
 if [ -n "$1" ]; then
     AGENT_NAME="$1"
+    [ -n "$ENROLL_TOKEN" ] || err "Enrollment token gerekli (2. arguman veya RMM_ENROLL_TOKEN)"
 else
     # Interactive: sadece isim sorulur, key otomatik uretilir
     if [ ! -t 0 ]; then
         warn "Pipe ile calistirildi, script indirilip tekrar calistiriliyor (terminal girisleri icin)..."
         TMPSCRIPT=$(mktemp)
-        curl -sSL "${SERVER_URL}/install.sh" -o "$TMPSCRIPT"
+        curl -sSL ${CURL_TLS} "${SERVER_URL}/install.sh" -o "$TMPSCRIPT"
         exec bash "$TMPSCRIPT" < /dev/tty
     fi
     DEFAULT_NAME=$(hostname)
     ask "Agent ismi [${DEFAULT_NAME}]: "
     read -r AGENT_NAME </dev/tty
     AGENT_NAME="${AGENT_NAME:-$DEFAULT_NAME}"
+    if [ -z "$ENROLL_TOKEN" ]; then
+        ask "Enrollment token (dashboard > Enrollment): "
+        read -r ENROLL_TOKEN </dev/tty
+    fi
+    [ -n "$ENROLL_TOKEN" ] || err "Enrollment token gerekli"
 fi
 
 # Key = isimden slug + rastgele (benzersiz olsun)
@@ -78,13 +107,27 @@ fi
 # Download
 log "Agent indiriliyor (${OS}/${ARCH})..."
 mkdir -p "$INSTALL_DIR"
-HTTP_CODE=$(curl -sSL -o "$INSTALL_DIR/agent" -w "%{http_code}" "${SERVER_URL}/api/v1/update/download?os=${OS}&arch=${ARCH}")
+if [ -n "$CA_TMP" ]; then
+    mv "$CA_TMP" "$INSTALL_DIR/ca.pem"
+    chmod 644 "$INSTALL_DIR/ca.pem"
+    [ -n "$CURL_TLS" ] && CURL_TLS="--cacert $INSTALL_DIR/ca.pem"
+    AGENT_TLS_ARGS="${AGENT_TLS_ARGS} -ca-file ${INSTALL_DIR}/ca.pem"
+    log "Server CA kaydedildi: ${INSTALL_DIR}/ca.pem"
+fi
+HTTP_CODE=$(curl -sSL ${CURL_TLS} -o "$INSTALL_DIR/agent" -w "%{http_code}" -H "X-Enrollment-Token: ${ENROLL_TOKEN}" "${SERVER_URL}/api/v1/update/download?os=${OS}&arch=${ARCH}")
+if [ "$HTTP_CODE" = "401" ]; then
+    err "Enrollment token gecersiz veya suresi dolmus."
+fi
 if [ "$HTTP_CODE" != "200" ]; then
     err "Agent indirilemedi (HTTP ${HTTP_CODE}). Server calistigından emin olun."
 fi
 chmod +x "$INSTALL_DIR/agent"
 log "Agent indirildi"
 
+# Enroll once: exchanges the token for a per-agent secret (${INSTALL_DIR}/agent.secret)
+# and, in mTLS mode, a client certificate (agent.crt / agent.key)
+rm -f "$INSTALL_DIR/agent.secret" "$INSTALL_DIR/agent.crt" "$INSTALL_DIR/agent.key"
+
 # Create systemd service
 log "Systemd servisi olusturuluyor..."
 cat > /etc/systemd/system/${SERVICE_NAME}.service << EOF
@@ -95,7 +138,7 @@ Wants=network-online.target
 
 [Service]
 Type=simple
-ExecStart=${INSTALL_DIR}/agent -server ${SERVER_URL} -key ${AGENT_KEY} -name "${AGENT_NAME}"
+ExecStart=${INSTALL_DIR}/agent -server ${SERVER_URL} -key ${AGENT_KEY} -name "${AGENT_NAME}" -enroll-token ${ENROLL_TOKEN} -secret-file ${INSTALL_DIR}/agent.secret ${AGENT_TLS_ARGS}
 Restart=always
 RestartSec=10
 StandardOutput=journal